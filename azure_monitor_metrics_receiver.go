@@ -3,8 +3,14 @@ package azuremonitormetricsreceiver
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"sync"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/tracing"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/managementgroups/armmanagementgroups"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 )
 
@@ -13,7 +19,28 @@ type AzureMonitorMetricsReceiver struct {
 	Targets      *Targets
 	AzureClients *AzureClients
 
-	subscriptionID string
+	subscriptionID          string
+	useMetricNameValue      bool
+	addMetricDisplayNameTag bool
+	normalizeMetricUnits    bool
+	preferredTimeGrain      string
+	metricDefinitionsCache  *metricDefinitionsCache
+	targetsPlans            []*targetsPlan
+	targetsMutex            sync.RWMutex
+	checkpointStore         CheckpointStore
+	watermarks              *watermarks
+	watermarksOnce          sync.Once
+	correctionWindow        time.Duration
+	unsettledBucketsNum     int
+	emittedMetricValues     *emittedMetricValues
+	emittedMetricValuesOnce sync.Once
+	now                     func() time.Time
+	requestTimeout          time.Duration
+	instrumentation         Instrumentation
+	logger                  *slog.Logger
+	tracer                  tracing.Tracer
+	useResourceGraph        bool
+	refreshInterval         time.Duration
 }
 
 // Targets contains all targets types.
@@ -22,6 +49,9 @@ type Targets struct {
 
 	resourceGroupTargets []*ResourceGroupTarget
 	subscriptionTargets  []*Resource
+	resourceGraphTargets []*ResourceGraphTarget
+
+	managementGroupTargets []*ManagementGroupTarget
 }
 
 // ResourceTarget describes an Azure resource by resource ID.
@@ -29,6 +59,15 @@ type ResourceTarget struct {
 	ResourceID   string
 	Metrics      []string
 	Aggregations []string
+	// TimeGrain is the ISO 8601 time grain of the metrics (PT1M, PT1H, etc.), used as the query interval.
+	// It is set by SplitResourceTargetsMetricsByMinTimeGrain. If empty, Azure Monitor API default interval is used.
+	TimeGrain string
+	// Retention is the ISO 8601 retention of the metrics in the time grain (P93D, etc.), the shortest of all metrics.
+	// It is set by SplitResourceTargetsMetricsByMinTimeGrain. If empty, the retention is unknown.
+	Retention string
+	// Timeout is the timeout of every Azure Monitor API call of the resource target.
+	// If zero, the receiver request timeout is used.
+	Timeout time.Duration
 }
 
 // ResourceGroupTarget describes an Azure resource group.
@@ -39,17 +78,42 @@ type ResourceGroupTarget struct {
 
 // Resource describes an Azure resource by resource type.
 type Resource struct {
-	resourceType string
-	metrics      []string
-	aggregations []string
+	resourceType         string
+	metrics              []string
+	aggregations         []string
+	timeout              time.Duration
+	expandChildResources bool
+}
+
+// ResourceGraphTarget describes Azure resources by an Azure Resource Graph query.
+type ResourceGraphTarget struct {
+	query         string
+	subscriptions []string
+	metrics       []string
+	aggregations  []string
+	timeout       time.Duration
+}
+
+// ManagementGroupTarget describes the subscriptions under an Azure management group, recursively.
+type ManagementGroupTarget struct {
+	managementGroup string
+	resources       []*Resource
 }
 
 // AzureClients contains all clients that communicate with Azure Monitor API.
 type AzureClients struct {
+	// Deprecated: Ctx is used only by the receiver methods without a context parameter.
+	// Use the receiver methods with a context parameter instead.
 	Ctx                     context.Context
 	ResourcesClient         ResourcesClient
 	MetricDefinitionsClient MetricDefinitionsClient
 	MetricsClient           MetricsClient
+	// ResourceGraphClient is used by resource graph targets and WithResourceGraphDiscovery.
+	ResourceGraphClient ResourceGraphClient
+	// ManagementGroupsClient is used by management group targets.
+	ManagementGroupsClient ManagementGroupsClient
+	// NewResourcesClient creates a resources client of a subscription. It is used by management group targets.
+	NewResourcesClient func(subscriptionID string) (ResourcesClient, error)
 }
 
 // Metric is a metric of an Azure resource using Azure Monitor API.
@@ -63,6 +127,10 @@ type azureResourcesClient struct {
 	client *armresources.Client
 }
 
+type azureManagementGroupsClient struct {
+	client *armmanagementgroups.Client
+}
+
 // ResourcesClient is an Azure resources client interface.
 type ResourcesClient interface {
 	List(context.Context, *armresources.ClientListOptions) ([]*armresources.ClientListResponse, error)
@@ -79,19 +147,37 @@ type MetricsClient interface {
 	List(context.Context, string, *armmonitor.MetricsClientListOptions) (armmonitor.MetricsClientListResponse, error)
 }
 
+// ResourceGraphClient is an Azure Resource Graph client interface.
+type ResourceGraphClient interface {
+	Resources(context.Context, armresourcegraph.QueryRequest, *armresourcegraph.ClientResourcesOptions) (armresourcegraph.ClientResourcesResponse, error)
+}
+
+// ManagementGroupsClient is an Azure management groups client interface.
+type ManagementGroupsClient interface {
+	GetDescendants(context.Context, string, *armmanagementgroups.ClientGetDescendantsOptions) ([]*armmanagementgroups.ClientGetDescendantsResponse, error)
+}
+
+// ReceiverOptions lets you set optional receiver parameters.
+type ReceiverOptions func(*AzureMonitorMetricsReceiver)
+
 // NewAzureMonitorMetricsReceiver lets you create a new receiver.
-func NewAzureMonitorMetricsReceiver(subscriptionID string, targets *Targets, azureClients *AzureClients) (*AzureMonitorMetricsReceiver, error) {
+func NewAzureMonitorMetricsReceiver(subscriptionID string, targets *Targets, azureClients *AzureClients, receiverOptions ...ReceiverOptions) (*AzureMonitorMetricsReceiver, error) {
 	azureMonitorMetricsReceiver := &AzureMonitorMetricsReceiver{
-		Targets:        targets,
-		AzureClients:   azureClients,
-		subscriptionID: subscriptionID,
+		Targets:                targets,
+		AzureClients:           azureClients,
+		subscriptionID:         subscriptionID,
+		metricDefinitionsCache: newMetricDefinitionsCache(),
+	}
+
+	for _, receiverOption := range receiverOptions {
+		receiverOption(azureMonitorMetricsReceiver)
 	}
 
 	if err := azureMonitorMetricsReceiver.checkValidation(); err != nil {
 		return nil, fmt.Errorf("got validation error: %v", err)
 	}
 
-	azureMonitorMetricsReceiver.addPrefixToResourceTargetsResourceID()
+	azureMonitorMetricsReceiver.resolveResourceTargetsResourceID()
 	return azureMonitorMetricsReceiver, nil
 }
 
@@ -104,6 +190,16 @@ func NewTargets(resourceTargets []*ResourceTarget, resourceGroupTargets []*Resou
 	}
 }
 
+// AddResourceGraphTargets adds resource graph targets to the targets.
+func (t *Targets) AddResourceGraphTargets(resourceGraphTargets ...*ResourceGraphTarget) {
+	t.resourceGraphTargets = append(t.resourceGraphTargets, resourceGraphTargets...)
+}
+
+// AddManagementGroupTargets adds management group targets to the targets.
+func (t *Targets) AddManagementGroupTargets(managementGroupTargets ...*ManagementGroupTarget) {
+	t.managementGroupTargets = append(t.managementGroupTargets, managementGroupTargets...)
+}
+
 // NewResourceTarget lets you create a new resource target.
 func NewResourceTarget(resourceID string, metrics []string, aggregations []string) *ResourceTarget {
 	return &ResourceTarget{
@@ -121,6 +217,15 @@ func NewResourceGroupTarget(resourceGroup string, resources []*Resource) *Resour
 	}
 }
 
+// NewManagementGroupTarget lets you create a new management group target. The resources are selected by resource type
+// in every subscription under the management group, including the subscriptions of its descendant management groups.
+func NewManagementGroupTarget(managementGroup string, resources []*Resource) *ManagementGroupTarget {
+	return &ManagementGroupTarget{
+		managementGroup: managementGroup,
+		resources:       resources,
+	}
+}
+
 // NewResource lets you create a new resource.
 func NewResource(resourceType string, metrics []string, aggregations []string) *Resource {
 	return &Resource{
@@ -129,3 +234,134 @@ func NewResource(resourceType string, metrics []string, aggregations []string) *
 		aggregations: aggregations,
 	}
 }
+
+// SetTimeout sets the timeout of every Azure Monitor API call of the resource targets created from the resource.
+// If zero, the receiver request timeout is used.
+func (r *Resource) SetTimeout(timeout time.Duration) {
+	r.timeout = timeout
+}
+
+// NewResourceGraphTarget lets you create a new resource graph target. The query is an Azure Resource Graph
+// (KQL) query that must project the resource id column, for example:
+// Resources | where type =~ 'Microsoft.Compute/virtualMachines' and tags.env == 'prod' | project id, type
+func NewResourceGraphTarget(query string, metrics []string, aggregations []string) *ResourceGraphTarget {
+	return &ResourceGraphTarget{
+		query:        query,
+		metrics:      metrics,
+		aggregations: aggregations,
+	}
+}
+
+// SetSubscriptions sets the subscriptions the query runs on. If empty, the query runs on the receiver subscription.
+func (rgt *ResourceGraphTarget) SetSubscriptions(subscriptionIDs []string) {
+	rgt.subscriptions = subscriptionIDs
+}
+
+// SetTimeout sets the timeout of every Azure Monitor API call of the resource targets created from the resource
+// graph target. If zero, the receiver request timeout is used.
+func (rgt *ResourceGraphTarget) SetTimeout(timeout time.Duration) {
+	rgt.timeout = timeout
+}
+
+// WithMetricNameValue lets you create metric names from the stable metric Name.Value instead of the
+// metric Name.LocalizedValue, which is a display string that Azure can change or localize.
+func WithMetricNameValue() ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.useMetricNameValue = true
+	}
+}
+
+// WithMetricDisplayNameTag lets you add the metric Name.LocalizedValue to the metric tags.
+func WithMetricDisplayNameTag() ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.addMetricDisplayNameTag = true
+	}
+}
+
+// WithMetricUnitsNormalization lets you convert metric values to their base units (seconds, bytes, etc.),
+// append the base unit suffix to the metric names and set the unit tag to the base unit.
+func WithMetricUnitsNormalization() ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.normalizeMetricUnits = true
+	}
+}
+
+// WithPreferredTimeGrain lets you set the ISO 8601 time grain (PT5M, PT1H, etc.) to collect metrics in,
+// for metrics that are available in it. Other metrics are collected in their min time grain.
+func WithPreferredTimeGrain(timeGrain string) ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.preferredTimeGrain = timeGrain
+	}
+}
+
+// WithCheckpointStore lets you collect metrics incrementally: every metric bucket newer than the last emitted bucket
+// of the metric is collected exactly once, and the watermarks are persisted to the checkpoint store.
+func WithCheckpointStore(checkpointStore CheckpointStore) ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.checkpointStore = checkpointStore
+	}
+}
+
+// WithCorrectionWindow lets you re-query the trailing window of every metric on every collection, and re-emit
+// the buckets within it whose values changed since they were emitted, because Azure Monitor revises recent buckets
+// after first publishing them.
+func WithCorrectionWindow(correctionWindow time.Duration) ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.correctionWindow = correctionWindow
+	}
+}
+
+// WithUnsettledBucketsSkipping lets you skip the newest buckets of every metric as not yet settled.
+// The skipped buckets are collected when they are no longer the newest.
+func WithUnsettledBucketsSkipping(unsettledBucketsNum int) ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.unsettledBucketsNum = unsettledBucketsNum
+	}
+}
+
+// WithRequestTimeout lets you set the timeout of every Azure API call, for resource targets without a timeout.
+func WithRequestTimeout(requestTimeout time.Duration) ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.requestTimeout = requestTimeout
+	}
+}
+
+// getContext returns the Azure clients context, which is used by the receiver methods without a context parameter.
+func (ammr *AzureMonitorMetricsReceiver) getContext() context.Context {
+	if ammr.AzureClients == nil || ammr.AzureClients.Ctx == nil {
+		return context.Background()
+	}
+
+	return ammr.AzureClients.Ctx
+}
+
+// withRequestTimeout returns a context with the receiver request timeout, if set.
+func (ammr *AzureMonitorMetricsReceiver) withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, ammr.requestTimeout)
+}
+
+// withResourceTargetTimeout returns a context with the resource target timeout, or the receiver request timeout
+// if the resource target has no timeout.
+func (ammr *AzureMonitorMetricsReceiver) withResourceTargetTimeout(ctx context.Context, target *ResourceTarget) (context.Context, context.CancelFunc) {
+	if target.Timeout > 0 {
+		return withTimeout(ctx, target.Timeout)
+	}
+
+	return ammr.withRequestTimeout(ctx)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (ammr *AzureMonitorMetricsReceiver) getCurrentTime() time.Time {
+	if ammr.now == nil {
+		return time.Now()
+	}
+
+	return ammr.now()
+}
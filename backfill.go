@@ -0,0 +1,312 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+)
+
+const (
+	// DefaultBackfillMaxDataPoints is the default max data points of a metric in a single backfill request.
+	DefaultBackfillMaxDataPoints = 1440
+
+	defaultBackfillTimeGrain    = time.Minute
+	backfillCheckpointKeyPrefix = "backfill:"
+)
+
+// Backfill collects the receiver metrics of a past time range into a sink.
+// The time range is split into chunks per resource target, and every chunk is collected in a single request.
+type Backfill struct {
+	receiver        *AzureMonitorMetricsReceiver
+	sink            Sink
+	start           time.Time
+	end             time.Time
+	resourceIDs     map[string]bool
+	maxDataPoints   int
+	checkpointStore CheckpointStore
+	progressHandler func(BackfillProgress)
+}
+
+// BackfillOptions lets you set optional backfill parameters.
+type BackfillOptions func(*Backfill)
+
+// BackfillProgress describes the progress of a backfill.
+type BackfillProgress struct {
+	// ResourceID is the resource ID of the last completed chunk.
+	ResourceID string
+	// ChunkStart and ChunkEnd are the time range of the last completed chunk.
+	ChunkStart time.Time
+	ChunkEnd   time.Time
+	// CompletedChunksNum includes the chunks that were skipped because they were completed before resuming.
+	CompletedChunksNum int
+	SkippedChunksNum   int
+	TotalChunksNum     int
+	MetricsNum         int
+}
+
+type backfillChunk struct {
+	target *ResourceTarget
+	start  time.Time
+	end    time.Time
+}
+
+// NewBackfill lets you create a new backfill of the receiver metrics from start (inclusive) to end (exclusive).
+// The receiver resource targets must be initialized.
+func NewBackfill(receiver *AzureMonitorMetricsReceiver, sink Sink, start time.Time, end time.Time, backfillOptions ...BackfillOptions) (*Backfill, error) {
+	if !start.Before(end) {
+		return nil, fmt.Errorf("backfill start %s must be before end %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+
+	backfill := &Backfill{
+		receiver:        receiver,
+		sink:            sink,
+		start:           start.UTC(),
+		end:             end.UTC(),
+		maxDataPoints:   DefaultBackfillMaxDataPoints,
+		progressHandler: func(BackfillProgress) {},
+	}
+
+	for _, backfillOption := range backfillOptions {
+		backfillOption(backfill)
+	}
+
+	return backfill, nil
+}
+
+// WithBackfillResourceIDs lets you backfill only the resource targets with the given resource IDs.
+// Resource IDs can be given with or without the '/subscriptions/<subscription ID>/' prefix.
+func WithBackfillResourceIDs(resourceIDs ...string) BackfillOptions {
+	return func(backfill *Backfill) {
+		backfill.resourceIDs = make(map[string]bool)
+		for _, resourceID := range resourceIDs {
+			if !strings.HasPrefix(strings.ToLower(resourceID), "/subscriptions/") {
+				resourceID = "/subscriptions/" + backfill.receiver.subscriptionID + "/" + strings.TrimPrefix(resourceID, "/")
+			}
+
+			backfill.resourceIDs[strings.ToLower(resourceID)] = true
+		}
+	}
+}
+
+// WithBackfillMaxDataPoints lets you set the max data points of a metric in a single request.
+// The chunk duration of a resource target is its time grain multiplied by the max data points.
+func WithBackfillMaxDataPoints(maxDataPoints int) BackfillOptions {
+	return func(backfill *Backfill) {
+		if maxDataPoints > 0 {
+			backfill.maxDataPoints = maxDataPoints
+		}
+	}
+}
+
+// WithBackfillCheckpointStore lets you resume a backfill of the same time range and resource targets.
+// The completed chunks are saved to the checkpoint store and skipped when the backfill runs again.
+// A chunk is completed only after its metrics are written; flushing sinks, such as BatchingSink, are flushed
+// to confirm it, see FlushingSink.
+func WithBackfillCheckpointStore(checkpointStore CheckpointStore) BackfillOptions {
+	return func(backfill *Backfill) {
+		backfill.checkpointStore = checkpointStore
+	}
+}
+
+// WithBackfillProgressHandler lets you handle the backfill progress after every completed chunk.
+func WithBackfillProgressHandler(progressHandler func(BackfillProgress)) BackfillOptions {
+	return func(backfill *Backfill) {
+		backfill.progressHandler = progressHandler
+	}
+}
+
+// Run collects all the backfill chunks into the sink, in time order per resource target.
+// It stops at the first error, and returns the progress so far.
+func (b *Backfill) Run(ctx context.Context) (BackfillProgress, error) {
+	progress := BackfillProgress{}
+
+	chunks, err := b.createChunks()
+	if err != nil {
+		return progress, err
+	}
+
+	progress.TotalChunksNum = len(chunks)
+
+	completedChunksEnds := make(map[string]time.Time)
+	if b.checkpointStore != nil {
+		if completedChunksEnds, err = b.checkpointStore.Load(ctx); err != nil {
+			return progress, fmt.Errorf("error loading backfill checkpoint: %v", err)
+		}
+	}
+
+	for _, chunk := range chunks {
+		if err = ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		checkpointKey := b.getCheckpointKey(chunk.target)
+		if completedChunksEnd, found := completedChunksEnds[checkpointKey]; found && !chunk.end.After(completedChunksEnd) {
+			progress.CompletedChunksNum++
+			progress.SkippedChunksNum++
+			continue
+		}
+
+		metricsNum, err := b.collectChunk(ctx, chunk)
+		if err != nil {
+			return progress, err
+		}
+
+		if b.checkpointStore != nil {
+			if err = b.checkpointStore.Save(ctx, map[string]time.Time{checkpointKey: chunk.end}); err != nil {
+				return progress, fmt.Errorf("error saving backfill checkpoint: %v", err)
+			}
+		}
+
+		progress.ResourceID = chunk.target.ResourceID
+		progress.ChunkStart = chunk.start
+		progress.ChunkEnd = chunk.end
+		progress.CompletedChunksNum++
+		progress.MetricsNum += metricsNum
+		b.progressHandler(progress)
+	}
+
+	return progress, nil
+}
+
+func (b *Backfill) createChunks() ([]*backfillChunk, error) {
+	b.receiver.targetsMutex.RLock()
+	targets := make([]*ResourceTarget, 0, len(b.receiver.Targets.ResourceTargets))
+	for _, target := range b.receiver.Targets.ResourceTargets {
+		if b.resourceIDs == nil || b.resourceIDs[strings.ToLower(target.ResourceID)] {
+			targets = append(targets, cloneResourceTarget(target))
+		}
+	}
+	b.receiver.targetsMutex.RUnlock()
+
+	chunks := make([]*backfillChunk, 0)
+	now := b.receiver.getCurrentTime().UTC()
+
+	for _, target := range targets {
+		timeGrain := defaultBackfillTimeGrain
+		if target.TimeGrain != "" {
+			targetTimeGrain, err := parseISO8601Duration(target.TimeGrain)
+			if err != nil {
+				return nil, fmt.Errorf("resource target %s time grain is invalid: %v", target.ResourceID, err)
+			}
+
+			timeGrain = targetTimeGrain
+		}
+
+		start := b.start
+
+		// Azure does not keep metrics older than the retention.
+		if target.Retention != "" {
+			retention, err := parseISO8601Duration(target.Retention)
+			if err != nil {
+				return nil, fmt.Errorf("resource target %s retention is invalid: %v", target.ResourceID, err)
+			}
+
+			if retentionStart := now.Add(-retention); start.Before(retentionStart) {
+				start = retentionStart
+			}
+		}
+
+		chunkDuration := timeGrain * time.Duration(b.maxDataPoints)
+		for chunkStart := start.Truncate(timeGrain); chunkStart.Before(b.end); chunkStart = chunkStart.Add(chunkDuration) {
+			chunkEnd := chunkStart.Add(chunkDuration)
+			if chunkEnd.After(b.end) {
+				chunkEnd = b.end
+			}
+
+			chunks = append(chunks, &backfillChunk{
+				target: target,
+				start:  chunkStart,
+				end:    chunkEnd,
+			})
+		}
+	}
+
+	return chunks, nil
+}
+
+func (b *Backfill) collectChunk(ctx context.Context, chunk *backfillChunk) (int, error) {
+	metricNames := strings.Join(chunk.target.Metrics, ",")
+	aggregations := strings.Join(chunk.target.Aggregations, ",")
+	timespan := formatTimespan(chunk.start, chunk.end)
+	options := &armmonitor.MetricsClientListOptions{
+		Metricnames: &metricNames,
+		Aggregation: &aggregations,
+		Timespan:    &timespan,
+	}
+
+	if chunk.target.TimeGrain != "" {
+		timeGrain := chunk.target.TimeGrain
+		options.Interval = &timeGrain
+	}
+
+	listCtx, cancel := b.receiver.withResourceTargetTimeout(ctx, chunk.target)
+	defer cancel()
+
+	listCtx, span := b.receiver.startMetricsListSpan(listCtx, chunk.target, timespan)
+	listStart := b.receiver.getCurrentTime()
+	response, err := b.receiver.AzureClients.MetricsClient.List(listCtx, chunk.target.ResourceID, options)
+	b.receiver.observeAPICall(APIMetrics, chunk.target.ResourceID, listStart, err)
+	endMetricsListSpan(span, &response, err)
+	if err != nil {
+		return 0, fmt.Errorf("error listing metrics for the resource target %s timespan %s: %v", chunk.target.ResourceID, timespan, err)
+	}
+
+	start := chunk.start
+	if start.Before(b.start) {
+		start = b.start
+	}
+
+	metrics, _, _, err := b.receiver.collectMetrics(&response, func(_ string, metricValues []*armmonitor.MetricValue) []*armmonitor.MetricValue {
+		return getMetricValuesBetween(metricValues, start, chunk.end)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error collecting resource target %s metrics timespan %s: %v", chunk.target.ResourceID, timespan, err)
+	}
+
+	if len(metrics) == 0 {
+		return 0, nil
+	}
+
+	if err = b.writeMetrics(ctx, metrics); err != nil {
+		return 0, fmt.Errorf("error writing resource target %s metrics timespan %s to sink: %v", chunk.target.ResourceID, timespan, err)
+	}
+
+	return len(metrics), nil
+}
+
+// writeMetrics writes the chunk metrics to the sink. If a checkpoint store is set, flushing sinks are flushed,
+// so the chunk is saved as completed only after its metrics are written.
+func (b *Backfill) writeMetrics(ctx context.Context, metrics []*Metric) error {
+	if flushingSink, ok := b.sink.(FlushingSink); ok && b.checkpointStore != nil {
+		return flushingSink.WriteAndFlush(ctx, metrics)
+	}
+
+	return b.sink.Write(ctx, metrics)
+}
+
+// getCheckpointKey returns the backfill checkpoint key of a resource target, which is unique per time range,
+// so a backfill of another time range does not skip chunks.
+func (b *Backfill) getCheckpointKey(target *ResourceTarget) string {
+	return backfillCheckpointKeyPrefix + strings.ToLower(target.ResourceID) + "|" + strings.Join(target.Metrics, ",") + "|" + formatTimespan(b.start, b.end)
+}
+
+// getMetricValuesBetween returns the metric values that have fields and are from start (inclusive) to end (exclusive),
+// oldest first.
+func getMetricValuesBetween(metricValues []*armmonitor.MetricValue, start time.Time, end time.Time) []*armmonitor.MetricValue {
+	selectedMetricValues := make([]*armmonitor.MetricValue, 0)
+	for _, metricValue := range metricValues {
+		if getMetricsClientMetricValueFields(metricValue) == nil ||
+			metricValue.TimeStamp.Before(start) || !metricValue.TimeStamp.Before(end) {
+			continue
+		}
+
+		selectedMetricValues = append(selectedMetricValues, metricValue)
+	}
+
+	sortMetricValues(selectedMetricValues)
+
+	return selectedMetricValues
+}
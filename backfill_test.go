@@ -0,0 +1,167 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testBackfillStart = time.Date(2022, 2, 22, 22, 0, 0, 0, time.UTC)
+	testBackfillEnd   = time.Date(2022, 2, 22, 23, 0, 0, 0, time.UTC)
+)
+
+// withTestBackfillResourceTargets sets a resource target in the PT1M time grain and a resource target without a time grain.
+func withTestBackfillResourceTargets() ReceiverOptions {
+	target := NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1, testMetric2}, []string{})
+	target.TimeGrain = "PT1M"
+
+	return withTestResourceTargets(
+		target,
+		NewResourceTarget(testFullResourceGroup1ResourceType2Resource2, []string{testMetric1}, []string{}),
+	)
+}
+
+func TestNewBackfill_BadTimeRange(t *testing.T) {
+	backfill, err := NewBackfill(newTestReceiver(withTestBackfillResourceTargets(), withTestNow(testBackfillEnd)), &mockSink{}, testBackfillEnd, testBackfillStart)
+	require.Error(t, err)
+	assert.Nil(t, backfill)
+}
+
+func TestBackfillRun_Chunks(t *testing.T) {
+	metricsClient := newCountingMetricsClient()
+	ammr := newTestReceiver(withTestBackfillResourceTargets(), withTestMetricsClient(metricsClient), withTestNow(testBackfillEnd))
+	sink := &mockSink{}
+	progresses := make([]BackfillProgress, 0)
+
+	backfill, err := NewBackfill(ammr, sink, testBackfillStart, testBackfillEnd,
+		WithBackfillResourceIDs(testResourceGroup1ResourceType1Resource1),
+		WithBackfillMaxDataPoints(30),
+		WithBackfillProgressHandler(func(progress BackfillProgress) {
+			progresses = append(progresses, progress)
+		}))
+	require.NoError(t, err)
+
+	progress, err := backfill.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, progress.TotalChunksNum)
+	assert.Equal(t, 2, progress.CompletedChunksNum)
+	assert.Equal(t, 10, progress.MetricsNum)
+	assert.Equal(t, 2, metricsClient.getMetricsCalls(testMetric1+","+testMetric2))
+	assert.Equal(t, "2022-02-22T22:30:00Z/2022-02-22T23:00:00Z", metricsClient.getMetricsTimespan(testMetric1+","+testMetric2))
+
+	require.Len(t, progresses, 2)
+	assert.Equal(t, time.Date(2022, 2, 22, 22, 30, 0, 0, time.UTC), progresses[0].ChunkEnd)
+
+	batches := sink.getBatches()
+	require.Len(t, batches, 2)
+	assert.Equal(t, []string{
+		"azure_monitor_microsoft_test_type1_metric1@2022-02-22T22:00:00Z",
+		"azure_monitor_microsoft_test_type1_metric1@2022-02-22T22:01:00Z",
+		"azure_monitor_microsoft_test_type1_metric1@2022-02-22T22:02:00Z",
+		"azure_monitor_microsoft_test_type1_metric2@2022-02-22T22:00:00Z",
+		"azure_monitor_microsoft_test_type1_metric2@2022-02-22T22:01:00Z",
+		"azure_monitor_microsoft_test_type1_metric2@2022-02-22T22:02:00Z",
+	}, getMetricsTimeStamps(batches[0]))
+	assert.Equal(t, []string{
+		"azure_monitor_microsoft_test_type1_metric1@2022-02-22T22:58:00Z",
+		"azure_monitor_microsoft_test_type1_metric1@2022-02-22T22:59:00Z",
+		"azure_monitor_microsoft_test_type1_metric2@2022-02-22T22:58:00Z",
+		"azure_monitor_microsoft_test_type1_metric2@2022-02-22T22:59:00Z",
+	}, getMetricsTimeStamps(batches[1]))
+}
+
+func TestBackfillRun_AllResourceTargets(t *testing.T) {
+	backfill, err := NewBackfill(newTestReceiver(withTestBackfillResourceTargets(), withTestNow(testBackfillEnd)), &mockSink{}, testBackfillStart, testBackfillEnd)
+	require.NoError(t, err)
+
+	progress, err := backfill.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, progress.TotalChunksNum)
+}
+
+func TestBackfillRun_Retention(t *testing.T) {
+	ammr := newTestReceiver(withTestBackfillResourceTargets(), withTestNow(testBackfillEnd))
+	ammr.Targets.ResourceTargets[0].Retention = "PT30M"
+
+	backfill, err := NewBackfill(ammr, &mockSink{}, testBackfillStart, testBackfillEnd,
+		WithBackfillResourceIDs(testFullResourceGroup1ResourceType1Resource1),
+		WithBackfillMaxDataPoints(30))
+	require.NoError(t, err)
+
+	progress, err := backfill.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, progress.TotalChunksNum)
+	assert.Equal(t, 4, progress.MetricsNum)
+}
+
+func TestBackfillRun_Resume(t *testing.T) {
+	checkpointStore := newMockCheckpointStore(nil)
+	ammr := newTestReceiver(withTestBackfillResourceTargets(), withTestNow(testBackfillEnd))
+
+	backfill, err := NewBackfill(ammr, &mockSink{err: fmt.Errorf("sink error")}, testBackfillStart, testBackfillEnd,
+		WithBackfillResourceIDs(testResourceGroup1ResourceType1Resource1),
+		WithBackfillMaxDataPoints(30),
+		WithBackfillCheckpointStore(checkpointStore))
+	require.NoError(t, err)
+
+	progress, err := backfill.Run(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, 0, progress.CompletedChunksNum)
+
+	checkpointKey := backfill.getCheckpointKey(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, checkpointStore.Save(context.Background(), map[string]time.Time{
+		checkpointKey: time.Date(2022, 2, 22, 22, 30, 0, 0, time.UTC),
+	}))
+
+	sink := &mockSink{}
+	backfill, err = NewBackfill(ammr, sink, testBackfillStart, testBackfillEnd,
+		WithBackfillResourceIDs(testResourceGroup1ResourceType1Resource1),
+		WithBackfillMaxDataPoints(30),
+		WithBackfillCheckpointStore(checkpointStore))
+	require.NoError(t, err)
+
+	progress, err = backfill.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, progress.CompletedChunksNum)
+	assert.Equal(t, 1, progress.SkippedChunksNum)
+	assert.Equal(t, 4, progress.MetricsNum)
+	assert.Len(t, sink.getBatches(), 1)
+	assert.Equal(t, testBackfillEnd, checkpointStore.getWatermark(checkpointKey))
+}
+
+func TestBackfillRun_BatchingSinkErrorKeepsCheckpoint(t *testing.T) {
+	checkpointStore := newMockCheckpointStore(nil)
+	ammr := newTestReceiver(withTestBackfillResourceTargets(), withTestNow(testBackfillEnd))
+	batchingSink := NewBatchingSink(&mockSink{err: fmt.Errorf("sink error")}, WithSinkFlushInterval(time.Hour))
+	defer batchingSink.Close()
+
+	backfill, err := NewBackfill(ammr, batchingSink, testBackfillStart, testBackfillEnd,
+		WithBackfillResourceIDs(testResourceGroup1ResourceType1Resource1),
+		WithBackfillMaxDataPoints(30),
+		WithBackfillCheckpointStore(checkpointStore))
+	require.NoError(t, err)
+
+	progress, err := backfill.Run(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sink error")
+	assert.Equal(t, 0, progress.CompletedChunksNum)
+
+	// The chunk metrics were not written, so the chunk is not saved as completed.
+	assert.True(t, checkpointStore.getWatermark(backfill.getCheckpointKey(ammr.Targets.ResourceTargets[0])).IsZero())
+}
+
+func TestBackfillRun_Canceled(t *testing.T) {
+	backfill, err := NewBackfill(newTestReceiver(withTestBackfillResourceTargets(), withTestNow(testBackfillEnd)), &mockSink{}, testBackfillStart, testBackfillEnd)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = backfill.Run(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,293 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metricIDMetricsPath = "/providers/Microsoft.Insights/metrics/"
+)
+
+// CheckpointStore is a store that collection watermarks are persisted to.
+// A watermark is the timestamp of the last emitted metric bucket, by metric watermark key.
+type CheckpointStore interface {
+	// Load returns all saved watermarks. It returns an empty map if nothing was saved yet.
+	Load(context.Context) (map[string]time.Time, error)
+	// Save saves the given watermarks. Saved watermarks that are not given are kept.
+	Save(context.Context, map[string]time.Time) error
+}
+
+// FileCheckpointStore is a checkpoint store that persists watermarks to a JSON file.
+type FileCheckpointStore struct {
+	path string
+
+	mutex      sync.Mutex
+	isLoaded   bool
+	watermarks map[string]time.Time
+}
+
+type checkpointFile struct {
+	Watermarks map[string]time.Time `json:"watermarks"`
+}
+
+type watermarks struct {
+	mutex    sync.RWMutex
+	isLoaded bool
+	values   map[string]time.Time
+}
+
+// NewFileCheckpointStore lets you create a new file checkpoint store.
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{
+		path: path,
+	}
+}
+
+// Load returns all watermarks saved in the file. It returns an empty map if the file does not exist.
+func (fcs *FileCheckpointStore) Load(_ context.Context) (map[string]time.Time, error) {
+	fcs.mutex.Lock()
+	defer fcs.mutex.Unlock()
+
+	if err := fcs.load(); err != nil {
+		return nil, err
+	}
+
+	return copyWatermarks(fcs.watermarks), nil
+}
+
+// Save merges the given watermarks with the saved watermarks and writes them to the file.
+// The file is replaced atomically, so a crash during save keeps the previous checkpoint.
+func (fcs *FileCheckpointStore) Save(_ context.Context, newWatermarks map[string]time.Time) error {
+	fcs.mutex.Lock()
+	defer fcs.mutex.Unlock()
+
+	if err := fcs.load(); err != nil {
+		return err
+	}
+
+	for key, watermark := range newWatermarks {
+		fcs.watermarks[key] = watermark
+	}
+
+	data, err := json.MarshalIndent(&checkpointFile{Watermarks: fcs.watermarks}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling checkpoint: %v", err)
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(fcs.path), filepath.Base(fcs.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating checkpoint temp file: %v", err)
+	}
+
+	tempPath := tempFile.Name()
+	if _, err = tempFile.Write(data); err != nil {
+		_ = tempFile.Close()
+		_ = os.Remove(tempPath)
+		return fmt.Errorf("error writing checkpoint temp file %s: %v", tempPath, err)
+	}
+
+	if err = tempFile.Close(); err != nil {
+		_ = os.Remove(tempPath)
+		return fmt.Errorf("error closing checkpoint temp file %s: %v", tempPath, err)
+	}
+
+	if err = os.Rename(tempPath, fcs.path); err != nil {
+		_ = os.Remove(tempPath)
+		return fmt.Errorf("error replacing checkpoint file %s: %v", fcs.path, err)
+	}
+
+	return nil
+}
+
+func (fcs *FileCheckpointStore) load() error {
+	if fcs.isLoaded {
+		return nil
+	}
+
+	fcs.watermarks = make(map[string]time.Time)
+
+	data, err := os.ReadFile(fcs.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fcs.isLoaded = true
+			return nil
+		}
+
+		return fmt.Errorf("error reading checkpoint file %s: %v", fcs.path, err)
+	}
+
+	var checkpoint checkpointFile
+	if err = json.Unmarshal(data, &checkpoint); err != nil {
+		return fmt.Errorf("checkpoint file %s is bad formatted: %v", fcs.path, err)
+	}
+
+	for key, watermark := range checkpoint.Watermarks {
+		fcs.watermarks[key] = watermark
+	}
+
+	fcs.isLoaded = true
+	return nil
+}
+
+// LoadCheckpoint loads the watermarks from the checkpoint store, replacing the watermarks in memory.
+// It is called automatically on the first collection.
+//
+// Deprecated: Use LoadCheckpointWithContext instead.
+func (ammr *AzureMonitorMetricsReceiver) LoadCheckpoint() error {
+	return ammr.LoadCheckpointWithContext(ammr.getContext())
+}
+
+// LoadCheckpointWithContext loads the watermarks from the checkpoint store, replacing the watermarks in memory.
+// It is called automatically on the first collection.
+func (ammr *AzureMonitorMetricsReceiver) LoadCheckpointWithContext(ctx context.Context) error {
+	if ammr.checkpointStore == nil {
+		return fmt.Errorf("checkpoint store is not set")
+	}
+
+	loadedWatermarks, err := ammr.checkpointStore.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("error loading checkpoint: %v", err)
+	}
+
+	ammr.getWatermarks().load(loadedWatermarks)
+	return nil
+}
+
+// SaveCheckpoint saves the watermarks in memory to the checkpoint store.
+//
+// Deprecated: Use SaveCheckpointWithContext instead.
+func (ammr *AzureMonitorMetricsReceiver) SaveCheckpoint() error {
+	return ammr.SaveCheckpointWithContext(ammr.getContext())
+}
+
+// SaveCheckpointWithContext saves the watermarks in memory to the checkpoint store.
+func (ammr *AzureMonitorMetricsReceiver) SaveCheckpointWithContext(ctx context.Context) error {
+	if ammr.checkpointStore == nil {
+		return fmt.Errorf("checkpoint store is not set")
+	}
+
+	if err := ammr.checkpointStore.Save(ctx, ammr.getWatermarks().getAll()); err != nil {
+		return fmt.Errorf("error saving checkpoint: %v", err)
+	}
+
+	return nil
+}
+
+func (ammr *AzureMonitorMetricsReceiver) isCheckpointEnabled() bool {
+	return ammr.checkpointStore != nil
+}
+
+func (ammr *AzureMonitorMetricsReceiver) ensureCheckpointLoaded(ctx context.Context) error {
+	if !ammr.isCheckpointEnabled() || ammr.getWatermarks().getIsLoaded() {
+		return nil
+	}
+
+	return ammr.LoadCheckpointWithContext(ctx)
+}
+
+func (ammr *AzureMonitorMetricsReceiver) getWatermarks() *watermarks {
+	ammr.watermarksOnce.Do(func() {
+		if ammr.watermarks == nil {
+			ammr.watermarks = newWatermarks()
+		}
+	})
+
+	return ammr.watermarks
+}
+
+// getResourceTargetMinWatermark returns the oldest watermark of the resource target metrics.
+// It returns false if none of the resource target metrics has a watermark.
+func (ammr *AzureMonitorMetricsReceiver) getResourceTargetMinWatermark(target *ResourceTarget) (time.Time, bool) {
+	var minWatermark time.Time
+	isFound := false
+
+	for _, metric := range target.Metrics {
+		watermark, found := ammr.getWatermarks().get(getMetricWatermarkKey(target.ResourceID, strings.Replace(metric, "%2", ",", -1)))
+		if !found {
+			continue
+		}
+
+		if !isFound || watermark.Before(minWatermark) {
+			minWatermark = watermark
+			isFound = true
+		}
+	}
+
+	return minWatermark, isFound
+}
+
+func newWatermarks() *watermarks {
+	return &watermarks{
+		values: make(map[string]time.Time),
+	}
+}
+
+func (w *watermarks) load(values map[string]time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.values = copyWatermarks(values)
+	w.isLoaded = true
+}
+
+func (w *watermarks) getIsLoaded() bool {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	return w.isLoaded
+}
+
+func (w *watermarks) get(key string) (time.Time, bool) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	watermark, found := w.values[key]
+	return watermark, found
+}
+
+func (w *watermarks) getAll() map[string]time.Time {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	return copyWatermarks(w.values)
+}
+
+// advance sets the given watermarks. A watermark never moves backwards.
+func (w *watermarks) advance(values map[string]time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for key, watermark := range values {
+		if currentWatermark, found := w.values[key]; found && !watermark.After(currentWatermark) {
+			continue
+		}
+
+		w.values[key] = watermark
+	}
+}
+
+// getMetricWatermarkKey returns the watermark key of a resource metric, which is the lower case metric ID.
+func getMetricWatermarkKey(resourceID string, metricName string) string {
+	return getMetricIDWatermarkKey(resourceID + metricIDMetricsPath + metricName)
+}
+
+func getMetricIDWatermarkKey(metricID string) string {
+	return strings.ToLower(metricID)
+}
+
+func copyWatermarks(values map[string]time.Time) map[string]time.Time {
+	newValues := make(map[string]time.Time, len(values))
+	for key, watermark := range values {
+		newValues[key] = watermark
+	}
+
+	return newValues
+}
@@ -0,0 +1,202 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testWatermarkNow       = time.Date(2022, 2, 22, 23, 0, 0, 0, time.UTC)
+	testMetric1WatermarkID = getMetricWatermarkKey(testFullResourceGroup1ResourceType1Resource1, testMetric1)
+	testMetric2WatermarkID = getMetricWatermarkKey(testFullResourceGroup1ResourceType1Resource1, testMetric2)
+)
+
+func getMetricsTimeStamps(metrics []*Metric) []string {
+	timeStamps := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		timeStamps = append(timeStamps, fmt.Sprintf("%s@%s", metric.Name, metric.Fields[MetricFieldTimeStamp]))
+	}
+
+	return timeStamps
+}
+
+func TestFileCheckpointStore_NoFile(t *testing.T) {
+	checkpointStore := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+
+	watermarks, err := checkpointStore.Load(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, watermarks)
+}
+
+func TestFileCheckpointStore_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	watermark1 := time.Date(2022, 2, 22, 22, 0, 0, 0, time.UTC)
+	watermark2 := time.Date(2022, 2, 22, 22, 1, 0, 0, time.UTC)
+
+	err := NewFileCheckpointStore(path).Save(context.Background(), map[string]time.Time{"metric1": watermark1})
+	require.NoError(t, err)
+
+	err = NewFileCheckpointStore(path).Save(context.Background(), map[string]time.Time{"metric2": watermark2})
+	require.NoError(t, err)
+
+	watermarks, err := NewFileCheckpointStore(path).Load(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, watermarks, 2)
+	assert.True(t, watermark1.Equal(watermarks["metric1"]))
+	assert.True(t, watermark2.Equal(watermarks["metric2"]))
+
+	files, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestFileCheckpointStore_BadFormatted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+
+	watermarks, err := NewFileCheckpointStore(path).Load(context.Background())
+	require.Error(t, err)
+	assert.Nil(t, watermarks)
+}
+
+func TestCollectResourceTargetMetrics_NoWatermark(t *testing.T) {
+	ammr := newTestReceiver(WithCheckpointStore(newMockCheckpointStore(nil)), withTestNow(testWatermarkNow))
+
+	metrics, notCollectedMetrics, err := ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	assert.Empty(t, notCollectedMetrics)
+	assert.Equal(t, []string{
+		"azure_monitor_microsoft_test_type1_metric1@2022-02-22T22:59:00Z",
+		"azure_monitor_microsoft_test_type1_metric2@2022-02-22T22:59:00Z",
+	}, getMetricsTimeStamps(metrics))
+
+	watermark, found := ammr.getWatermarks().get(testMetric1WatermarkID)
+	require.True(t, found)
+	assert.Equal(t, time.Date(2022, 2, 22, 22, 59, 0, 0, time.UTC), watermark)
+}
+
+func TestCollectResourceTargetMetrics_Watermark(t *testing.T) {
+	checkpointStore := newMockCheckpointStore(map[string]time.Time{
+		testMetric1WatermarkID: time.Date(2022, 2, 22, 22, 1, 0, 0, time.UTC),
+		testMetric2WatermarkID: time.Date(2022, 2, 22, 22, 58, 0, 0, time.UTC),
+	})
+	metricsClient := newCountingMetricsClient()
+	ammr := newTestReceiver(WithCheckpointStore(checkpointStore), withTestMetricsClient(metricsClient), withTestNow(testWatermarkNow))
+
+	metrics, _, err := ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"azure_monitor_microsoft_test_type1_metric1@2022-02-22T22:02:00Z",
+		"azure_monitor_microsoft_test_type1_metric1@2022-02-22T22:58:00Z",
+		"azure_monitor_microsoft_test_type1_metric1@2022-02-22T22:59:00Z",
+		"azure_monitor_microsoft_test_type1_metric2@2022-02-22T22:59:00Z",
+	}, getMetricsTimeStamps(metrics))
+	assert.Equal(t, "2022-02-22T22:01:00Z/2022-02-22T23:00:00Z", metricsClient.getMetricsTimespan(testMetric1+","+testMetric2))
+
+	metrics, _, err = ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+	assert.Equal(t, "2022-02-22T22:59:00Z/2022-02-22T23:00:00Z", metricsClient.getMetricsTimespan(testMetric1+","+testMetric2))
+}
+
+func TestCollectResourceTargetMetrics_WatermarkRetention(t *testing.T) {
+	checkpointStore := newMockCheckpointStore(map[string]time.Time{
+		testMetric1WatermarkID: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	metricsClient := newCountingMetricsClient()
+	ammr := newTestReceiver(WithCheckpointStore(checkpointStore), withTestMetricsClient(metricsClient), withTestNow(testWatermarkNow))
+	ammr.Targets.ResourceTargets[0].Retention = "P1D"
+
+	_, _, err := ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	assert.Equal(t, "2022-02-21T23:00:00Z/2022-02-22T23:00:00Z", metricsClient.getMetricsTimespan(testMetric1+","+testMetric2))
+}
+
+func TestCollectResourceTargetsMetricsToSink_SavesCheckpoint(t *testing.T) {
+	checkpointStore := newMockCheckpointStore(nil)
+	ammr := newTestReceiver(WithCheckpointStore(checkpointStore), withTestNow(testWatermarkNow))
+	sink := &mockSink{}
+
+	_, err := ammr.CollectResourceTargetsMetricsToSink(sink)
+	require.NoError(t, err)
+	assert.Len(t, sink.getBatches(), 1)
+	assert.Equal(t, 1, checkpointStore.saveCalls)
+	assert.Equal(t, time.Date(2022, 2, 22, 22, 59, 0, 0, time.UTC), checkpointStore.getWatermark(testMetric1WatermarkID))
+	assert.Equal(t, time.Date(2022, 2, 22, 22, 59, 0, 0, time.UTC), checkpointStore.getWatermark(testMetric2WatermarkID))
+}
+
+func TestCollectResourceTargetsMetricsToSink_SinkErrorKeepsWatermark(t *testing.T) {
+	watermark := time.Date(2022, 2, 22, 22, 1, 0, 0, time.UTC)
+	checkpointStore := newMockCheckpointStore(map[string]time.Time{
+		testMetric1WatermarkID: watermark,
+	})
+	ammr := newTestReceiver(WithCheckpointStore(checkpointStore), withTestNow(testWatermarkNow))
+
+	_, err := ammr.CollectResourceTargetsMetricsToSink(&mockSink{err: fmt.Errorf("sink error")})
+	require.Error(t, err)
+	assert.Equal(t, watermark, checkpointStore.getWatermark(testMetric1WatermarkID))
+
+	sink := &mockSink{}
+	_, err = ammr.CollectResourceTargetsMetricsToSink(sink)
+	require.NoError(t, err)
+	require.Len(t, sink.getBatches(), 1)
+	assert.Len(t, sink.getBatches()[0], 4)
+}
+
+func TestCollectResourceTargetsMetricsToSink_BatchingSinkErrorKeepsWatermark(t *testing.T) {
+	watermark := time.Date(2022, 2, 22, 22, 1, 0, 0, time.UTC)
+
+	for name, test := range map[string]struct {
+		sink                   *mockSink
+		batchingSinkOptions    []BatchingSinkOptions
+		expectedErrorSubstring string
+	}{
+		"sink error": {
+			sink:                   &mockSink{err: fmt.Errorf("sink error")},
+			expectedErrorSubstring: "sink error",
+		},
+		"dropped metrics": {
+			sink: &mockSink{block: make(chan struct{})},
+			batchingSinkOptions: []BatchingSinkOptions{
+				WithSinkBatchSize(1), WithSinkQueueSize(1), WithSinkBackpressurePolicy(BackpressurePolicyDrop),
+			},
+			expectedErrorSubstring: "metrics were dropped",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			checkpointStore := newMockCheckpointStore(map[string]time.Time{
+				testMetric1WatermarkID: watermark,
+			})
+			ammr := newTestReceiver(WithCheckpointStore(checkpointStore), withTestNow(testWatermarkNow))
+			batchingSink := NewBatchingSink(test.sink, append(test.batchingSinkOptions, WithSinkFlushInterval(time.Hour))...)
+
+			_, err := ammr.CollectResourceTargetsMetricsToSink(batchingSink)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectedErrorSubstring)
+
+			// The metrics were not delivered, so the watermark is kept and they are collected again.
+			assert.Equal(t, watermark, checkpointStore.getWatermark(testMetric1WatermarkID))
+			memoryWatermark, _ := ammr.getWatermarks().get(testMetric1WatermarkID)
+			assert.Equal(t, watermark, memoryWatermark)
+
+			if test.sink.block != nil {
+				close(test.sink.block)
+			}
+			batchingSink.Close()
+		})
+	}
+}
+
+func TestSaveCheckpoint_NoCheckpointStore(t *testing.T) {
+	ammr := newTestReceiver(withTestNow(testWatermarkNow))
+
+	require.Error(t, ammr.SaveCheckpoint())
+	require.Error(t, ammr.LoadCheckpoint())
+}
@@ -0,0 +1,152 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// childResourceType is a well-known child resource type of a parent resource type.
+type childResourceType struct {
+	resourceType string
+	// name is the name of the single child resource of every parent resource, such as default.
+	// If empty, the child resources are listed with their parent resources.
+	name string
+}
+
+// wellKnownChildResourceTypes are the child resource types that have metrics, by lower case parent resource type.
+var wellKnownChildResourceTypes = map[string][]*childResourceType{
+	"microsoft.storage/storageaccounts": {
+		{resourceType: "Microsoft.Storage/storageAccounts/blobServices", name: "default"},
+		{resourceType: "Microsoft.Storage/storageAccounts/fileServices", name: "default"},
+		{resourceType: "Microsoft.Storage/storageAccounts/queueServices", name: "default"},
+		{resourceType: "Microsoft.Storage/storageAccounts/tableServices", name: "default"},
+	},
+	"microsoft.sql/servers": {
+		{resourceType: "Microsoft.Sql/servers/databases"},
+		{resourceType: "Microsoft.Sql/servers/elasticPools"},
+	},
+}
+
+// SetChildResourcesExpansion sets whether resource targets are also created for the well-known child resources of
+// the resources, such as the blob, file, queue and table services of storage accounts, and the databases and elastic
+// pools of SQL servers. The child resource targets collect all their metrics, with the resource aggregations.
+func (r *Resource) SetChildResourcesExpansion(expandChildResources bool) {
+	r.expandChildResources = expandChildResources
+}
+
+// getResourcesListedTypes returns the resource types to list for the resources, which are the resource types and
+// the listed child resource types of the resources that expand their child resources.
+func getResourcesListedTypes(resources []*Resource) []string {
+	resourceTypes := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		resourceTypes = append(resourceTypes, resource.resourceType)
+		if !resource.expandChildResources {
+			continue
+		}
+
+		for _, childType := range getWellKnownChildResourceTypes(resource.resourceType) {
+			if childType.name == "" {
+				resourceTypes = append(resourceTypes, childType.resourceType)
+			}
+		}
+	}
+
+	return resourceTypes
+}
+
+func getWellKnownChildResourceTypes(resourceType string) []*childResourceType {
+	return wellKnownChildResourceTypes[strings.ToLower(resourceType)]
+}
+
+// createChildResourceTargets creates resource targets for the well-known child resources of the parent resource.
+// The listed child resources are taken from the resources, and the single child resources that are not found
+// are skipped.
+func (ammr *AzureMonitorMetricsReceiver) createChildResourceTargets(
+	ctx context.Context,
+	parentResourceID string,
+	resources []*armresources.GenericResourceExpanded,
+	targetResource *Resource,
+) (int, error) {
+	resourceTargetsCreatedNum := 0
+
+	for _, childType := range getWellKnownChildResourceTypes(targetResource.resourceType) {
+		if childType.name != "" {
+			childResourceID := createChildResourceID(parentResourceID, childType)
+			isFound, err := ammr.isSingleChildResourceFound(ctx, childResourceID, targetResource)
+			if err != nil {
+				return resourceTargetsCreatedNum, err
+			}
+
+			if !isFound {
+				continue
+			}
+
+			ammr.addChildResourceTarget(ctx, childResourceID, childType.resourceType, targetResource)
+			resourceTargetsCreatedNum++
+			continue
+		}
+
+		for _, resource := range resources {
+			resourceID, err := getResourcesClientResourceID(resource)
+			if err != nil {
+				return resourceTargetsCreatedNum, err
+			}
+
+			resourceType, err := getResourcesClientResourceType(resource)
+			if err != nil {
+				return resourceTargetsCreatedNum, err
+			}
+
+			if !strings.EqualFold(*resourceType, childType.resourceType) ||
+				!strings.HasPrefix(strings.ToLower(*resourceID), strings.ToLower(parentResourceID)+"/") {
+				continue
+			}
+
+			ammr.addChildResourceTarget(ctx, *resourceID, *resourceType, targetResource)
+			resourceTargetsCreatedNum++
+		}
+	}
+
+	return resourceTargetsCreatedNum, nil
+}
+
+// isSingleChildResourceFound returns whether the single child resource exists and has metrics. Not every parent
+// resource has all the single child resources, for example BlobStorage and premium storage accounts have no file,
+// queue and table services, so their metric definitions are not found or empty.
+func (ammr *AzureMonitorMetricsReceiver) isSingleChildResourceFound(ctx context.Context, resourceID string, targetResource *Resource) (bool, error) {
+	target := NewResourceTarget(resourceID, []string{}, []string{})
+	target.Timeout = targetResource.timeout
+
+	_, err := ammr.getMetricDefinitionsResponse(ctx, target)
+	if err == nil {
+		return true, nil
+	}
+
+	var responseError *azcore.ResponseError
+	if errors.Is(err, errMetricDefinitionsEmpty) || (errors.As(err, &responseError) && responseError.StatusCode == http.StatusNotFound) {
+		ammr.getLogger().DebugContext(ctx, "skipped child resource without metrics", "resource_id", resourceID, "error", err)
+		return false, nil
+	}
+
+	return false, fmt.Errorf("error checking child resource %s: %v", resourceID, err)
+}
+
+func (ammr *AzureMonitorMetricsReceiver) addChildResourceTarget(ctx context.Context, resourceID string, resourceType string, targetResource *Resource) {
+	newTarget := NewResourceTarget(resourceID, []string{}, copyStrings(targetResource.aggregations))
+	newTarget.Timeout = targetResource.timeout
+	ammr.Targets.ResourceTargets = append(ammr.Targets.ResourceTargets, newTarget)
+	ammr.getLogger().DebugContext(ctx, "created child resource target", "resource_id", resourceID, "resource_type", resourceType)
+}
+
+// createChildResourceID creates the resource ID of a single child resource, such as
+// .../storageAccounts/account/blobServices/default.
+func createChildResourceID(parentResourceID string, childType *childResourceType) string {
+	childTypeName := childType.resourceType[strings.LastIndex(childType.resourceType, "/")+1:]
+	return strings.TrimSuffix(parentResourceID, "/") + "/" + childTypeName + "/" + childType.name
+}
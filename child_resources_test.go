@@ -0,0 +1,173 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testStorageAccountID = "/subscriptions/" + testSubscriptionID + "/resourceGroups/" + testResourceGroup1 +
+		"/providers/Microsoft.Storage/storageAccounts/account"
+	testSQLServerID = "/subscriptions/" + testSubscriptionID + "/resourceGroups/" + testResourceGroup1 +
+		"/providers/Microsoft.Sql/servers/server"
+)
+
+func newTestGenericResource(resourceID string, resourceType string) *armresources.GenericResourceExpanded {
+	return &armresources.GenericResourceExpanded{ID: to.Ptr(resourceID), Type: to.Ptr(resourceType)}
+}
+
+func newTestStorageAccountChildResourcesReceiver(errs map[string]error) *AzureMonitorMetricsReceiver {
+	azureClients := setMockAzureClients()
+	azureClients.MetricDefinitionsClient = &mockStaticMetricDefinitionsClient{
+		metricDefinitions: map[string][]*armmonitor.MetricDefinition{
+			testStorageAccountID + "/blobServices/default": {newTestMetricDefinition(testMetric1, "PT1M")},
+			testStorageAccountID + "/fileServices/default": {newTestMetricDefinition(testMetric1, "PT1M")},
+		},
+		errs: errs,
+	}
+
+	return &AzureMonitorMetricsReceiver{Targets: NewTargets(nil, nil, nil), AzureClients: azureClients}
+}
+
+func TestCreateResourceTargetFromTargetResources_ChildResourcesExpansion(t *testing.T) {
+	storageAccounts := NewResource("Microsoft.Storage/storageAccounts", []string{testMetric1}, []string{"Total"})
+	storageAccounts.SetChildResourcesExpansion(true)
+
+	// The queue services are not found, and the table services have no metric definitions.
+	ammr := newTestStorageAccountChildResourcesReceiver(map[string]error{
+		testStorageAccountID + "/queueServices/default": &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "ResourceNotFound"},
+	})
+
+	resourceTargetsCreatedNum, err := ammr.createResourceTargetFromTargetResources(context.Background(),
+		[]*armresources.GenericResourceExpanded{newTestGenericResource(testStorageAccountID, "Microsoft.Storage/storageAccounts")},
+		[]*Resource{storageAccounts})
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, resourceTargetsCreatedNum)
+	require.Len(t, ammr.Targets.ResourceTargets, 3)
+	assert.Equal(t, testStorageAccountID, ammr.Targets.ResourceTargets[0].ResourceID)
+	assert.Equal(t, []string{testMetric1}, ammr.Targets.ResourceTargets[0].Metrics)
+	assert.Equal(t, testStorageAccountID+"/blobServices/default", ammr.Targets.ResourceTargets[1].ResourceID)
+	assert.Equal(t, testStorageAccountID+"/fileServices/default", ammr.Targets.ResourceTargets[2].ResourceID)
+
+	// The child resource targets collect all their metrics.
+	assert.Empty(t, ammr.Targets.ResourceTargets[1].Metrics)
+	assert.Equal(t, []string{"Total"}, ammr.Targets.ResourceTargets[1].Aggregations)
+}
+
+func TestCreateResourceTargetFromTargetResources_ChildResourcesExpansionError(t *testing.T) {
+	storageAccounts := NewResource("Microsoft.Storage/storageAccounts", []string{}, []string{})
+	storageAccounts.SetChildResourcesExpansion(true)
+
+	ammr := newTestStorageAccountChildResourcesReceiver(map[string]error{
+		testStorageAccountID + "/queueServices/default": &azcore.ResponseError{StatusCode: http.StatusTooManyRequests, ErrorCode: "TooManyRequests"},
+	})
+
+	_, err := ammr.createResourceTargetFromTargetResources(context.Background(),
+		[]*armresources.GenericResourceExpanded{newTestGenericResource(testStorageAccountID, "Microsoft.Storage/storageAccounts")},
+		[]*Resource{storageAccounts})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error checking child resource "+testStorageAccountID+"/queueServices/default")
+}
+
+func TestCreateResourceTargetFromTargetResources_ListedChildResources(t *testing.T) {
+	sqlServers := NewResource("Microsoft.Sql/servers", []string{}, []string{})
+	sqlServers.SetChildResourcesExpansion(true)
+	ammr := &AzureMonitorMetricsReceiver{Targets: NewTargets(nil, nil, nil)}
+
+	resourceTargetsCreatedNum, err := ammr.createResourceTargetFromTargetResources(context.Background(),
+		[]*armresources.GenericResourceExpanded{
+			newTestGenericResource(testSQLServerID, "Microsoft.Sql/servers"),
+			newTestGenericResource(testSQLServerID+"/databases/database", "microsoft.sql/servers/databases"),
+			newTestGenericResource(testSQLServerID+"2/databases/otherDatabase", "Microsoft.Sql/servers/databases"),
+		},
+		[]*Resource{sqlServers})
+	require.NoError(t, err)
+
+	// The database of the other server is not a child resource of the server.
+	assert.Equal(t, 2, resourceTargetsCreatedNum)
+	require.Len(t, ammr.Targets.ResourceTargets, 2)
+	assert.Equal(t, testSQLServerID+"/databases/database", ammr.Targets.ResourceTargets[1].ResourceID)
+}
+
+func TestCreateResourceTargetFromTargetResources_NoChildResourcesExpansion(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{Targets: NewTargets(nil, nil, nil)}
+
+	resourceTargetsCreatedNum, err := ammr.createResourceTargetFromTargetResources(context.Background(),
+		[]*armresources.GenericResourceExpanded{newTestGenericResource(testStorageAccountID, "Microsoft.Storage/storageAccounts")},
+		[]*Resource{NewResource("Microsoft.Storage/storageAccounts", []string{}, []string{})})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, resourceTargetsCreatedNum)
+}
+
+func TestCreateClientResourcesFilter_ChildResourcesExpansion(t *testing.T) {
+	sqlServers := NewResource("Microsoft.Sql/servers", []string{}, []string{})
+	sqlServers.SetChildResourcesExpansion(true)
+	storageAccounts := NewResource("Microsoft.Storage/storageAccounts", []string{}, []string{})
+	storageAccounts.SetChildResourcesExpansion(true)
+
+	// The single child resources of storage accounts are not listed.
+	assert.Equal(t, "resourceType eq 'Microsoft.Sql/servers' or resourceType eq 'Microsoft.Sql/servers/databases' or "+
+		"resourceType eq 'Microsoft.Sql/servers/elasticPools' or resourceType eq 'Microsoft.Storage/storageAccounts'",
+		createClientResourcesFilter([]*Resource{sqlServers, storageAccounts}))
+}
+
+func TestGetMetricTags_ChildResource(t *testing.T) {
+	for name, test := range map[string]struct {
+		resourceID         string
+		resourceName       string
+		parentResourceName string
+	}{
+		"resource": {resourceID: testSQLServerID, resourceName: "server"},
+		"child resource": {
+			resourceID:         testStorageAccountID + "/blobServices/default",
+			resourceName:       "account/blobServices/default",
+			parentResourceName: "account",
+		},
+		"nested child resource": {
+			resourceID:         testSQLServerID + "/databases/database/backups/backup",
+			resourceName:       "server/databases/database/backups/backup",
+			parentResourceName: "server/database",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			metric := &armmonitor.Metric{ID: to.Ptr(test.resourceID + "/providers/Microsoft.Insights/metrics/" + testMetric1)}
+
+			response := &armmonitor.MetricsClientListResponse{Response: armmonitor.Response{
+				Namespace:      to.Ptr(testResourceType1),
+				Resourceregion: to.Ptr(testResourceRegion),
+			}}
+			metric.Unit = to.Ptr(armmonitor.UnitCount)
+
+			metricTags, err := getMetricTags(metric, response)
+			require.NoError(t, err)
+			assert.Equal(t, test.resourceName, metricTags[MetricTagResourceName])
+
+			parentResourceName, found := metricTags[MetricTagParentResource]
+			assert.Equal(t, test.parentResourceName != "", found)
+			assert.Equal(t, test.parentResourceName, parentResourceName)
+		})
+	}
+}
+
+func TestConfigCreateTargets_ExpandChildResources(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+subscription_targets:
+  - resource_type: Microsoft.Storage/storageAccounts
+    expand_child_resources: true
+`), ConfigFormatYAML)
+	require.NoError(t, err)
+
+	targets := config.CreateTargets()
+	require.Len(t, targets.subscriptionTargets, 1)
+	assert.True(t, targets.subscriptionTargets[0].expandChildResources)
+}
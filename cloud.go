@@ -0,0 +1,64 @@
+package azuremonitormetricsreceiver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+const (
+	// CloudPublic is the Azure public cloud.
+	CloudPublic = "public"
+	// CloudUSGovernment is the Azure US Government cloud.
+	CloudUSGovernment = "usgovernment"
+	// CloudChina is the Azure China cloud.
+	CloudChina = "china"
+	// CloudCustom is a cloud with custom endpoints, such as Azure Stack.
+	CloudCustom = "custom"
+)
+
+// GetAzureCloud returns the Azure cloud configuration of a cloud name: public, usgovernment or china.
+// The Azure cloud names (AzurePublicCloud, AzureUSGovernment, AzureChinaCloud) are supported too.
+func GetAzureCloud(name string) (cloud.Configuration, error) {
+	switch strings.ToLower(name) {
+	case "", CloudPublic, "azurepubliccloud", "azurecloud":
+		return cloud.AzurePublic, nil
+	case CloudUSGovernment, "azureusgovernment", "azureusgovernmentcloud":
+		return cloud.AzureGovernment, nil
+	case CloudChina, "azurechinacloud":
+		return cloud.AzureChina, nil
+	default:
+		return cloud.Configuration{}, fmt.Errorf("cloud %s is not supported", name)
+	}
+}
+
+// NewCustomAzureCloud returns an Azure cloud configuration with custom endpoints.
+// If resourceManagerAudience is empty, the resource manager endpoint is used as the audience.
+func NewCustomAzureCloud(activeDirectoryAuthorityHost string, resourceManagerEndpoint string, resourceManagerAudience string) (cloud.Configuration, error) {
+	if activeDirectoryAuthorityHost == "" || resourceManagerEndpoint == "" {
+		return cloud.Configuration{}, fmt.Errorf("custom cloud active directory authority host and resource manager endpoint are required")
+	}
+
+	if resourceManagerAudience == "" {
+		resourceManagerAudience = resourceManagerEndpoint
+	}
+
+	return cloud.Configuration{
+		ActiveDirectoryAuthorityHost: activeDirectoryAuthorityHost,
+		Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {
+				Audience: resourceManagerAudience,
+				Endpoint: resourceManagerEndpoint,
+			},
+		},
+	}, nil
+}
+
+// WithAzureCloud lets you set the Azure cloud of the credential and all the Azure clients.
+// It keeps the other Azure client options, so it can be used with WithAzureClientOptions in any order.
+func WithAzureCloud(cloudConfiguration cloud.Configuration) ClientOptions {
+	return func(azureClientOptions *AzureClientOptions) {
+		azureClientOptions.cloud = &cloudConfiguration
+	}
+}
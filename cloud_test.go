@@ -0,0 +1,104 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testTokenCredential struct {
+	mutex  sync.Mutex
+	scopes []string
+}
+
+func (ttc *testTokenCredential) GetToken(_ context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	ttc.mutex.Lock()
+	defer ttc.mutex.Unlock()
+
+	ttc.scopes = append(ttc.scopes, options.Scopes...)
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestGetAzureCloud_Names(t *testing.T) {
+	clouds := map[string]cloud.Configuration{
+		"":                  cloud.AzurePublic,
+		"public":            cloud.AzurePublic,
+		"AzurePublicCloud":  cloud.AzurePublic,
+		"usgovernment":      cloud.AzureGovernment,
+		"AzureUSGovernment": cloud.AzureGovernment,
+		"china":             cloud.AzureChina,
+		"AzureChinaCloud":   cloud.AzureChina,
+	}
+
+	for name, expectedCloud := range clouds {
+		azureCloud, err := GetAzureCloud(name)
+		require.NoError(t, err)
+		assert.Equal(t, expectedCloud.ActiveDirectoryAuthorityHost, azureCloud.ActiveDirectoryAuthorityHost, name)
+	}
+
+	_, err := GetAzureCloud("mars")
+	require.Error(t, err)
+}
+
+func TestNewCustomAzureCloud_Success(t *testing.T) {
+	azureCloud, err := NewCustomAzureCloud("https://login.example.com/", "https://management.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://management.example.com", azureCloud.Services[cloud.ResourceManager].Endpoint)
+	assert.Equal(t, "https://management.example.com", azureCloud.Services[cloud.ResourceManager].Audience)
+
+	_, err = NewCustomAzureCloud("", "https://management.example.com", "")
+	require.Error(t, err)
+}
+
+func TestWithAzureCloud_KeepsClientOptions(t *testing.T) {
+	clientOptions := &azcore.ClientOptions{Retry: policy.RetryOptions{MaxRetries: 7}}
+
+	options := getAzureClientOptions([]func(*AzureClientOptions){WithAzureCloud(cloud.AzureChina), WithAzureClientOptions(clientOptions)})
+	assert.Equal(t, cloud.AzureChina.ActiveDirectoryAuthorityHost, options.Cloud.ActiveDirectoryAuthorityHost)
+	assert.Equal(t, int32(7), options.Retry.MaxRetries)
+
+	options = getAzureClientOptions([]func(*AzureClientOptions){WithAzureClientOptions(clientOptions), WithAzureCloud(cloud.AzureGovernment)})
+	assert.Equal(t, cloud.AzureGovernment.ActiveDirectoryAuthorityHost, options.Cloud.ActiveDirectoryAuthorityHost)
+	assert.Equal(t, int32(7), options.Retry.MaxRetries)
+	assert.Empty(t, clientOptions.Cloud.ActiveDirectoryAuthorityHost)
+}
+
+func TestCreateAzureClientsWithCreds_CustomCloud(t *testing.T) {
+	requestPaths := make(chan string, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requestPaths <- request.URL.Path
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write([]byte(`{"value": []}`))
+	}))
+	defer server.Close()
+
+	azureCloud, err := NewCustomAzureCloud("https://login.example.com/", server.URL, "https://management.example.com")
+	require.NoError(t, err)
+
+	credential := &testTokenCredential{}
+	azureClients, err := CreateAzureClientsWithCreds(testSubscriptionID, credential,
+		WithAzureClientOptions(&azcore.ClientOptions{Transport: server.Client()}),
+		WithAzureCloud(azureCloud))
+	require.NoError(t, err)
+
+	_, err = azureClients.MetricsClient.List(context.Background(), testFullResourceGroup1ResourceType1Resource1, nil)
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(<-requestPaths, testFullResourceGroup1ResourceType1Resource1))
+	assert.Equal(t, []string{"https://management.example.com/.default"}, credential.scopes)
+}
+
+func TestCreateAzureClients_Cloud(t *testing.T) {
+	_, err := CreateAzureClients(testSubscriptionID, testClientID, testClientSecret, testTenantID, WithAzureCloud(cloud.AzureChina))
+	require.NoError(t, err)
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	receiver "github.com/logzio/azure-monitor-metrics-receiver"
+)
+
+// sinkCloseTimeout is the max time to wait for the queued metrics to be written when the sink is closed.
+const sinkCloseTimeout = 30 * time.Second
+
+type printSink struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+type printedMetric struct {
+	Name   string                 `json:"name"`
+	Fields map[string]interface{} `json:"fields"`
+	Tags   map[string]string      `json:"tags"`
+}
+
+type backfillCommandOptions struct {
+	start            string
+	end              string
+	resourceIDs      string
+	maxDataPoints    int
+	checkpointFile   string
+	remoteWriteURL   string
+	remoteWriteToken string
+}
+
+type fixtureCommandOptions struct {
+	recordFixture string
+	replayFixture string
+}
+
+type printedResourceTarget struct {
+	ResourceID   string   `json:"resource_id"`
+	Metrics      []string `json:"metrics"`
+	Aggregations []string `json:"aggregations"`
+	TimeGrain    string   `json:"time_grain,omitempty"`
+}
+
+func newPrintSink(output io.Writer) *printSink {
+	return &printSink{encoder: json.NewEncoder(output)}
+}
+
+func (ps *printSink) Write(_ context.Context, metrics []*receiver.Metric) error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	for _, metric := range metrics {
+		if err := ps.encoder.Encode(&printedMetric{Name: metric.Name, Fields: metric.Fields, Tags: metric.Tags}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createSink creates a Prometheus remote write sink if the remote write URL is set, or a print sink otherwise.
+// The returned function flushes and closes the sink.
+func createSink(remoteWriteURL string, remoteWriteToken string, output io.Writer) (receiver.Sink, func(), error) {
+	if remoteWriteURL == "" {
+		return newPrintSink(output), func() {}, nil
+	}
+
+	remoteWriteSender, err := receiver.NewRemoteWriteSender(remoteWriteURL, receiver.WithRemoteWriteToken(remoteWriteToken))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	batchingSink := receiver.NewBatchingSink(remoteWriteSender, receiver.WithSinkErrorHandler(func(err error) {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	}))
+
+	return batchingSink, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sinkCloseTimeout)
+		defer cancel()
+
+		if err := batchingSink.CloseWithContext(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+	}, nil
+}
+
+// createFixtureClientOptions returns the Azure client options that record the Azure responses to the record
+// fixture file or replay the responses of the replay fixture file, and a function that saves the recorded responses.
+func createFixtureClientOptions(options *fixtureCommandOptions) ([]func(*receiver.AzureClientOptions), func() error, error) {
+	if options.recordFixture != "" && options.replayFixture != "" {
+		return nil, nil, fmt.Errorf("record fixture and replay fixture cannot be used together")
+	}
+
+	if options.replayFixture != "" {
+		fixture, err := receiver.LoadFixture(options.replayFixture)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return []func(*receiver.AzureClientOptions){receiver.WithFixtureReplayer(receiver.NewFixtureReplayer(fixture))}, func() error { return nil }, nil
+	}
+
+	if options.recordFixture != "" {
+		recorder := receiver.NewFixtureRecorder()
+		return []func(*receiver.AzureClientOptions){receiver.WithFixtureRecorder(recorder)}, func() error {
+			return recorder.Save(options.recordFixture)
+		}, nil
+	}
+
+	return nil, func() error { return nil }, nil
+}
+
+// withFixture runs the command with the fixture Azure client options, and saves the recorded responses
+// even if the command failed, so the failure can be replayed.
+func withFixture(options *fixtureCommandOptions, command func(clientOptions ...func(*receiver.AzureClientOptions)) error) error {
+	clientOptions, saveFixture, err := createFixtureClientOptions(options)
+	if err != nil {
+		return err
+	}
+
+	err = command(clientOptions...)
+	if saveErr := saveFixture(); saveErr != nil && err == nil {
+		return saveErr
+	}
+
+	return err
+}
+
+// initializeReceivers creates a receiver for every subscription in the config file and resolves their resource targets.
+func initializeReceivers(ctx context.Context, configPath string, clientOptions ...func(*receiver.AzureClientOptions)) ([]*receiver.AzureMonitorMetricsReceiver, *receiver.Config, error) {
+	cfg, err := receiver.LoadConfig(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	receivers, err := cfg.CreateReceivers(clientOptions...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating receivers: %v", err)
+	}
+
+	for _, ammr := range receivers {
+		if err = ammr.InitializeTargetsWithContext(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return receivers, cfg, nil
+}
+
+func collectCommand(ctx context.Context, configPath string, fixtureOptions *fixtureCommandOptions, output io.Writer) error {
+	return withFixture(fixtureOptions, func(clientOptions ...func(*receiver.AzureClientOptions)) error {
+		receivers, _, err := initializeReceivers(ctx, configPath, clientOptions...)
+		if err != nil {
+			return err
+		}
+
+		return collect(ctx, receivers, newPrintSink(output))
+	})
+}
+
+func runLoopCommand(ctx context.Context, configPath string, interval time.Duration, remoteWriteURL string, remoteWriteToken string, output io.Writer) error {
+	receivers, cfg, err := initializeReceivers(ctx, configPath)
+	if err != nil {
+		return err
+	}
+
+	if interval == 0 {
+		if interval, err = cfg.GetCollectionInterval(); err != nil {
+			return err
+		}
+	}
+
+	if interval <= 0 {
+		return fmt.Errorf("collection interval must be positive")
+	}
+
+	sink, closeSink, err := createSink(remoteWriteURL, remoteWriteToken, output)
+	if err != nil {
+		return err
+	}
+	defer closeSink()
+
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	defer signal.Stop(reloadSignals)
+
+	if cfg.Collection.TimeGrainScheduling {
+		return runSchedulers(ctx, cfg, configPath, receivers, sink, reloadSignals)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		refreshTargets(ctx, receivers)
+
+		if err = collect(ctx, receivers, sink); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+
+		for isWaiting := true; isWaiting; {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-reloadSignals:
+				if err = reloadTargets(ctx, cfg, configPath, receivers); err != nil {
+					fmt.Fprintf(os.Stderr, "error reloading targets: %v\n", err)
+				}
+			case <-ticker.C:
+				isWaiting = false
+			}
+		}
+	}
+}
+
+// runSchedulers collects the metrics of every receiver resource targets at their time grain until the context is done.
+// The targets are reloaded from the config file on reload signals, and the schedulers refresh the expired management
+// group targets. The reloaded and refreshed targets are collected from the next scheduled collection.
+func runSchedulers(ctx context.Context, cfg *receiver.Config, configPath string, receivers []*receiver.AzureMonitorMetricsReceiver,
+	sink receiver.Sink, reloadSignals <-chan os.Signal) error {
+	ingestionDelay, err := cfg.GetIngestionDelay()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reloadSignals:
+				if err := reloadTargets(ctx, cfg, configPath, receivers); err != nil {
+					fmt.Fprintf(os.Stderr, "error reloading targets: %v\n", err)
+				}
+			}
+		}
+	}()
+
+	errorChannel := make(chan error, len(receivers))
+	for _, ammr := range receivers {
+		scheduler := receiver.NewScheduler(ammr, sink,
+			receiver.WithSchedulerIngestionDelay(ingestionDelay),
+			receiver.WithSchedulerErrorHandler(func(err error) {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			}))
+
+		go func() {
+			errorChannel <- scheduler.Run(ctx)
+		}()
+	}
+
+	for range receivers {
+		if schedulerErr := <-errorChannel; schedulerErr != nil && err == nil {
+			err = schedulerErr
+		}
+	}
+
+	return err
+}
+
+// reloadTargets reloads the targets of the receivers from the config file.
+// The config subscriptions, credentials and cloud can't be changed without restart.
+func reloadTargets(ctx context.Context, cfg *receiver.Config, configPath string, receivers []*receiver.AzureMonitorMetricsReceiver) error {
+	reloadedCfg, err := receiver.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	if err = reloadedCfg.Validate(); err != nil {
+		return err
+	}
+
+	if err = checkReloadedConfig(cfg, reloadedCfg); err != nil {
+		return err
+	}
+
+	for _, ammr := range receivers {
+		diff, err := ammr.ReloadTargetsWithContext(ctx, reloadedCfg.CreateTargets())
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "reloaded targets: %d added, %d removed, %d unchanged\n",
+			diff.AddedTargetsNum, diff.RemovedTargetsNum, diff.UnchangedTargetsNum)
+	}
+
+	return nil
+}
+
+// checkReloadedConfig checks that the reloaded config has the same subscriptions, credentials and cloud as the config
+// the receivers were created with.
+func checkReloadedConfig(cfg *receiver.Config, reloadedCfg *receiver.Config) error {
+	subscriptionIDs := make(map[string]bool)
+	for _, subscriptionID := range cfg.GetSubscriptionIDs() {
+		subscriptionIDs[strings.ToLower(subscriptionID)] = true
+	}
+
+	reloadedSubscriptionIDs := make(map[string]bool)
+	for _, subscriptionID := range reloadedCfg.GetSubscriptionIDs() {
+		reloadedSubscriptionIDs[strings.ToLower(subscriptionID)] = true
+	}
+
+	if len(subscriptionIDs) != len(reloadedSubscriptionIDs) {
+		return fmt.Errorf("changing subscriptions requires restart")
+	}
+
+	for subscriptionID := range reloadedSubscriptionIDs {
+		if !subscriptionIDs[subscriptionID] {
+			return fmt.Errorf("changing subscriptions requires restart")
+		}
+	}
+
+	if cfg.Credentials != reloadedCfg.Credentials {
+		return fmt.Errorf("changing credentials requires restart")
+	}
+
+	if !strings.EqualFold(cfg.Cloud, reloadedCfg.Cloud) || cfg.CloudEndpoints != reloadedCfg.CloudEndpoints {
+		return fmt.Errorf("changing cloud requires restart")
+	}
+
+	return nil
+}
+
+// refreshTargets refreshes the expired management group targets of the receivers.
+func refreshTargets(ctx context.Context, receivers []*receiver.AzureMonitorMetricsReceiver) {
+	for _, ammr := range receivers {
+		if err := ammr.RefreshTargetsWithContext(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "error refreshing targets: %v\n", err)
+		}
+	}
+}
+
+func collect(ctx context.Context, receivers []*receiver.AzureMonitorMetricsReceiver, sink receiver.Sink) error {
+	for _, ammr := range receivers {
+		notCollectedMetrics, err := ammr.CollectResourceTargetsMetricsToSinkWithContext(ctx, sink)
+		if err != nil {
+			return err
+		}
+
+		for _, notCollectedMetric := range notCollectedMetrics {
+			fmt.Fprintf(os.Stderr, "metric was not collected: %s\n", notCollectedMetric)
+		}
+	}
+
+	return nil
+}
+
+func backfillCommand(ctx context.Context, configPath string, options *backfillCommandOptions, output io.Writer) error {
+	start, err := time.Parse(time.RFC3339, options.start)
+	if err != nil {
+		return fmt.Errorf("backfill start is bad formatted: %v", err)
+	}
+
+	end, err := time.Parse(time.RFC3339, options.end)
+	if err != nil {
+		return fmt.Errorf("backfill end is bad formatted: %v", err)
+	}
+
+	receivers, _, err := initializeReceivers(ctx, configPath)
+	if err != nil {
+		return err
+	}
+
+	sink, closeSink, err := createSink(options.remoteWriteURL, options.remoteWriteToken, output)
+	if err != nil {
+		return err
+	}
+	defer closeSink()
+
+	backfillOptions := []receiver.BackfillOptions{
+		receiver.WithBackfillMaxDataPoints(options.maxDataPoints),
+		receiver.WithBackfillProgressHandler(func(progress receiver.BackfillProgress) {
+			fmt.Fprintf(os.Stderr, "backfilled %d/%d chunks, %d metrics: %s %s/%s\n",
+				progress.CompletedChunksNum, progress.TotalChunksNum, progress.MetricsNum, progress.ResourceID,
+				progress.ChunkStart.Format(time.RFC3339), progress.ChunkEnd.Format(time.RFC3339))
+		}),
+	}
+
+	if options.resourceIDs != "" {
+		backfillOptions = append(backfillOptions, receiver.WithBackfillResourceIDs(strings.Split(options.resourceIDs, ",")...))
+	}
+
+	if options.checkpointFile != "" {
+		backfillOptions = append(backfillOptions, receiver.WithBackfillCheckpointStore(receiver.NewFileCheckpointStore(options.checkpointFile)))
+	}
+
+	totalChunksNum := 0
+	for _, ammr := range receivers {
+		backfill, err := receiver.NewBackfill(ammr, sink, start, end, backfillOptions...)
+		if err != nil {
+			return err
+		}
+
+		progress, err := backfill.Run(ctx)
+		if err != nil {
+			return err
+		}
+
+		totalChunksNum += progress.TotalChunksNum
+		if progress.SkippedChunksNum > 0 {
+			fmt.Fprintf(os.Stderr, "skipped %d chunks that were backfilled before\n", progress.SkippedChunksNum)
+		}
+	}
+
+	if totalChunksNum == 0 {
+		return fmt.Errorf("no resource targets to backfill")
+	}
+
+	return nil
+}
+
+func discoverCommand(ctx context.Context, configPath string, fixtureOptions *fixtureCommandOptions, output io.Writer) error {
+	return withFixture(fixtureOptions, func(clientOptions ...func(*receiver.AzureClientOptions)) error {
+		receivers, _, err := initializeReceivers(ctx, configPath, clientOptions...)
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(output)
+		for _, ammr := range receivers {
+			for _, target := range ammr.Targets.ResourceTargets {
+				if err = encoder.Encode(&printedResourceTarget{
+					ResourceID:   target.ResourceID,
+					Metrics:      target.Metrics,
+					Aggregations: target.Aggregations,
+					TimeGrain:    target.TimeGrain,
+				}); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func validateCommand(configPath string, output io.Writer) error {
+	cfg, err := receiver.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return fmt.Errorf("config is invalid: %v", err)
+	}
+
+	_, err = fmt.Fprintf(output, "config %s is valid\n", configPath)
+	return err
+}
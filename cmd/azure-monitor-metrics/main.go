@@ -0,0 +1,120 @@
+// Command azure-monitor-metrics collects metrics of Azure resources using Azure Monitor API.
+//
+// Usage:
+//
+//	azure-monitor-metrics <command> -config <path> [flags]
+//
+// The commands are:
+//
+//	collect   collects metrics once and prints them
+//	run       collects metrics every interval until interrupted
+//	backfill  collects metrics of a past time range
+//	discover  prints the resolved resource targets
+//	validate  checks the config file
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	receiver "github.com/logzio/azure-monitor-metrics-receiver"
+)
+
+const usage = `Usage: azure-monitor-metrics <command> -config <path> [flags]
+
+Commands:
+  collect   collects metrics once and prints them
+  run       collects metrics every interval until interrupted
+  backfill  collects metrics of a past time range
+  discover  prints the resolved resource targets
+  validate  checks the config file
+
+Run 'azure-monitor-metrics <command> -h' for the command flags.
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := runCommand(ctx, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runCommand(ctx context.Context, args []string, output io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command is missing\n%s", usage)
+	}
+
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	configPath := flags.String("config", "config.yaml", "path of the YAML or JSON config file")
+
+	switch args[0] {
+	case "collect":
+		fixtureOptions := addFixtureFlags(flags)
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		return collectCommand(ctx, *configPath, fixtureOptions, output)
+	case "run":
+		interval := flags.Duration("interval", 0, "time between collections (default is the config collection interval)")
+		remoteWriteURL := flags.String("remote-write-url", "", "Prometheus remote write URL to send metrics to instead of printing them")
+		remoteWriteToken := flags.String("remote-write-token", os.Getenv("REMOTE_WRITE_TOKEN"), "Prometheus remote write bearer token")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *interval < 0 {
+			return fmt.Errorf("interval must not be negative\n%s", usage)
+		}
+
+		return runLoopCommand(ctx, *configPath, *interval, *remoteWriteURL, *remoteWriteToken, output)
+	case "backfill":
+		options := &backfillCommandOptions{}
+		flags.StringVar(&options.start, "start", "", "RFC 3339 start time of the time range (inclusive)")
+		flags.StringVar(&options.end, "end", "", "RFC 3339 end time of the time range (exclusive)")
+		flags.StringVar(&options.resourceIDs, "resource-ids", "", "comma separated resource IDs to backfill (default is all resource targets)")
+		flags.IntVar(&options.maxDataPoints, "max-data-points", receiver.DefaultBackfillMaxDataPoints, "max data points of a metric in a single request")
+		flags.StringVar(&options.checkpointFile, "checkpoint-file", "", "file to save the completed chunks to, to resume the backfill")
+		flags.StringVar(&options.remoteWriteURL, "remote-write-url", "", "Prometheus remote write URL to send metrics to instead of printing them")
+		flags.StringVar(&options.remoteWriteToken, "remote-write-token", os.Getenv("REMOTE_WRITE_TOKEN"), "Prometheus remote write bearer token")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		return backfillCommand(ctx, *configPath, options, output)
+	case "discover":
+		fixtureOptions := addFixtureFlags(flags)
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		return discoverCommand(ctx, *configPath, fixtureOptions, output)
+	case "validate":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		return validateCommand(*configPath, output)
+	case "help", "-h", "-help", "--help":
+		_, err := fmt.Fprint(output, usage)
+		return err
+	default:
+		return fmt.Errorf("unknown command %s\n%s", args[0], usage)
+	}
+}
+
+func addFixtureFlags(flags *flag.FlagSet) *fixtureCommandOptions {
+	options := &fixtureCommandOptions{}
+	flags.StringVar(&options.recordFixture, "record-fixture", "", "file to record the scrubbed Azure responses to, to replay them later")
+	flags.StringVar(&options.replayFixture, "replay-fixture", "", "file of recorded Azure responses to replay instead of calling Azure")
+
+	return options
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	receiver "github.com/logzio/azure-monitor-metrics-receiver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestRunCommand_ValidateValidConfig(t *testing.T) {
+	path := writeTestConfig(t, `{
+		"subscription_id": "subscriptionID",
+		"credentials": {"client_id": "clientID", "client_secret": "clientSecret", "tenant_id": "tenantID"},
+		"subscription_targets": [{"resource_type": "Microsoft.Test/type1"}]
+	}`)
+
+	output := &bytes.Buffer{}
+	err := runCommand(context.Background(), []string{"validate", "-config", path}, output)
+	require.NoError(t, err)
+
+	assert.Contains(t, output.String(), "is valid")
+}
+
+func TestRunCommand_ValidateInvalidConfig(t *testing.T) {
+	path := writeTestConfig(t, `{
+		"subscription_id": "subscriptionID",
+		"credentials": {"client_id": "clientID", "client_secret": "clientSecret", "tenant_id": "tenantID"},
+		"subscription_targets": [{"resource_type": "Microsoft.Test/type1", "aggregations": ["Invalid"]}]
+	}`)
+
+	err := runCommand(context.Background(), []string{"validate", "-config", path}, &bytes.Buffer{})
+	require.Error(t, err)
+}
+
+func TestRunCommand_BackfillBadTimeRange(t *testing.T) {
+	err := runCommand(context.Background(), []string{"backfill", "-start", "yesterday", "-end", "2022-02-22T23:00:00Z"}, &bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "backfill start is bad formatted")
+}
+
+func TestRunCommand_RunNegativeInterval(t *testing.T) {
+	err := runCommand(context.Background(), []string{"run", "-interval", "-1m"}, &bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "interval must not be negative")
+}
+
+func TestCheckReloadedConfig(t *testing.T) {
+	const configContent = `{
+		"subscription_ids": ["subscriptionID1", "subscriptionID2"],
+		"credentials": {"client_id": "clientID", "client_secret": "clientSecret", "tenant_id": "tenantID"},
+		"subscription_targets": [{"resource_type": "Microsoft.Test/type1"}]
+	}`
+
+	cfg, err := receiver.ParseConfig([]byte(configContent), receiver.ConfigFormatJSON)
+	require.NoError(t, err)
+
+	for name, test := range map[string]struct {
+		change                 func(*receiver.Config)
+		expectedErrorSubstring string
+	}{
+		"targets": {change: func(cfg *receiver.Config) {
+			cfg.SubscriptionTargets = append(cfg.SubscriptionTargets, &receiver.ResourceConfig{ResourceType: "Microsoft.Test/type2"})
+		}},
+		"subscriptions order": {change: func(cfg *receiver.Config) {
+			cfg.SubscriptionIDs = []string{"SUBSCRIPTIONID2", "subscriptionID1"}
+		}},
+		"replaced subscription": {
+			change: func(cfg *receiver.Config) {
+				cfg.SubscriptionIDs = []string{"subscriptionID1", "subscriptionID3"}
+			},
+			expectedErrorSubstring: "changing subscriptions requires restart",
+		},
+		"added subscription": {
+			change: func(cfg *receiver.Config) {
+				cfg.SubscriptionIDs = append(cfg.SubscriptionIDs, "subscriptionID3")
+			},
+			expectedErrorSubstring: "changing subscriptions requires restart",
+		},
+		"credentials": {
+			change: func(cfg *receiver.Config) {
+				cfg.Credentials.ClientSecret = "otherClientSecret"
+			},
+			expectedErrorSubstring: "changing credentials requires restart",
+		},
+		"cloud": {
+			change: func(cfg *receiver.Config) {
+				cfg.Cloud = "china"
+			},
+			expectedErrorSubstring: "changing cloud requires restart",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			reloadedCfg, err := receiver.ParseConfig([]byte(configContent), receiver.ConfigFormatJSON)
+			require.NoError(t, err)
+			test.change(reloadedCfg)
+
+			err = checkReloadedConfig(cfg, reloadedCfg)
+			if test.expectedErrorSubstring == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectedErrorSubstring)
+		})
+	}
+}
+
+func TestRunCommand_UnknownCommand(t *testing.T) {
+	err := runCommand(context.Background(), []string{"unknown"}, &bytes.Buffer{})
+	require.Error(t, err)
+}
+
+func TestRunCommand_NoCommand(t *testing.T) {
+	err := runCommand(context.Background(), []string{}, &bytes.Buffer{})
+	require.Error(t, err)
+}
+
+func TestRunCommand_DiscoverReplayFixture(t *testing.T) {
+	path := writeTestConfig(t, `{
+		"subscription_id": "subscriptionID",
+		"credentials": {"client_id": "clientID", "client_secret": "clientSecret", "tenant_id": "tenantID"},
+		"subscription_targets": [{"resource_type": "Microsoft.Test/type1"}]
+	}`)
+
+	resourceID := "/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/rg/providers/Microsoft.Test/type1/resource1"
+	fixture := &receiver.Fixture{Interactions: []*receiver.FixtureInteraction{
+		{
+			Method:     "GET",
+			URL:        "/subscriptions/00000000-0000-0000-0000-000000000001/resources?%24filter=resourceType+eq+%27Microsoft.Test%2Ftype1%27&api-version=2021-04-01",
+			StatusCode: 200,
+			Body:       `{"value":[{"id":"` + resourceID + `","type":"Microsoft.Test/type1"}]}`,
+		},
+		{
+			Method:     "GET",
+			URL:        resourceID + "/providers/Microsoft.Insights/metricDefinitions?api-version=2021-05-01",
+			StatusCode: 200,
+			Body:       `{"value":[{"name":{"value":"metric1"},"metricAvailabilities":[{"timeGrain":"PT1M"}]}]}`,
+		},
+	}}
+
+	fixturePath := filepath.Join(t.TempDir(), "fixture.json")
+	content, err := json.Marshal(fixture)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(fixturePath, content, 0o600))
+
+	output := &bytes.Buffer{}
+	err = runCommand(context.Background(), []string{"discover", "-config", path, "-replay-fixture", fixturePath}, output)
+	require.NoError(t, err)
+
+	assert.Contains(t, output.String(), `"resource_id":"/subscriptions/subscriptionID/resourceGroups/rg/providers/Microsoft.Test/type1/resource1"`)
+	assert.Contains(t, output.String(), `"metrics":["metric1"]`)
+	assert.Contains(t, output.String(), `"time_grain":"PT1M"`)
+}
+
+func TestRunCommand_RecordAndReplayFixture(t *testing.T) {
+	err := runCommand(context.Background(), []string{"collect", "-record-fixture", "a.json", "-replay-fixture", "b.json"}, &bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot be used together")
+}
@@ -1,8 +1,10 @@
 package azuremonitormetricsreceiver
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
 )
@@ -27,104 +29,271 @@ const (
 	MetricTagSubscriptionID = "subscription_id"
 	// MetricTagResourceGroup is resource group metric tag name.
 	MetricTagResourceGroup  = "resource_group"
-	// MetricTagResourceName is resource name metric tag name.
+	// MetricTagResourceName is resource name metric tag name. For child resources, it is the names and types
+	// from the top level resource name, such as account/blobServices/default.
 	MetricTagResourceName   = "resource_name"
+	// MetricTagParentResource is parent resource metric tag name. It is set for child resources only.
+	MetricTagParentResource = "parent_resource"
 	// MetricTagNamespace is namespace metric tag name.
 	MetricTagNamespace      = "namespace"
 	// MetricTagResourceRegion is resource region metric tag name.
 	MetricTagResourceRegion = "resource_region"
 	// MetricTagUnit is unit metric tag name.
 	MetricTagUnit           = "unit"
+	// MetricTagMetricDisplayName is metric display name metric tag name.
+	MetricTagMetricDisplayName = "metric_display_name"
 )
 
+// metricIDMetricsInfix separates the resource ID and the metric name in lower case metric IDs.
+const metricIDMetricsInfix = "/providers/microsoft.insights/metrics/"
+
+// metricValuesSelector selects the metric values of a metric to create metrics from, by metric watermark key.
+// The selected metric values must be ordered oldest first.
+type metricValuesSelector func(watermarkKey string, metricValues []*armmonitor.MetricValue) []*armmonitor.MetricValue
+
 // CollectResourceTargetMetrics collects metrics of a resource target.
+// If a checkpoint store is set, every metric bucket newer than the metric watermark is collected and the watermarks
+// are advanced in memory. Use SaveCheckpoint to persist them.
+//
+// Deprecated: Use CollectResourceTargetMetricsWithContext instead.
 func (ammr *AzureMonitorMetricsReceiver) CollectResourceTargetMetrics(target *ResourceTarget) ([]*Metric, []string, error) {
+	return ammr.CollectResourceTargetMetricsWithContext(ammr.getContext(), target)
+}
+
+// CollectResourceTargetMetricsWithContext collects metrics of a resource target.
+// The Azure Monitor API call is canceled when the context is done, or when the resource target timeout expires.
+// If a checkpoint store is set, every metric bucket newer than the metric watermark is collected and the watermarks
+// are advanced in memory. Use SaveCheckpointWithContext to persist them.
+func (ammr *AzureMonitorMetricsReceiver) CollectResourceTargetMetricsWithContext(ctx context.Context, target *ResourceTarget) ([]*Metric, []string, error) {
+	metrics, notCollectedMetrics, update, err := ammr.collectResourceTargetMetrics(ctx, target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ammr.commitCollectionUpdate(update)
+	return metrics, notCollectedMetrics, nil
+}
+
+// collectResourceTargetMetrics collects metrics of a resource target without committing the collection update,
+// so callers can commit it only after the metrics are written.
+func (ammr *AzureMonitorMetricsReceiver) collectResourceTargetMetrics(ctx context.Context, target *ResourceTarget) ([]*Metric, []string, *collectionUpdate, error) {
+	start := ammr.getCurrentTime()
+
+	metrics, notCollectedMetrics, update, err := ammr.listResourceTargetMetrics(ctx, target)
+	ammr.observeResourceTargetCollection(target, start, len(metrics), len(notCollectedMetrics), err)
+	return metrics, notCollectedMetrics, update, err
+}
+
+func (ammr *AzureMonitorMetricsReceiver) listResourceTargetMetrics(ctx context.Context, target *ResourceTarget) ([]*Metric, []string, *collectionUpdate, error) {
+	if err := ammr.ensureCheckpointLoaded(ctx); err != nil {
+		return nil, nil, nil, err
+	}
+
 	metricNames := strings.Join(target.Metrics, ",")
 	aggregations := strings.Join(target.Aggregations, ",")
-	response, err := ammr.AzureClients.MetricsClient.List(ammr.AzureClients.Ctx, target.ResourceID, &armmonitor.MetricsClientListOptions{
+	options := &armmonitor.MetricsClientListOptions{
 		Metricnames: &metricNames,
 		Aggregation: &aggregations,
-	})
+	}
+
+	if target.TimeGrain != "" {
+		timeGrain := target.TimeGrain
+		options.Interval = &timeGrain
+	}
+
+	timespan := ammr.createResourceTargetTimespan(target)
+	if timespan != "" {
+		options.Timespan = &timespan
+	}
+
+	listCtx, cancel := ammr.withResourceTargetTimeout(ctx, target)
+	defer cancel()
+
+	ammr.getLogger().DebugContext(ctx, "listing metrics", "resource_id", target.ResourceID, "metrics", metricNames,
+		"aggregations", aggregations, "time_grain", target.TimeGrain, "timespan", timespan)
+
+	listCtx, span := ammr.startMetricsListSpan(listCtx, target, timespan)
+	listStart := ammr.getCurrentTime()
+	response, err := ammr.AzureClients.MetricsClient.List(listCtx, target.ResourceID, options)
+	ammr.observeAPICall(APIMetrics, target.ResourceID, listStart, err)
+	endMetricsListSpan(span, &response, err)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error listing metrics for the resource target %s: %v", target.ResourceID, err)
+		return nil, nil, nil, fmt.Errorf("error listing metrics for the resource target %s: %v", target.ResourceID, err)
 	}
 
-	metrics, notCollectedMetrics, err := collectMetrics(&response)
+	metrics, notCollectedMetrics, update, err := ammr.collectMetrics(&response, ammr.selectNewMetricValues)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error collecting resource target %s metrics: %v", target.ResourceID, err)
+		return nil, nil, nil, fmt.Errorf("error collecting resource target %s metrics: %v", target.ResourceID, err)
 	}
 
-	return metrics, notCollectedMetrics, nil
+	return metrics, notCollectedMetrics, update, nil
+}
+
+// createResourceTargetTimespan returns the timespan from the oldest watermark of the resource target metrics,
+// or from the correction window start if it is older, until now. It returns an empty timespan if there is no
+// watermark and no correction window, so the Azure Monitor API default timespan is used.
+func (ammr *AzureMonitorMetricsReceiver) createResourceTargetTimespan(target *ResourceTarget) string {
+	end := ammr.getCurrentTime().UTC()
+
+	var start time.Time
+	isStartFound := false
+
+	if ammr.isCheckpointEnabled() {
+		start, isStartFound = ammr.getResourceTargetMinWatermark(target)
+	}
+
+	if ammr.correctionWindow > 0 {
+		if windowStart := end.Add(-ammr.correctionWindow); !isStartFound || windowStart.Before(start) {
+			start = windowStart
+			isStartFound = true
+		}
+	}
+
+	if !isStartFound {
+		return ""
+	}
+
+	// Azure does not keep metrics older than the retention.
+	if retention, err := parseISO8601Duration(target.Retention); err == nil && retention > 0 && start.Before(end.Add(-retention)) {
+		start = end.Add(-retention)
+	}
+
+	return formatTimespan(start, end)
 }
 
-func collectMetrics(response *armmonitor.MetricsClientListResponse) ([]*Metric, []string, error) {
+// selectNewMetricValues selects the settled metric values newer than the metric watermark if the metric has
+// a watermark, or the latest settled metric value otherwise. If there is a correction window, the settled metric
+// values within it that changed since they were emitted are selected too.
+func (ammr *AzureMonitorMetricsReceiver) selectNewMetricValues(watermarkKey string, metricValues []*armmonitor.MetricValue) []*armmonitor.MetricValue {
+	settledMetricValues := getSettledMetricValues(metricValues, ammr.unsettledBucketsNum)
+
+	var newMetricValues []*armmonitor.MetricValue
+	watermark, hasWatermark := ammr.getWatermarks().get(watermarkKey)
+
+	if ammr.isCheckpointEnabled() && hasWatermark {
+		newMetricValues = getMetricValuesAfter(settledMetricValues, watermark)
+	} else if metricValue := getLatestMetricValue(settledMetricValues); metricValue != nil {
+		newMetricValues = []*armmonitor.MetricValue{metricValue}
+	}
+
+	if ammr.correctionWindow > 0 {
+		newMetricValues = mergeMetricValues(newMetricValues, ammr.getChangedMetricValues(watermarkKey, settledMetricValues))
+	}
+
+	return newMetricValues
+}
+
+// collectMetrics creates metrics from the metric values the selector selects. A metric that has no metric values
+// to select from is not collected.
+func (ammr *AzureMonitorMetricsReceiver) collectMetrics(
+	response *armmonitor.MetricsClientListResponse,
+	selectMetricValues metricValuesSelector) ([]*Metric, []string, *collectionUpdate, error) {
 	metrics := make([]*Metric, 0)
 	notCollectedMetric := make([]string, 0)
+	update := newCollectionUpdate()
 
 	for _, metric := range response.Value {
 		errorMessage, err := getMetricsClientMetricErrorMessage(metric)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 
 		if errorMessage != nil {
-			return nil, nil, fmt.Errorf("response error: %s", *errorMessage)
+			return nil, nil, nil, fmt.Errorf("response error: %s", *errorMessage)
 		}
 
-		if len(metric.Timeseries) == 0 {
-			metricID, err := getMetricsClientMetricID(metric)
-			if err != nil {
-				return nil, nil, err
-			}
+		metricID, err := getMetricsClientMetricID(metric)
+		if err != nil {
+			return nil, nil, nil, err
+		}
 
+		if len(metric.Timeseries) == 0 {
+			ammr.getLogger().Debug("metric was not collected", "metric_id", *metricID, "reason", "metric has no time series")
 			notCollectedMetric = append(notCollectedMetric, *metricID)
 			continue
 		}
 
 		timeseries := metric.Timeseries[0]
 		if timeseries == nil {
-			return nil, nil, fmt.Errorf("metrics client response is bad formatted: metric timeseries is missing")
+			return nil, nil, nil, fmt.Errorf("metrics client response is bad formatted: metric timeseries is missing")
 		}
 
 		if len(timeseries.Data) == 0 {
-			metricID, err := getMetricsClientMetricID(metric)
-			if err != nil {
-				return nil, nil, err
-			}
-
+			ammr.getLogger().Debug("metric was not collected", "metric_id", *metricID, "reason", "metric time series has no values")
 			notCollectedMetric = append(notCollectedMetric, *metricID)
 			continue
 		}
 
-		metricName, err := createMetricName(metric, response)
+		var metricName *string
+
+		if ammr.useMetricNameValue {
+			metricName, err = createMetricNameFromNameValue(metric, response)
+		} else {
+			metricName, err = createMetricName(metric, response)
+		}
+
 		if err != nil {
-			return nil, nil, fmt.Errorf("error creating metric name: %v", err)
+			return nil, nil, nil, fmt.Errorf("error creating metric name: %v", err)
+		}
+
+		if getLatestMetricValue(timeseries.Data) == nil {
+			ammr.getLogger().Debug("metric was not collected", "metric_id", *metricID, "reason", "metric values have no aggregation values")
+			notCollectedMetric = append(notCollectedMetric, *metricID)
+			continue
 		}
 
-		metricFields := getMetricFields(timeseries.Data)
-		if metricFields == nil {
-			metricID, err := getMetricsClientMetricID(metric)
+		watermarkKey := getMetricIDWatermarkKey(*metricID)
+		metricValues := selectMetricValues(watermarkKey, timeseries.Data)
+		if len(metricValues) == 0 {
+			ammr.getLogger().Debug("metric was skipped", "metric_id", *metricID, "reason", "metric has no new or changed settled values")
+			continue
+		}
+
+		for _, metricValue := range metricValues {
+			newMetric, err := ammr.createMetric(*metricName, getMetricsClientMetricValueFields(metricValue), metric, response)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 
-			notCollectedMetric = append(notCollectedMetric, *metricID)
-			continue
+			metrics = append(metrics, newMetric)
 		}
 
-		metricTags, err := getMetricTags(metric, response)
+		update.addMetricValues(ammr, watermarkKey, metricValues)
+	}
+
+	return metrics, notCollectedMetric, update, nil
+}
+
+func (ammr *AzureMonitorMetricsReceiver) createMetric(
+	metricName string,
+	metricFields map[string]interface{},
+	metric *armmonitor.Metric,
+	response *armmonitor.MetricsClientListResponse) (*Metric, error) {
+	metricTags, err := getMetricTags(metric, response)
+	if err != nil {
+		return nil, fmt.Errorf("error getting metric tags: %v", err)
+	}
+
+	if ammr.addMetricDisplayNameTag {
+		metricDisplayName, err := getMetricsClientMetricNameLocalizedValue(metric)
 		if err != nil {
-			return nil, nil, fmt.Errorf("error getting metric tags: %v", err)
+			return nil, fmt.Errorf("error getting metric display name: %v", err)
 		}
 
-		metrics = append(metrics, &Metric{
-			Name:   *metricName,
-			Fields: metricFields,
-			Tags:   metricTags,
-		})
+		metricTags[MetricTagMetricDisplayName] = *metricDisplayName
+	}
+
+	newMetric := &Metric{
+		Name:   metricName,
+		Fields: metricFields,
+		Tags:   metricTags,
+	}
+
+	if ammr.normalizeMetricUnits {
+		normalizeMetricUnit(newMetric)
 	}
 
-	return metrics, notCollectedMetric, nil
+	return newMetric, nil
 }
 
 func getMetricsClientMetricErrorMessage(metric *armmonitor.Metric) (*string, error) {
@@ -174,6 +343,24 @@ func getMetricsClientMetricNameLocalizedValue(metric *armmonitor.Metric) (*strin
 	return metricNameLocalizedValue, nil
 }
 
+func getMetricsClientMetricNameValue(metric *armmonitor.Metric) (*string, error) {
+	if metric == nil {
+		return nil, fmt.Errorf("metrics client response is bad formatted: metric is missing")
+	}
+
+	metricName := metric.Name
+	if metricName == nil {
+		return nil, fmt.Errorf("metrics client response is bad formatted: metric Name is missing")
+	}
+
+	metricNameValue := metricName.Value
+	if metricNameValue == nil {
+		return nil, fmt.Errorf("metrics client response is bad formatted: metric Name.Value is missing")
+	}
+
+	return metricNameValue, nil
+}
+
 func getMetricsClientMetricUnit(metric *armmonitor.Metric) (*string, error) {
 	if metric == nil {
 		return nil, fmt.Errorf("metrics client response is bad formatted: metric is missing")
@@ -260,49 +447,129 @@ func createMetricName(metric *armmonitor.Metric, response *armmonitor.MetricsCli
 		return nil, err
 	}
 
-	replacer := strings.NewReplacer(".", "_", "/", "_", " ", "_", "(", "_", ")", "_")
-	metricName := fmt.Sprintf("azure_monitor_%s_%s",
-		replacer.Replace(strings.ToLower(*namespace)),
-		replacer.Replace(strings.ToLower(*name)))
+	metricName := formatMetricName(*namespace, *name)
+	return &metricName, nil
+}
+
+func createMetricNameFromNameValue(metric *armmonitor.Metric, response *armmonitor.MetricsClientListResponse) (*string, error) {
+	namespace, err := getMetricsClientResponseNamespace(response)
+	if err != nil {
+		return nil, err
+	}
 
+	name, err := getMetricsClientMetricNameValue(metric)
+	if err != nil {
+		return nil, err
+	}
+
+	metricName := formatMetricName(*namespace, *name)
 	return &metricName, nil
 }
 
-func getMetricFields(metricValues []*armmonitor.MetricValue) map[string]interface{} {
+func formatMetricName(namespace string, name string) string {
+	replacer := strings.NewReplacer(".", "_", "/", "_", " ", "_", "(", "_", ")", "_")
+	return fmt.Sprintf("azure_monitor_%s_%s",
+		replacer.Replace(strings.ToLower(namespace)),
+		replacer.Replace(strings.ToLower(name)))
+}
+
+// CreateMetricNamesMigrationMap creates a map from the resource targets metric names that are based on
+// the metric Name.LocalizedValue to the metric names that are based on the metric Name.Value.
+// Use it to migrate dashboards and alerts before using WithMetricNameValue.
+//
+// Deprecated: Use CreateMetricNamesMigrationMapWithContext instead.
+func (ammr *AzureMonitorMetricsReceiver) CreateMetricNamesMigrationMap() (map[string]string, error) {
+	return ammr.CreateMetricNamesMigrationMapWithContext(ammr.getContext())
+}
+
+// CreateMetricNamesMigrationMapWithContext creates a map from the resource targets metric names that are based on
+// the metric Name.LocalizedValue to the metric names that are based on the metric Name.Value.
+// Use it to migrate dashboards and alerts before using WithMetricNameValue.
+func (ammr *AzureMonitorMetricsReceiver) CreateMetricNamesMigrationMapWithContext(ctx context.Context) (map[string]string, error) {
+	metricNamesMap := make(map[string]string)
+	checkedResourceIDs := make(map[string]bool)
+
+	for _, target := range ammr.Targets.ResourceTargets {
+		if checkedResourceIDs[target.ResourceID] {
+			continue
+		}
+
+		checkedResourceIDs[target.ResourceID] = true
+
+		response, err := ammr.getMetricDefinitionsResponse(ctx, target)
+		if err != nil {
+			return nil, fmt.Errorf("error getting metric definitions response for resource target %s: %v", target.ResourceID, err)
+		}
+
+		for _, metricDefinition := range response.Value {
+			namespace, err := getMetricDefinitionsClientMetricNamespace(metricDefinition)
+			if err != nil {
+				return nil, err
+			}
+
+			metricNameValue, err := getMetricDefinitionsClientMetricNameValue(metricDefinition)
+			if err != nil {
+				return nil, err
+			}
+
+			metricNameLocalizedValue, err := getMetricDefinitionsClientMetricNameLocalizedValue(metricDefinition)
+			if err != nil {
+				return nil, err
+			}
+
+			metricNamesMap[formatMetricName(*namespace, *metricNameLocalizedValue)] = formatMetricName(*namespace, *metricNameValue)
+		}
+	}
+
+	return metricNamesMap, nil
+}
+
+// getLatestMetricValue returns the latest metric value that has fields.
+func getLatestMetricValue(metricValues []*armmonitor.MetricValue) *armmonitor.MetricValue {
 	for index := len(metricValues) - 1; index >= 0; index-- {
-		metricFields := getMetricsClientMetricValueFields(metricValues[index])
-		if metricFields == nil {
+		if getMetricsClientMetricValueFields(metricValues[index]) == nil {
 			continue
 		}
 
-		return metricFields
+		return metricValues[index]
 	}
 
 	return nil
 }
 
-func getMetricTags(metric *armmonitor.Metric, response *armmonitor.MetricsClientListResponse) (map[string]string, error) {
-	tags := make(map[string]string)
-	subscriptionID, err := getMetricSubscriptionID(metric)
-	if err != nil {
-		return nil, err
+// getMetricValuesAfter returns the metric values that have fields and are newer than the watermark, oldest first.
+func getMetricValuesAfter(metricValues []*armmonitor.MetricValue, watermark time.Time) []*armmonitor.MetricValue {
+	newMetricValues := make([]*armmonitor.MetricValue, 0)
+	for _, metricValue := range metricValues {
+		if getMetricsClientMetricValueFields(metricValue) == nil || !metricValue.TimeStamp.After(watermark) {
+			continue
+		}
+
+		newMetricValues = append(newMetricValues, metricValue)
 	}
 
-	tags[MetricTagSubscriptionID] = *subscriptionID
+	sortMetricValues(newMetricValues)
 
-	resourceGroupName, err := getMetricResourceGroupName(metric)
+	return newMetricValues
+}
+
+func getMetricTags(metric *armmonitor.Metric, response *armmonitor.MetricsClientListResponse) (map[string]string, error) {
+	tags := make(map[string]string)
+	resourceID, err := getMetricResourceID(metric)
 	if err != nil {
 		return nil, err
 	}
 
-	tags[MetricTagResourceGroup] = *resourceGroupName
+	tags[MetricTagSubscriptionID] = resourceID.SubscriptionID()
 
-	resourceName, err := getMetricResourceName(metric)
-	if err != nil {
-		return nil, err
-	}
+	// The resource group tag is set for every resource, so all the metrics have the same tags, and is empty
+	// for resources that are not under a resource group.
+	tags[MetricTagResourceGroup] = resourceID.ResourceGroup()
+	tags[MetricTagResourceName] = resourceID.relativeName()
 
-	tags[MetricTagResourceName] = *resourceName
+	if parentNames := resourceID.ParentNames(); parentNames != nil {
+		tags[MetricTagParentResource] = strings.Join(parentNames, "/")
+	}
 
 	namespace, err := getMetricsClientResponseNamespace(response)
 	if err != nil {
@@ -328,66 +595,27 @@ func getMetricTags(metric *armmonitor.Metric, response *armmonitor.MetricsClient
 	return tags, nil
 }
 
-func getMetricSubscriptionID(metric *armmonitor.Metric) (*string, error) {
+// getMetricResourceID returns the resource ID of a metric ID, which is the resource ID followed by
+// /providers/Microsoft.Insights/metrics/<metric name>. The metric name may contain slashes.
+func getMetricResourceID(metric *armmonitor.Metric) (*ResourceID, error) {
 	metricID, err := getMetricsClientMetricID(metric)
 	if err != nil {
 		return nil, err
 	}
 
-	subscriptionID, err := getPartOfMetricID(*metricID, 0, 2, false)
-	if err != nil {
-		return nil, err
-	}
-
-	return subscriptionID, nil
-}
-
-func getMetricResourceGroupName(metric *armmonitor.Metric) (*string, error) {
-	metricID, err := getMetricsClientMetricID(metric)
-	if err != nil {
-		return nil, err
-	}
-
-	resourceGroupName, err := getPartOfMetricID(*metricID, 0, 4, false)
-	if err != nil {
-		return nil, err
-	}
-
-	return resourceGroupName, nil
-}
-
-func getMetricResourceName(metric *armmonitor.Metric) (*string, error) {
-	metricID, err := getMetricsClientMetricID(metric)
-	if err != nil {
-		return nil, err
+	index := strings.LastIndex(strings.ToLower(*metricID), metricIDMetricsInfix)
+	if index == -1 {
+		return nil, fmt.Errorf("metrics client response is bad formatted: metric ID is bad formatted")
 	}
 
-	resourceGroupName, err := getPartOfMetricID(*metricID, 1, 2, true)
+	resourceID, err := ParseResourceID((*metricID)[:index])
 	if err != nil {
-		return nil, err
-	}
-
-	return resourceGroupName, nil
-}
-
-func getPartOfMetricID(metricID string, partIndex int, partSubPartIndex int, getPartToEnd bool) (*string, error) {
-	metricIDParts := strings.Split(metricID, "/providers/")
-	if len(metricIDParts) <= partIndex {
-		return nil, fmt.Errorf("metrics client response is bad formatted: metric ID is bad formatted")
+		return nil, fmt.Errorf("metrics client response is bad formatted: metric ID is bad formatted: %v", err)
 	}
 
-	resourceIDPartSubParts := strings.Split(metricIDParts[partIndex], "/")
-	if len(resourceIDPartSubParts) <= partSubPartIndex {
+	if resourceID.IsRelative() || resourceID.Provider() == "" {
 		return nil, fmt.Errorf("metrics client response is bad formatted: metric ID is bad formatted")
 	}
 
-	var part string
-
-	if getPartToEnd {
-		part = strings.Join(resourceIDPartSubParts[partSubPartIndex:], "/")
-	} else {
-		part = resourceIDPartSubParts[partSubPartIndex]
-	}
-
-	return &part, nil
+	return resourceID, nil
 }
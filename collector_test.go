@@ -198,7 +198,7 @@ func TestGetMetricName_Success(t *testing.T) {
 	assert.Equal(t, "azure_monitor_microsoft_test_type1_metric1", *metricName)
 }
 
-func TestGetMetricFields_AllTimeseriesWithData(t *testing.T) {
+func TestCollectMetrics_AllTimeseriesWithData(t *testing.T) {
 	ammr := &AzureMonitorMetricsReceiver{
 		Targets: NewTargets(
 			[]*ResourceTarget{
@@ -214,8 +214,12 @@ func TestGetMetricFields_AllTimeseriesWithData(t *testing.T) {
 	response, err := ammr.AzureClients.MetricsClient.List(ammr.AzureClients.Ctx, ammr.Targets.ResourceTargets[0].ResourceID, nil)
 	assert.NoError(t, err)
 
-	metricFields := getMetricFields(response.Value[0].Timeseries[0].Data)
-	require.NotNil(t, metricFields)
+	metrics, notCollectedMetrics, _, err := ammr.collectMetrics(&response, ammr.selectNewMetricValues)
+	require.NoError(t, err)
+	assert.Empty(t, notCollectedMetrics)
+	require.NotEmpty(t, metrics)
+
+	metricFields := metrics[0].Fields
 
 	assert.Len(t, metricFields, 3)
 
@@ -224,7 +228,7 @@ func TestGetMetricFields_AllTimeseriesWithData(t *testing.T) {
 	assert.Equal(t, 5.0, metricFields[MetricFieldMaximum])
 }
 
-func TestGetMetricFields_LastTimeseriesWithoutData(t *testing.T) {
+func TestCollectMetrics_LastTimeseriesWithoutData(t *testing.T) {
 	ammr := &AzureMonitorMetricsReceiver{
 		Targets: NewTargets(
 			[]*ResourceTarget{
@@ -240,8 +244,12 @@ func TestGetMetricFields_LastTimeseriesWithoutData(t *testing.T) {
 	response, err := ammr.AzureClients.MetricsClient.List(ammr.AzureClients.Ctx, ammr.Targets.ResourceTargets[0].ResourceID, nil)
 	assert.NoError(t, err)
 
-	metricFields := getMetricFields(response.Value[0].Timeseries[0].Data)
-	require.NotNil(t, metricFields)
+	metrics, notCollectedMetrics, _, err := ammr.collectMetrics(&response, ammr.selectNewMetricValues)
+	require.NoError(t, err)
+	assert.Empty(t, notCollectedMetrics)
+	require.NotEmpty(t, metrics)
+
+	metricFields := metrics[0].Fields
 
 	assert.Len(t, metricFields, 3)
 
@@ -250,7 +258,7 @@ func TestGetMetricFields_LastTimeseriesWithoutData(t *testing.T) {
 	assert.Equal(t, 2.5, metricFields[MetricFieldMinimum])
 }
 
-func TestGetMetricFields_AllTimeseriesWithoutData(t *testing.T) {
+func TestCollectMetrics_AllTimeseriesWithoutData(t *testing.T) {
 	ammr := &AzureMonitorMetricsReceiver{
 		Targets: NewTargets(
 			[]*ResourceTarget{
@@ -266,11 +274,13 @@ func TestGetMetricFields_AllTimeseriesWithoutData(t *testing.T) {
 	response, err := ammr.AzureClients.MetricsClient.List(ammr.AzureClients.Ctx, ammr.Targets.ResourceTargets[0].ResourceID, nil)
 	assert.NoError(t, err)
 
-	metricFields := getMetricFields(response.Value[0].Timeseries[0].Data)
-	require.Nil(t, metricFields)
+	metrics, notCollectedMetrics, _, err := ammr.collectMetrics(&response, ammr.selectNewMetricValues)
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+	assert.Contains(t, notCollectedMetrics, *response.Value[0].ID)
 }
 
-func TestGetMetricFields_NoTimeseriesData(t *testing.T) {
+func TestCollectMetrics_NoTimeseriesData(t *testing.T) {
 	ammr := &AzureMonitorMetricsReceiver{
 		Targets: NewTargets(
 			[]*ResourceTarget{
@@ -286,8 +296,10 @@ func TestGetMetricFields_NoTimeseriesData(t *testing.T) {
 	response, err := ammr.AzureClients.MetricsClient.List(ammr.AzureClients.Ctx, ammr.Targets.ResourceTargets[0].ResourceID, nil)
 	assert.NoError(t, err)
 
-	metricFields := getMetricFields(response.Value[0].Timeseries[0].Data)
-	require.Nil(t, metricFields)
+	metrics, notCollectedMetrics, _, err := ammr.collectMetrics(&response, ammr.selectNewMetricValues)
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+	assert.Contains(t, notCollectedMetrics, *response.Value[0].ID)
 }
 
 func TestGetMetricTags_Success(t *testing.T) {
@@ -319,3 +331,111 @@ func TestGetMetricTags_Success(t *testing.T) {
 	assert.Equal(t, testResourceRegion, metricTags[MetricTagResourceRegion])
 	assert.Equal(t, string(armmonitor.MetricUnitCount), metricTags[MetricTagUnit])
 }
+
+func TestCollectResourceTargetMetrics_MetricNameLocalizedValue(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource7, []string{testMetric4}, []string{string(armmonitor.AggregationTypeEnumAverage)}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	metrics, notCollectedMetrics, err := ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+
+	assert.Len(t, metrics, 1)
+	assert.Len(t, notCollectedMetrics, 0)
+
+	assert.Equal(t, "azure_monitor_microsoft_test_type1_percentage_cpu", metrics[0].Name)
+	assert.NotContains(t, metrics[0].Tags, MetricTagMetricDisplayName)
+}
+
+func TestCollectResourceTargetMetrics_MetricNameValueWithDisplayNameTag(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource7, []string{testMetric4}, []string{string(armmonitor.AggregationTypeEnumAverage)}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:            setMockAzureClients(),
+		subscriptionID:          testSubscriptionID,
+		useMetricNameValue:      true,
+		addMetricDisplayNameTag: true,
+	}
+
+	metrics, notCollectedMetrics, err := ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+
+	assert.Len(t, metrics, 1)
+	assert.Len(t, notCollectedMetrics, 0)
+
+	assert.Equal(t, "azure_monitor_microsoft_test_type1_cpupercentage", metrics[0].Name)
+	assert.Len(t, metrics[0].Tags, 7)
+	assert.Equal(t, testMetric4DisplayName, metrics[0].Tags[MetricTagMetricDisplayName])
+}
+
+func TestCreateMetricNamesMigrationMap_Success(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource7, []string{testMetric4}, []string{}),
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource7, []string{testMetric4}, []string{}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	metricNamesMap, err := ammr.CreateMetricNamesMigrationMap()
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"azure_monitor_microsoft_test_type1_percentage_cpu": "azure_monitor_microsoft_test_type1_cpupercentage",
+	}, metricNamesMap)
+}
+
+func TestCreateMetricNamesMigrationMap_MissingLocalizedValue(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{}, []string{}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	_, err := ammr.CreateMetricNamesMigrationMap()
+	require.Error(t, err)
+}
+
+func TestCollectResourceTargetMetrics_TimeGrainInterval(t *testing.T) {
+	azureClients := setMockAzureClients()
+	metricsClient := newCountingMetricsClient()
+	azureClients.MetricsClient = metricsClient
+
+	target := NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric3}, []string{string(armmonitor.AggregationTypeEnumTotal)})
+	target.TimeGrain = "PT5M"
+
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets:        NewTargets([]*ResourceTarget{target}, []*ResourceGroupTarget{}, []*Resource{}),
+		AzureClients:   azureClients,
+		subscriptionID: testSubscriptionID,
+	}
+
+	_, _, err := ammr.CollectResourceTargetMetrics(target)
+	require.NoError(t, err)
+
+	assert.Equal(t, "PT5M", metricsClient.getMetricsInterval(testMetric3))
+}
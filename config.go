@@ -0,0 +1,730 @@
+package azuremonitormetricsreceiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ConfigFormatYAML is YAML config format.
+	ConfigFormatYAML = "yaml"
+	// ConfigFormatJSON is JSON config format.
+	ConfigFormatJSON = "json"
+
+	// DefaultCollectionInterval is the default time between collections.
+	DefaultCollectionInterval = time.Minute
+
+	// CredentialTypeClientSecret is a service principal with a client secret (client_id, client_secret, tenant_id).
+	CredentialTypeClientSecret = "client_secret"
+	// CredentialTypeClientCertificate is a service principal with a certificate (client_id, tenant_id,
+	// certificate_path and optional certificate_password).
+	CredentialTypeClientCertificate = "client_certificate"
+	// CredentialTypeManagedIdentity is a managed identity. client_id is the optional user-assigned identity.
+	CredentialTypeManagedIdentity = "managed_identity"
+	// CredentialTypeWorkloadIdentity is a workload identity federation. client_id, tenant_id and federated_token_file
+	// default to the environment variables set by the Azure workload identity webhook.
+	CredentialTypeWorkloadIdentity = "workload_identity"
+	// CredentialTypeAzureCLI is the user logged in to the Azure CLI. tenant_id is optional.
+	CredentialTypeAzureCLI = "azure_cli"
+	// CredentialTypeDefault is the Azure SDK default credential chain. tenant_id is optional.
+	CredentialTypeDefault = "default"
+)
+
+var configEnvironmentVariableRegexp = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Config is the receiver configuration that can be loaded from a YAML or JSON file.
+type Config struct {
+	Credentials            CredentialsConfig              `yaml:"credentials" json:"credentials"`
+	Cloud                  string                         `yaml:"cloud" json:"cloud"`
+	CloudEndpoints         CloudEndpointsConfig           `yaml:"cloud_endpoints" json:"cloud_endpoints"`
+	SubscriptionID         string                         `yaml:"subscription_id" json:"subscription_id"`
+	SubscriptionIDs        []string                       `yaml:"subscription_ids" json:"subscription_ids"`
+	ResourceTargets        []*ResourceTargetConfig        `yaml:"resource_targets" json:"resource_targets"`
+	ResourceGroupTargets   []*ResourceGroupTargetConfig   `yaml:"resource_group_targets" json:"resource_group_targets"`
+	SubscriptionTargets    []*ResourceConfig              `yaml:"subscription_targets" json:"subscription_targets"`
+	ResourceGraphTargets   []*ResourceGraphTargetConfig   `yaml:"resource_graph_targets" json:"resource_graph_targets"`
+	ManagementGroupTargets []*ManagementGroupTargetConfig `yaml:"management_group_targets" json:"management_group_targets"`
+	Collection             CollectionConfig               `yaml:"collection" json:"collection"`
+}
+
+// CredentialsConfig describes the Azure credentials.
+type CredentialsConfig struct {
+	// Type is the credential type. The default is client_secret.
+	Type                string `yaml:"type" json:"type"`
+	ClientID            string `yaml:"client_id" json:"client_id"`
+	ClientSecret        string `yaml:"client_secret" json:"client_secret"`
+	TenantID            string `yaml:"tenant_id" json:"tenant_id"`
+	CertificatePath     string `yaml:"certificate_path" json:"certificate_path"`
+	CertificatePassword string `yaml:"certificate_password" json:"certificate_password"`
+	FederatedTokenFile  string `yaml:"federated_token_file" json:"federated_token_file"`
+}
+
+// CloudEndpointsConfig describes the endpoints of a custom cloud.
+type CloudEndpointsConfig struct {
+	ActiveDirectoryAuthorityHost string `yaml:"active_directory_authority_host" json:"active_directory_authority_host"`
+	ResourceManagerEndpoint      string `yaml:"resource_manager_endpoint" json:"resource_manager_endpoint"`
+	ResourceManagerAudience      string `yaml:"resource_manager_audience" json:"resource_manager_audience"`
+}
+
+// ResourceTargetConfig describes a resource target.
+type ResourceTargetConfig struct {
+	ResourceID   string   `yaml:"resource_id" json:"resource_id"`
+	Metrics      []string `yaml:"metrics" json:"metrics"`
+	Aggregations []string `yaml:"aggregations" json:"aggregations"`
+	// Timeout is the timeout of every Azure Monitor API call of the target. The default is the collection request timeout.
+	Timeout string `yaml:"timeout" json:"timeout"`
+}
+
+// ResourceGroupTargetConfig describes a resource group target.
+type ResourceGroupTargetConfig struct {
+	ResourceGroup string            `yaml:"resource_group" json:"resource_group"`
+	Resources     []*ResourceConfig `yaml:"resources" json:"resources"`
+}
+
+// ManagementGroupTargetConfig describes a management group target.
+type ManagementGroupTargetConfig struct {
+	ManagementGroup string            `yaml:"management_group" json:"management_group"`
+	Resources       []*ResourceConfig `yaml:"resources" json:"resources"`
+}
+
+// ResourceConfig describes a resource by resource type.
+type ResourceConfig struct {
+	ResourceType string   `yaml:"resource_type" json:"resource_type"`
+	Metrics      []string `yaml:"metrics" json:"metrics"`
+	Aggregations []string `yaml:"aggregations" json:"aggregations"`
+	// Timeout is the timeout of every Azure Monitor API call of the target. The default is the collection request timeout.
+	Timeout string `yaml:"timeout" json:"timeout"`
+	// ExpandChildResources creates resource targets for the well-known child resources of the resources too.
+	ExpandChildResources bool `yaml:"expand_child_resources" json:"expand_child_resources"`
+}
+
+// ResourceGraphTargetConfig describes a resource graph target.
+type ResourceGraphTargetConfig struct {
+	// Query is an Azure Resource Graph query that projects the resource id column.
+	Query string `yaml:"query" json:"query"`
+	// SubscriptionIDs are the subscriptions the query runs on. The default is the receiver subscription.
+	SubscriptionIDs []string `yaml:"subscription_ids" json:"subscription_ids"`
+	Metrics         []string `yaml:"metrics" json:"metrics"`
+	Aggregations    []string `yaml:"aggregations" json:"aggregations"`
+	// Timeout is the timeout of every Azure Monitor API call of the target. The default is the collection request timeout.
+	Timeout string `yaml:"timeout" json:"timeout"`
+}
+
+// CollectionConfig describes the collection options.
+type CollectionConfig struct {
+	Interval                 string `yaml:"interval" json:"interval"`
+	TimeGrainScheduling      bool   `yaml:"time_grain_scheduling" json:"time_grain_scheduling"`
+	IngestionDelay           string `yaml:"ingestion_delay" json:"ingestion_delay"`
+	MetricNameValue          bool   `yaml:"metric_name_value" json:"metric_name_value"`
+	MetricDisplayNameTag     bool   `yaml:"metric_display_name_tag" json:"metric_display_name_tag"`
+	MetricUnitsNormalization bool   `yaml:"metric_units_normalization" json:"metric_units_normalization"`
+	PreferredTimeGrain       string `yaml:"preferred_time_grain" json:"preferred_time_grain"`
+	CheckpointFile           string `yaml:"checkpoint_file" json:"checkpoint_file"`
+	CorrectionWindow         string `yaml:"correction_window" json:"correction_window"`
+	UnsettledBuckets         int    `yaml:"unsettled_buckets" json:"unsettled_buckets"`
+	RequestTimeout           string `yaml:"request_timeout" json:"request_timeout"`
+	ResourceGraphDiscovery   bool   `yaml:"resource_graph_discovery" json:"resource_graph_discovery"`
+	// ManagementGroupsRefreshInterval is the time between refreshes of the management group targets.
+	ManagementGroupsRefreshInterval string `yaml:"management_groups_refresh_interval" json:"management_groups_refresh_interval"`
+}
+
+// LoadConfig loads a config file. Files with .json extension are parsed as JSON, other files are parsed as YAML.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %v", path, err)
+	}
+
+	format := ConfigFormatYAML
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		format = ConfigFormatJSON
+	}
+
+	config, err := ParseConfig(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// ParseConfig parses a YAML or JSON config. References to environment variables in the form of ${VAR} or
+// ${VAR:-default} in the config values are replaced with the environment variables values after parsing, so the
+// values can contain any character, and $$ is replaced with $. References in YAML comments are ignored.
+func ParseConfig(data []byte, format string) (*Config, error) {
+	config := &Config{}
+
+	switch format {
+	case ConfigFormatYAML:
+		if err := parseYAMLConfig(data, config); err != nil {
+			return nil, err
+		}
+	case ConfigFormatJSON:
+		if err := parseJSONConfig(data, config); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("config format %s is not supported", format)
+	}
+
+	return config, nil
+}
+
+func parseYAMLConfig(data []byte, config *Config) error {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return err
+	}
+
+	if document.Kind == 0 {
+		return nil
+	}
+
+	interpolator := &configInterpolator{}
+	interpolator.interpolateYAMLNode(&document)
+	if err := interpolator.getError(); err != nil {
+		return err
+	}
+
+	return document.Decode(config)
+}
+
+func parseJSONConfig(data []byte, config *Config) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("config is bad formatted: unexpected data after the JSON value")
+	}
+
+	interpolator := &configInterpolator{}
+	document = interpolator.interpolateJSONValue(document)
+	if err := interpolator.getError(); err != nil {
+		return err
+	}
+
+	interpolatedData, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(interpolatedData, config)
+}
+
+// configInterpolator replaces references to environment variables in parsed config values.
+type configInterpolator struct {
+	missingEnvironmentVariables []string
+}
+
+// interpolateYAMLNode replaces references to environment variables in the scalar values of the node and its
+// descendants. Plain scalars are resolved again after the replacement, so ${VAR} can be used for non-string values.
+func (ci *configInterpolator) interpolateYAMLNode(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, childNode := range node.Content {
+			ci.interpolateYAMLNode(childNode)
+		}
+	case yaml.MappingNode:
+		// Mapping keys are at even indexes and are not interpolated.
+		for index := 1; index < len(node.Content); index += 2 {
+			ci.interpolateYAMLNode(node.Content[index])
+		}
+	case yaml.ScalarNode:
+		value := ci.interpolateString(node.Value)
+		if value == node.Value {
+			return
+		}
+
+		node.Value = value
+		if node.Style == 0 && !isYAMLNullValue(value) {
+			node.Tag = ""
+		} else {
+			node.Tag = "!!str"
+		}
+	}
+}
+
+func isYAMLNullValue(value string) bool {
+	switch value {
+	case "", "~", "null", "Null", "NULL":
+		return true
+	default:
+		return false
+	}
+}
+
+// interpolateJSONValue replaces references to environment variables in the string values of a decoded JSON value.
+func (ci *configInterpolator) interpolateJSONValue(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case string:
+		return ci.interpolateString(typedValue)
+	case []interface{}:
+		for index, item := range typedValue {
+			typedValue[index] = ci.interpolateJSONValue(item)
+		}
+	case map[string]interface{}:
+		for key, item := range typedValue {
+			typedValue[key] = ci.interpolateJSONValue(item)
+		}
+	}
+
+	return value
+}
+
+func (ci *configInterpolator) interpolateString(value string) string {
+	return configEnvironmentVariableRegexp.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+
+		submatches := configEnvironmentVariableRegexp.FindStringSubmatch(match)
+		name := submatches[1]
+
+		if environmentVariableValue, found := os.LookupEnv(name); found {
+			return environmentVariableValue
+		}
+
+		if submatches[2] != "" {
+			return submatches[3]
+		}
+
+		ci.missingEnvironmentVariables = append(ci.missingEnvironmentVariables, name)
+		return ""
+	})
+}
+
+func (ci *configInterpolator) getError() error {
+	if len(ci.missingEnvironmentVariables) > 0 {
+		return fmt.Errorf("config references environment variables that are not set: %s", strings.Join(ci.missingEnvironmentVariables, ", "))
+	}
+
+	return nil
+}
+
+// Validate checks the config without calling Azure.
+func (c *Config) Validate() error {
+	if err := c.Credentials.Validate(); err != nil {
+		return err
+	}
+
+	if _, err := c.GetAzureCloud(); err != nil {
+		return err
+	}
+
+	if _, err := c.GetCollectionInterval(); err != nil {
+		return err
+	}
+
+	if _, err := c.GetIngestionDelay(); err != nil {
+		return err
+	}
+
+	if _, err := c.GetCorrectionWindow(); err != nil {
+		return err
+	}
+
+	if c.Collection.UnsettledBuckets < 0 {
+		return fmt.Errorf("collection unsettled buckets must not be negative")
+	}
+
+	if _, err := c.GetRequestTimeout(); err != nil {
+		return err
+	}
+
+	if _, err := c.GetManagementGroupsRefreshInterval(); err != nil {
+		return err
+	}
+
+	if err := c.checkTargetsTimeouts(); err != nil {
+		return err
+	}
+
+	subscriptionIDs := c.GetSubscriptionIDs()
+	if len(subscriptionIDs) == 0 {
+		return fmt.Errorf("subscription ID is empty or missing")
+	}
+
+	for _, subscriptionID := range subscriptionIDs {
+		ammr := &AzureMonitorMetricsReceiver{
+			Targets:        c.CreateTargets(),
+			subscriptionID: subscriptionID,
+		}
+
+		if err := ammr.checkValidation(); err != nil {
+			return fmt.Errorf("subscription %s: %v", subscriptionID, err)
+		}
+	}
+
+	return nil
+}
+
+// GetType returns the credential type.
+func (cc *CredentialsConfig) GetType() string {
+	if cc.Type == "" {
+		return CredentialTypeClientSecret
+	}
+
+	return cc.Type
+}
+
+// Validate checks that the credentials have the fields their type requires.
+func (cc *CredentialsConfig) Validate() error {
+	switch cc.GetType() {
+	case CredentialTypeClientSecret:
+		if cc.ClientID == "" || cc.ClientSecret == "" || cc.TenantID == "" {
+			return fmt.Errorf("credentials client_id, client_secret and tenant_id are required")
+		}
+	case CredentialTypeClientCertificate:
+		if cc.ClientID == "" || cc.TenantID == "" || cc.CertificatePath == "" {
+			return fmt.Errorf("credentials client_id, tenant_id and certificate_path are required")
+		}
+	case CredentialTypeManagedIdentity, CredentialTypeWorkloadIdentity, CredentialTypeAzureCLI, CredentialTypeDefault:
+	default:
+		return fmt.Errorf("credentials type %s is not supported", cc.Type)
+	}
+
+	return nil
+}
+
+// CreateAzureClients creates Azure clients with the credentials of the credentials type.
+// The credentials are not used if the Azure clients replay a fixture.
+func (cc *CredentialsConfig) CreateAzureClients(subscriptionID string, clientOptions ...func(*AzureClientOptions)) (*AzureClients, error) {
+	if newAzureClientOptions(clientOptions).fixtureReplayer != nil {
+		return CreateAzureClientsWithCreds(subscriptionID, fixtureTokenCredential{}, clientOptions...)
+	}
+
+	switch cc.GetType() {
+	case CredentialTypeClientSecret:
+		return CreateAzureClients(subscriptionID, cc.ClientID, cc.ClientSecret, cc.TenantID, clientOptions...)
+	case CredentialTypeClientCertificate:
+		return CreateAzureClientsWithClientCertificate(subscriptionID, cc.ClientID, cc.TenantID, cc.CertificatePath, cc.CertificatePassword, clientOptions...)
+	case CredentialTypeManagedIdentity:
+		return CreateAzureClientsWithManagedIdentity(subscriptionID, cc.ClientID, clientOptions...)
+	case CredentialTypeWorkloadIdentity:
+		return CreateAzureClientsWithWorkloadIdentity(subscriptionID, cc.ClientID, cc.TenantID, cc.FederatedTokenFile, clientOptions...)
+	case CredentialTypeAzureCLI:
+		return CreateAzureClientsWithAzureCLI(subscriptionID, cc.TenantID, clientOptions...)
+	case CredentialTypeDefault:
+		return CreateAzureClientsWithDefaultCredential(subscriptionID, cc.TenantID, clientOptions...)
+	default:
+		return nil, fmt.Errorf("credentials type %s is not supported", cc.Type)
+	}
+}
+
+// GetSubscriptionIDs returns the config subscription IDs.
+func (c *Config) GetSubscriptionIDs() []string {
+	subscriptionIDs := make([]string, 0, len(c.SubscriptionIDs)+1)
+	if c.SubscriptionID != "" {
+		subscriptionIDs = append(subscriptionIDs, c.SubscriptionID)
+	}
+
+	for _, subscriptionID := range c.SubscriptionIDs {
+		if subscriptionID != c.SubscriptionID {
+			subscriptionIDs = append(subscriptionIDs, subscriptionID)
+		}
+	}
+
+	return subscriptionIDs
+}
+
+// GetCollectionInterval returns the config collection interval.
+func (c *Config) GetCollectionInterval() (time.Duration, error) {
+	if c.Collection.Interval == "" {
+		return DefaultCollectionInterval, nil
+	}
+
+	interval, err := time.ParseDuration(c.Collection.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("collection interval is bad formatted: %v", err)
+	}
+
+	if interval <= 0 {
+		return 0, fmt.Errorf("collection interval must be positive")
+	}
+
+	return interval, nil
+}
+
+// GetIngestionDelay returns the config ingestion delay.
+func (c *Config) GetIngestionDelay() (time.Duration, error) {
+	if c.Collection.IngestionDelay == "" {
+		return 0, nil
+	}
+
+	ingestionDelay, err := time.ParseDuration(c.Collection.IngestionDelay)
+	if err != nil {
+		return 0, fmt.Errorf("collection ingestion delay is bad formatted: %v", err)
+	}
+
+	if ingestionDelay < 0 {
+		return 0, fmt.Errorf("collection ingestion delay must not be negative")
+	}
+
+	return ingestionDelay, nil
+}
+
+// GetCorrectionWindow returns the config correction window.
+func (c *Config) GetCorrectionWindow() (time.Duration, error) {
+	if c.Collection.CorrectionWindow == "" {
+		return 0, nil
+	}
+
+	correctionWindow, err := time.ParseDuration(c.Collection.CorrectionWindow)
+	if err != nil {
+		return 0, fmt.Errorf("collection correction window is bad formatted: %v", err)
+	}
+
+	if correctionWindow < 0 {
+		return 0, fmt.Errorf("collection correction window must not be negative")
+	}
+
+	return correctionWindow, nil
+}
+
+// GetRequestTimeout returns the config request timeout. Zero means no timeout.
+func (c *Config) GetRequestTimeout() (time.Duration, error) {
+	return parseConfigTimeout(c.Collection.RequestTimeout, "collection request timeout")
+}
+
+// GetManagementGroupsRefreshInterval returns the config management groups refresh interval.
+func (c *Config) GetManagementGroupsRefreshInterval() (time.Duration, error) {
+	if c.Collection.ManagementGroupsRefreshInterval == "" {
+		return DefaultManagementGroupsRefreshInterval, nil
+	}
+
+	refreshInterval, err := time.ParseDuration(c.Collection.ManagementGroupsRefreshInterval)
+	if err != nil {
+		return 0, fmt.Errorf("collection management groups refresh interval is bad formatted: %v", err)
+	}
+
+	if refreshInterval <= 0 {
+		return 0, fmt.Errorf("collection management groups refresh interval must be positive")
+	}
+
+	return refreshInterval, nil
+}
+
+func (c *Config) checkTargetsTimeouts() error {
+	for index, target := range c.ResourceTargets {
+		if _, err := parseConfigTimeout(target.Timeout, fmt.Sprintf("resource target #%d timeout", index+1)); err != nil {
+			return err
+		}
+	}
+
+	for resourceGroupIndex, target := range c.ResourceGroupTargets {
+		for resourceIndex, resource := range target.Resources {
+			name := fmt.Sprintf("resource group target #%d resource #%d timeout", resourceGroupIndex+1, resourceIndex+1)
+			if _, err := parseConfigTimeout(resource.Timeout, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	for index, target := range c.SubscriptionTargets {
+		if _, err := parseConfigTimeout(target.Timeout, fmt.Sprintf("subscription target #%d timeout", index+1)); err != nil {
+			return err
+		}
+	}
+
+	for index, target := range c.ResourceGraphTargets {
+		if _, err := parseConfigTimeout(target.Timeout, fmt.Sprintf("resource graph target #%d timeout", index+1)); err != nil {
+			return err
+		}
+	}
+
+	for managementGroupIndex, target := range c.ManagementGroupTargets {
+		for resourceIndex, resource := range target.Resources {
+			name := fmt.Sprintf("management group target #%d resource #%d timeout", managementGroupIndex+1, resourceIndex+1)
+			if _, err := parseConfigTimeout(resource.Timeout, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetAzureCloud returns the config Azure cloud configuration. The default is the Azure public cloud.
+func (c *Config) GetAzureCloud() (cloud.Configuration, error) {
+	if strings.ToLower(c.Cloud) == CloudCustom {
+		return NewCustomAzureCloud(c.CloudEndpoints.ActiveDirectoryAuthorityHost, c.CloudEndpoints.ResourceManagerEndpoint, c.CloudEndpoints.ResourceManagerAudience)
+	}
+
+	return GetAzureCloud(c.Cloud)
+}
+
+// CreateReceivers creates a receiver for every config subscription.
+func (c *Config) CreateReceivers(clientOptions ...func(*AzureClientOptions)) ([]*AzureMonitorMetricsReceiver, error) {
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("got validation error: %v", err)
+	}
+
+	receivers := make([]*AzureMonitorMetricsReceiver, 0)
+	receiverOptions := c.createReceiverOptions()
+
+	if c.Cloud != "" {
+		azureCloud, err := c.GetAzureCloud()
+		if err != nil {
+			return nil, err
+		}
+
+		clientOptions = append(append([]func(*AzureClientOptions){}, clientOptions...), WithAzureCloud(azureCloud))
+	}
+
+	for _, subscriptionID := range c.GetSubscriptionIDs() {
+		azureClients, err := c.Credentials.CreateAzureClients(subscriptionID, clientOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("error creating Azure clients for subscription %s: %v", subscriptionID, err)
+		}
+
+		ammr, err := NewAzureMonitorMetricsReceiver(subscriptionID, c.CreateTargets(), azureClients, receiverOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("error creating receiver for subscription %s: %v", subscriptionID, err)
+		}
+
+		receivers = append(receivers, ammr)
+	}
+
+	return receivers, nil
+}
+
+// CreateReceiver creates a receiver for the config subscription. The config must have a single subscription.
+func (c *Config) CreateReceiver(clientOptions ...func(*AzureClientOptions)) (*AzureMonitorMetricsReceiver, error) {
+	if len(c.GetSubscriptionIDs()) > 1 {
+		return nil, fmt.Errorf("config has more than one subscription, use CreateReceivers instead")
+	}
+
+	receivers, err := c.CreateReceivers(clientOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return receivers[0], nil
+}
+
+// CreateTargets creates the config targets.
+func (c *Config) CreateTargets() *Targets {
+	resourceTargets := make([]*ResourceTarget, 0, len(c.ResourceTargets))
+	for _, target := range c.ResourceTargets {
+		resourceTarget := NewResourceTarget(target.ResourceID, copyStrings(target.Metrics), copyStrings(target.Aggregations))
+		resourceTarget.Timeout, _ = parseConfigTimeout(target.Timeout, "")
+		resourceTargets = append(resourceTargets, resourceTarget)
+	}
+
+	resourceGroupTargets := make([]*ResourceGroupTarget, 0, len(c.ResourceGroupTargets))
+	for _, target := range c.ResourceGroupTargets {
+		resourceGroupTargets = append(resourceGroupTargets, NewResourceGroupTarget(target.ResourceGroup, createConfigResources(target.Resources)))
+	}
+
+	targets := NewTargets(resourceTargets, resourceGroupTargets, createConfigResources(c.SubscriptionTargets))
+	for _, target := range c.ResourceGraphTargets {
+		resourceGraphTarget := NewResourceGraphTarget(target.Query, copyStrings(target.Metrics), copyStrings(target.Aggregations))
+		resourceGraphTarget.SetSubscriptions(copyStrings(target.SubscriptionIDs))
+		timeout, _ := parseConfigTimeout(target.Timeout, "")
+		resourceGraphTarget.SetTimeout(timeout)
+		targets.AddResourceGraphTargets(resourceGraphTarget)
+	}
+
+	for _, target := range c.ManagementGroupTargets {
+		targets.AddManagementGroupTargets(NewManagementGroupTarget(target.ManagementGroup, createConfigResources(target.Resources)))
+	}
+
+	return targets
+}
+
+func (c *Config) createReceiverOptions() []ReceiverOptions {
+	receiverOptions := make([]ReceiverOptions, 0)
+
+	if c.Collection.MetricNameValue {
+		receiverOptions = append(receiverOptions, WithMetricNameValue())
+	}
+
+	if c.Collection.MetricDisplayNameTag {
+		receiverOptions = append(receiverOptions, WithMetricDisplayNameTag())
+	}
+
+	if c.Collection.MetricUnitsNormalization {
+		receiverOptions = append(receiverOptions, WithMetricUnitsNormalization())
+	}
+
+	if c.Collection.PreferredTimeGrain != "" {
+		receiverOptions = append(receiverOptions, WithPreferredTimeGrain(c.Collection.PreferredTimeGrain))
+	}
+
+	if correctionWindow, err := c.GetCorrectionWindow(); err == nil && correctionWindow > 0 {
+		receiverOptions = append(receiverOptions, WithCorrectionWindow(correctionWindow))
+	}
+
+	if c.Collection.UnsettledBuckets > 0 {
+		receiverOptions = append(receiverOptions, WithUnsettledBucketsSkipping(c.Collection.UnsettledBuckets))
+	}
+
+	if requestTimeout, err := c.GetRequestTimeout(); err == nil && requestTimeout > 0 {
+		receiverOptions = append(receiverOptions, WithRequestTimeout(requestTimeout))
+	}
+
+	if c.Collection.ResourceGraphDiscovery {
+		receiverOptions = append(receiverOptions, WithResourceGraphDiscovery())
+	}
+
+	if refreshInterval, err := c.GetManagementGroupsRefreshInterval(); err == nil && c.Collection.ManagementGroupsRefreshInterval != "" {
+		receiverOptions = append(receiverOptions, WithManagementGroupsRefreshInterval(refreshInterval))
+	}
+
+	// All receivers share the checkpoint store, so they do not overwrite each other's watermarks.
+	if c.Collection.CheckpointFile != "" {
+		receiverOptions = append(receiverOptions, WithCheckpointStore(NewFileCheckpointStore(c.Collection.CheckpointFile)))
+	}
+
+	return receiverOptions
+}
+
+func createConfigResources(resourceConfigs []*ResourceConfig) []*Resource {
+	resources := make([]*Resource, 0, len(resourceConfigs))
+	for _, resource := range resourceConfigs {
+		newResource := NewResource(resource.ResourceType, copyStrings(resource.Metrics), copyStrings(resource.Aggregations))
+		timeout, _ := parseConfigTimeout(resource.Timeout, "")
+		newResource.SetTimeout(timeout)
+		newResource.SetChildResourcesExpansion(resource.ExpandChildResources)
+		resources = append(resources, newResource)
+	}
+
+	return resources
+}
+
+// parseConfigTimeout parses a config timeout. An empty timeout is zero, which means no timeout.
+func parseConfigTimeout(timeout string, name string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("%s is bad formatted: %v", name, err)
+	}
+
+	if duration < 0 {
+		return 0, fmt.Errorf("%s must not be negative", name)
+	}
+
+	return duration, nil
+}
+
+func copyStrings(values []string) []string {
+	return append(make([]string, 0, len(values)), values...)
+}
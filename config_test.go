@@ -0,0 +1,315 @@
+package azuremonitormetricsreceiver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testYAMLConfig = `
+credentials:
+  client_id: clientID
+  client_secret: ${TEST_AZURE_CLIENT_SECRET}
+  tenant_id: ${TEST_AZURE_TENANT_ID:-tenantID}
+subscription_id: subscriptionID
+resource_targets:
+  - resource_id: resourceGroups/resourceGroup1/providers/Microsoft.Test/type1/resource1
+    metrics: [metric1, metric2]
+    aggregations: [Total]
+resource_group_targets:
+  - resource_group: resourceGroup1
+    resources:
+      - resource_type: Microsoft.Test/type1
+subscription_targets:
+  - resource_type: Microsoft.Test/type2
+    aggregations: [Average, Maximum]
+collection:
+  interval: 5m
+  time_grain_scheduling: true
+  ingestion_delay: 30s
+  metric_name_value: true
+  metric_units_normalization: true
+`
+
+const testJSONConfig = `{
+	"credentials": {"client_id": "clientID", "client_secret": "clientSecret", "tenant_id": "tenantID"},
+	"subscription_ids": ["subscriptionID1", "subscriptionID2"],
+	"subscription_targets": [{"resource_type": "Microsoft.Test/type1"}]
+}`
+
+func TestParseConfig_YAML(t *testing.T) {
+	t.Setenv("TEST_AZURE_CLIENT_SECRET", testClientSecret)
+
+	config, err := ParseConfig([]byte(testYAMLConfig), ConfigFormatYAML)
+	require.NoError(t, err)
+	require.NoError(t, config.Validate())
+
+	assert.Equal(t, CredentialsConfig{ClientID: testClientID, ClientSecret: testClientSecret, TenantID: testTenantID}, config.Credentials)
+	assert.Equal(t, []string{testSubscriptionID}, config.GetSubscriptionIDs())
+	require.Len(t, config.ResourceTargets, 1)
+	assert.Equal(t, testResourceGroup1ResourceType1Resource1, config.ResourceTargets[0].ResourceID)
+	assert.Equal(t, []string{testMetric1, testMetric2}, config.ResourceTargets[0].Metrics)
+	require.Len(t, config.ResourceGroupTargets, 1)
+	assert.Equal(t, testResourceType1, config.ResourceGroupTargets[0].Resources[0].ResourceType)
+	require.Len(t, config.SubscriptionTargets, 1)
+	assert.Equal(t, []string{"Average", "Maximum"}, config.SubscriptionTargets[0].Aggregations)
+
+	interval, err := config.GetCollectionInterval()
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, interval)
+
+	ingestionDelay, err := config.GetIngestionDelay()
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, ingestionDelay)
+	assert.True(t, config.Collection.TimeGrainScheduling)
+	assert.Len(t, config.createReceiverOptions(), 2)
+}
+
+func TestParseConfig_MissingEnvironmentVariable(t *testing.T) {
+	_, err := ParseConfig([]byte(testYAMLConfig), ConfigFormatYAML)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "TEST_AZURE_CLIENT_SECRET")
+}
+
+func TestParseConfig_EscapedDollar(t *testing.T) {
+	config, err := ParseConfig([]byte(`{"credentials": {"client_secret": "a$$b"}}`), ConfigFormatJSON)
+	require.NoError(t, err)
+
+	assert.Equal(t, "a$b", config.Credentials.ClientSecret)
+}
+
+func TestParseConfig_EnvironmentVariableSpecialCharacters(t *testing.T) {
+	secret := "a\"b\\c: d #e\nsubscription_id: injected"
+	t.Setenv("TEST_AZURE_CLIENT_SECRET", secret)
+
+	for format, data := range map[string]string{
+		ConfigFormatYAML: "credentials:\n  client_secret: ${TEST_AZURE_CLIENT_SECRET}\nsubscription_id: subscriptionID\n",
+		ConfigFormatJSON: `{"credentials": {"client_secret": "${TEST_AZURE_CLIENT_SECRET}"}, "subscription_id": "subscriptionID"}`,
+	} {
+		t.Run(format, func(t *testing.T) {
+			config, err := ParseConfig([]byte(data), format)
+			require.NoError(t, err)
+
+			assert.Equal(t, secret, config.Credentials.ClientSecret)
+			assert.Equal(t, testSubscriptionID, config.SubscriptionID)
+		})
+	}
+}
+
+func TestParseConfig_EnvironmentVariableNonStringValue(t *testing.T) {
+	t.Setenv("TEST_TIME_GRAIN_SCHEDULING", "true")
+
+	config, err := ParseConfig([]byte(`
+collection:
+  time_grain_scheduling: ${TEST_TIME_GRAIN_SCHEDULING}
+  unsettled_buckets: ${TEST_UNSETTLED_BUCKETS:-2}
+`), ConfigFormatYAML)
+	require.NoError(t, err)
+
+	assert.True(t, config.Collection.TimeGrainScheduling)
+	assert.Equal(t, 2, config.Collection.UnsettledBuckets)
+}
+
+func TestParseConfig_EnvironmentVariableNullValue(t *testing.T) {
+	t.Setenv("TEST_AZURE_CLIENT_SECRET", "null")
+
+	config, err := ParseConfig([]byte("credentials:\n  client_secret: ${TEST_AZURE_CLIENT_SECRET}\n"), ConfigFormatYAML)
+	require.NoError(t, err)
+
+	assert.Equal(t, "null", config.Credentials.ClientSecret)
+}
+
+func TestParseConfig_EnvironmentVariableInComment(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+# client_secret: ${TEST_NOT_SET_ENVIRONMENT_VARIABLE}
+subscription_id: subscriptionID
+`), ConfigFormatYAML)
+	require.NoError(t, err)
+
+	assert.Equal(t, testSubscriptionID, config.SubscriptionID)
+}
+
+func TestParseConfig_UnsupportedFormat(t *testing.T) {
+	_, err := ParseConfig([]byte(testJSONConfig), "toml")
+	require.Error(t, err)
+}
+
+func TestLoadConfig_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(testJSONConfig), 0o600))
+
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"subscriptionID1", "subscriptionID2"}, config.GetSubscriptionIDs())
+
+	interval, err := config.GetCollectionInterval()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultCollectionInterval, interval)
+}
+
+func TestConfigValidate_NoCredentials(t *testing.T) {
+	config, err := ParseConfig([]byte(`{"subscription_id": "subscriptionID", "subscription_targets": [{"resource_type": "Microsoft.Test/type1"}]}`), ConfigFormatJSON)
+	require.NoError(t, err)
+
+	require.Error(t, config.Validate())
+}
+
+func TestConfigValidate_CredentialsTypes(t *testing.T) {
+	config, err := ParseConfig([]byte(testJSONConfig), ConfigFormatJSON)
+	require.NoError(t, err)
+
+	config.Credentials = CredentialsConfig{Type: CredentialTypeManagedIdentity}
+	require.NoError(t, config.Validate())
+
+	config.Credentials = CredentialsConfig{Type: CredentialTypeWorkloadIdentity}
+	require.NoError(t, config.Validate())
+
+	config.Credentials = CredentialsConfig{Type: CredentialTypeAzureCLI}
+	require.NoError(t, config.Validate())
+
+	config.Credentials = CredentialsConfig{Type: CredentialTypeDefault}
+	require.NoError(t, config.Validate())
+
+	config.Credentials = CredentialsConfig{Type: CredentialTypeClientCertificate, ClientID: testClientID, TenantID: testTenantID}
+	require.Error(t, config.Validate())
+
+	config.Credentials.CertificatePath = "certificate.pem"
+	require.NoError(t, config.Validate())
+
+	config.Credentials = CredentialsConfig{Type: "password"}
+	require.Error(t, config.Validate())
+}
+
+func TestConfigCreateReceivers_ManagedIdentity(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+credentials:
+  type: managed_identity
+  client_id: clientID
+subscription_id: subscriptionID
+subscription_targets:
+  - resource_type: Microsoft.Test/type1
+`), ConfigFormatYAML)
+	require.NoError(t, err)
+
+	receivers, err := config.CreateReceivers()
+	require.NoError(t, err)
+	assert.Len(t, receivers, 1)
+}
+
+func TestConfigValidate_NoTargets(t *testing.T) {
+	config, err := ParseConfig([]byte(`{"credentials": {"client_id": "clientID", "client_secret": "clientSecret", "tenant_id": "tenantID"}, "subscription_id": "subscriptionID"}`), ConfigFormatJSON)
+	require.NoError(t, err)
+
+	require.Error(t, config.Validate())
+}
+
+func TestConfigValidate_BadInterval(t *testing.T) {
+	config, err := ParseConfig([]byte(testJSONConfig), ConfigFormatJSON)
+	require.NoError(t, err)
+
+	config.Collection.Interval = "often"
+	require.Error(t, config.Validate())
+}
+
+func TestConfigValidate_NegativeIngestionDelay(t *testing.T) {
+	config, err := ParseConfig([]byte(testJSONConfig), ConfigFormatJSON)
+	require.NoError(t, err)
+
+	config.Collection.IngestionDelay = "-1m"
+	require.Error(t, config.Validate())
+}
+
+func TestConfigValidate_BadCorrectionWindow(t *testing.T) {
+	config, err := ParseConfig([]byte(testJSONConfig), ConfigFormatJSON)
+	require.NoError(t, err)
+
+	config.Collection.CorrectionWindow = "recent"
+	require.Error(t, config.Validate())
+
+	config.Collection.CorrectionWindow = "10m"
+	config.Collection.UnsettledBuckets = -1
+	require.Error(t, config.Validate())
+}
+
+func TestConfigValidate_Timeouts(t *testing.T) {
+	config, err := ParseConfig([]byte(testJSONConfig), ConfigFormatJSON)
+	require.NoError(t, err)
+
+	config.Collection.RequestTimeout = "soon"
+	require.Error(t, config.Validate())
+
+	config.Collection.RequestTimeout = "30s"
+	config.SubscriptionTargets[0].Timeout = "-1s"
+	require.Error(t, config.Validate())
+
+	config.SubscriptionTargets[0].Timeout = "2m"
+	require.NoError(t, config.Validate())
+
+	requestTimeout, err := config.GetRequestTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, requestTimeout)
+	assert.Equal(t, 2*time.Minute, config.CreateTargets().subscriptionTargets[0].timeout)
+}
+
+func TestConfigValidate_Cloud(t *testing.T) {
+	config, err := ParseConfig([]byte(testJSONConfig), ConfigFormatJSON)
+	require.NoError(t, err)
+
+	config.Cloud = "mars"
+	require.Error(t, config.Validate())
+
+	config.Cloud = CloudCustom
+	require.Error(t, config.Validate())
+
+	config.CloudEndpoints = CloudEndpointsConfig{
+		ActiveDirectoryAuthorityHost: "https://login.example.com/",
+		ResourceManagerEndpoint:      "https://management.example.com",
+	}
+	require.NoError(t, config.Validate())
+
+	config.Cloud = "usgovernment"
+	azureCloud, err := config.GetAzureCloud()
+	require.NoError(t, err)
+	assert.Equal(t, cloud.AzureGovernment.ActiveDirectoryAuthorityHost, azureCloud.ActiveDirectoryAuthorityHost)
+
+	receivers, err := config.CreateReceivers()
+	require.NoError(t, err)
+	assert.Len(t, receivers, 2)
+}
+
+func TestConfigCreateReceivers_MultipleSubscriptions(t *testing.T) {
+	config, err := ParseConfig([]byte(testJSONConfig), ConfigFormatJSON)
+	require.NoError(t, err)
+
+	receivers, err := config.CreateReceivers()
+	require.NoError(t, err)
+	require.Len(t, receivers, 2)
+
+	assert.Equal(t, "subscriptionID1", receivers[0].subscriptionID)
+	assert.Equal(t, "subscriptionID2", receivers[1].subscriptionID)
+	assert.NotSame(t, receivers[0].Targets.subscriptionTargets[0], receivers[1].Targets.subscriptionTargets[0])
+
+	_, err = config.CreateReceiver()
+	require.Error(t, err)
+}
+
+func TestConfigCreateReceiver_Success(t *testing.T) {
+	t.Setenv("TEST_AZURE_CLIENT_SECRET", testClientSecret)
+
+	config, err := ParseConfig([]byte(testYAMLConfig), ConfigFormatYAML)
+	require.NoError(t, err)
+
+	ammr, err := config.CreateReceiver()
+	require.NoError(t, err)
+
+	assert.Equal(t, testFullResourceGroup1ResourceType1Resource1, ammr.Targets.ResourceTargets[0].ResourceID)
+	assert.True(t, ammr.useMetricNameValue)
+	assert.True(t, ammr.normalizeMetricUnits)
+	assert.False(t, ammr.addMetricDisplayNameTag)
+}
@@ -0,0 +1,118 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectResourceTargetMetricsWithContext_TargetTimeout(t *testing.T) {
+	metricsClient := &hangingMetricsClient{}
+	ammr := newTestReceiver(withTestMetricsClient(metricsClient), WithRequestTimeout(time.Hour))
+
+	target := ammr.Targets.ResourceTargets[0]
+	target.Timeout = 10 * time.Millisecond
+
+	start := time.Now()
+	metrics, notCollectedMetrics, err := ammr.CollectResourceTargetMetricsWithContext(context.Background(), target)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+	assert.Nil(t, metrics)
+	assert.Nil(t, notCollectedMetrics)
+
+	deadlines := metricsClient.getDeadlines()
+	require.Len(t, deadlines, 1)
+	assert.True(t, deadlines[0].Before(start.Add(time.Minute)))
+}
+
+func TestCollectResourceTargetMetricsWithContext_RequestTimeout(t *testing.T) {
+	metricsClient := &hangingMetricsClient{}
+	ammr := newTestReceiver(withTestMetricsClient(metricsClient), WithRequestTimeout(10*time.Millisecond))
+
+	_, _, err := ammr.CollectResourceTargetMetricsWithContext(context.Background(), ammr.Targets.ResourceTargets[0])
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+	assert.Len(t, metricsClient.getDeadlines(), 1)
+}
+
+func TestCollectResourceTargetMetricsWithContext_Canceled(t *testing.T) {
+	metricsClient := &hangingMetricsClient{}
+	ammr := newTestReceiver(withTestMetricsClient(metricsClient))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	_, _, err := ammr.CollectResourceTargetMetricsWithContext(ctx, ammr.Targets.ResourceTargets[0])
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.Canceled.Error())
+	assert.Empty(t, metricsClient.getDeadlines())
+}
+
+func TestCollectResourceTargetMetrics_AzureClientsContext(t *testing.T) {
+	metricsClient := &hangingMetricsClient{}
+	ammr := newTestReceiver(withTestMetricsClient(metricsClient))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ammr.AzureClients.Ctx = ctx
+
+	_, _, err := ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.Canceled.Error())
+}
+
+func TestCollectResourceTargetsMetricsToSinkWithContext_Canceled(t *testing.T) {
+	metricsClient := &hangingMetricsClient{}
+	ammr := newTestReceiver(withTestMetricsClient(metricsClient))
+	sink := &mockSink{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ammr.CollectResourceTargetsMetricsToSinkWithContext(ctx, sink)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, metricsClient.getCalls())
+	assert.Empty(t, sink.getBatches())
+}
+
+func TestInitializeTargetsWithContext_Timeouts(t *testing.T) {
+	resource := NewResource(testResourceType1, []string{}, []string{})
+	resource.SetTimeout(time.Minute)
+
+	target := NewResourceTarget(testResourceGroup1ResourceType2Resource2, []string{}, []string{})
+	target.Timeout = 2 * time.Minute
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID,
+		NewTargets([]*ResourceTarget{target}, []*ResourceGroupTarget{}, []*Resource{resource}),
+		setMockAzureClients(),
+		WithRequestTimeout(time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, ammr.InitializeTargetsWithContext(context.Background()))
+	require.NotEmpty(t, ammr.Targets.ResourceTargets)
+
+	for _, resourceTarget := range ammr.Targets.ResourceTargets {
+		if resourceTarget.ResourceID == testFullResourceGroup1ResourceType2Resource2 {
+			assert.Equal(t, 2*time.Minute, resourceTarget.Timeout)
+		} else {
+			assert.Equal(t, time.Minute, resourceTarget.Timeout)
+		}
+	}
+}
+
+func TestNewAzureMonitorMetricsReceiver_NegativeTimeout(t *testing.T) {
+	targets := NewTargets([]*ResourceTarget{NewResourceTarget(testResourceGroup1ResourceType1Resource1, []string{}, []string{})}, []*ResourceGroupTarget{}, []*Resource{})
+	_, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, targets, setMockAzureClients(), WithRequestTimeout(-time.Second))
+	require.Error(t, err)
+
+	target := NewResourceTarget(testResourceGroup1ResourceType1Resource1, []string{}, []string{})
+	target.Timeout = -time.Second
+	_, err = NewAzureMonitorMetricsReceiver(testSubscriptionID, NewTargets([]*ResourceTarget{target}, []*ResourceGroupTarget{}, []*Resource{}), setMockAzureClients())
+	require.Error(t, err)
+}
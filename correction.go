@@ -0,0 +1,182 @@
+package azuremonitormetricsreceiver
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+)
+
+// collectionUpdate is the watermarks and the emitted metric values of a collection.
+// It is committed to the receiver only after the collected metrics are emitted.
+type collectionUpdate struct {
+	watermarks          map[string]time.Time
+	emittedMetricValues map[string]map[time.Time]string
+}
+
+// emittedMetricValues are the fingerprints of the metric values emitted within the correction window,
+// by metric watermark key and metric value timestamp.
+type emittedMetricValues struct {
+	mutex  sync.RWMutex
+	values map[string]map[time.Time]string
+}
+
+func newCollectionUpdate() *collectionUpdate {
+	return &collectionUpdate{
+		watermarks:          make(map[string]time.Time),
+		emittedMetricValues: make(map[string]map[time.Time]string),
+	}
+}
+
+// addMetricValues adds the emitted metric values of a metric, which are ordered oldest first.
+func (cu *collectionUpdate) addMetricValues(ammr *AzureMonitorMetricsReceiver, watermarkKey string, metricValues []*armmonitor.MetricValue) {
+	if ammr.isCheckpointEnabled() {
+		cu.watermarks[watermarkKey] = *metricValues[len(metricValues)-1].TimeStamp
+	}
+
+	if ammr.correctionWindow <= 0 {
+		return
+	}
+
+	if _, found := cu.emittedMetricValues[watermarkKey]; !found {
+		cu.emittedMetricValues[watermarkKey] = make(map[time.Time]string)
+	}
+
+	for _, metricValue := range metricValues {
+		cu.emittedMetricValues[watermarkKey][metricValue.TimeStamp.UTC()] = getMetricValueFingerprint(metricValue)
+	}
+}
+
+// commitCollectionUpdate advances the watermarks and records the emitted metric values of a collection.
+func (ammr *AzureMonitorMetricsReceiver) commitCollectionUpdate(update *collectionUpdate) {
+	ammr.getWatermarks().advance(update.watermarks)
+
+	if ammr.correctionWindow > 0 {
+		ammr.getEmittedMetricValues().set(update.emittedMetricValues, ammr.getCurrentTime().Add(-ammr.correctionWindow))
+	}
+}
+
+func (ammr *AzureMonitorMetricsReceiver) getEmittedMetricValues() *emittedMetricValues {
+	ammr.emittedMetricValuesOnce.Do(func() {
+		if ammr.emittedMetricValues == nil {
+			ammr.emittedMetricValues = newEmittedMetricValues()
+		}
+	})
+
+	return ammr.emittedMetricValues
+}
+
+// getChangedMetricValues returns the metric values within the correction window that were emitted before
+// with other values, oldest first.
+func (ammr *AzureMonitorMetricsReceiver) getChangedMetricValues(watermarkKey string, metricValues []*armmonitor.MetricValue) []*armmonitor.MetricValue {
+	windowStart := ammr.getCurrentTime().Add(-ammr.correctionWindow)
+	changedMetricValues := make([]*armmonitor.MetricValue, 0)
+
+	for _, metricValue := range metricValues {
+		if getMetricsClientMetricValueFields(metricValue) == nil || metricValue.TimeStamp.Before(windowStart) {
+			continue
+		}
+
+		fingerprint, found := ammr.getEmittedMetricValues().get(watermarkKey, metricValue.TimeStamp.UTC())
+		if found && fingerprint != getMetricValueFingerprint(metricValue) {
+			changedMetricValues = append(changedMetricValues, metricValue)
+		}
+	}
+
+	sortMetricValues(changedMetricValues)
+	return changedMetricValues
+}
+
+func newEmittedMetricValues() *emittedMetricValues {
+	return &emittedMetricValues{
+		values: make(map[string]map[time.Time]string),
+	}
+}
+
+func (emv *emittedMetricValues) get(watermarkKey string, timeStamp time.Time) (string, bool) {
+	emv.mutex.RLock()
+	defer emv.mutex.RUnlock()
+
+	fingerprint, found := emv.values[watermarkKey][timeStamp]
+	return fingerprint, found
+}
+
+// set records the given emitted metric values and removes the emitted metric values older than the window start.
+func (emv *emittedMetricValues) set(values map[string]map[time.Time]string, windowStart time.Time) {
+	emv.mutex.Lock()
+	defer emv.mutex.Unlock()
+
+	for watermarkKey, fingerprints := range values {
+		if _, found := emv.values[watermarkKey]; !found {
+			emv.values[watermarkKey] = make(map[time.Time]string)
+		}
+
+		for timeStamp, fingerprint := range fingerprints {
+			emv.values[watermarkKey][timeStamp] = fingerprint
+		}
+	}
+
+	for watermarkKey, fingerprints := range emv.values {
+		for timeStamp := range fingerprints {
+			if timeStamp.Before(windowStart) {
+				delete(fingerprints, timeStamp)
+			}
+		}
+
+		if len(fingerprints) == 0 {
+			delete(emv.values, watermarkKey)
+		}
+	}
+}
+
+// getMetricValueFingerprint returns a string that changes when any of the metric value fields changes.
+func getMetricValueFingerprint(metricValue *armmonitor.MetricValue) string {
+	return fmt.Sprint(getMetricsClientMetricValueFields(metricValue))
+}
+
+// getSettledMetricValues returns the metric values without the newest unsettledNum metric values, oldest first.
+func getSettledMetricValues(metricValues []*armmonitor.MetricValue, unsettledNum int) []*armmonitor.MetricValue {
+	settledMetricValues := make([]*armmonitor.MetricValue, 0, len(metricValues))
+	for _, metricValue := range metricValues {
+		if metricValue != nil && metricValue.TimeStamp != nil {
+			settledMetricValues = append(settledMetricValues, metricValue)
+		}
+	}
+
+	sortMetricValues(settledMetricValues)
+
+	if unsettledNum >= len(settledMetricValues) {
+		return settledMetricValues[:0]
+	}
+
+	return settledMetricValues[:len(settledMetricValues)-unsettledNum]
+}
+
+// mergeMetricValues merges metric values that are ordered oldest first, without duplicates.
+func mergeMetricValues(metricValues []*armmonitor.MetricValue, otherMetricValues []*armmonitor.MetricValue) []*armmonitor.MetricValue {
+	if len(otherMetricValues) == 0 {
+		return metricValues
+	}
+
+	timeStamps := make(map[time.Time]bool)
+	mergedMetricValues := make([]*armmonitor.MetricValue, 0, len(metricValues)+len(otherMetricValues))
+	for _, metricValue := range append(append([]*armmonitor.MetricValue{}, metricValues...), otherMetricValues...) {
+		if timeStamps[*metricValue.TimeStamp] {
+			continue
+		}
+
+		timeStamps[*metricValue.TimeStamp] = true
+		mergedMetricValues = append(mergedMetricValues, metricValue)
+	}
+
+	sortMetricValues(mergedMetricValues)
+	return mergedMetricValues
+}
+
+func sortMetricValues(metricValues []*armmonitor.MetricValue) {
+	sort.SliceStable(metricValues, func(i, j int) bool {
+		return metricValues[i].TimeStamp.Before(*metricValues[j].TimeStamp)
+	})
+}
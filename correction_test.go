@@ -0,0 +1,105 @@
+package azuremonitormetricsreceiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectResourceTargetMetrics_UnsettledBucketsSkipping(t *testing.T) {
+	ammr := newTestReceiver(withTestNow(testWatermarkNow))
+	ammr.unsettledBucketsNum = 1
+
+	metrics, _, err := ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"azure_monitor_microsoft_test_type1_metric1@2022-02-22T22:58:00Z",
+		"azure_monitor_microsoft_test_type1_metric2@2022-02-22T22:58:00Z",
+	}, getMetricsTimeStamps(metrics))
+}
+
+func TestCollectResourceTargetMetrics_UnsettledBucketsSkippingWatermark(t *testing.T) {
+	checkpointStore := newMockCheckpointStore(map[string]time.Time{
+		testMetric1WatermarkID: time.Date(2022, 2, 22, 22, 1, 0, 0, time.UTC),
+	})
+	ammr := newTestReceiver(WithCheckpointStore(checkpointStore), withTestNow(testWatermarkNow))
+	ammr.unsettledBucketsNum = 2
+
+	metrics, _, err := ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"azure_monitor_microsoft_test_type1_metric1@2022-02-22T22:02:00Z",
+		"azure_monitor_microsoft_test_type1_metric2@2022-02-22T22:02:00Z",
+	}, getMetricsTimeStamps(metrics))
+}
+
+func TestCollectResourceTargetMetrics_CorrectionWindow(t *testing.T) {
+	checkpointStore := newMockCheckpointStore(map[string]time.Time{
+		testMetric1WatermarkID: time.Date(2022, 2, 22, 22, 57, 0, 0, time.UTC),
+		testMetric2WatermarkID: time.Date(2022, 2, 22, 22, 57, 0, 0, time.UTC),
+	})
+	ammr := newTestReceiver(WithCheckpointStore(checkpointStore), withTestMetricsClient(newRevisingMetricsClient(3, 10.0)), withTestNow(testWatermarkNow))
+	ammr.correctionWindow = 5 * time.Minute
+
+	metrics, _, err := ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	assert.Len(t, metrics, 4)
+
+	metrics, _, err = ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"azure_monitor_microsoft_test_type1_metric1@2022-02-22T22:58:00Z",
+		"azure_monitor_microsoft_test_type1_metric2@2022-02-22T22:58:00Z",
+	}, getMetricsTimeStamps(metrics))
+	assert.Equal(t, 10.0, metrics[0].Fields[MetricFieldTotal])
+
+	metrics, _, err = ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+}
+
+func TestCollectResourceTargetMetrics_CorrectionWindowTimespan(t *testing.T) {
+	metricsClient := newCountingMetricsClient()
+	ammr := newTestReceiver(withTestMetricsClient(metricsClient), withTestNow(testWatermarkNow))
+	ammr.correctionWindow = 10 * time.Minute
+
+	_, _, err := ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	assert.Equal(t, "2022-02-22T22:50:00Z/2022-02-22T23:00:00Z", metricsClient.getMetricsTimespan(testMetric1+","+testMetric2))
+}
+
+func TestGetSettledMetricValues(t *testing.T) {
+	timeStamps := []time.Time{
+		time.Date(2022, 2, 22, 22, 1, 0, 0, time.UTC),
+		time.Date(2022, 2, 22, 22, 0, 0, 0, time.UTC),
+		time.Date(2022, 2, 22, 22, 2, 0, 0, time.UTC),
+	}
+	metricValues := []*armmonitor.MetricValue{
+		{TimeStamp: &timeStamps[0]},
+		{TimeStamp: &timeStamps[1]},
+		{TimeStamp: &timeStamps[2]},
+	}
+
+	settledMetricValues := getSettledMetricValues(metricValues, 1)
+	require.Len(t, settledMetricValues, 2)
+	assert.Equal(t, timeStamps[1], *settledMetricValues[0].TimeStamp)
+	assert.Equal(t, timeStamps[0], *settledMetricValues[1].TimeStamp)
+
+	assert.Empty(t, getSettledMetricValues(metricValues, 3))
+	assert.Len(t, getSettledMetricValues(metricValues, 0), 3)
+}
+
+func TestNewAzureMonitorMetricsReceiver_NegativeCorrectionWindow(t *testing.T) {
+	targets := NewTargets([]*ResourceTarget{NewResourceTarget(testResourceGroup1ResourceType1Resource1, []string{}, []string{})}, []*ResourceGroupTarget{}, []*Resource{})
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, targets, setMockAzureClients(), WithCorrectionWindow(-time.Minute))
+	require.Error(t, err)
+	assert.Nil(t, ammr)
+
+	ammr, err = NewAzureMonitorMetricsReceiver(testSubscriptionID, targets, setMockAzureClients(), WithUnsettledBucketsSkipping(-1))
+	require.Error(t, err)
+	assert.Nil(t, ammr)
+}
@@ -0,0 +1,116 @@
+package azuremonitormetricsreceiver
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// CreateAzureClientsWithManagedIdentity creates Azure clients with managed identity credentials.
+// If identityID is empty, the system-assigned managed identity is used. Otherwise, identityID is the client ID
+// or the resource ID (starting with '/subscriptions/') of a user-assigned managed identity.
+func CreateAzureClientsWithManagedIdentity(subscriptionID string, identityID string, clientOptions ...func(*AzureClientOptions)) (*AzureClients, error) {
+	options := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: getAzureClientOptions(clientOptions)}
+
+	if identityID != "" {
+		if strings.HasPrefix(strings.ToLower(identityID), "/subscriptions/") {
+			options.ID = azidentity.ResourceID(identityID)
+		} else {
+			options.ID = azidentity.ClientID(identityID)
+		}
+	}
+
+	credential, err := azidentity.NewManagedIdentityCredential(options)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Azure managed identity credential: %w", err)
+	}
+
+	return CreateAzureClientsWithCreds(subscriptionID, credential, clientOptions...)
+}
+
+// CreateAzureClientsWithWorkloadIdentity creates Azure clients with workload identity federation credentials.
+// Empty clientID, tenantID and tokenFilePath default to the AZURE_CLIENT_ID, AZURE_TENANT_ID and
+// AZURE_FEDERATED_TOKEN_FILE environment variables, which are set by the Azure workload identity webhook.
+func CreateAzureClientsWithWorkloadIdentity(subscriptionID string, clientID string, tenantID string, tokenFilePath string, clientOptions ...func(*AzureClientOptions)) (*AzureClients, error) {
+	credential, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+		ClientOptions: getAzureClientOptions(clientOptions),
+		ClientID:      clientID,
+		TenantID:      tenantID,
+		TokenFilePath: tokenFilePath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating Azure workload identity credential: %w", err)
+	}
+
+	return CreateAzureClientsWithCreds(subscriptionID, credential, clientOptions...)
+}
+
+// CreateAzureClientsWithClientCertificate creates Azure clients with service principal certificate credentials.
+// The certificate file is a PEM or PKCS#12 file that contains the certificate and its private key.
+// The certificate password is optional.
+func CreateAzureClientsWithClientCertificate(
+	subscriptionID string,
+	clientID string,
+	tenantID string,
+	certificatePath string,
+	certificatePassword string,
+	clientOptions ...func(*AzureClientOptions)) (*AzureClients, error) {
+	certificateData, err := os.ReadFile(certificatePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading certificate file %s: %w", certificatePath, err)
+	}
+
+	var password []byte
+	if certificatePassword != "" {
+		password = []byte(certificatePassword)
+	}
+
+	certificates, key, err := azidentity.ParseCertificates(certificateData, password)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing certificate file %s: %w", certificatePath, err)
+	}
+
+	credential, err := azidentity.NewClientCertificateCredential(tenantID, clientID, certificates, key,
+		&azidentity.ClientCertificateCredentialOptions{ClientOptions: getAzureClientOptions(clientOptions)})
+	if err != nil {
+		return nil, fmt.Errorf("error creating Azure client certificate credential: %w", err)
+	}
+
+	return CreateAzureClientsWithCreds(subscriptionID, credential, clientOptions...)
+}
+
+// newAzureCLICredential creates the Azure CLI credential. Tests replace it, since the credential runs the Azure CLI.
+var newAzureCLICredential = func(options *azidentity.AzureCLICredentialOptions) (azcore.TokenCredential, error) {
+	return azidentity.NewAzureCLICredential(options)
+}
+
+// CreateAzureClientsWithAzureCLI creates Azure clients with the credentials of the user logged in to the Azure CLI.
+// If tenantID is empty, the Azure CLI default tenant is used.
+// The Azure CLI credential has no client options: it gets tokens from the Azure CLI for the resource manager audience
+// of the cloud set by WithAzureCloud, so the Azure CLI must be logged in to that cloud (az cloud set).
+func CreateAzureClientsWithAzureCLI(subscriptionID string, tenantID string, clientOptions ...func(*AzureClientOptions)) (*AzureClients, error) {
+	credential, err := newAzureCLICredential(&azidentity.AzureCLICredentialOptions{TenantID: tenantID})
+	if err != nil {
+		return nil, fmt.Errorf("error creating Azure CLI credential: %w", err)
+	}
+
+	return CreateAzureClientsWithCreds(subscriptionID, credential, clientOptions...)
+}
+
+// CreateAzureClientsWithDefaultCredential creates Azure clients with the Azure SDK default credential chain:
+// environment variables, workload identity, managed identity, Azure CLI and Azure Developer CLI, in this order.
+// If tenantID is empty, the default tenant of each credential is used.
+func CreateAzureClientsWithDefaultCredential(subscriptionID string, tenantID string, clientOptions ...func(*AzureClientOptions)) (*AzureClients, error) {
+	credential, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+		ClientOptions: getAzureClientOptions(clientOptions),
+		TenantID:      tenantID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating Azure default credential: %w", err)
+	}
+
+	return CreateAzureClientsWithCreds(subscriptionID, credential, clientOptions...)
+}
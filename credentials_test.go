@@ -0,0 +1,134 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestCertificate(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})...)
+
+	path := filepath.Join(t.TempDir(), "certificate.pem")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+
+	return path
+}
+
+func TestCreateAzureClientsWithManagedIdentity_SystemAssigned(t *testing.T) {
+	azureClients, err := CreateAzureClientsWithManagedIdentity(testSubscriptionID, "")
+	require.NoError(t, err)
+	assert.NotNil(t, azureClients.MetricsClient)
+}
+
+func TestCreateAzureClientsWithManagedIdentity_UserAssigned(t *testing.T) {
+	_, err := CreateAzureClientsWithManagedIdentity(testSubscriptionID, testClientID)
+	require.NoError(t, err)
+
+	_, err = CreateAzureClientsWithManagedIdentity(testSubscriptionID, "/subscriptions/subscriptionID/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/identity")
+	require.NoError(t, err)
+}
+
+func TestCreateAzureClientsWithWorkloadIdentity_Success(t *testing.T) {
+	tokenFilePath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFilePath, []byte("token"), 0600))
+
+	_, err := CreateAzureClientsWithWorkloadIdentity(testSubscriptionID, testClientID, testTenantID, tokenFilePath)
+	require.NoError(t, err)
+}
+
+func TestCreateAzureClientsWithWorkloadIdentity_MissingTokenFile(t *testing.T) {
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "")
+	require.NoError(t, os.Unsetenv("AZURE_FEDERATED_TOKEN_FILE"))
+
+	_, err := CreateAzureClientsWithWorkloadIdentity(testSubscriptionID, testClientID, testTenantID, "")
+	require.Error(t, err)
+}
+
+func TestCreateAzureClientsWithClientCertificate_Success(t *testing.T) {
+	_, err := CreateAzureClientsWithClientCertificate(testSubscriptionID, testClientID, testTenantID, writeTestCertificate(t), "")
+	require.NoError(t, err)
+}
+
+func TestCreateAzureClientsWithClientCertificate_BadCertificate(t *testing.T) {
+	_, err := CreateAzureClientsWithClientCertificate(testSubscriptionID, testClientID, testTenantID, filepath.Join(t.TempDir(), "missing.pem"), "")
+	require.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "certificate.pem")
+	require.NoError(t, os.WriteFile(path, []byte("not a certificate"), 0600))
+
+	_, err = CreateAzureClientsWithClientCertificate(testSubscriptionID, testClientID, testTenantID, path, "")
+	require.Error(t, err)
+}
+
+func TestCreateAzureClientsWithAzureCLI_Success(t *testing.T) {
+	_, err := CreateAzureClientsWithAzureCLI(testSubscriptionID, testTenantID)
+	require.NoError(t, err)
+}
+
+func TestCreateAzureClientsWithAzureCLI_TenantAndCloud(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write([]byte(`{"value": []}`))
+	}))
+	defer server.Close()
+
+	credential := &testTokenCredential{}
+	var credentialOptions *azidentity.AzureCLICredentialOptions
+	defer func(previousNewAzureCLICredential func(*azidentity.AzureCLICredentialOptions) (azcore.TokenCredential, error)) {
+		newAzureCLICredential = previousNewAzureCLICredential
+	}(newAzureCLICredential)
+	newAzureCLICredential = func(options *azidentity.AzureCLICredentialOptions) (azcore.TokenCredential, error) {
+		credentialOptions = options
+		return credential, nil
+	}
+
+	azureCloud, err := NewCustomAzureCloud("https://login.example.com/", server.URL, "https://management.example.com")
+	require.NoError(t, err)
+
+	azureClients, err := CreateAzureClientsWithAzureCLI(testSubscriptionID, testTenantID,
+		WithAzureClientOptions(&azcore.ClientOptions{Transport: server.Client()}),
+		WithAzureCloud(azureCloud))
+	require.NoError(t, err)
+
+	_, err = azureClients.MetricsClient.List(context.Background(), testFullResourceGroup1ResourceType1Resource1, nil)
+	require.NoError(t, err)
+
+	require.NotNil(t, credentialOptions)
+	assert.Equal(t, testTenantID, credentialOptions.TenantID)
+	assert.Equal(t, []string{"https://management.example.com/.default"}, credential.scopes)
+}
+
+func TestCreateAzureClientsWithDefaultCredential_Success(t *testing.T) {
+	_, err := CreateAzureClientsWithDefaultCredential(testSubscriptionID, "")
+	require.NoError(t, err)
+}
@@ -0,0 +1,256 @@
+// Package emulator is a local Azure Resource Manager server that emulates the Azure resources, metric definitions
+// and metrics APIs, so the Azure SDK clients can be pointed at it in tests and demos, offline.
+package emulator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+)
+
+const (
+	// APIMetrics is the Azure Monitor metrics API.
+	APIMetrics = "metrics"
+	// APIMetricDefinitions is the Azure Monitor metric definitions API.
+	APIMetricDefinitions = "metric_definitions"
+	// APIResources is the Azure Resource Manager resources API.
+	APIResources = "resources"
+
+	// DefaultPageSize is the default number of resources in a resources page.
+	DefaultPageSize = 1000
+
+	audience = "https://management.emulator.local"
+)
+
+// Server is a local Azure Resource Manager server. It serves the resources of a single subscription over TLS.
+type Server struct {
+	subscriptionID    string
+	server            *httptest.Server
+	pageSize          int
+	mutex             sync.Mutex
+	resources         []*Resource
+	metricDefinitions map[string][]*MetricDefinition
+	metrics           map[string]map[string]*Metric
+	faults            []*fault
+	requests          []*Request
+}
+
+// ServerOptions lets you set optional server parameters.
+type ServerOptions func(*Server)
+
+// Resource is an Azure resource. The resource group is taken from the resource ID.
+type Resource struct {
+	ID       string
+	Type     string
+	Location string
+}
+
+// MetricDefinition is an Azure Monitor metric definition of a resource.
+type MetricDefinition struct {
+	Name        string
+	DisplayName string
+	Unit        armmonitor.MetricUnit
+	// PrimaryAggregation is used when a metrics request has no aggregation. If empty, Average is used.
+	PrimaryAggregation armmonitor.AggregationType
+	Aggregations       []armmonitor.AggregationType
+	// TimeGrains are the ISO 8601 time grains of the metric (PT1M, PT1H, etc.). If empty, PT1M is used.
+	TimeGrains []string
+	// Retention is the ISO 8601 retention of every time grain. If empty, P93D is used.
+	Retention  string
+	Dimensions []string
+}
+
+// Metric is the data of an Azure Monitor metric of a resource.
+type Metric struct {
+	Name        string
+	DisplayName string
+	Unit        armmonitor.Unit
+	TimeSeries  []*TimeSeries
+}
+
+// TimeSeries is a metric time series. A time series without dimensions is the metric total, which is returned when
+// a metrics request has no filter. Time series with dimensions are returned when a metrics request filters by them.
+type TimeSeries struct {
+	Dimensions map[string]string
+	Values     []*armmonitor.MetricValue
+}
+
+// Request is a request the server got.
+type Request struct {
+	API        string
+	Method     string
+	Path       string
+	Query      map[string]string
+	StatusCode int
+}
+
+type fault struct {
+	api        string
+	resourceID string
+	statusCode int
+	errorCode  string
+	retryAfter time.Duration
+	times      int
+}
+
+type staticTokenCredential struct{}
+
+// NewServer lets you create and start a new server of the subscription. Close it when you are done.
+func NewServer(subscriptionID string, serverOptions ...ServerOptions) *Server {
+	server := &Server{
+		subscriptionID:    subscriptionID,
+		pageSize:          DefaultPageSize,
+		metricDefinitions: make(map[string][]*MetricDefinition),
+		metrics:           make(map[string]map[string]*Metric),
+	}
+
+	for _, serverOption := range serverOptions {
+		serverOption(server)
+	}
+
+	server.server = httptest.NewTLSServer(http.HandlerFunc(server.serveHTTP))
+	return server
+}
+
+// WithPageSize lets you set the number of resources in a resources page.
+func WithPageSize(pageSize int) ServerOptions {
+	return func(server *Server) {
+		if pageSize > 0 {
+			server.pageSize = pageSize
+		}
+	}
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// URL returns the server base URL.
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Cloud returns an Azure cloud configuration whose Resource Manager endpoint is the server.
+func (s *Server) Cloud() cloud.Configuration {
+	return cloud.Configuration{
+		ActiveDirectoryAuthorityHost: s.server.URL + "/",
+		Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {
+				Endpoint: s.server.URL,
+				Audience: audience,
+			},
+		},
+	}
+}
+
+// Client returns an HTTP client that trusts the server certificate. Use it as the Azure client options transport.
+func (s *Server) Client() *http.Client {
+	return s.server.Client()
+}
+
+// ClientOptions returns Azure client options that send requests to the server. Retries are kept fast,
+// so throttling can be tested without waiting.
+func (s *Server) ClientOptions() *azcore.ClientOptions {
+	return &azcore.ClientOptions{
+		Cloud:     s.Cloud(),
+		Transport: s.Client(),
+		Retry: policy.RetryOptions{
+			RetryDelay:    time.Millisecond,
+			MaxRetryDelay: 10 * time.Millisecond,
+		},
+	}
+}
+
+// Credential returns a credential that gets a static token, which the server accepts.
+func (s *Server) Credential() azcore.TokenCredential {
+	return staticTokenCredential{}
+}
+
+// AddResource adds a resource to the subscription.
+func (s *Server) AddResource(resource *Resource) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.resources = append(s.resources, resource)
+}
+
+// AddMetricDefinitions adds metric definitions to the resource.
+func (s *Server) AddMetricDefinitions(resourceID string, metricDefinitions ...*MetricDefinition) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := strings.ToLower(resourceID)
+	s.metricDefinitions[key] = append(s.metricDefinitions[key], metricDefinitions...)
+}
+
+// SetMetric sets the data of a metric of the resource. The resource must have the metric definition.
+func (s *Server) SetMetric(resourceID string, metric *Metric) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := strings.ToLower(resourceID)
+	if _, found := s.metrics[key]; !found {
+		s.metrics[key] = make(map[string]*Metric)
+	}
+
+	s.metrics[key][strings.ToLower(metric.Name)] = metric
+}
+
+// Fail makes the next times requests of the API fail with the status code and the Azure error code.
+// If resourceID is empty, the requests of every resource fail.
+func (s *Server) Fail(api string, resourceID string, statusCode int, errorCode string, times int) {
+	s.addFault(&fault{api: api, resourceID: resourceID, statusCode: statusCode, errorCode: errorCode, times: times})
+}
+
+// Throttle makes the next times requests of the API fail with 429 Too Many Requests, asking to retry after retryAfter.
+// If resourceID is empty, the requests of every resource are throttled.
+func (s *Server) Throttle(api string, resourceID string, retryAfter time.Duration, times int) {
+	s.addFault(&fault{
+		api:        api,
+		resourceID: resourceID,
+		statusCode: http.StatusTooManyRequests,
+		errorCode:  "TooManyRequests",
+		retryAfter: retryAfter,
+		times:      times,
+	})
+}
+
+// Requests returns the requests the server got, in order.
+func (s *Server) Requests() []*Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]*Request{}, s.requests...)
+}
+
+// APIRequestsNum returns the number of requests of the API the server got.
+func (s *Server) APIRequestsNum(api string) int {
+	requestsNum := 0
+	for _, request := range s.Requests() {
+		if request.API == api {
+			requestsNum++
+		}
+	}
+
+	return requestsNum
+}
+
+func (s *Server) addFault(newFault *fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.faults = append(s.faults, newFault)
+}
+
+func (staticTokenCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "emulator", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
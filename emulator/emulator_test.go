@@ -0,0 +1,275 @@
+package emulator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testSubscriptionID = "subscriptionID"
+	testResourceType1  = "Microsoft.Test/type1"
+	testResourceType2  = "Microsoft.Test/type2"
+	testResourceID1    = "/subscriptions/subscriptionID/resourceGroups/resourceGroup1/providers/Microsoft.Test/type1/resource1"
+	testResourceID2    = "/subscriptions/subscriptionID/resourceGroups/resourceGroup1/providers/Microsoft.Test/type2/resource2"
+	testResourceID3    = "/subscriptions/subscriptionID/resourceGroups/resourceGroup2/providers/Microsoft.Test/type1/resource3"
+)
+
+var testTime = time.Date(2022, 2, 22, 22, 0, 0, 0, time.UTC)
+
+func newTestServer(serverOptions ...ServerOptions) *Server {
+	server := NewServer(testSubscriptionID, serverOptions...)
+	server.AddResource(&Resource{ID: testResourceID1, Type: testResourceType1, Location: "eastus"})
+	server.AddResource(&Resource{ID: testResourceID2, Type: testResourceType2, Location: "eastus"})
+	server.AddResource(&Resource{ID: testResourceID3, Type: testResourceType1, Location: "westus"})
+	server.AddMetricDefinitions(testResourceID1,
+		&MetricDefinition{
+			Name:         "metric1",
+			Unit:         armmonitor.MetricUnitCount,
+			Aggregations: []armmonitor.AggregationType{armmonitor.AggregationTypeTotal, armmonitor.AggregationTypeMaximum},
+			TimeGrains:   []string{"PT1M", "PT1H"},
+			Dimensions:   []string{"Instance"},
+		},
+		&MetricDefinition{Name: "metric2", Unit: armmonitor.MetricUnitBytes})
+	server.SetMetric(testResourceID1, &Metric{
+		Name: "metric1",
+		TimeSeries: []*TimeSeries{
+			{Values: []*armmonitor.MetricValue{
+				{TimeStamp: to.Ptr(testTime), Total: to.Ptr(3.0), Maximum: to.Ptr(2.0)},
+				{TimeStamp: to.Ptr(testTime.Add(time.Minute)), Total: to.Ptr(5.0), Maximum: to.Ptr(4.0)},
+			}},
+			{Dimensions: map[string]string{"Instance": "a"}, Values: []*armmonitor.MetricValue{
+				{TimeStamp: to.Ptr(testTime), Total: to.Ptr(1.0), Maximum: to.Ptr(1.0)},
+			}},
+			{Dimensions: map[string]string{"Instance": "b"}, Values: []*armmonitor.MetricValue{
+				{TimeStamp: to.Ptr(testTime), Total: to.Ptr(2.0), Maximum: to.Ptr(2.0)},
+			}},
+		},
+	})
+
+	return server
+}
+
+func newTestClientOptions(server *Server) *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: *server.ClientOptions()}
+}
+
+func TestServer_ResourcesPages(t *testing.T) {
+	server := newTestServer(WithPageSize(1))
+	defer server.Close()
+
+	client, err := armresources.NewClient(testSubscriptionID, server.Credential(), newTestClientOptions(server))
+	require.NoError(t, err)
+
+	ids := make([]string, 0)
+	pager := client.NewListPager(&armresources.ClientListOptions{Filter: to.Ptr("resourceType eq 'Microsoft.Test/type1'")})
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		require.NoError(t, err)
+		require.Len(t, page.Value, 1)
+
+		ids = append(ids, *page.Value[0].ID)
+		assert.Equal(t, testResourceType1, *page.Value[0].Type)
+	}
+
+	assert.Equal(t, []string{testResourceID1, testResourceID3}, ids)
+	assert.Equal(t, 2, server.APIRequestsNum(APIResources))
+}
+
+func TestServer_ResourceGroupResources(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	client, err := armresources.NewClient(testSubscriptionID, server.Credential(), newTestClientOptions(server))
+	require.NoError(t, err)
+
+	pager := client.NewListByResourceGroupPager("resourceGroup1", &armresources.ClientListByResourceGroupOptions{
+		Filter: to.Ptr("resourceType eq 'Microsoft.Test/type1' or resourceType eq 'Microsoft.Test/type2'"),
+	})
+	page, err := pager.NextPage(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, page.Value, 2)
+	assert.False(t, pager.More())
+
+	pager = client.NewListByResourceGroupPager("missing", nil)
+	_, err = pager.NextPage(context.Background())
+	assertResponseError(t, err, http.StatusNotFound, "ResourceGroupNotFound")
+}
+
+func TestServer_ResourcesBadFilter(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	client, err := armresources.NewClient(testSubscriptionID, server.Credential(), newTestClientOptions(server))
+	require.NoError(t, err)
+
+	pager := client.NewListPager(&armresources.ClientListOptions{Filter: to.Ptr("location eq 'eastus'")})
+	_, err = pager.NextPage(context.Background())
+	assertResponseError(t, err, http.StatusBadRequest, "InvalidFilterInQueryString")
+}
+
+func TestServer_MetricDefinitions(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	client, err := armmonitor.NewMetricDefinitionsClient(testSubscriptionID, server.Credential(), newTestClientOptions(server))
+	require.NoError(t, err)
+
+	page, err := client.NewListPager(testResourceID1, nil).NextPage(context.Background())
+	require.NoError(t, err)
+	require.Len(t, page.Value, 2)
+
+	metricDefinition := page.Value[0]
+	assert.Equal(t, "metric1", *metricDefinition.Name.Value)
+	assert.Equal(t, armmonitor.MetricUnitCount, *metricDefinition.Unit)
+	require.Len(t, metricDefinition.MetricAvailabilities, 2)
+	assert.Equal(t, "PT1H", *metricDefinition.MetricAvailabilities[1].TimeGrain)
+	assert.Equal(t, "P93D", *metricDefinition.MetricAvailabilities[1].Retention)
+	assert.Equal(t, "Instance", *metricDefinition.Dimensions[0].Value)
+	assert.Equal(t, "PT1M", *page.Value[1].MetricAvailabilities[0].TimeGrain)
+
+	_, err = client.NewListPager(testResourceID1+"missing", nil).NextPage(context.Background())
+	assertResponseError(t, err, http.StatusNotFound, "ResourceNotFound")
+}
+
+func TestServer_Metrics(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	client, err := armmonitor.NewMetricsClient(testSubscriptionID, server.Credential(), newTestClientOptions(server))
+	require.NoError(t, err)
+
+	response, err := client.List(context.Background(), testResourceID1, &armmonitor.MetricsClientListOptions{
+		Metricnames: to.Ptr("metric1,metric2"),
+		Aggregation: to.Ptr("Total"),
+		Interval:    to.Ptr("PT1M"),
+		Timespan:    to.Ptr("2022-02-22T22:00:00Z/2022-02-22T22:01:00Z"),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, testResourceType1, *response.Namespace)
+	assert.Equal(t, "eastus", *response.Resourceregion)
+	assert.Equal(t, "PT1M", *response.Interval)
+	require.Len(t, response.Value, 2)
+
+	metric := response.Value[0]
+	assert.Equal(t, testResourceID1+"/providers/Microsoft.Insights/metrics/metric1", *metric.ID)
+	assert.Equal(t, "Success", *metric.ErrorCode)
+	require.Len(t, metric.Timeseries, 1)
+	require.Len(t, metric.Timeseries[0].Data, 1)
+	assert.Equal(t, 3.0, *metric.Timeseries[0].Data[0].Total)
+	assert.Nil(t, metric.Timeseries[0].Data[0].Maximum)
+	assert.True(t, testTime.Equal(*metric.Timeseries[0].Data[0].TimeStamp))
+
+	assert.Empty(t, response.Value[1].Timeseries)
+}
+
+func TestServer_MetricsDimensions(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	client, err := armmonitor.NewMetricsClient(testSubscriptionID, server.Credential(), newTestClientOptions(server))
+	require.NoError(t, err)
+
+	options := &armmonitor.MetricsClientListOptions{
+		Metricnames: to.Ptr("metric1"),
+		Aggregation: to.Ptr("Maximum"),
+		Timespan:    to.Ptr("2022-02-22T22:00:00Z/2022-02-22T23:00:00Z"),
+		Filter:      to.Ptr("Instance eq '*'"),
+	}
+	response, err := client.List(context.Background(), testResourceID1, options)
+	require.NoError(t, err)
+
+	timeSeries := response.Value[0].Timeseries
+	require.Len(t, timeSeries, 2)
+	assert.Equal(t, "Instance", *timeSeries[0].Metadatavalues[0].Name.Value)
+	assert.Equal(t, "a", *timeSeries[0].Metadatavalues[0].Value)
+	assert.Equal(t, 1.0, *timeSeries[0].Data[0].Maximum)
+	assert.Equal(t, "b", *timeSeries[1].Metadatavalues[0].Value)
+
+	options.Filter = to.Ptr("Instance eq 'b'")
+	response, err = client.List(context.Background(), testResourceID1, options)
+	require.NoError(t, err)
+	require.Len(t, response.Value[0].Timeseries, 1)
+	assert.Equal(t, 2.0, *response.Value[0].Timeseries[0].Data[0].Maximum)
+}
+
+func TestServer_MetricsUnknownMetric(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	client, err := armmonitor.NewMetricsClient(testSubscriptionID, server.Credential(), newTestClientOptions(server))
+	require.NoError(t, err)
+
+	_, err = client.List(context.Background(), testResourceID1, &armmonitor.MetricsClientListOptions{Metricnames: to.Ptr("metric3")})
+	assertResponseError(t, err, http.StatusBadRequest, "BadRequest")
+}
+
+func TestServer_Throttle(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	server.Throttle(APIMetrics, testResourceID1, time.Millisecond, 2)
+
+	client, err := armmonitor.NewMetricsClient(testSubscriptionID, server.Credential(), newTestClientOptions(server))
+	require.NoError(t, err)
+
+	_, err = client.List(context.Background(), testResourceID1, &armmonitor.MetricsClientListOptions{Metricnames: to.Ptr("metric1")})
+	require.NoError(t, err)
+
+	requests := server.Requests()
+	require.Len(t, requests, 3)
+	assert.Equal(t, http.StatusTooManyRequests, requests[0].StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, requests[1].StatusCode)
+	assert.Equal(t, http.StatusOK, requests[2].StatusCode)
+	assert.Equal(t, "metric1", requests[2].Query["metricnames"])
+}
+
+func TestServer_Fail(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	server.Fail(APIMetricDefinitions, "", http.StatusForbidden, "AuthorizationFailed", 1)
+
+	clientOptions := newTestClientOptions(server)
+	clientOptions.Retry = policy.RetryOptions{MaxRetries: -1}
+	client, err := armmonitor.NewMetricDefinitionsClient(testSubscriptionID, server.Credential(), clientOptions)
+	require.NoError(t, err)
+
+	_, err = client.NewListPager(testResourceID1, nil).NextPage(context.Background())
+	assertResponseError(t, err, http.StatusForbidden, "AuthorizationFailed")
+
+	_, err = client.NewListPager(testResourceID1, nil).NextPage(context.Background())
+	require.NoError(t, err)
+}
+
+func TestServer_SubscriptionNotFound(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	client, err := armresources.NewClient("otherSubscriptionID", server.Credential(), newTestClientOptions(server))
+	require.NoError(t, err)
+
+	_, err = client.NewListPager(nil).NextPage(context.Background())
+	assertResponseError(t, err, http.StatusNotFound, "SubscriptionNotFound")
+}
+
+func assertResponseError(t *testing.T, err error, statusCode int, errorCode string) {
+	t.Helper()
+
+	var responseError *azcore.ResponseError
+	require.True(t, errors.As(err, &responseError), "error %v is not a response error", err)
+	assert.Equal(t, statusCode, responseError.StatusCode)
+	assert.Equal(t, errorCode, responseError.ErrorCode)
+}
@@ -0,0 +1,658 @@
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+const (
+	metricDefinitionsPathSuffix = "/providers/microsoft.insights/metricdefinitions"
+	metricsPathSuffix           = "/providers/microsoft.insights/metrics"
+
+	defaultTimeGrain = "PT1M"
+	defaultRetention = "P93D"
+)
+
+var (
+	resourcesFilterRegexp = regexp.MustCompile(`(?i)^resourceType eq '([^']+)'$`)
+	metricsFilterRegexp   = regexp.MustCompile(`(?i)^(.+?) eq '([^']*)'$`)
+)
+
+type responseError struct {
+	statusCode int
+	code       string
+	message    string
+}
+
+func (s *Server) serveHTTP(writer http.ResponseWriter, request *http.Request) {
+	api, handle := s.route(request)
+	statusCode := handle(writer, request)
+
+	query := make(map[string]string)
+	for key, values := range request.URL.Query() {
+		query[key] = strings.Join(values, ",")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.requests = append(s.requests, &Request{
+		API:        api,
+		Method:     request.Method,
+		Path:       request.URL.Path,
+		Query:      query,
+		StatusCode: statusCode,
+	})
+}
+
+// route returns the API of the request and its handler, which writes the response and returns its status code.
+func (s *Server) route(request *http.Request) (string, func(http.ResponseWriter, *http.Request) int) {
+	path := strings.ToLower(strings.TrimSuffix(request.URL.Path, "/"))
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case request.Method != http.MethodGet:
+		return "", writeErrorHandler(&responseError{http.StatusMethodNotAllowed, "MethodNotAllowed", "only GET requests are emulated"})
+	case strings.HasSuffix(path, metricDefinitionsPathSuffix):
+		return APIMetricDefinitions, s.handleMetricDefinitions
+	case strings.HasSuffix(path, metricsPathSuffix):
+		return APIMetrics, s.handleMetrics
+	case len(parts) == 3 && parts[0] == "subscriptions" && parts[2] == "resources":
+		return APIResources, s.handleResources
+	case len(parts) == 5 && parts[0] == "subscriptions" && parts[2] == "resourcegroups" && parts[4] == "resources":
+		return APIResources, s.handleResources
+	default:
+		return "", writeErrorHandler(&responseError{http.StatusNotFound, "InvalidResourceType", "the resource type could not be found"})
+	}
+}
+
+func (s *Server) handleResources(writer http.ResponseWriter, request *http.Request) int {
+	parts := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	if err := s.checkSubscription(parts[1]); err != nil {
+		return writeError(writer, err)
+	}
+
+	if err := s.takeFault(writer, APIResources, ""); err != nil {
+		return writeError(writer, err)
+	}
+
+	resourceTypes, err := parseResourcesFilter(request.URL.Query().Get("$filter"))
+	if err != nil {
+		return writeError(writer, err)
+	}
+
+	resourceGroup := ""
+	if len(parts) == 5 {
+		resourceGroup = parts[3]
+	}
+
+	resources, err := s.getResources(resourceGroup, resourceTypes)
+	if err != nil {
+		return writeError(writer, err)
+	}
+
+	skip := 0
+	if skipToken := request.URL.Query().Get("$skiptoken"); skipToken != "" {
+		var parseErr error
+		if skip, parseErr = strconv.Atoi(skipToken); parseErr != nil || skip < 0 {
+			return writeError(writer, &responseError{http.StatusBadRequest, "InvalidSkipToken", "the skip token is invalid"})
+		}
+	}
+
+	response := armresources.ResourceListResult{Value: make([]*armresources.GenericResourceExpanded, 0)}
+	for index := skip; index < len(resources) && index < skip+s.pageSize; index++ {
+		response.Value = append(response.Value, createGenericResource(resources[index]))
+	}
+
+	if skip+s.pageSize < len(resources) {
+		nextQuery := request.URL.Query()
+		nextQuery.Set("$skiptoken", strconv.Itoa(skip+s.pageSize))
+		nextLink := s.server.URL + request.URL.Path + "?" + nextQuery.Encode()
+		response.NextLink = &nextLink
+	}
+
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+func (s *Server) handleMetricDefinitions(writer http.ResponseWriter, request *http.Request) int {
+	resourceID := request.URL.Path[:len(request.URL.Path)-len(metricDefinitionsPathSuffix)]
+	if err := s.checkResource(resourceID); err != nil {
+		return writeError(writer, err)
+	}
+
+	if err := s.takeFault(writer, APIMetricDefinitions, resourceID); err != nil {
+		return writeError(writer, err)
+	}
+
+	s.mutex.Lock()
+	metricDefinitions := s.metricDefinitions[strings.ToLower(resourceID)]
+	resourceType := s.getResourceType(resourceID)
+	s.mutex.Unlock()
+
+	response := armmonitor.MetricDefinitionCollection{Value: make([]*armmonitor.MetricDefinition, 0, len(metricDefinitions))}
+	for _, metricDefinition := range metricDefinitions {
+		response.Value = append(response.Value, createMetricDefinition(resourceID, resourceType, metricDefinition))
+	}
+
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+func (s *Server) handleMetrics(writer http.ResponseWriter, request *http.Request) int {
+	resourceID := request.URL.Path[:len(request.URL.Path)-len(metricsPathSuffix)]
+	if err := s.checkResource(resourceID); err != nil {
+		return writeError(writer, err)
+	}
+
+	if err := s.takeFault(writer, APIMetrics, resourceID); err != nil {
+		return writeError(writer, err)
+	}
+
+	query := request.URL.Query()
+	start, end, err := parseTimespan(query.Get("timespan"))
+	if err != nil {
+		return writeError(writer, err)
+	}
+
+	dimensionsFilter, err := parseMetricsFilter(query.Get("$filter"))
+	if err != nil {
+		return writeError(writer, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	metricDefinitions := make(map[string]*MetricDefinition)
+	for _, metricDefinition := range s.metricDefinitions[strings.ToLower(resourceID)] {
+		metricDefinitions[strings.ToLower(metricDefinition.Name)] = metricDefinition
+	}
+
+	metricNames := strings.Split(query.Get("metricnames"), ",")
+	if query.Get("metricnames") == "" {
+		return writeError(writer, &responseError{http.StatusBadRequest, "BadRequest", "metricnames is missing"})
+	}
+
+	interval := query.Get("interval")
+	timespan := formatTimespan(start, end)
+	resourceType := s.getResourceType(resourceID)
+	response := armmonitor.Response{
+		Timespan:       &timespan,
+		Namespace:      &resourceType,
+		Resourceregion: s.getResourceLocation(resourceID),
+		Value:          make([]*armmonitor.Metric, 0, len(metricNames)),
+	}
+
+	for _, metricName := range metricNames {
+		// Azure Monitor API metric names with commas are requested with %2 instead of commas.
+		metricName = strings.ReplaceAll(metricName, "%2", ",")
+		metricDefinition, found := metricDefinitions[strings.ToLower(metricName)]
+		if !found {
+			return writeError(writer, &responseError{http.StatusBadRequest, "BadRequest",
+				fmt.Sprintf("Failed to find metric configuration for provider: %s, resource Type: %s, metric: %s",
+					strings.Split(resourceType, "/")[0], resourceType, metricName)})
+		}
+
+		if interval == "" {
+			interval = getMetricDefinitionTimeGrains(metricDefinition)[0]
+		}
+
+		aggregations, err := parseAggregations(query.Get("aggregation"), metricDefinition)
+		if err != nil {
+			return writeError(writer, err)
+		}
+
+		response.Value = append(response.Value, createMetric(resourceID, metricDefinition,
+			s.metrics[strings.ToLower(resourceID)][strings.ToLower(metricDefinition.Name)], aggregations, dimensionsFilter, start, end))
+	}
+
+	response.Interval = &interval
+	return writeJSON(writer, http.StatusOK, response)
+}
+
+func (s *Server) checkSubscription(subscriptionID string) *responseError {
+	if !strings.EqualFold(subscriptionID, s.subscriptionID) {
+		return &responseError{http.StatusNotFound, "SubscriptionNotFound", fmt.Sprintf("The subscription '%s' could not be found.", subscriptionID)}
+	}
+
+	return nil
+}
+
+func (s *Server) checkResource(resourceID string) *responseError {
+	parts := strings.Split(strings.Trim(resourceID, "/"), "/")
+	if len(parts) < 2 || !strings.EqualFold(parts[0], "subscriptions") {
+		return &responseError{http.StatusBadRequest, "InvalidResourceId", fmt.Sprintf("The resource ID '%s' is invalid.", resourceID)}
+	}
+
+	if err := s.checkSubscription(parts[1]); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.getResource(resourceID) == nil {
+		return &responseError{http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("The Resource '%s' was not found.", resourceID)}
+	}
+
+	return nil
+}
+
+// takeFault writes the Retry-After header of the first fault that matches the request, and returns its error.
+func (s *Server) takeFault(writer http.ResponseWriter, api string, resourceID string) *responseError {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for index, fault := range s.faults {
+		if fault.api != api || (fault.resourceID != "" && !strings.EqualFold(fault.resourceID, resourceID)) {
+			continue
+		}
+
+		fault.times--
+		if fault.times <= 0 {
+			s.faults = append(s.faults[:index], s.faults[index+1:]...)
+		}
+
+		if fault.retryAfter > 0 {
+			writer.Header().Set("Retry-After-Ms", strconv.FormatInt(fault.retryAfter.Milliseconds(), 10))
+		}
+
+		return &responseError{fault.statusCode, fault.errorCode, fmt.Sprintf("emulated %s error", fault.errorCode)}
+	}
+
+	return nil
+}
+
+// getResources returns the resources of the resource group, or of the subscription if the resource group is empty,
+// that have one of the resource types, or any resource type if there are none. The mutex must be unlocked.
+func (s *Server) getResources(resourceGroup string, resourceTypes []string) ([]*Resource, *responseError) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	resources := make([]*Resource, 0)
+	isResourceGroupFound := false
+
+	for _, resource := range s.resources {
+		if resourceGroup != "" {
+			if !strings.EqualFold(getResourceGroup(resource.ID), resourceGroup) {
+				continue
+			}
+
+			isResourceGroupFound = true
+		}
+
+		if len(resourceTypes) > 0 && !containsFold(resourceTypes, resource.Type) {
+			continue
+		}
+
+		resources = append(resources, resource)
+	}
+
+	if resourceGroup != "" && !isResourceGroupFound {
+		return nil, &responseError{http.StatusNotFound, "ResourceGroupNotFound", fmt.Sprintf("Resource group '%s' could not be found.", resourceGroup)}
+	}
+
+	return resources, nil
+}
+
+// getResource returns the resource of the resource ID, or nil if there is none. The mutex must be locked.
+func (s *Server) getResource(resourceID string) *Resource {
+	for _, resource := range s.resources {
+		if strings.EqualFold(resource.ID, resourceID) {
+			return resource
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) getResourceType(resourceID string) string {
+	if resource := s.getResource(resourceID); resource != nil {
+		return resource.Type
+	}
+
+	return ""
+}
+
+func (s *Server) getResourceLocation(resourceID string) *string {
+	if resource := s.getResource(resourceID); resource != nil && resource.Location != "" {
+		return &resource.Location
+	}
+
+	return nil
+}
+
+func createGenericResource(resource *Resource) *armresources.GenericResourceExpanded {
+	id := resource.ID
+	resourceType := resource.Type
+	name := id[strings.LastIndex(id, "/")+1:]
+	genericResource := &armresources.GenericResourceExpanded{ID: &id, Name: &name, Type: &resourceType}
+	if resource.Location != "" {
+		location := resource.Location
+		genericResource.Location = &location
+	}
+
+	return genericResource
+}
+
+func createMetricDefinition(resourceID string, resourceType string, metricDefinition *MetricDefinition) *armmonitor.MetricDefinition {
+	displayName := metricDefinition.DisplayName
+	if displayName == "" {
+		displayName = metricDefinition.Name
+	}
+
+	retention := metricDefinition.Retention
+	if retention == "" {
+		retention = defaultRetention
+	}
+
+	id := resourceID + "/providers/microsoft.insights/metricdefinitions/" + metricDefinition.Name
+	name := metricDefinition.Name
+	unit := metricDefinition.Unit
+	primaryAggregation := getPrimaryAggregation(metricDefinition)
+	isDimensionRequired := false
+	newMetricDefinition := &armmonitor.MetricDefinition{
+		ID:                     &id,
+		ResourceID:             &resourceID,
+		Namespace:              &resourceType,
+		Name:                   &armmonitor.LocalizableString{Value: &name, LocalizedValue: &displayName},
+		PrimaryAggregationType: &primaryAggregation,
+		IsDimensionRequired:    &isDimensionRequired,
+	}
+
+	if unit != "" {
+		newMetricDefinition.Unit = &unit
+	}
+
+	for _, aggregation := range metricDefinition.Aggregations {
+		aggregation := aggregation
+		newMetricDefinition.SupportedAggregationTypes = append(newMetricDefinition.SupportedAggregationTypes, &aggregation)
+	}
+
+	for _, timeGrain := range getMetricDefinitionTimeGrains(metricDefinition) {
+		timeGrain := timeGrain
+		newMetricDefinition.MetricAvailabilities = append(newMetricDefinition.MetricAvailabilities,
+			&armmonitor.MetricAvailability{TimeGrain: &timeGrain, Retention: &retention})
+	}
+
+	for _, dimension := range metricDefinition.Dimensions {
+		dimension := dimension
+		newMetricDefinition.Dimensions = append(newMetricDefinition.Dimensions,
+			&armmonitor.LocalizableString{Value: &dimension, LocalizedValue: &dimension})
+	}
+
+	return newMetricDefinition
+}
+
+// createMetric creates the metric of the response, with the time series that match the dimensions filter
+// and their values in the timespan, with only the requested aggregations.
+func createMetric(
+	resourceID string,
+	metricDefinition *MetricDefinition,
+	metric *Metric,
+	aggregations []armmonitor.AggregationType,
+	dimensionsFilter map[string]string,
+	start time.Time,
+	end time.Time) *armmonitor.Metric {
+	id := resourceID + "/providers/Microsoft.Insights/metrics/" + metricDefinition.Name
+	name := metricDefinition.Name
+	displayName := metricDefinition.DisplayName
+	if displayName == "" {
+		displayName = metricDefinition.Name
+	}
+
+	unit := armmonitor.Unit(metricDefinition.Unit)
+	if metric != nil && metric.Unit != "" {
+		unit = metric.Unit
+	}
+
+	errorCode := "Success"
+	newMetric := &armmonitor.Metric{
+		ID:         &id,
+		Name:       &armmonitor.LocalizableString{Value: &name, LocalizedValue: &displayName},
+		Unit:       &unit,
+		ErrorCode:  &errorCode,
+		Timeseries: make([]*armmonitor.TimeSeriesElement, 0),
+	}
+
+	if metric == nil {
+		return newMetric
+	}
+
+	for _, timeSeries := range metric.TimeSeries {
+		if !isTimeSeriesInDimensionsFilter(timeSeries, dimensionsFilter) {
+			continue
+		}
+
+		timeSeriesElement := &armmonitor.TimeSeriesElement{Data: make([]*armmonitor.MetricValue, 0)}
+		for _, dimension := range getSortedKeys(dimensionsFilter) {
+			dimension := dimension
+			value := timeSeries.Dimensions[dimension]
+			timeSeriesElement.Metadatavalues = append(timeSeriesElement.Metadatavalues,
+				&armmonitor.MetadataValue{Name: &armmonitor.LocalizableString{Value: &dimension, LocalizedValue: &dimension}, Value: &value})
+		}
+
+		for _, value := range timeSeries.Values {
+			if value.TimeStamp == nil || value.TimeStamp.Before(start) || !value.TimeStamp.Before(end) {
+				continue
+			}
+
+			timeSeriesElement.Data = append(timeSeriesElement.Data, selectAggregations(value, aggregations))
+		}
+
+		newMetric.Timeseries = append(newMetric.Timeseries, timeSeriesElement)
+	}
+
+	return newMetric
+}
+
+// isTimeSeriesInDimensionsFilter returns true if the time series has the filter dimensions and values.
+// A '*' value matches any value. A time series without dimensions matches only an empty filter.
+func isTimeSeriesInDimensionsFilter(timeSeries *TimeSeries, dimensionsFilter map[string]string) bool {
+	if len(dimensionsFilter) == 0 {
+		return len(timeSeries.Dimensions) == 0
+	}
+
+	if len(timeSeries.Dimensions) != len(dimensionsFilter) {
+		return false
+	}
+
+	for dimension, filterValue := range dimensionsFilter {
+		value, found := timeSeries.Dimensions[dimension]
+		if !found || (filterValue != "*" && !strings.EqualFold(filterValue, value)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func selectAggregations(value *armmonitor.MetricValue, aggregations []armmonitor.AggregationType) *armmonitor.MetricValue {
+	newValue := &armmonitor.MetricValue{TimeStamp: value.TimeStamp}
+	for _, aggregation := range aggregations {
+		switch aggregation {
+		case armmonitor.AggregationTypeAverage:
+			newValue.Average = value.Average
+		case armmonitor.AggregationTypeCount:
+			newValue.Count = value.Count
+		case armmonitor.AggregationTypeMaximum:
+			newValue.Maximum = value.Maximum
+		case armmonitor.AggregationTypeMinimum:
+			newValue.Minimum = value.Minimum
+		case armmonitor.AggregationTypeTotal:
+			newValue.Total = value.Total
+		}
+	}
+
+	return newValue
+}
+
+func parseResourcesFilter(filter string) ([]string, *responseError) {
+	resourceTypes := make([]string, 0)
+	if filter == "" {
+		return resourceTypes, nil
+	}
+
+	for _, condition := range regexp.MustCompile(`(?i) or `).Split(filter, -1) {
+		match := resourcesFilterRegexp.FindStringSubmatch(strings.TrimSpace(condition))
+		if match == nil {
+			return nil, &responseError{http.StatusBadRequest, "InvalidFilterInQueryString",
+				fmt.Sprintf("Invalid $filter '%s' specified in the query string.", filter)}
+		}
+
+		resourceTypes = append(resourceTypes, match[1])
+	}
+
+	return resourceTypes, nil
+}
+
+// parseMetricsFilter returns the dimensions and values of a filter such as "Dimension1 eq 'value' and Dimension2 eq '*'".
+func parseMetricsFilter(filter string) (map[string]string, *responseError) {
+	dimensionsFilter := make(map[string]string)
+	if filter == "" {
+		return dimensionsFilter, nil
+	}
+
+	for _, condition := range regexp.MustCompile(`(?i) and `).Split(filter, -1) {
+		match := metricsFilterRegexp.FindStringSubmatch(strings.TrimSpace(condition))
+		if match == nil {
+			return nil, &responseError{http.StatusBadRequest, "BadRequest", fmt.Sprintf("Invalid $filter '%s'.", filter)}
+		}
+
+		dimensionsFilter[match[1]] = match[2]
+	}
+
+	return dimensionsFilter, nil
+}
+
+func parseAggregations(aggregation string, metricDefinition *MetricDefinition) ([]armmonitor.AggregationType, *responseError) {
+	if aggregation == "" {
+		return []armmonitor.AggregationType{getPrimaryAggregation(metricDefinition)}, nil
+	}
+
+	aggregations := make([]armmonitor.AggregationType, 0)
+	for _, name := range strings.Split(aggregation, ",") {
+		isAggregationFound := false
+		for _, aggregationType := range armmonitor.PossibleAggregationTypeValues() {
+			if strings.EqualFold(string(aggregationType), strings.TrimSpace(name)) {
+				aggregations = append(aggregations, aggregationType)
+				isAggregationFound = true
+			}
+		}
+
+		if !isAggregationFound {
+			return nil, &responseError{http.StatusBadRequest, "BadRequest", fmt.Sprintf("Invalid aggregation '%s'.", name)}
+		}
+	}
+
+	return aggregations, nil
+}
+
+// parseTimespan returns the start and end of a timespan such as 2022-02-22T22:00:00Z/2022-02-22T23:00:00Z,
+// or the last hour if the timespan is empty, like Azure Monitor API does.
+func parseTimespan(timespan string) (time.Time, time.Time, *responseError) {
+	if timespan == "" {
+		end := time.Now().UTC()
+		return end.Add(-time.Hour), end, nil
+	}
+
+	parts := strings.Split(timespan, "/")
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, &responseError{http.StatusBadRequest, "BadRequest", fmt.Sprintf("Invalid timespan '%s'.", timespan)}
+	}
+
+	start, startErr := time.Parse(time.RFC3339, parts[0])
+	end, endErr := time.Parse(time.RFC3339, parts[1])
+	if startErr != nil || endErr != nil || !start.Before(end) {
+		return time.Time{}, time.Time{}, &responseError{http.StatusBadRequest, "BadRequest", fmt.Sprintf("Invalid timespan '%s'.", timespan)}
+	}
+
+	return start, end, nil
+}
+
+func formatTimespan(start time.Time, end time.Time) string {
+	return start.UTC().Format(time.RFC3339) + "/" + end.UTC().Format(time.RFC3339)
+}
+
+func getPrimaryAggregation(metricDefinition *MetricDefinition) armmonitor.AggregationType {
+	if metricDefinition.PrimaryAggregation == "" {
+		return armmonitor.AggregationTypeAverage
+	}
+
+	return metricDefinition.PrimaryAggregation
+}
+
+func getMetricDefinitionTimeGrains(metricDefinition *MetricDefinition) []string {
+	if len(metricDefinition.TimeGrains) == 0 {
+		return []string{defaultTimeGrain}
+	}
+
+	return metricDefinition.TimeGrains
+}
+
+func getResourceGroup(resourceID string) string {
+	parts := strings.Split(strings.Trim(resourceID, "/"), "/")
+	for index := 0; index+1 < len(parts); index++ {
+		if strings.EqualFold(parts[index], "resourceGroups") {
+			return parts[index+1]
+		}
+	}
+
+	return ""
+}
+
+func getSortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func containsFold(values []string, value string) bool {
+	for _, currentValue := range values {
+		if strings.EqualFold(currentValue, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeErrorHandler(err *responseError) func(http.ResponseWriter, *http.Request) int {
+	return func(writer http.ResponseWriter, _ *http.Request) int {
+		return writeError(writer, err)
+	}
+}
+
+func writeError(writer http.ResponseWriter, err *responseError) int {
+	writer.Header().Set("x-ms-error-code", err.code)
+	return writeJSON(writer, err.statusCode, map[string]interface{}{
+		"error": map[string]string{
+			"code":    err.code,
+			"message": err.message,
+		},
+	})
+}
+
+func writeJSON(writer http.ResponseWriter, statusCode int, value interface{}) int {
+	body, err := json.Marshal(value)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	_, _ = writer.Write(body)
+	return statusCode
+}
@@ -0,0 +1,142 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/logzio/azure-monitor-metrics-receiver/emulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEmulatorServer(t *testing.T, serverOptions ...emulator.ServerOptions) *emulator.Server {
+	server := emulator.NewServer(testSubscriptionID, serverOptions...)
+	t.Cleanup(server.Close)
+
+	timeStamp := time.Now().UTC().Truncate(time.Minute).Add(-5 * time.Minute)
+	for _, resource := range []struct {
+		id           string
+		resourceType string
+	}{
+		{testFullResourceGroup1ResourceType1Resource1, testResourceType1},
+		{testFullResourceGroup1ResourceType2Resource2, testResourceType2},
+		{testFullResourceGroup2ResourceType1Resource3, testResourceType1},
+	} {
+		server.AddResource(&emulator.Resource{ID: resource.id, Type: resource.resourceType, Location: testResourceRegion})
+		server.AddMetricDefinitions(resource.id,
+			&emulator.MetricDefinition{
+				Name:         testMetric1,
+				Unit:         armmonitor.MetricUnitCount,
+				Aggregations: []armmonitor.AggregationType{armmonitor.AggregationTypeTotal, armmonitor.AggregationTypeMaximum},
+				TimeGrains:   []string{"PT1M", "PT5M"},
+			},
+			&emulator.MetricDefinition{
+				Name:       testMetric2,
+				Unit:       armmonitor.MetricUnitBytes,
+				TimeGrains: []string{"PT5M"},
+			})
+		server.SetMetric(resource.id, &emulator.Metric{
+			Name: testMetric1,
+			TimeSeries: []*emulator.TimeSeries{{Values: []*armmonitor.MetricValue{
+				{TimeStamp: to.Ptr(timeStamp), Total: to.Ptr(1.0), Maximum: to.Ptr(1.0)},
+				{TimeStamp: to.Ptr(timeStamp.Add(time.Minute)), Total: to.Ptr(2.0), Maximum: to.Ptr(2.0)},
+			}}},
+		})
+	}
+
+	return server
+}
+
+func newTestEmulatorAzureClients(t *testing.T, server *emulator.Server) *AzureClients {
+	azureClients, err := CreateAzureClientsWithCreds(testSubscriptionID, server.Credential(), WithAzureClientOptions(server.ClientOptions()))
+	require.NoError(t, err)
+
+	return azureClients
+}
+
+func TestEmulator_InitializeAndCollect(t *testing.T) {
+	server := newTestEmulatorServer(t, emulator.WithPageSize(1))
+	targets := NewTargets(
+		[]*ResourceTarget{NewResourceTarget(testResourceGroup2ResourceType1Resource3, []string{testMetric1}, []string{})},
+		[]*ResourceGroupTarget{NewResourceGroupTarget(testResourceGroup1, []*Resource{NewResource(testResourceType2, []string{}, []string{})})},
+		[]*Resource{NewResource(testResourceType1, []string{testMetric1}, []string{"Total"})})
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, targets, newTestEmulatorAzureClients(t, server))
+	require.NoError(t, err)
+	require.NoError(t, ammr.InitializeTargetsWithContext(context.Background()))
+
+	resourceIDs := make([]string, 0)
+	for _, target := range ammr.Targets.ResourceTargets {
+		resourceIDs = append(resourceIDs, target.ResourceID+" "+target.TimeGrain)
+	}
+
+	sort.Strings(resourceIDs)
+	assert.Equal(t, []string{
+		testFullResourceGroup1ResourceType1Resource1 + " PT1M",
+		testFullResourceGroup1ResourceType2Resource2 + " PT1M",
+		testFullResourceGroup1ResourceType2Resource2 + " PT5M",
+		testFullResourceGroup2ResourceType1Resource3 + " PT1M",
+		testFullResourceGroup2ResourceType1Resource3 + " PT1M",
+	}, resourceIDs)
+
+	// The subscription resources are listed in two pages.
+	assert.Equal(t, 3, server.APIRequestsNum(emulator.APIResources))
+
+	// Without a checkpoint, only the latest value of every metric is collected.
+	totals := make(map[string]float64)
+	for _, target := range ammr.Targets.ResourceTargets {
+		metrics, _, err := ammr.CollectResourceTargetMetricsWithContext(context.Background(), target)
+		require.NoError(t, err)
+
+		for _, metric := range metrics {
+			if total, found := metric.Fields["total"]; found {
+				totals[metric.Tags[MetricTagResourceName]] += total.(float64)
+			}
+		}
+	}
+
+	assert.Equal(t, map[string]float64{"resource1": 2, "resource2": 2, "resource3": 4}, totals)
+}
+
+func TestEmulator_Throttling(t *testing.T) {
+	server := newTestEmulatorServer(t)
+	server.Throttle(emulator.APIMetrics, testFullResourceGroup1ResourceType1Resource1, time.Millisecond, 1)
+
+	ammr := &AzureMonitorMetricsReceiver{
+		AzureClients:   newTestEmulatorAzureClients(t, server),
+		subscriptionID: testSubscriptionID,
+	}
+
+	target := NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1}, []string{"Total"})
+	metrics, _, err := ammr.CollectResourceTargetMetricsWithContext(context.Background(), target)
+	require.NoError(t, err)
+	assert.Len(t, metrics, 1)
+
+	requests := server.Requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, http.StatusTooManyRequests, requests[0].StatusCode)
+	assert.Equal(t, http.StatusOK, requests[1].StatusCode)
+}
+
+func TestEmulator_ErrorCode(t *testing.T) {
+	server := newTestEmulatorServer(t)
+	server.Fail(emulator.APIMetricDefinitions, "", http.StatusForbidden, "AuthorizationFailed", 1)
+
+	targets := NewTargets([]*ResourceTarget{NewResourceTarget(testResourceGroup1ResourceType1Resource1, []string{}, []string{})}, nil, nil)
+	instrumentation := NewPrometheusInstrumentation()
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, targets, newTestEmulatorAzureClients(t, server),
+		WithInstrumentation(instrumentation))
+	require.NoError(t, err)
+
+	err = ammr.InitializeTargetsWithContext(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "AuthorizationFailed")
+
+	text := getInstrumentationText(t, instrumentation)
+	assert.Contains(t, text, `azure_monitor_receiver_api_calls_total{api="metric_definitions",resource_type="Microsoft.Test/type1",status="error"} 1`+"\n")
+}
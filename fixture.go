@@ -0,0 +1,459 @@
+package azuremonitormetricsreceiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const (
+	fixtureRedactedValue = "REDACTED"
+	// fixtureSubscriptionIDFormat is the format of the placeholder of the nth subscription ID of a fixture.
+	fixtureSubscriptionIDFormat = "00000000-0000-0000-0000-%012d"
+)
+
+var (
+	// fixtureResponseHeaders are the only response headers that are recorded.
+	fixtureResponseHeaders = []string{"Content-Type", "Retry-After", "Retry-After-Ms", "X-Ms-Retry-After-Ms", "X-Ms-Error-Code"}
+	// fixtureIgnoredQueryParameters change on every run, so they are not matched when replaying.
+	fixtureIgnoredQueryParameters = []string{"timespan"}
+	// fixtureSecretKeyRegexp matches the JSON keys whose values are redacted.
+	fixtureSecretKeyRegexp = regexp.MustCompile(`(?i)(secret|password|token|connectionstring|key|tenantid|principalid|clientid)$`)
+	// fixtureNotSecretKeys match fixtureSecretKeyRegexp but are not redacted, such as the Resource Graph page tokens,
+	// which are sent back in the next page request.
+	fixtureNotSecretKeys = []string{"$skipToken"}
+	// fixtureSubscriptionIDRegexps match the subscription IDs of resource IDs and of subscription ID JSON values,
+	// such as the subscription IDs of management group descendants and Resource Graph rows.
+	fixtureSubscriptionIDRegexps = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)/subscriptions/([^/?#&"'\\\s]+)`),
+		regexp.MustCompile(`(?i)"subscriptionId"\s*:\s*"([^"]+)"`),
+	}
+	// fixtureSubscriptionIDPlaceholderRegexp matches the subscription ID placeholders.
+	fixtureSubscriptionIDPlaceholderRegexp = regexp.MustCompile(`^00000000-0000-0000-0000-[0-9]{12}$`)
+)
+
+// Fixture is the recorded Azure Resource Manager responses of a run. Subscription IDs are replaced with
+// placeholders, and secrets are redacted.
+type Fixture struct {
+	Interactions []*FixtureInteraction `json:"interactions"`
+}
+
+// FixtureInteraction is a recorded request and its response. RequestBodyHash is the SHA-256 hash of the body of
+// POST requests, such as Resource Graph queries, whose query and page are in the request body.
+type FixtureInteraction struct {
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	RequestBodyHash string            `json:"request_body_hash,omitempty"`
+	StatusCode      int               `json:"status_code"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Body            string            `json:"body"`
+}
+
+// FixtureRecorder records the Azure Resource Manager responses of the Azure clients it is set on.
+type FixtureRecorder struct {
+	fixture         *Fixture
+	subscriptionIDs *fixtureSubscriptionIDs
+	mutex           sync.Mutex
+}
+
+// FixtureReplayer serves recorded Azure Resource Manager responses to the Azure clients it is set on,
+// instead of sending requests to Azure.
+type FixtureReplayer struct {
+	interactions    map[string][]*FixtureInteraction
+	subscriptionIDs *fixtureSubscriptionIDs
+	mutex           sync.Mutex
+}
+
+// fixtureSubscriptionIDs are the subscription IDs of a fixture, by the order they were first seen in.
+// The nth subscription ID is replaced with the nth placeholder.
+type fixtureSubscriptionIDs struct {
+	subscriptionIDs []string
+	mutex           sync.Mutex
+}
+
+type fixtureRecordingTransport struct {
+	recorder  *FixtureRecorder
+	transport policy.Transporter
+}
+
+type fixtureReplayingTransport struct {
+	replayer       *FixtureReplayer
+	subscriptionID string
+	placeholder    string
+}
+
+type fixtureTokenCredential struct{}
+
+// NewFixtureRecorder lets you create a new fixture recorder.
+func NewFixtureRecorder() *FixtureRecorder {
+	return &FixtureRecorder{
+		fixture:         &Fixture{Interactions: make([]*FixtureInteraction, 0)},
+		subscriptionIDs: &fixtureSubscriptionIDs{},
+	}
+}
+
+// NewFixtureReplayer lets you create a new fixture replayer of the fixture.
+func NewFixtureReplayer(fixture *Fixture) *FixtureReplayer {
+	replayer := &FixtureReplayer{
+		interactions:    make(map[string][]*FixtureInteraction),
+		subscriptionIDs: &fixtureSubscriptionIDs{},
+	}
+	for _, interaction := range fixture.Interactions {
+		key := getFixtureInteractionKey(interaction.Method, interaction.URL, interaction.RequestBodyHash)
+		replayer.interactions[key] = append(replayer.interactions[key], interaction)
+	}
+
+	return replayer
+}
+
+// LoadFixture loads a fixture from a JSON file.
+func LoadFixture(path string) (*Fixture, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading fixture file %s: %v", path, err)
+	}
+
+	fixture := &Fixture{}
+	if err = json.Unmarshal(content, fixture); err != nil {
+		return nil, fmt.Errorf("fixture file %s is bad formatted: %v", path, err)
+	}
+
+	return fixture, nil
+}
+
+// WithFixtureRecorder lets you record the Azure Resource Manager responses of the Azure clients.
+// The credential requests are not recorded.
+func WithFixtureRecorder(recorder *FixtureRecorder) ClientOptions {
+	return func(azureClientOptions *AzureClientOptions) {
+		azureClientOptions.fixtureRecorder = recorder
+	}
+}
+
+// WithFixtureReplayer lets you serve recorded Azure Resource Manager responses to the Azure clients.
+// The Azure clients created from a CredentialsConfig do not use the credentials when replaying.
+func WithFixtureReplayer(replayer *FixtureReplayer) ClientOptions {
+	return func(azureClientOptions *AzureClientOptions) {
+		azureClientOptions.fixtureReplayer = replayer
+	}
+}
+
+// Fixture returns the responses recorded so far.
+func (fr *FixtureRecorder) Fixture() *Fixture {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	return &Fixture{Interactions: append([]*FixtureInteraction{}, fr.fixture.Interactions...)}
+}
+
+// Save saves the responses recorded so far to a JSON file.
+func (fr *FixtureRecorder) Save(path string) error {
+	content, err := json.MarshalIndent(fr.Fixture(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(path, content, 0o600); err != nil {
+		return fmt.Errorf("error writing fixture file %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// newTransport returns a transport that records the responses of the subscription Azure clients.
+// Every subscription ID in the recorded responses is replaced with a placeholder, not only the subscription ID
+// of the Azure clients.
+func (fr *FixtureRecorder) newTransport(subscriptionID string, transport policy.Transporter) policy.Transporter {
+	if transport == nil {
+		transport = http.DefaultClient
+	}
+
+	fr.subscriptionIDs.getPlaceholder(subscriptionID)
+	return &fixtureRecordingTransport{
+		recorder:  fr,
+		transport: transport,
+	}
+}
+
+func (fr *FixtureRecorder) record(interaction *FixtureInteraction) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	fr.fixture.Interactions = append(fr.fixture.Interactions, interaction)
+}
+
+// newTransport returns a transport that replays the responses of the subscription Azure clients.
+// The subscriptions are matched with the recorded subscriptions by the order their Azure clients are created in.
+func (fr *FixtureReplayer) newTransport(subscriptionID string) policy.Transporter {
+	return &fixtureReplayingTransport{
+		replayer:       fr,
+		subscriptionID: subscriptionID,
+		placeholder:    fr.subscriptionIDs.getPlaceholder(subscriptionID),
+	}
+}
+
+// take returns the next interaction of the request. The last interaction of a request is replayed again
+// when all of its interactions were replayed.
+func (fr *FixtureReplayer) take(method string, requestURL string, requestBodyHash string) *FixtureInteraction {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	key := getFixtureInteractionKey(method, requestURL, requestBodyHash)
+	interactions := fr.interactions[key]
+	if len(interactions) == 0 {
+		return nil
+	}
+
+	if len(interactions) > 1 {
+		fr.interactions[key] = interactions[1:]
+	}
+
+	return interactions[0]
+}
+
+func (frt *fixtureRecordingTransport) Do(request *http.Request) (*http.Response, error) {
+	requestBodyHash, err := getFixtureRequestBodyHash(request, frt.recorder.subscriptionIDs, true)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := frt.transport.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	interaction := &FixtureInteraction{
+		Method:          request.Method,
+		URL:             frt.recorder.subscriptionIDs.scrub(request.URL.RequestURI(), true),
+		RequestBodyHash: requestBodyHash,
+		StatusCode:      response.StatusCode,
+		Headers:         make(map[string]string),
+		Body:            scrubFixtureBody(string(body), frt.recorder.subscriptionIDs),
+	}
+
+	for _, header := range fixtureResponseHeaders {
+		if value := response.Header.Get(header); value != "" {
+			interaction.Headers[header] = value
+		}
+	}
+
+	frt.recorder.record(interaction)
+	return response, nil
+}
+
+func (frt *fixtureReplayingTransport) Do(request *http.Request) (*http.Response, error) {
+	requestBodyHash, err := getFixtureRequestBodyHash(request, frt.replayer.subscriptionIDs, false)
+	if err != nil {
+		return nil, err
+	}
+
+	requestURL := frt.replayer.subscriptionIDs.scrub(request.URL.RequestURI(), false)
+	interaction := frt.replayer.take(request.Method, requestURL, requestBodyHash)
+	if interaction == nil {
+		return createFixtureResponse(request, http.StatusNotFound, map[string]string{"X-Ms-Error-Code": "FixtureInteractionNotFound"},
+			fmt.Sprintf(`{"error":{"code":"FixtureInteractionNotFound","message":"fixture has no response of %s %s"}}`, request.Method, requestURL)), nil
+	}
+
+	return createFixtureResponse(request, interaction.StatusCode, interaction.Headers,
+		strings.ReplaceAll(interaction.Body, frt.placeholder, frt.subscriptionID)), nil
+}
+
+func (fixtureTokenCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "fixture", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func createFixtureResponse(request *http.Request, statusCode int, headers map[string]string, body string) *http.Response {
+	response := &http.Response{
+		StatusCode:    statusCode,
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}
+
+	for header, value := range headers {
+		response.Header.Set(header, value)
+	}
+
+	return response
+}
+
+// getPlaceholder returns the placeholder of the subscription ID. A placeholder is its own placeholder, so the
+// replayed subscriptions that were found in responses, such as management group descendants, keep their placeholders.
+func (fsi *fixtureSubscriptionIDs) getPlaceholder(subscriptionID string) string {
+	if fixtureSubscriptionIDPlaceholderRegexp.MatchString(subscriptionID) {
+		return subscriptionID
+	}
+
+	fsi.mutex.Lock()
+	defer fsi.mutex.Unlock()
+
+	for index, currentSubscriptionID := range fsi.subscriptionIDs {
+		if strings.EqualFold(currentSubscriptionID, subscriptionID) {
+			return fmt.Sprintf(fixtureSubscriptionIDFormat, index+1)
+		}
+	}
+
+	fsi.subscriptionIDs = append(fsi.subscriptionIDs, subscriptionID)
+	return fmt.Sprintf(fixtureSubscriptionIDFormat, len(fsi.subscriptionIDs))
+}
+
+// scrub replaces the subscription IDs with their placeholders, ignoring case. If isAddingFound is true,
+// the subscription IDs found in the value are added first, otherwise only the known subscription IDs are replaced.
+func (fsi *fixtureSubscriptionIDs) scrub(value string, isAddingFound bool) string {
+	if isAddingFound {
+		for _, subscriptionIDRegexp := range fixtureSubscriptionIDRegexps {
+			for _, match := range subscriptionIDRegexp.FindAllStringSubmatch(value, -1) {
+				fsi.getPlaceholder(match[1])
+			}
+		}
+	}
+
+	fsi.mutex.Lock()
+	subscriptionIDs := append([]string{}, fsi.subscriptionIDs...)
+	fsi.mutex.Unlock()
+
+	for index, subscriptionID := range subscriptionIDs {
+		value = scrubFixtureString(value, subscriptionID, fmt.Sprintf(fixtureSubscriptionIDFormat, index+1))
+	}
+
+	return value
+}
+
+// getFixtureRequestBodyHash returns the SHA-256 hash of the POST request body with its subscription IDs replaced
+// with their placeholders, or an empty string if the request is not a POST request. The request body is read
+// and replaced, so the request can be sent.
+func getFixtureRequestBodyHash(request *http.Request, subscriptionIDs *fixtureSubscriptionIDs, isAddingFound bool) (string, error) {
+	if request.Method != http.MethodPost || request.Body == nil {
+		return "", nil
+	}
+
+	body, err := io.ReadAll(request.Body)
+	_ = request.Body.Close()
+	if err != nil {
+		return "", err
+	}
+
+	request.Body = io.NopCloser(bytes.NewReader(body))
+
+	hash := sha256.Sum256([]byte(subscriptionIDs.scrub(string(body), isAddingFound)))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// getFixtureInteractionKey returns the method and the lower case path of the URL, with its query parameters
+// sorted and without the ignored query parameters, and the request body hash if it is not empty.
+func getFixtureInteractionKey(method string, requestURL string, requestBodyHash string) string {
+	key := getFixtureInteractionURLKey(method, requestURL)
+	if requestBodyHash != "" {
+		key += " " + requestBodyHash
+	}
+
+	return key
+}
+
+func getFixtureInteractionURLKey(method string, requestURL string) string {
+	parsedURL, err := url.Parse(requestURL)
+	if err != nil {
+		return method + " " + strings.ToLower(requestURL)
+	}
+
+	query := parsedURL.Query()
+	for _, parameter := range fixtureIgnoredQueryParameters {
+		query.Del(parameter)
+	}
+
+	parameters := make([]string, 0, len(query))
+	for parameter, values := range query {
+		parameters = append(parameters, strings.ToLower(parameter)+"="+strings.Join(values, ","))
+	}
+
+	sort.Strings(parameters)
+	return method + " " + strings.ToLower(parsedURL.Path) + "?" + strings.Join(parameters, "&")
+}
+
+// scrubFixtureString replaces the subscription ID with its placeholder, ignoring case.
+func scrubFixtureString(value string, subscriptionID string, placeholder string) string {
+	if subscriptionID == "" {
+		return value
+	}
+
+	return regexp.MustCompile("(?i)"+regexp.QuoteMeta(subscriptionID)).ReplaceAllString(value, placeholder)
+}
+
+// scrubFixtureBody replaces all the subscription IDs with their placeholders and, if the body is JSON, redacts
+// the values of keys that may hold secrets or identities.
+func scrubFixtureBody(body string, subscriptionIDs *fixtureSubscriptionIDs) string {
+	body = subscriptionIDs.scrub(body, true)
+
+	// Numbers are decoded as json.Number, so they are encoded back as they were.
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return body
+	}
+
+	scrubbedBody, err := json.Marshal(redactFixtureJSONValue(value))
+	if err != nil {
+		return body
+	}
+
+	return string(scrubbedBody)
+}
+
+func redactFixtureJSONValue(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for key, keyValue := range typedValue {
+			if _, isString := keyValue.(string); isString && isFixtureSecretKey(key) {
+				typedValue[key] = fixtureRedactedValue
+				continue
+			}
+
+			typedValue[key] = redactFixtureJSONValue(keyValue)
+		}
+	case []interface{}:
+		for index, item := range typedValue {
+			typedValue[index] = redactFixtureJSONValue(item)
+		}
+	}
+
+	return value
+}
+
+func isFixtureSecretKey(key string) bool {
+	for _, notSecretKey := range fixtureNotSecretKeys {
+		if strings.EqualFold(key, notSecretKey) {
+			return false
+		}
+	}
+
+	return fixtureSecretKeyRegexp.MatchString(key)
+}
@@ -0,0 +1,245 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/logzio/azure-monitor-metrics-receiver/emulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testOtherSubscriptionID = "11111111-2222-3333-4444-555555555555"
+
+func getTestResourceTargetsIDs(ammr *AzureMonitorMetricsReceiver) []string {
+	resourceIDs := make([]string, 0)
+	for _, target := range ammr.Targets.ResourceTargets {
+		resourceIDs = append(resourceIDs, target.ResourceID+" "+strings.Join(target.Metrics, ",")+" "+target.TimeGrain)
+	}
+
+	sort.Strings(resourceIDs)
+	return resourceIDs
+}
+
+func TestFixture_RecordAndReplay(t *testing.T) {
+	server := newTestEmulatorServer(t, emulator.WithPageSize(1))
+	recorder := NewFixtureRecorder()
+	azureClients, err := CreateAzureClientsWithCreds(testSubscriptionID, server.Credential(),
+		WithAzureClientOptions(server.ClientOptions()), WithFixtureRecorder(recorder))
+	require.NoError(t, err)
+
+	targets := NewTargets(nil, nil, []*Resource{NewResource(testResourceType1, []string{}, []string{})})
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, targets, azureClients)
+	require.NoError(t, err)
+	require.NoError(t, ammr.InitializeTargetsWithContext(context.Background()))
+
+	recordedMetrics, _, err := ammr.CollectResourceTargetMetricsWithContext(context.Background(), ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	require.NotEmpty(t, recordedMetrics)
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	require.NoError(t, recorder.Save(path))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, strings.ToLower(string(content)), strings.ToLower(testSubscriptionID))
+	assert.NotContains(t, string(content), "Bearer")
+	assert.Contains(t, string(content), "00000000-0000-0000-0000-000000000001")
+
+	server.Close()
+
+	fixture, err := LoadFixture(path)
+	require.NoError(t, err)
+	assert.Len(t, fixture.Interactions, len(recorder.Fixture().Interactions))
+
+	replayedAzureClients, err := CreateAzureClientsWithCreds(testOtherSubscriptionID, &testTokenCredential{},
+		WithFixtureReplayer(NewFixtureReplayer(fixture)))
+	require.NoError(t, err)
+
+	replayedTargets := NewTargets(nil, nil, []*Resource{NewResource(testResourceType1, []string{}, []string{})})
+	replayedAmmr, err := NewAzureMonitorMetricsReceiver(testOtherSubscriptionID, replayedTargets, replayedAzureClients)
+	require.NoError(t, err)
+	require.NoError(t, replayedAmmr.InitializeTargetsWithContext(context.Background()))
+
+	assert.Equal(t,
+		strings.ReplaceAll(strings.Join(getTestResourceTargetsIDs(ammr), "\n"), testSubscriptionID, testOtherSubscriptionID),
+		strings.Join(getTestResourceTargetsIDs(replayedAmmr), "\n"))
+
+	replayedMetrics, _, err := replayedAmmr.CollectResourceTargetMetricsWithContext(context.Background(), replayedAmmr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	require.Len(t, replayedMetrics, len(recordedMetrics))
+	assert.Equal(t, recordedMetrics[0].Fields, replayedMetrics[0].Fields)
+	assert.Equal(t, testOtherSubscriptionID, replayedMetrics[0].Tags[MetricTagSubscriptionID])
+}
+
+func TestFixtureReplayer_NotFound(t *testing.T) {
+	azureClients, err := CreateAzureClientsWithCreds(testSubscriptionID, &testTokenCredential{},
+		WithFixtureReplayer(NewFixtureReplayer(&Fixture{})))
+	require.NoError(t, err)
+
+	_, err = azureClients.MetricDefinitionsClient.List(context.Background(), testFullResourceGroup1ResourceType1Resource1, nil)
+	require.Error(t, err)
+
+	var responseError *azcore.ResponseError
+	require.ErrorAs(t, err, &responseError)
+	assert.Equal(t, "FixtureInteractionNotFound", responseError.ErrorCode)
+}
+
+func TestFixtureReplayer_Sequence(t *testing.T) {
+	fixture := &Fixture{Interactions: []*FixtureInteraction{
+		{Method: "GET", URL: "/path?b=2&a=1&timespan=x", StatusCode: 429, Body: "first"},
+		{Method: "GET", URL: "/path?a=1&b=2", StatusCode: 200, Body: "second"},
+	}}
+	replayer := NewFixtureReplayer(fixture)
+
+	assert.Equal(t, "first", replayer.take("GET", "/PATH?a=1&b=2&timespan=y", "").Body)
+	assert.Equal(t, "second", replayer.take("GET", "/path?b=2&a=1", "").Body)
+	assert.Equal(t, "second", replayer.take("GET", "/path?a=1&b=2", "").Body)
+	assert.Nil(t, replayer.take("GET", "/path?a=1", ""))
+}
+
+func TestScrubFixtureBody(t *testing.T) {
+	body := `{"value":[{"id":"/subscriptions/SUBSCRIPTIONID/resourceGroups/rg","identity":{"principalId":"p","tenantId":"t"},` +
+		`"properties":{"primaryKey":"k","clientSecret":"s","connectionString":"c","size":12345678901234567890}}]}`
+
+	subscriptionIDs := &fixtureSubscriptionIDs{}
+	subscriptionIDs.getPlaceholder(testSubscriptionID)
+	scrubbedBody := scrubFixtureBody(body, subscriptionIDs)
+
+	assert.Contains(t, scrubbedBody, `"/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/rg"`)
+	assert.Contains(t, scrubbedBody, `"principalId":"REDACTED"`)
+	assert.Contains(t, scrubbedBody, `"tenantId":"REDACTED"`)
+	assert.Contains(t, scrubbedBody, `"primaryKey":"REDACTED"`)
+	assert.Contains(t, scrubbedBody, `"clientSecret":"REDACTED"`)
+	assert.Contains(t, scrubbedBody, `"connectionString":"REDACTED"`)
+	assert.Contains(t, scrubbedBody, `"size":12345678901234567890`)
+
+	assert.Equal(t, "not json other", scrubFixtureBody("not json other", subscriptionIDs))
+}
+
+func TestScrubFixtureBody_AllSubscriptionIDs(t *testing.T) {
+	const subscriptionID = "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+	subscriptionIDs := &fixtureSubscriptionIDs{}
+	subscriptionIDs.getPlaceholder(subscriptionID)
+
+	body := `{"value":[{"id":"/subscriptions/` + testOtherSubscriptionID + `","type":"/subscriptions","name":"` + testOtherSubscriptionID + `"},` +
+		`{"subscriptionId":"third","id":"/subscriptions/` + subscriptionID + `/resourceGroups/rg"}]}`
+	scrubbedBody := scrubFixtureBody(body, subscriptionIDs)
+
+	assert.NotContains(t, scrubbedBody, testOtherSubscriptionID)
+	assert.NotContains(t, scrubbedBody, "third")
+	assert.NotContains(t, scrubbedBody, subscriptionID)
+	assert.Contains(t, scrubbedBody, `"name":"00000000-0000-0000-0000-000000000002"`)
+	assert.Contains(t, scrubbedBody, `"subscriptionId":"00000000-0000-0000-0000-000000000003"`)
+	assert.Contains(t, scrubbedBody, `"/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/rg"`)
+
+	// The placeholders are not replaced again.
+	assert.Equal(t, scrubbedBody, scrubFixtureBody(scrubbedBody, subscriptionIDs))
+	assert.Equal(t, "00000000-0000-0000-0000-000000000002", subscriptionIDs.getPlaceholder(testOtherSubscriptionID))
+}
+
+func TestFixture_RecordAndReplayManagementGroupDescendants(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write([]byte(`{"value":[{"id":"/subscriptions/` + testOtherSubscriptionID + `","type":"/subscriptions",` +
+			`"name":"` + testOtherSubscriptionID + `","properties":{"displayName":"other"}}]}`))
+	}))
+	defer server.Close()
+
+	azureCloud, err := NewCustomAzureCloud("https://login.example.com/", server.URL, "https://management.example.com")
+	require.NoError(t, err)
+
+	recorder := NewFixtureRecorder()
+	azureClients, err := CreateAzureClientsWithCreds(testSubscriptionID, &testTokenCredential{},
+		WithAzureClientOptions(&azcore.ClientOptions{Transport: server.Client()}), WithAzureCloud(azureCloud),
+		WithFixtureRecorder(recorder))
+	require.NoError(t, err)
+
+	ammr := &AzureMonitorMetricsReceiver{AzureClients: azureClients, subscriptionID: testSubscriptionID}
+	subscriptionIDs, err := ammr.listManagementGroupSubscriptions(context.Background(), "mg")
+	require.NoError(t, err)
+	assert.Equal(t, []string{testOtherSubscriptionID}, subscriptionIDs)
+
+	fixture := recorder.Fixture()
+	require.Len(t, fixture.Interactions, 1)
+	assert.NotContains(t, fixture.Interactions[0].Body, testOtherSubscriptionID)
+
+	// The descendant subscriptions are replayed as their placeholders.
+	replayedAzureClients, err := CreateAzureClientsWithCreds(testSubscriptionID, &testTokenCredential{},
+		WithAzureCloud(azureCloud), WithFixtureReplayer(NewFixtureReplayer(fixture)))
+	require.NoError(t, err)
+
+	replayedAmmr := &AzureMonitorMetricsReceiver{AzureClients: replayedAzureClients, subscriptionID: testSubscriptionID}
+	subscriptionIDs, err = replayedAmmr.listManagementGroupSubscriptions(context.Background(), "mg")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"00000000-0000-0000-0000-000000000002"}, subscriptionIDs)
+}
+
+func TestFixture_RecordAndReplayResourceGraphQueries(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var queryRequest struct {
+			Query   string `json:"query"`
+			Options struct {
+				SkipToken string `json:"$skipToken"`
+			} `json:"options"`
+		}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&queryRequest))
+
+		// Every query has two pages, and the second page has a resource of another subscription.
+		resourceID := "/subscriptions/" + testSubscriptionID + "/resourceGroups/rg/providers/" + queryRequest.Query + "/first"
+		skipToken := `,"$skipToken":"second"`
+		if queryRequest.Options.SkipToken == "second" {
+			resourceID = "/subscriptions/" + testOtherSubscriptionID + "/resourceGroups/rg/providers/" + queryRequest.Query + "/second"
+			skipToken = ""
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write([]byte(`{"count":1,"data":[{"id":"` + resourceID + `","type":"` + queryRequest.Query + `"}]` + skipToken + `}`))
+	}))
+	defer server.Close()
+
+	azureCloud, err := NewCustomAzureCloud("https://login.example.com/", server.URL, "https://management.example.com")
+	require.NoError(t, err)
+
+	recorder := NewFixtureRecorder()
+	azureClients, err := CreateAzureClientsWithCreds(testSubscriptionID, &testTokenCredential{},
+		WithAzureClientOptions(&azcore.ClientOptions{Transport: server.Client()}), WithAzureCloud(azureCloud),
+		WithFixtureRecorder(recorder))
+	require.NoError(t, err)
+
+	ammr := &AzureMonitorMetricsReceiver{AzureClients: azureClients, subscriptionID: testSubscriptionID}
+	for _, query := range []string{"Microsoft.Test/type1", "Microsoft.Test/type2"} {
+		resources, err := ammr.listResourceGraphResources(context.Background(), query, []string{testSubscriptionID})
+		require.NoError(t, err)
+		require.Len(t, resources, 2)
+	}
+
+	fixture := recorder.Fixture()
+	require.Len(t, fixture.Interactions, 4)
+	for _, interaction := range fixture.Interactions {
+		assert.NotEmpty(t, interaction.RequestBodyHash)
+		assert.NotContains(t, interaction.Body, testOtherSubscriptionID)
+	}
+
+	// The queries and their pages are replayed by their request bodies, not by the recorded order.
+	replayedAzureClients, err := CreateAzureClientsWithCreds(testOtherSubscriptionID, &testTokenCredential{},
+		WithAzureCloud(azureCloud), WithFixtureReplayer(NewFixtureReplayer(fixture)))
+	require.NoError(t, err)
+
+	replayedAmmr := &AzureMonitorMetricsReceiver{AzureClients: replayedAzureClients, subscriptionID: testOtherSubscriptionID}
+	for _, query := range []string{"Microsoft.Test/type2", "Microsoft.Test/type1"} {
+		resources, err := replayedAmmr.listResourceGraphResources(context.Background(), query, []string{testOtherSubscriptionID})
+		require.NoError(t, err)
+		require.Len(t, resources, 2)
+		assert.Equal(t, "/subscriptions/"+testOtherSubscriptionID+"/resourceGroups/rg/providers/"+query+"/first", *resources[0].ID)
+		assert.Equal(t, "/subscriptions/00000000-0000-0000-0000-000000000002/resourceGroups/rg/providers/"+query+"/second", *resources[1].ID)
+	}
+}
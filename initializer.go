@@ -2,13 +2,22 @@ package azuremonitormetricsreceiver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/tracing"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/managementgroups/armmanagementgroups"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 )
 
@@ -17,12 +26,31 @@ const (
 	MaxMetricsPerRequest = 20
 )
 
+// errMetricDefinitionsEmpty is returned when a resource has no metric definitions.
+var errMetricDefinitionsEmpty = errors.New("metric definitions response is bad formatted: Value is empty")
+
+type metricDefinitionsCache struct {
+	mutex     sync.RWMutex
+	responses map[string]*armmonitor.MetricDefinitionsClientListResponse
+}
+
+// metricAvailability is a metric time grain and the retention of the metric in this time grain.
+type metricAvailability struct {
+	timeGrain         string
+	timeGrainDuration time.Duration
+	retention         string
+	retentionDuration time.Duration
+}
+
 type metricDefWrapper struct {
 	client *armmonitor.MetricDefinitionsClient
 }
 
 type AzureClientOptions struct {
-	clientOptions *azcore.ClientOptions
+	clientOptions   *azcore.ClientOptions
+	cloud           *cloud.Configuration
+	fixtureRecorder *FixtureRecorder
+	fixtureReplayer *FixtureReplayer
 }
 
 func (w *metricDefWrapper) List(ctx context.Context, resourceID string, options *armmonitor.MetricDefinitionsClientListOptions) (armmonitor.MetricDefinitionsClientListResponse, error) {
@@ -41,33 +69,21 @@ func (w *metricDefWrapper) List(ctx context.Context, resourceID string, options
 
 // CreateAzureClients creates Azure clients with service principal credentials
 func CreateAzureClients(subscriptionID string, clientID string, clientSecret string, tenantID string, clientOptions ...func(*AzureClientOptions)) (*AzureClients, error) {
-	var options *azidentity.ClientSecretCredentialOptions = nil
-	azureClientOptions := checkOptionalClientParameters(clientOptions, &AzureClientOptions{})
-
-	if azureClientOptions != nil {
-		options = &azidentity.ClientSecretCredentialOptions{ClientOptions: *azureClientOptions.clientOptions}
-	}
+	options := &azidentity.ClientSecretCredentialOptions{ClientOptions: getAzureClientOptions(clientOptions)}
 
 	credential, err := azidentity.NewClientSecretCredential(tenantID, clientID, clientSecret, options)
 	if err != nil {
 		return nil, fmt.Errorf("error creating Azure client credential: %w", err)
 	}
 
-	if azureClientOptions != nil {
-		return CreateAzureClientsWithCreds(subscriptionID, credential, WithAzureClientOptions(azureClientOptions.clientOptions))
-	} else {
-		return CreateAzureClientsWithCreds(subscriptionID, credential)
-	}
+	return CreateAzureClientsWithCreds(subscriptionID, credential, clientOptions...)
 }
 
 // CreateAzureClientsWithCreds creates Azure clients with provided TokenCredential
 func CreateAzureClientsWithCreds(subscriptionID string, credential azcore.TokenCredential, clientOptions ...func(*AzureClientOptions)) (*AzureClients, error) {
-	var armClientOptions *arm.ClientOptions = nil
-	azureClientOptions := checkOptionalClientParameters(clientOptions, &AzureClientOptions{})
-
-	if azureClientOptions != nil {
-		armClientOptions = &arm.ClientOptions{ClientOptions: *azureClientOptions.clientOptions}
-	}
+	azureClientOptions := newAzureClientOptions(clientOptions)
+	armClientOptions := &arm.ClientOptions{ClientOptions: azureClientOptions.getClientOptions()}
+	armClientOptions.Transport = azureClientOptions.getARMTransport(subscriptionID, armClientOptions.Transport)
 
 	metricClient, err := armmonitor.NewMetricsClient(subscriptionID, credential, armClientOptions)
 	if err != nil {
@@ -83,11 +99,36 @@ func CreateAzureClientsWithCreds(subscriptionID string, credential azcore.TokenC
 		return nil, fmt.Errorf("error creating Azure definitions client: %w", err)
 	}
 
+	resourceGraphClient, err := armresourcegraph.NewClient(credential, armClientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Azure resource graph client: %w", err)
+	}
+
+	managementGroupsClient, err := armmanagementgroups.NewClient(credential, armClientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Azure management groups client: %w", err)
+	}
+
+	newResourcesClient := func(resourcesSubscriptionID string) (ResourcesClient, error) {
+		resourcesClientOptions := &arm.ClientOptions{ClientOptions: azureClientOptions.getClientOptions()}
+		resourcesClientOptions.Transport = azureClientOptions.getARMTransport(resourcesSubscriptionID, resourcesClientOptions.Transport)
+
+		client, err := armresources.NewClient(resourcesSubscriptionID, credential, resourcesClientOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error creating Azure resources client: %w", err)
+		}
+
+		return &azureResourcesClient{client: client}, nil
+	}
+
 	return &AzureClients{
 		Ctx:                     context.Background(),
 		ResourcesClient:         &azureResourcesClient{client: resClient},
 		MetricsClient:           metricClient,
 		MetricDefinitionsClient: &metricDefWrapper{client: defClient},
+		ResourceGraphClient:     resourceGraphClient,
+		ManagementGroupsClient:  &azureManagementGroupsClient{client: managementGroupsClient},
+		NewResourcesClient:      newResourcesClient,
 	}, nil
 }
 
@@ -99,17 +140,46 @@ func WithAzureClientOptions(options *azcore.ClientOptions) ClientOptions {
 	}
 }
 
-func checkOptionalClientParameters(clientOptions []func(*AzureClientOptions), azureClientOptions *AzureClientOptions) *AzureClientOptions {
-	if clientOptions != nil {
-		for _, optArgs := range clientOptions {
-			optArgs(azureClientOptions)
-		}
+// getAzureClientOptions returns the Azure client options the client options set, or the default Azure client
+// options if none is set. The Azure cloud is set last, so it is not replaced by WithAzureClientOptions.
+func getAzureClientOptions(clientOptions []func(*AzureClientOptions)) azcore.ClientOptions {
+	return newAzureClientOptions(clientOptions).getClientOptions()
+}
 
-		if &azureClientOptions != nil {
-			return azureClientOptions
-		}
+func newAzureClientOptions(clientOptions []func(*AzureClientOptions)) *AzureClientOptions {
+	azureClientOptions := &AzureClientOptions{}
+	for _, clientOption := range clientOptions {
+		clientOption(azureClientOptions)
 	}
-	return nil
+
+	return azureClientOptions
+}
+
+func (aco *AzureClientOptions) getClientOptions() azcore.ClientOptions {
+	options := azcore.ClientOptions{}
+	if aco.clientOptions != nil {
+		options = *aco.clientOptions
+	}
+
+	if aco.cloud != nil {
+		options.Cloud = *aco.cloud
+	}
+
+	return options
+}
+
+// getARMTransport returns the transport of the Azure Resource Manager clients of the subscription, which replays or
+// records the responses if a fixture replayer or recorder is set. The credential requests use the transport as is.
+func (aco *AzureClientOptions) getARMTransport(subscriptionID string, transport policy.Transporter) policy.Transporter {
+	if aco.fixtureReplayer != nil {
+		return aco.fixtureReplayer.newTransport(subscriptionID)
+	}
+
+	if aco.fixtureRecorder != nil {
+		return aco.fixtureRecorder.newTransport(subscriptionID, transport)
+	}
+
+	return transport
 }
 
 func (ammr *AzureMonitorMetricsReceiver) checkValidation() error {
@@ -117,10 +187,33 @@ func (ammr *AzureMonitorMetricsReceiver) checkValidation() error {
 		return fmt.Errorf("subscription ID is empty or missing")
 	}
 
-	if len(ammr.Targets.ResourceTargets) == 0 && len(ammr.Targets.resourceGroupTargets) == 0 && len(ammr.Targets.subscriptionTargets) == 0 {
+	if len(ammr.Targets.ResourceTargets) == 0 && len(ammr.Targets.resourceGroupTargets) == 0 && len(ammr.Targets.subscriptionTargets) == 0 &&
+		len(ammr.Targets.resourceGraphTargets) == 0 && len(ammr.Targets.managementGroupTargets) == 0 {
 		return fmt.Errorf("no target to collect metrics from")
 	}
 
+	if ammr.correctionWindow < 0 {
+		return fmt.Errorf("correction window must not be negative")
+	}
+
+	if ammr.unsettledBucketsNum < 0 {
+		return fmt.Errorf("unsettled buckets number must not be negative")
+	}
+
+	if ammr.requestTimeout < 0 {
+		return fmt.Errorf("request timeout must not be negative")
+	}
+
+	if ammr.refreshInterval < 0 {
+		return fmt.Errorf("management groups refresh interval must not be negative")
+	}
+
+	if ammr.preferredTimeGrain != "" {
+		if _, err := parseISO8601Duration(ammr.preferredTimeGrain); err != nil {
+			return fmt.Errorf("preferred time grain is invalid: %v", err)
+		}
+	}
+
 	if err := ammr.checkResourceTargetsValidation(); err != nil {
 		return err
 	}
@@ -129,7 +222,15 @@ func (ammr *AzureMonitorMetricsReceiver) checkValidation() error {
 		return err
 	}
 
-	return ammr.checkSubscriptionTargetValidation()
+	if err := ammr.checkSubscriptionTargetValidation(); err != nil {
+		return err
+	}
+
+	if err := ammr.checkResourceGraphTargetsValidation(); err != nil {
+		return err
+	}
+
+	return ammr.checkManagementGroupTargetsValidation()
 }
 
 func (ammr *AzureMonitorMetricsReceiver) checkResourceTargetsValidation() error {
@@ -139,6 +240,19 @@ func (ammr *AzureMonitorMetricsReceiver) checkResourceTargetsValidation() error
 				"resource target #%d resource ID is empty or missing", index+1)
 		}
 
+		resourceID, err := ParseResourceID(target.ResourceID)
+		if err != nil {
+			return fmt.Errorf("resource target #%d resource ID is bad formatted: %v", index+1, err)
+		}
+
+		if resourceID.Provider() == "" {
+			return fmt.Errorf("resource target #%d resource ID %s is not a resource", index+1, target.ResourceID)
+		}
+
+		if target.Timeout < 0 {
+			return fmt.Errorf("resource target #%d timeout must not be negative", index+1)
+		}
+
 		if len(target.Aggregations) > 0 {
 			if !areTargetAggregationsValid(target.Aggregations) {
 				return fmt.Errorf("resource target #%d aggregations contain invalid aggregation/s. "+
@@ -169,6 +283,10 @@ func (ammr *AzureMonitorMetricsReceiver) checkResourceGroupTargetsValidation() e
 					resourceGroupIndex+1, resourceIndex+1)
 			}
 
+			if resource.timeout < 0 {
+				return fmt.Errorf("resource group target #%d resource #%d timeout must not be negative", resourceGroupIndex+1, resourceIndex+1)
+			}
+
 			if len(resource.aggregations) > 0 {
 				if !areTargetAggregationsValid(resource.aggregations) {
 					return fmt.Errorf("resource group target #%d resource #%d aggregations contain invalid aggregation/s. "+
@@ -188,6 +306,10 @@ func (ammr *AzureMonitorMetricsReceiver) checkSubscriptionTargetValidation() err
 				"subscription target #%d resource_type is empty or missing. Please check your configuration", index+1)
 		}
 
+		if target.timeout < 0 {
+			return fmt.Errorf("subscription target #%d timeout must not be negative", index+1)
+		}
+
 		if len(target.aggregations) > 0 {
 			if !areTargetAggregationsValid(target.aggregations) {
 				return fmt.Errorf("subscription target #%d aggregations contain invalid aggregation/s. "+
@@ -199,20 +321,83 @@ func (ammr *AzureMonitorMetricsReceiver) checkSubscriptionTargetValidation() err
 	return nil
 }
 
-func (ammr *AzureMonitorMetricsReceiver) addPrefixToResourceTargetsResourceID() {
-	for _, target := range ammr.Targets.ResourceTargets {
-		target.ResourceID = "/subscriptions/" + ammr.subscriptionID + "/" + target.ResourceID
+func (ammr *AzureMonitorMetricsReceiver) checkResourceGraphTargetsValidation() error {
+	for index, target := range ammr.Targets.resourceGraphTargets {
+		if strings.TrimSpace(target.query) == "" {
+			return fmt.Errorf("resource graph target #%d query is empty or missing", index+1)
+		}
+
+		for _, subscriptionID := range target.subscriptions {
+			if subscriptionID == "" {
+				return fmt.Errorf("resource graph target #%d subscription ID is empty", index+1)
+			}
+		}
+
+		if target.timeout < 0 {
+			return fmt.Errorf("resource graph target #%d timeout must not be negative", index+1)
+		}
+
+		if len(target.aggregations) > 0 {
+			if !areTargetAggregationsValid(target.aggregations) {
+				return fmt.Errorf("resource graph target #%d aggregations contain invalid aggregation/s. "+
+					"The valid aggregations are: %s", index+1, strings.Join(getPossibleAggregations(), ", "))
+			}
+		}
 	}
+
+	return nil
+}
+
+func (ammr *AzureMonitorMetricsReceiver) checkManagementGroupTargetsValidation() error {
+	for managementGroupIndex, target := range ammr.Targets.managementGroupTargets {
+		if target.managementGroup == "" {
+			return fmt.Errorf(
+				"management group target #%d management group is empty or missing",
+				managementGroupIndex+1)
+		}
+
+		if len(target.resources) == 0 {
+			return fmt.Errorf("management group target #%d has no resources", managementGroupIndex+1)
+		}
+
+		for resourceIndex, resource := range target.resources {
+			if resource.resourceType == "" {
+				return fmt.Errorf(
+					"management group target #%d resource #%d resource_type is empty or missing. Please check your configuration",
+					managementGroupIndex+1, resourceIndex+1)
+			}
+
+			if resource.timeout < 0 {
+				return fmt.Errorf("management group target #%d resource #%d timeout must not be negative", managementGroupIndex+1, resourceIndex+1)
+			}
+
+			if len(resource.aggregations) > 0 {
+				if !areTargetAggregationsValid(resource.aggregations) {
+					return fmt.Errorf("management group target #%d resource #%d aggregations contain invalid aggregation/s. "+
+						"The valid aggregations are: %s", managementGroupIndex+1, resourceIndex+1, strings.Join(getPossibleAggregations(), ", "))
+				}
+			}
+		}
+	}
+
+	return nil
 }
 
 // CreateResourceTargetsFromResourceGroupTargets creates resource targets from resource group targets.
+//
+// Deprecated: Use CreateResourceTargetsFromResourceGroupTargetsWithContext instead.
 func (ammr *AzureMonitorMetricsReceiver) CreateResourceTargetsFromResourceGroupTargets() error {
+	return ammr.CreateResourceTargetsFromResourceGroupTargetsWithContext(ammr.getContext())
+}
+
+// CreateResourceTargetsFromResourceGroupTargetsWithContext creates resource targets from resource group targets.
+func (ammr *AzureMonitorMetricsReceiver) CreateResourceTargetsFromResourceGroupTargetsWithContext(ctx context.Context) error {
 	if len(ammr.Targets.resourceGroupTargets) == 0 {
 		return nil
 	}
 
 	for _, target := range ammr.Targets.resourceGroupTargets {
-		if err := ammr.createResourceTargetFromResourceGroupTarget(target); err != nil {
+		if err := ammr.createResourceTargetFromResourceGroupTarget(ctx, target); err != nil {
 			return fmt.Errorf("error creating resource targets from resource group target %s: %v", target.resourceGroup, err)
 		}
 	}
@@ -220,53 +405,132 @@ func (ammr *AzureMonitorMetricsReceiver) CreateResourceTargetsFromResourceGroupT
 	return nil
 }
 
-func (ammr *AzureMonitorMetricsReceiver) createResourceTargetFromResourceGroupTarget(target *ResourceGroupTarget) error {
-	resourceTargetsCreatedNum := 0
-	filter := createClientResourcesFilter(target.resources)
-	responses, err := ammr.AzureClients.ResourcesClient.ListByResourceGroup(ammr.AzureClients.Ctx, target.resourceGroup,
-		&armresources.ClientListByResourceGroupOptions{Filter: &filter})
+func (ammr *AzureMonitorMetricsReceiver) createResourceTargetFromResourceGroupTarget(ctx context.Context, target *ResourceGroupTarget) error {
+	var resources []*armresources.GenericResourceExpanded
+	var err error
+
+	if ammr.useResourceGraph {
+		query := createResourceGraphQuery(target.resourceGroup, target.resources)
+		resources, err = ammr.listResourceGraphResources(ctx, query, []string{ammr.subscriptionID})
+	} else {
+		resources, err = ammr.listResourceGroupResources(ctx, target)
+	}
 	if err != nil {
 		return err
 	}
 
-	for _, response := range responses {
-		currentResourceTargetsCreatedNum, err := ammr.createResourceTargetFromTargetResources(response.Value, target.resources)
-		if err != nil {
-			return fmt.Errorf("error creating resource target from resource group target resources: %v", err)
+	resourceTargetsCreatedNum, err := ammr.createResourceTargetFromTargetResources(ctx, resources, target.resources)
+	if err != nil {
+		return fmt.Errorf("error creating resource target from resource group target resources: %v", err)
+	}
+
+	ammr.getLogger().DebugContext(ctx, "created resource targets from resource group target",
+		"resource_group", target.resourceGroup, "resource_targets", resourceTargetsCreatedNum)
+	return nil
+}
+
+func (ammr *AzureMonitorMetricsReceiver) listResourceGroupResources(ctx context.Context, target *ResourceGroupTarget) ([]*armresources.GenericResourceExpanded, error) {
+	filter := createClientResourcesFilter(target.resources)
+
+	ctx, cancel := ammr.withRequestTimeout(ctx)
+	defer cancel()
+
+	ammr.getLogger().DebugContext(ctx, "listing resources of resource group", "resource_group", target.resourceGroup, "filter", filter)
+
+	ctx, span := ammr.startSpan(ctx, spanResourcesList,
+		tracing.Attribute{Key: attributeSubscriptionID, Value: ammr.subscriptionID},
+		tracing.Attribute{Key: attributeResourceGroup, Value: target.resourceGroup},
+		tracing.Attribute{Key: attributeFilter, Value: filter})
+
+	start := ammr.getCurrentTime()
+	responses, err := ammr.AzureClients.ResourcesClient.ListByResourceGroup(ctx, target.resourceGroup,
+		&armresources.ClientListByResourceGroupOptions{Filter: &filter})
+	ammr.observeAPICall(APIResources, "", start, err)
+
+	resources := make([]*armresources.GenericResourceExpanded, 0)
+	if err == nil {
+		for _, response := range responses {
+			resources = append(resources, response.Value...)
 		}
 
-		resourceTargetsCreatedNum += currentResourceTargetsCreatedNum
+		setResourcesListSpanAttributes(span, len(responses), len(resources))
 	}
+	endSpan(span, err)
 
-	return nil
+	return resources, err
 }
 
 // CreateResourceTargetsFromSubscriptionTargets creates resource targets from subscription targets.
+//
+// Deprecated: Use CreateResourceTargetsFromSubscriptionTargetsWithContext instead.
 func (ammr *AzureMonitorMetricsReceiver) CreateResourceTargetsFromSubscriptionTargets() error {
+	return ammr.CreateResourceTargetsFromSubscriptionTargetsWithContext(ammr.getContext())
+}
+
+// CreateResourceTargetsFromSubscriptionTargetsWithContext creates resource targets from subscription targets.
+func (ammr *AzureMonitorMetricsReceiver) CreateResourceTargetsFromSubscriptionTargetsWithContext(ctx context.Context) error {
 	if len(ammr.Targets.subscriptionTargets) == 0 {
 		return nil
 	}
 
-	resourceTargetsCreatedNum := 0
-	filter := createClientResourcesFilter(ammr.Targets.subscriptionTargets)
-	responses, err := ammr.AzureClients.ResourcesClient.List(ammr.AzureClients.Ctx, &armresources.ClientListOptions{Filter: &filter})
+	var resources []*armresources.GenericResourceExpanded
+	var err error
+
+	if ammr.useResourceGraph {
+		query := createResourceGraphQuery("", ammr.Targets.subscriptionTargets)
+		resources, err = ammr.listResourceGraphResources(ctx, query, []string{ammr.subscriptionID})
+	} else {
+		resources, err = ammr.listSubscriptionResources(ctx, ammr.AzureClients.ResourcesClient, ammr.subscriptionID, ammr.Targets.subscriptionTargets)
+	}
 	if err != nil {
 		return err
 	}
 
-	for _, response := range responses {
-		currentResourceTargetsCreatedNum, err := ammr.createResourceTargetFromTargetResources(response.Value, ammr.Targets.subscriptionTargets)
-		if err != nil {
-			return fmt.Errorf("error creating resource target from subscription targets: %v", err)
+	resourceTargetsCreatedNum, err := ammr.createResourceTargetFromTargetResources(ctx, resources, ammr.Targets.subscriptionTargets)
+	if err != nil {
+		return fmt.Errorf("error creating resource target from subscription targets: %v", err)
+	}
+
+	ammr.getLogger().DebugContext(ctx, "created resource targets from subscription targets",
+		"subscription_id", ammr.subscriptionID, "resource_targets", resourceTargetsCreatedNum)
+	return nil
+}
+
+func (ammr *AzureMonitorMetricsReceiver) listSubscriptionResources(
+	ctx context.Context,
+	resourcesClient ResourcesClient,
+	subscriptionID string,
+	targetResources []*Resource,
+) ([]*armresources.GenericResourceExpanded, error) {
+	filter := createClientResourcesFilter(targetResources)
+
+	ctx, cancel := ammr.withRequestTimeout(ctx)
+	defer cancel()
+
+	ammr.getLogger().DebugContext(ctx, "listing resources of subscription", "subscription_id", subscriptionID, "filter", filter)
+
+	ctx, span := ammr.startSpan(ctx, spanResourcesList,
+		tracing.Attribute{Key: attributeSubscriptionID, Value: subscriptionID},
+		tracing.Attribute{Key: attributeFilter, Value: filter})
+
+	start := ammr.getCurrentTime()
+	responses, err := resourcesClient.List(ctx, &armresources.ClientListOptions{Filter: &filter})
+	ammr.observeAPICall(APIResources, "", start, err)
+
+	resources := make([]*armresources.GenericResourceExpanded, 0)
+	if err == nil {
+		for _, response := range responses {
+			resources = append(resources, response.Value...)
 		}
 
-		resourceTargetsCreatedNum += currentResourceTargetsCreatedNum
+		setResourcesListSpanAttributes(span, len(responses), len(resources))
 	}
+	endSpan(span, err)
 
-	return nil
+	return resources, err
 }
 
-func (ammr *AzureMonitorMetricsReceiver) createResourceTargetFromTargetResources(resources []*armresources.GenericResourceExpanded, targetResources []*Resource) (int, error) {
+func (ammr *AzureMonitorMetricsReceiver) createResourceTargetFromTargetResources(ctx context.Context, resources []*armresources.GenericResourceExpanded, targetResources []*Resource) (int, error) {
 	resourceTargetsCreatedNum := 0
 
 	for _, targetResource := range targetResources {
@@ -283,13 +547,26 @@ func (ammr *AzureMonitorMetricsReceiver) createResourceTargetFromTargetResources
 				return resourceTargetsCreatedNum, err
 			}
 
-			if *resourceType != targetResource.resourceType {
+			// Resource types are case-insensitive, and Azure Resource Graph returns them in lower case.
+			if !strings.EqualFold(*resourceType, targetResource.resourceType) {
 				continue
 			}
 
-			ammr.Targets.ResourceTargets = append(ammr.Targets.ResourceTargets, NewResourceTarget(*resourceID, targetResource.metrics, targetResource.aggregations))
+			newTarget := NewResourceTarget(*resourceID, targetResource.metrics, targetResource.aggregations)
+			newTarget.Timeout = targetResource.timeout
+			ammr.Targets.ResourceTargets = append(ammr.Targets.ResourceTargets, newTarget)
+			ammr.getLogger().DebugContext(ctx, "created resource target", "resource_id", *resourceID, "resource_type", *resourceType)
 			isResourceTargetCreated = true
 			resourceTargetsCreatedNum++
+
+			if targetResource.expandChildResources {
+				childResourceTargetsCreatedNum, err := ammr.createChildResourceTargets(ctx, *resourceID, resources, targetResource)
+				if err != nil {
+					return resourceTargetsCreatedNum, err
+				}
+
+				resourceTargetsCreatedNum += childResourceTargetsCreatedNum
+			}
 		}
 
 		if !isResourceTargetCreated {
@@ -301,10 +578,17 @@ func (ammr *AzureMonitorMetricsReceiver) createResourceTargetFromTargetResources
 }
 
 // CheckResourceTargetsMetricsValidation checks resource targets metrics validation.
+//
+// Deprecated: Use CheckResourceTargetsMetricsValidationWithContext instead.
 func (ammr *AzureMonitorMetricsReceiver) CheckResourceTargetsMetricsValidation() error {
+	return ammr.CheckResourceTargetsMetricsValidationWithContext(ammr.getContext())
+}
+
+// CheckResourceTargetsMetricsValidationWithContext checks resource targets metrics validation.
+func (ammr *AzureMonitorMetricsReceiver) CheckResourceTargetsMetricsValidationWithContext(ctx context.Context) error {
 	for _, target := range ammr.Targets.ResourceTargets {
 		if len(target.Metrics) > 0 {
-			response, err := ammr.getMetricDefinitionsResponse(target.ResourceID)
+			response, err := ammr.getMetricDefinitionsResponse(ctx, target)
 			if err != nil {
 				return fmt.Errorf("error getting metric definitions response for resource target %s: %v", target.ResourceID, err)
 			}
@@ -319,13 +603,20 @@ func (ammr *AzureMonitorMetricsReceiver) CheckResourceTargetsMetricsValidation()
 }
 
 // SetResourceTargetsMetrics sets resource targets metrics if their metrics array is empty.
+//
+// Deprecated: Use SetResourceTargetsMetricsWithContext instead.
 func (ammr *AzureMonitorMetricsReceiver) SetResourceTargetsMetrics() error {
+	return ammr.SetResourceTargetsMetricsWithContext(ammr.getContext())
+}
+
+// SetResourceTargetsMetricsWithContext sets resource targets metrics if their metrics array is empty.
+func (ammr *AzureMonitorMetricsReceiver) SetResourceTargetsMetricsWithContext(ctx context.Context) error {
 	for _, target := range ammr.Targets.ResourceTargets {
 		if len(target.Metrics) > 0 {
 			continue
 		}
 
-		response, err := ammr.getMetricDefinitionsResponse(target.ResourceID)
+		response, err := ammr.getMetricDefinitionsResponse(ctx, target)
 		if err != nil {
 			return fmt.Errorf("error getting metric definitions response for resource target %s: %v", target.ResourceID, err)
 		}
@@ -333,6 +624,9 @@ func (ammr *AzureMonitorMetricsReceiver) SetResourceTargetsMetrics() error {
 		if err = target.setMetrics(response.Value); err != nil {
 			return fmt.Errorf("error setting resource target %s metrics: %v", target.ResourceID, err)
 		}
+
+		ammr.getLogger().DebugContext(ctx, "set resource target metrics from metric definitions",
+			"resource_id", target.ResourceID, "metrics", len(target.Metrics))
 	}
 
 	ammr.changeResourceTargetsMetricsWithComma()
@@ -346,9 +640,16 @@ func (ammr *AzureMonitorMetricsReceiver) changeResourceTargetsMetricsWithComma()
 }
 
 // SplitResourceTargetsMetricsByMinTimeGrain splits resource targets metrics by min time grain.
+//
+// Deprecated: Use SplitResourceTargetsMetricsByMinTimeGrainWithContext instead.
 func (ammr *AzureMonitorMetricsReceiver) SplitResourceTargetsMetricsByMinTimeGrain() error {
+	return ammr.SplitResourceTargetsMetricsByMinTimeGrainWithContext(ammr.getContext())
+}
+
+// SplitResourceTargetsMetricsByMinTimeGrainWithContext splits resource targets metrics by min time grain.
+func (ammr *AzureMonitorMetricsReceiver) SplitResourceTargetsMetricsByMinTimeGrainWithContext(ctx context.Context) error {
 	for _, target := range ammr.Targets.ResourceTargets {
-		if err := ammr.splitResourceTargetMetricsByMinTimeGrain(target); err != nil {
+		if err := ammr.splitResourceTargetMetricsByMinTimeGrain(ctx, target); err != nil {
 			return fmt.Errorf("error checking resource target %s metrics min time grain: %v", target.ResourceID, err)
 		}
 	}
@@ -356,55 +657,175 @@ func (ammr *AzureMonitorMetricsReceiver) SplitResourceTargetsMetricsByMinTimeGra
 	return nil
 }
 
-func (ammr *AzureMonitorMetricsReceiver) splitResourceTargetMetricsByMinTimeGrain(target *ResourceTarget) error {
-	response, err := ammr.getMetricDefinitionsResponse(target.ResourceID)
+func (ammr *AzureMonitorMetricsReceiver) splitResourceTargetMetricsByMinTimeGrain(ctx context.Context, target *ResourceTarget) error {
+	response, err := ammr.getMetricDefinitionsResponse(ctx, target)
 	if err != nil {
 		return fmt.Errorf("error getting metric definitions response for resource target %s: %v", target.ResourceID, err)
 	}
 
-	timeGrainsMetricsMap, err := target.createResourceTargetTimeGrainsMetricsMap(response.Value)
+	timeGrainsMetricsMap, timeGrainsAvailabilities, err := target.createResourceTargetTimeGrainsMetricsMap(response.Value, ammr.preferredTimeGrain)
 	if err != nil {
 		return fmt.Errorf("error creating resource target time grains metrics map: %v", err)
 	}
 
-	if len(timeGrainsMetricsMap) == 1 {
-		return nil
+	timeGrains, err := sortTimeGrains(timeGrainsMetricsMap)
+	if err != nil {
+		return fmt.Errorf("error sorting resource target time grains: %v", err)
 	}
 
-	var firstTimeGrain string
+	if len(timeGrains) == 0 {
+		return nil
+	}
 
-	for timeGrain := range timeGrainsMetricsMap {
-		firstTimeGrain = timeGrain
-		break
+	if len(timeGrains) > 1 {
+		ammr.getLogger().DebugContext(ctx, "splitting resource target metrics by time grain",
+			"resource_id", target.ResourceID, "time_grains", timeGrains)
 	}
 
-	for timeGrain, metrics := range timeGrainsMetricsMap {
-		if timeGrain == firstTimeGrain {
-			target.Metrics = metrics
-			continue
-		}
+	// The smallest time grain stays in the original target, so the plan is the same on every run.
+	target.Metrics = timeGrainsMetricsMap[timeGrains[0]]
+	target.TimeGrain = timeGrains[0]
+	target.Retention = timeGrainsAvailabilities[timeGrains[0]].retention
 
+	for _, timeGrain := range timeGrains[1:] {
 		newTargetAggregations := make([]string, 0)
 		newTargetAggregations = append(newTargetAggregations, target.Aggregations...)
-		ammr.Targets.ResourceTargets = append(ammr.Targets.ResourceTargets, NewResourceTarget(target.ResourceID, metrics, newTargetAggregations))
+		newTarget := NewResourceTarget(target.ResourceID, timeGrainsMetricsMap[timeGrain], newTargetAggregations)
+		newTarget.TimeGrain = timeGrain
+		newTarget.Retention = timeGrainsAvailabilities[timeGrain].retention
+		newTarget.Timeout = target.Timeout
+		ammr.Targets.ResourceTargets = append(ammr.Targets.ResourceTargets, newTarget)
 	}
 
 	return nil
 }
 
-func (ammr *AzureMonitorMetricsReceiver) getMetricDefinitionsResponse(resourceID string) (*armmonitor.MetricDefinitionsClientListResponse, error) {
-	response, err := ammr.AzureClients.MetricDefinitionsClient.List(ammr.AzureClients.Ctx, resourceID, nil)
+// sortTimeGrains returns the time grains of the map sorted by duration.
+func sortTimeGrains(timeGrainsMetricsMap map[string][]string) ([]string, error) {
+	timeGrains := make([]string, 0, len(timeGrainsMetricsMap))
+	durations := make(map[string]time.Duration)
+
+	for timeGrain := range timeGrainsMetricsMap {
+		duration, err := parseISO8601Duration(timeGrain)
+		if err != nil {
+			return nil, err
+		}
+
+		timeGrains = append(timeGrains, timeGrain)
+		durations[timeGrain] = duration
+	}
+
+	sort.Slice(timeGrains, func(i, j int) bool {
+		if durations[timeGrains[i]] == durations[timeGrains[j]] {
+			return timeGrains[i] < timeGrains[j]
+		}
+
+		return durations[timeGrains[i]] < durations[timeGrains[j]]
+	})
+
+	return timeGrains, nil
+}
+
+func (ammr *AzureMonitorMetricsReceiver) getMetricDefinitionsResponse(ctx context.Context, target *ResourceTarget) (*armmonitor.MetricDefinitionsClientListResponse, error) {
+	resourceID := target.ResourceID
+	if response := ammr.metricDefinitionsCache.get(resourceID); response != nil {
+		return response, nil
+	}
+
+	ctx, cancel := ammr.withResourceTargetTimeout(ctx, target)
+	defer cancel()
+
+	ctx, span := ammr.startSpan(ctx, spanMetricDefinitionsList,
+		tracing.Attribute{Key: attributeResourceID, Value: resourceID},
+		tracing.Attribute{Key: attributeResourceType, Value: getResourceIDResourceType(resourceID)})
+
+	start := ammr.getCurrentTime()
+	ammr.getLogger().DebugContext(ctx, "listing metric definitions", "resource_id", resourceID)
+	response, err := ammr.AzureClients.MetricDefinitionsClient.List(ctx, resourceID, nil)
+	ammr.observeAPICall(APIMetricDefinitions, resourceID, start, err)
+	if err == nil {
+		span.SetAttributes(tracing.Attribute{Key: attributeMetricDefinitionsNum, Value: len(response.Value)})
+	}
+	endSpan(span, err)
 	if err != nil {
-		return nil, fmt.Errorf("error listing metric definitions for the resource target %s: %v", resourceID, err)
+		return nil, fmt.Errorf("error listing metric definitions for the resource target %s: %w", resourceID, err)
 	}
 
 	if len(response.Value) == 0 {
-		return nil, fmt.Errorf("metric definitions response is bad formatted: Value is empty")
+		return nil, errMetricDefinitionsEmpty
 	}
 
+	ammr.metricDefinitionsCache.set(resourceID, &response)
 	return &response, nil
 }
 
+func newMetricDefinitionsCache() *metricDefinitionsCache {
+	return &metricDefinitionsCache{responses: make(map[string]*armmonitor.MetricDefinitionsClientListResponse)}
+}
+
+// get returns the cached metric definitions response of the resource, or nil if it is not cached.
+// A nil cache never has cached responses.
+func (mdc *metricDefinitionsCache) get(resourceID string) *armmonitor.MetricDefinitionsClientListResponse {
+	if mdc == nil {
+		return nil
+	}
+
+	mdc.mutex.RLock()
+	defer mdc.mutex.RUnlock()
+
+	return mdc.responses[resourceID]
+}
+
+func (mdc *metricDefinitionsCache) set(resourceID string, response *armmonitor.MetricDefinitionsClientListResponse) {
+	if mdc == nil {
+		return
+	}
+
+	mdc.mutex.Lock()
+	defer mdc.mutex.Unlock()
+
+	mdc.responses[resourceID] = response
+}
+
+// retain removes the cached metric definitions responses of the resources that are not in the resource IDs.
+func (mdc *metricDefinitionsCache) retain(resourceIDs map[string]bool) {
+	if mdc == nil {
+		return
+	}
+
+	mdc.mutex.Lock()
+	defer mdc.mutex.Unlock()
+
+	for resourceID := range mdc.responses {
+		if !resourceIDs[resourceID] {
+			delete(mdc.responses, resourceID)
+		}
+	}
+}
+
+// pruneMetricDefinitionsCache removes the cached metric definitions of the resources that no resource target
+// collects, so the cache does not grow with every reload and refresh. The caller must hold the targets lock.
+func (ammr *AzureMonitorMetricsReceiver) pruneMetricDefinitionsCache() {
+	resourceIDs := make(map[string]bool)
+	for _, target := range ammr.Targets.ResourceTargets {
+		resourceIDs[target.ResourceID] = true
+	}
+
+	ammr.metricDefinitionsCache.retain(resourceIDs)
+}
+
+// ClearMetricDefinitionsCache clears the cached metric definitions, so they are listed again on the next initialization.
+func (ammr *AzureMonitorMetricsReceiver) ClearMetricDefinitionsCache() {
+	if ammr.metricDefinitionsCache == nil {
+		return
+	}
+
+	ammr.metricDefinitionsCache.mutex.Lock()
+	defer ammr.metricDefinitionsCache.mutex.Unlock()
+
+	ammr.metricDefinitionsCache.responses = make(map[string]*armmonitor.MetricDefinitionsClientListResponse)
+}
+
 // SplitResourceTargetsWithMoreThanMaxMetrics splits resource targets with more than max metrics.
 func (ammr *AzureMonitorMetricsReceiver) SplitResourceTargetsWithMoreThanMaxMetrics() {
 	for _, target := range ammr.Targets.ResourceTargets {
@@ -412,6 +833,9 @@ func (ammr *AzureMonitorMetricsReceiver) SplitResourceTargetsWithMoreThanMaxMetr
 			continue
 		}
 
+		ammr.getLogger().Debug("splitting resource target with more than max metrics per request",
+			"resource_id", target.ResourceID, "metrics", len(target.Metrics), "max_metrics", MaxMetricsPerRequest)
+
 		for start := MaxMetricsPerRequest; start < len(target.Metrics); start += MaxMetricsPerRequest {
 			end := start + MaxMetricsPerRequest
 
@@ -423,6 +847,9 @@ func (ammr *AzureMonitorMetricsReceiver) SplitResourceTargetsWithMoreThanMaxMetr
 			newTargetAggregations := make([]string, 0)
 			newTargetAggregations = append(newTargetAggregations, target.Aggregations...)
 			newTarget := NewResourceTarget(target.ResourceID, newTargetMetrics, newTargetAggregations)
+			newTarget.TimeGrain = target.TimeGrain
+			newTarget.Retention = target.Retention
+			newTarget.Timeout = target.Timeout
 			ammr.Targets.ResourceTargets = append(ammr.Targets.ResourceTargets, newTarget)
 		}
 
@@ -435,6 +862,7 @@ func (ammr *AzureMonitorMetricsReceiver) SetResourceTargetsAggregations() {
 	for _, target := range ammr.Targets.ResourceTargets {
 		if len(target.Aggregations) == 0 {
 			target.setAggregations()
+			ammr.getLogger().Debug("set resource target default aggregations", "resource_id", target.ResourceID, "aggregations", target.Aggregations)
 		}
 	}
 }
@@ -444,7 +872,12 @@ func (arc *azureResourcesClient) List(ctx context.Context, options *armresources
 	pager := arc.client.NewListPager(options)
 
 	for pager.More() {
-		response, err := pager.NextPage(ctx)
+		pageCtx, span := startPageSpan(ctx, spanResourcesListPage, len(responses)+1)
+		response, err := pager.NextPage(pageCtx)
+		if err == nil {
+			span.SetAttributes(tracing.Attribute{Key: attributeResourcesNum, Value: len(response.Value)})
+		}
+		endSpan(span, err)
 		if err != nil {
 			return nil, err
 		}
@@ -463,7 +896,12 @@ func (arc *azureResourcesClient) ListByResourceGroup(
 	pager := arc.client.NewListByResourceGroupPager(resourceGroup, options)
 
 	for pager.More() {
-		response, err := pager.NextPage(ctx)
+		pageCtx, span := startPageSpan(ctx, spanResourcesListPage, len(responses)+1)
+		response, err := pager.NextPage(pageCtx)
+		if err == nil {
+			span.SetAttributes(tracing.Attribute{Key: attributeResourcesNum, Value: len(response.Value)})
+		}
+		endSpan(span, err)
 		if err != nil {
 			return nil, err
 		}
@@ -515,32 +953,38 @@ func (rt *ResourceTarget) checkMetricsValidation(metricDefinitions []*armmonitor
 	return nil
 }
 
-func (rt *ResourceTarget) createResourceTargetTimeGrainsMetricsMap(metricDefinitions []*armmonitor.MetricDefinition) (map[string][]string, error) {
+func (rt *ResourceTarget) createResourceTargetTimeGrainsMetricsMap(metricDefinitions []*armmonitor.MetricDefinition, preferredTimeGrain string) (map[string][]string, map[string]*metricAvailability, error) {
 	timeGrainsMetrics := make(map[string][]string)
+	timeGrainsAvailabilities := make(map[string]*metricAvailability)
 
 	for _, metric := range rt.Metrics {
+		// The metrics with comma are already changed to %2, see changeMetricsWithComma.
+		metricName := strings.Replace(metric, "%2", ",", -1)
+
 		for _, metricDefinition := range metricDefinitions {
 			metricNameValue, err := getMetricDefinitionsClientMetricNameValue(metricDefinition)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 
-			if metric == *metricNameValue {
-				metricMinTimeGrain, err := getMetricDefinitionsMetricMinTimeGrain(metricDefinition)
+			if metricName == *metricNameValue {
+				availability, err := getMetricDefinitionsMetricAvailability(metricDefinition, preferredTimeGrain)
 				if err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 
-				if _, found := timeGrainsMetrics[*metricMinTimeGrain]; !found {
-					timeGrainsMetrics[*metricMinTimeGrain] = []string{metric}
-				} else {
-					timeGrainsMetrics[*metricMinTimeGrain] = append(timeGrainsMetrics[*metricMinTimeGrain], metric)
+				timeGrainsMetrics[availability.timeGrain] = append(timeGrainsMetrics[availability.timeGrain], metric)
+
+				// The time grain group retention is the shortest known retention of its metrics.
+				groupAvailability, found := timeGrainsAvailabilities[availability.timeGrain]
+				if !found || availability.hasShorterRetention(groupAvailability) {
+					timeGrainsAvailabilities[availability.timeGrain] = availability
 				}
 			}
 		}
 	}
 
-	return timeGrainsMetrics, nil
+	return timeGrainsMetrics, timeGrainsAvailabilities, nil
 }
 
 func (rt *ResourceTarget) changeMetricsWithComma() {
@@ -580,13 +1024,14 @@ func areTargetAggregationsValid(targetAggregations []string) bool {
 
 func createClientResourcesFilter(resources []*Resource) string {
 	var filter string
-	resourcesSize := len(resources)
+	resourceTypes := getResourcesListedTypes(resources)
+	resourceTypesSize := len(resourceTypes)
 
-	for index, resource := range resources {
-		if index+1 == resourcesSize {
-			filter += "resourceType eq " + "'" + resource.resourceType + "'"
+	for index, resourceType := range resourceTypes {
+		if index+1 == resourceTypesSize {
+			filter += "resourceType eq " + "'" + resourceType + "'"
 		} else {
-			filter += "resourceType eq " + "'" + resource.resourceType + "'" + " or "
+			filter += "resourceType eq " + "'" + resourceType + "'" + " or "
 		}
 	}
 
@@ -635,7 +1080,40 @@ func getMetricDefinitionsClientMetricNameValue(metricDefinition *armmonitor.Metr
 	return metricNameValue, nil
 }
 
-func getMetricDefinitionsMetricMinTimeGrain(metricDefinition *armmonitor.MetricDefinition) (*string, error) {
+func getMetricDefinitionsClientMetricNameLocalizedValue(metricDefinition *armmonitor.MetricDefinition) (*string, error) {
+	if metricDefinition == nil {
+		return nil, fmt.Errorf("metric definitions client response is bad formatted: metric definition is missing")
+	}
+
+	metricName := metricDefinition.Name
+	if metricName == nil {
+		return nil, fmt.Errorf("metric definitions client response is bad formatted: metric definition Name is missing")
+	}
+
+	metricNameLocalizedValue := metricName.LocalizedValue
+	if metricNameLocalizedValue == nil {
+		return nil, fmt.Errorf("metric definitions client response is bad formatted: metric definition Name.LocalizedValue is missing")
+	}
+
+	return metricNameLocalizedValue, nil
+}
+
+func getMetricDefinitionsClientMetricNamespace(metricDefinition *armmonitor.MetricDefinition) (*string, error) {
+	if metricDefinition == nil {
+		return nil, fmt.Errorf("metric definitions client response is bad formatted: metric definition is missing")
+	}
+
+	if metricDefinition.Namespace == nil {
+		return nil, fmt.Errorf("metric definitions client response is bad formatted: metric definition Namespace is missing")
+	}
+
+	return metricDefinition.Namespace, nil
+}
+
+// getMetricDefinitionsMetricAvailability returns the metric availability with the preferred time grain,
+// or the metric availability with the min time grain if there is no preferred time grain or the metric is not
+// available in it. Availabilities with the same time grain are merged to the longest retention.
+func getMetricDefinitionsMetricAvailability(metricDefinition *armmonitor.MetricDefinition, preferredTimeGrain string) (*metricAvailability, error) {
 	if metricDefinition == nil {
 		return nil, fmt.Errorf("metric definitions client response is bad formatted: metric definition is missing")
 	}
@@ -644,15 +1122,98 @@ func getMetricDefinitionsMetricMinTimeGrain(metricDefinition *armmonitor.MetricD
 		return nil, fmt.Errorf("metric definitions client response is bad formatted: metric definition MetricAvailabilities is empty")
 	}
 
-	metricAvailability := metricDefinition.MetricAvailabilities[0]
-	if metricDefinition.MetricAvailabilities[0] == nil {
-		return nil, fmt.Errorf("metric definitions client response is bad formatted: metric definition MetricAvailabilities[0] is missing")
+	var minAvailability *metricAvailability
+	var preferredAvailability *metricAvailability
+	var preferredTimeGrainDuration time.Duration
+
+	if preferredTimeGrain != "" {
+		duration, err := parseISO8601Duration(preferredTimeGrain)
+		if err != nil {
+			return nil, fmt.Errorf("preferred time grain %s is bad formatted: %v", preferredTimeGrain, err)
+		}
+
+		preferredTimeGrainDuration = duration
+	}
+
+	for index, availability := range metricDefinition.MetricAvailabilities {
+		if availability == nil {
+			return nil, fmt.Errorf("metric definitions client response is bad formatted: metric definition MetricAvailabilities[%d] is missing", index)
+		}
+
+		if availability.TimeGrain == nil {
+			return nil, fmt.Errorf("metric definitions client response is bad formatted: metric definition MetricAvailabilities[%d].TimeGrain is missing", index)
+		}
+
+		currentAvailability, err := newMetricAvailability(*availability.TimeGrain, availability.Retention)
+		if err != nil {
+			return nil, fmt.Errorf("metric definitions client response is bad formatted: metric definition MetricAvailabilities[%d]: %v", index, err)
+		}
+
+		if minAvailability == nil || currentAvailability.timeGrainDuration < minAvailability.timeGrainDuration {
+			minAvailability = currentAvailability
+		} else if currentAvailability.timeGrainDuration == minAvailability.timeGrainDuration {
+			minAvailability = minAvailability.mergeRetention(currentAvailability)
+		}
+
+		if preferredTimeGrainDuration > 0 && currentAvailability.timeGrainDuration == preferredTimeGrainDuration {
+			if preferredAvailability == nil {
+				preferredAvailability = currentAvailability
+			} else {
+				preferredAvailability = preferredAvailability.mergeRetention(currentAvailability)
+			}
+		}
+	}
+
+	if preferredAvailability != nil {
+		return preferredAvailability, nil
 	}
 
-	timeGrain := metricAvailability.TimeGrain
-	if timeGrain == nil {
-		return nil, fmt.Errorf("metric definitions client response is bad formatted: metric definition MetricAvailabilities[0].TimeGrain is missing")
+	return minAvailability, nil
+}
+
+func newMetricAvailability(timeGrain string, retention *string) (*metricAvailability, error) {
+	timeGrainDuration, err := parseISO8601Duration(timeGrain)
+	if err != nil {
+		return nil, fmt.Errorf("time grain: %v", err)
+	}
+
+	if timeGrainDuration <= 0 {
+		return nil, fmt.Errorf("time grain %s is not positive", timeGrain)
+	}
+
+	availability := &metricAvailability{
+		timeGrain:         timeGrain,
+		timeGrainDuration: timeGrainDuration,
+	}
+
+	if retention != nil && *retention != "" {
+		retentionDuration, err := parseISO8601Duration(*retention)
+		if err != nil {
+			return nil, fmt.Errorf("retention: %v", err)
+		}
+
+		availability.retention = *retention
+		availability.retentionDuration = retentionDuration
+	}
+
+	return availability, nil
+}
+
+// mergeRetention returns the availability with the longest retention. An unknown retention is the shortest.
+func (ma *metricAvailability) mergeRetention(other *metricAvailability) *metricAvailability {
+	if other.retentionDuration > ma.retentionDuration {
+		return other
+	}
+
+	return ma
+}
+
+// hasShorterRetention returns true if the availability retention is shorter than the other one. An unknown retention
+// is never shorter than a known one.
+func (ma *metricAvailability) hasShorterRetention(other *metricAvailability) bool {
+	if ma.retentionDuration == 0 {
+		return false
 	}
 
-	return timeGrain, nil
+	return other.retentionDuration == 0 || ma.retentionDuration < other.retentionDuration
 }
@@ -3,7 +3,10 @@ package azuremonitormetricsreceiver
 import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
 	"github.com/stretchr/testify/assert"
@@ -290,12 +293,13 @@ func TestCheckConfigValidation_NoSubscriptionID(t *testing.T) {
 	require.Error(t, err)
 }
 
-func TestAddPrefixToResourceTargetsResourceID_Success(t *testing.T) {
+func TestResolveResourceTargetsResourceID_Success(t *testing.T) {
 	ammr := &AzureMonitorMetricsReceiver{
 		Targets: NewTargets(
 			[]*ResourceTarget{
 				NewResourceTarget(testResourceGroup1ResourceType1Resource1, []string{}, []string{}),
-				NewResourceTarget(testResourceGroup1ResourceType2Resource2, []string{}, []string{}),
+				NewResourceTarget(testFullResourceGroup1ResourceType2Resource2, []string{}, []string{}),
+				NewResourceTarget("/"+strings.Replace(testResourceGroup2ResourceType1Resource3, "resourceGroups", "resourcegroups", 1), []string{}, []string{}),
 			},
 			[]*ResourceGroupTarget{},
 			[]*Resource{},
@@ -304,10 +308,12 @@ func TestAddPrefixToResourceTargetsResourceID_Success(t *testing.T) {
 		subscriptionID: testSubscriptionID,
 	}
 
-	ammr.addPrefixToResourceTargetsResourceID()
+	ammr.resolveResourceTargetsResourceID()
 
 	assert.Equal(t, testFullResourceGroup1ResourceType1Resource1, ammr.Targets.ResourceTargets[0].ResourceID)
+	// Full resource IDs are not prefixed again.
 	assert.Equal(t, testFullResourceGroup1ResourceType2Resource2, ammr.Targets.ResourceTargets[1].ResourceID)
+	assert.Equal(t, testFullResourceGroup2ResourceType1Resource3, ammr.Targets.ResourceTargets[2].ResourceID)
 }
 
 func TestCreateResourceTargetsFromResourceGroupTargets_Success(t *testing.T) {
@@ -529,6 +535,11 @@ func TestSplitResourceTargetsMetricsByMinTimeGrain_Success(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Len(t, ammr.Targets.ResourceTargets, 4)
+	assert.Equal(t, []string{testMetric1, testMetric2}, ammr.Targets.ResourceTargets[0].Metrics)
+	assert.Equal(t, "PT1M", ammr.Targets.ResourceTargets[0].TimeGrain)
+	assert.Equal(t, testFullResourceGroup1ResourceType1Resource1, ammr.Targets.ResourceTargets[3].ResourceID)
+	assert.Equal(t, []string{testMetric3}, ammr.Targets.ResourceTargets[3].Metrics)
+	assert.Equal(t, "PT5M", ammr.Targets.ResourceTargets[3].TimeGrain)
 
 	for _, target := range ammr.Targets.ResourceTargets {
 		if target.ResourceID == testFullResourceGroup1ResourceType1Resource1 {
@@ -554,6 +565,213 @@ func TestSplitResourceTargetsMetricsByMinTimeGrain_Success(t *testing.T) {
 	}
 }
 
+func TestSplitResourceTargetsMetricsByMinTimeGrain_Deterministic(t *testing.T) {
+	for run := 0; run < 20; run++ {
+		ammr := &AzureMonitorMetricsReceiver{
+			Targets: NewTargets(
+				[]*ResourceTarget{
+					NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric3, testMetric1, testMetric2}, []string{}),
+				},
+				[]*ResourceGroupTarget{},
+				[]*Resource{},
+			),
+			AzureClients:   setMockAzureClients(),
+			subscriptionID: testSubscriptionID,
+		}
+
+		err := ammr.SplitResourceTargetsMetricsByMinTimeGrain()
+		require.NoError(t, err)
+
+		require.Len(t, ammr.Targets.ResourceTargets, 2)
+		assert.Equal(t, []string{testMetric1, testMetric2}, ammr.Targets.ResourceTargets[0].Metrics)
+		assert.Equal(t, "PT1M", ammr.Targets.ResourceTargets[0].TimeGrain)
+		assert.Equal(t, []string{testMetric3}, ammr.Targets.ResourceTargets[1].Metrics)
+		assert.Equal(t, "PT5M", ammr.Targets.ResourceTargets[1].TimeGrain)
+	}
+}
+
+func TestSplitResourceTargetsMetricsByMinTimeGrain_SingleTimeGrain(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup2ResourceType1Resource3, []string{testMetric1, testMetric2, testMetric3}, []string{}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	err := ammr.SplitResourceTargetsMetricsByMinTimeGrain()
+	require.NoError(t, err)
+
+	require.Len(t, ammr.Targets.ResourceTargets, 1)
+	assert.Equal(t, "PT1M", ammr.Targets.ResourceTargets[0].TimeGrain)
+}
+
+func TestSplitResourceTargetsMetricsByMinTimeGrain_PreferredTimeGrain(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1, testMetric2, testMetric3}, []string{}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:       setMockAzureClients(),
+		subscriptionID:     testSubscriptionID,
+		preferredTimeGrain: "PT5M",
+	}
+
+	err := ammr.SplitResourceTargetsMetricsByMinTimeGrain()
+	require.NoError(t, err)
+
+	require.Len(t, ammr.Targets.ResourceTargets, 1)
+	assert.Equal(t, []string{testMetric1, testMetric2, testMetric3}, ammr.Targets.ResourceTargets[0].Metrics)
+	assert.Equal(t, "PT5M", ammr.Targets.ResourceTargets[0].TimeGrain)
+}
+
+func TestSplitResourceTargetsMetricsByMinTimeGrain_MetricWithComma(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1, testMetric3ChangedComma}, []string{}),
+				NewResourceTarget(testFullResourceGroup1ResourceType2Resource2, []string{testMetric3ChangedComma}, []string{}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+	ammr.AzureClients.MetricDefinitionsClient = &mockStaticMetricDefinitionsClient{
+		metricDefinitions: map[string][]*armmonitor.MetricDefinition{
+			testFullResourceGroup1ResourceType1Resource1: {
+				newTestMetricDefinition(testMetric1, "PT1M"),
+				newTestMetricDefinition(testMetric3WithComma, "PT5M"),
+			},
+			testFullResourceGroup1ResourceType2Resource2: {newTestMetricDefinition(testMetric3WithComma, "PT5M")},
+		},
+	}
+
+	err := ammr.SplitResourceTargetsMetricsByMinTimeGrain()
+	require.NoError(t, err)
+
+	require.Len(t, ammr.Targets.ResourceTargets, 3)
+	assert.Equal(t, []string{testMetric1}, ammr.Targets.ResourceTargets[0].Metrics)
+	assert.Equal(t, "PT1M", ammr.Targets.ResourceTargets[0].TimeGrain)
+	assert.Equal(t, []string{testMetric3ChangedComma}, ammr.Targets.ResourceTargets[1].Metrics)
+	assert.Equal(t, "PT5M", ammr.Targets.ResourceTargets[1].TimeGrain)
+	assert.Equal(t, testFullResourceGroup1ResourceType1Resource1, ammr.Targets.ResourceTargets[2].ResourceID)
+	assert.Equal(t, []string{testMetric3ChangedComma}, ammr.Targets.ResourceTargets[2].Metrics)
+	assert.Equal(t, "PT5M", ammr.Targets.ResourceTargets[2].TimeGrain)
+}
+
+func TestGetMetricDefinitionsMetricAvailability_MinTimeGrain(t *testing.T) {
+	metricDefinition := &armmonitor.MetricDefinition{
+		MetricAvailabilities: []*armmonitor.MetricAvailability{
+			{TimeGrain: to.Ptr("PT1H"), Retention: to.Ptr("P93D")},
+			{TimeGrain: to.Ptr("PT1M"), Retention: to.Ptr("P30D")},
+			{TimeGrain: to.Ptr("PT5M"), Retention: to.Ptr("P93D")},
+			{TimeGrain: to.Ptr("PT1M"), Retention: to.Ptr("P93D")},
+		},
+	}
+
+	availability, err := getMetricDefinitionsMetricAvailability(metricDefinition, "")
+	require.NoError(t, err)
+	assert.Equal(t, "PT1M", availability.timeGrain)
+	assert.Equal(t, time.Minute, availability.timeGrainDuration)
+	assert.Equal(t, "P93D", availability.retention)
+	assert.Equal(t, 93*24*time.Hour, availability.retentionDuration)
+}
+
+func TestGetMetricDefinitionsMetricAvailability_PreferredTimeGrain(t *testing.T) {
+	metricDefinition := &armmonitor.MetricDefinition{
+		MetricAvailabilities: []*armmonitor.MetricAvailability{
+			{TimeGrain: to.Ptr("PT1M"), Retention: to.Ptr("P93D")},
+			{TimeGrain: to.Ptr("PT1H"), Retention: to.Ptr("P93D")},
+		},
+	}
+
+	availability, err := getMetricDefinitionsMetricAvailability(metricDefinition, "PT1H")
+	require.NoError(t, err)
+	assert.Equal(t, "PT1H", availability.timeGrain)
+
+	availability, err = getMetricDefinitionsMetricAvailability(metricDefinition, "PT5M")
+	require.NoError(t, err)
+	assert.Equal(t, "PT1M", availability.timeGrain)
+}
+
+func TestGetMetricDefinitionsMetricAvailability_EquivalentPreferredTimeGrain(t *testing.T) {
+	metricDefinition := &armmonitor.MetricDefinition{
+		MetricAvailabilities: []*armmonitor.MetricAvailability{
+			{TimeGrain: to.Ptr("PT1M"), Retention: to.Ptr("P93D")},
+			{TimeGrain: to.Ptr("PT1H"), Retention: to.Ptr("P93D")},
+		},
+	}
+
+	availability, err := getMetricDefinitionsMetricAvailability(metricDefinition, "PT60M")
+	require.NoError(t, err)
+	assert.Equal(t, "PT1H", availability.timeGrain)
+	assert.Equal(t, time.Hour, availability.timeGrainDuration)
+}
+
+func TestCreateResourceTargetTimeGrainsMetricsMap_UnknownRetention(t *testing.T) {
+	target := NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1, testMetric2, testMetric3}, []string{})
+	metricDefinitions := []*armmonitor.MetricDefinition{
+		{
+			Name:                 &armmonitor.LocalizableString{Value: to.Ptr(testMetric1)},
+			MetricAvailabilities: []*armmonitor.MetricAvailability{{TimeGrain: to.Ptr("PT1M"), Retention: to.Ptr("P93D")}},
+		},
+		{
+			Name:                 &armmonitor.LocalizableString{Value: to.Ptr(testMetric2)},
+			MetricAvailabilities: []*armmonitor.MetricAvailability{{TimeGrain: to.Ptr("PT1M")}},
+		},
+		{
+			Name:                 &armmonitor.LocalizableString{Value: to.Ptr(testMetric3)},
+			MetricAvailabilities: []*armmonitor.MetricAvailability{{TimeGrain: to.Ptr("PT1M"), Retention: to.Ptr("P30D")}},
+		},
+	}
+
+	timeGrainsMetrics, timeGrainsAvailabilities, err := target.createResourceTargetTimeGrainsMetricsMap(metricDefinitions, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{testMetric1, testMetric2, testMetric3}, timeGrainsMetrics["PT1M"])
+	require.Contains(t, timeGrainsAvailabilities, "PT1M")
+	assert.Equal(t, "P30D", timeGrainsAvailabilities["PT1M"].retention)
+	assert.Equal(t, 30*24*time.Hour, timeGrainsAvailabilities["PT1M"].retentionDuration)
+}
+
+func TestGetMetricDefinitionsMetricAvailability_NoRetention(t *testing.T) {
+	metricDefinition := &armmonitor.MetricDefinition{
+		MetricAvailabilities: []*armmonitor.MetricAvailability{
+			{TimeGrain: to.Ptr("PT5M")},
+		},
+	}
+
+	availability, err := getMetricDefinitionsMetricAvailability(metricDefinition, "")
+	require.NoError(t, err)
+	assert.Equal(t, "PT5M", availability.timeGrain)
+	assert.Empty(t, availability.retention)
+}
+
+func TestGetMetricDefinitionsMetricAvailability_BadFormatted(t *testing.T) {
+	metricDefinitions := []*armmonitor.MetricDefinition{
+		nil,
+		{},
+		{MetricAvailabilities: []*armmonitor.MetricAvailability{nil}},
+		{MetricAvailabilities: []*armmonitor.MetricAvailability{{Retention: to.Ptr("P93D")}}},
+		{MetricAvailabilities: []*armmonitor.MetricAvailability{{TimeGrain: to.Ptr("1 minute")}}},
+		{MetricAvailabilities: []*armmonitor.MetricAvailability{{TimeGrain: to.Ptr("PT1M"), Retention: to.Ptr("forever")}}},
+	}
+
+	for _, metricDefinition := range metricDefinitions {
+		availability, err := getMetricDefinitionsMetricAvailability(metricDefinition, "")
+		require.Error(t, err)
+		assert.Nil(t, availability)
+	}
+}
+
 func TestSplitResourceTargetsWithMoreThanMaxMetrics_Success(t *testing.T) {
 	ammr := &AzureMonitorMetricsReceiver{
 		Targets: NewTargets(
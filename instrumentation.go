@@ -0,0 +1,135 @@
+package azuremonitormetricsreceiver
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+const (
+	// APIMetrics is the Azure Monitor metrics API.
+	APIMetrics = "metrics"
+	// APIMetricDefinitions is the Azure Monitor metric definitions API.
+	APIMetricDefinitions = "metric_definitions"
+	// APIResources is the Azure Resource Manager resources API.
+	APIResources = "resources"
+	// APIResourceGraph is the Azure Resource Graph resources API.
+	APIResourceGraph = "resource_graph"
+	// APIManagementGroups is the Azure management groups descendants API.
+	APIManagementGroups = "management_groups"
+)
+
+// Instrumentation observes the receiver itself: Azure API calls, resource target collections and targets
+// initializations. Its methods are called synchronously, and may be called concurrently.
+type Instrumentation interface {
+	// ObserveAPICall is called after every Azure API call.
+	ObserveAPICall(call *APICall)
+	// ObserveResourceTargetCollection is called after every resource target collection.
+	ObserveResourceTargetCollection(collection *ResourceTargetCollection)
+	// ObserveTargetsInitialization is called after every targets initialization and reload.
+	ObserveTargetsInitialization(initialization *TargetsInitialization)
+}
+
+// APICall describes an Azure API call.
+type APICall struct {
+	// API is APIMetrics, APIMetricDefinitions, APIResources, APIResourceGraph or APIManagementGroups.
+	API string
+	// ResourceType is the resource type of the resource target. It is empty for APIResources, APIResourceGraph and
+	// APIManagementGroups calls.
+	ResourceType string
+	Duration     time.Duration
+	// StatusCode is the HTTP status code of a failed call. It is zero if the call succeeded or got no response.
+	StatusCode int
+	Err        error
+}
+
+// ResourceTargetCollection describes the collection of a resource target.
+type ResourceTargetCollection struct {
+	ResourceID             string
+	ResourceType           string
+	MetricsNum             int
+	NotCollectedMetricsNum int
+	Duration               time.Duration
+	Err                    error
+}
+
+// TargetsInitialization describes a targets initialization or reload.
+type TargetsInitialization struct {
+	ResourceTargetsNum int
+	Duration           time.Duration
+	Err                error
+}
+
+type noopInstrumentation struct{}
+
+// IsThrottled returns true if Azure throttled the call.
+func (call *APICall) IsThrottled() bool {
+	return call.StatusCode == http.StatusTooManyRequests
+}
+
+// WithInstrumentation lets you observe the receiver API calls, collections and initializations.
+// See PrometheusInstrumentation.
+func WithInstrumentation(instrumentation Instrumentation) ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.instrumentation = instrumentation
+	}
+}
+
+func (ammr *AzureMonitorMetricsReceiver) getInstrumentation() Instrumentation {
+	if ammr.instrumentation == nil {
+		return noopInstrumentation{}
+	}
+
+	return ammr.instrumentation
+}
+
+func (ammr *AzureMonitorMetricsReceiver) observeAPICall(api string, resourceID string, start time.Time, err error) {
+	call := &APICall{
+		API:      api,
+		Duration: ammr.getCurrentTime().Sub(start),
+		Err:      err,
+	}
+
+	if resourceID != "" {
+		call.ResourceType = getResourceIDResourceType(resourceID)
+	}
+
+	var responseError *azcore.ResponseError
+	if errors.As(err, &responseError) {
+		call.StatusCode = responseError.StatusCode
+	}
+
+	ammr.getInstrumentation().ObserveAPICall(call)
+}
+
+func (ammr *AzureMonitorMetricsReceiver) observeResourceTargetCollection(target *ResourceTarget, start time.Time, metricsNum int, notCollectedMetricsNum int, err error) {
+	ammr.getInstrumentation().ObserveResourceTargetCollection(&ResourceTargetCollection{
+		ResourceID:             target.ResourceID,
+		ResourceType:           getResourceIDResourceType(target.ResourceID),
+		MetricsNum:             metricsNum,
+		NotCollectedMetricsNum: notCollectedMetricsNum,
+		Duration:               ammr.getCurrentTime().Sub(start),
+		Err:                    err,
+	})
+}
+
+func (ammr *AzureMonitorMetricsReceiver) observeTargetsInitialization(start time.Time, err error) {
+	initialization := &TargetsInitialization{
+		Duration: ammr.getCurrentTime().Sub(start),
+		Err:      err,
+	}
+
+	if err == nil {
+		initialization.ResourceTargetsNum = len(ammr.Targets.ResourceTargets)
+	}
+
+	ammr.getInstrumentation().ObserveTargetsInitialization(initialization)
+}
+
+func (noopInstrumentation) ObserveAPICall(*APICall) {}
+
+func (noopInstrumentation) ObserveResourceTargetCollection(*ResourceTargetCollection) {}
+
+func (noopInstrumentation) ObserveTargetsInitialization(*TargetsInitialization) {}
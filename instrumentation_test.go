@@ -0,0 +1,117 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getInstrumentationText(t *testing.T, instrumentation *PrometheusInstrumentation) string {
+	recorder := httptest.NewRecorder()
+	instrumentation.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	return recorder.Body.String()
+}
+
+func TestPrometheusInstrumentation_Collection(t *testing.T) {
+	instrumentation := NewPrometheusInstrumentation()
+	ammr := newTestReceiver(WithInstrumentation(instrumentation))
+
+	metrics, _, err := ammr.CollectResourceTargetMetricsWithContext(context.Background(), ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+
+	text := getInstrumentationText(t, instrumentation)
+	assert.Contains(t, text, "# TYPE azure_monitor_receiver_api_calls_total counter\n")
+	assert.Contains(t, text, `azure_monitor_receiver_api_calls_total{api="metrics",resource_type="Microsoft.Test/type1",status="success"} 1`+"\n")
+	assert.Contains(t, text, `azure_monitor_receiver_api_call_duration_seconds_bucket{api="metrics",le="+Inf"} 1`+"\n")
+	assert.Contains(t, text, `azure_monitor_receiver_api_call_duration_seconds_count{api="metrics"} 1`+"\n")
+	assert.Contains(t, text, `azure_monitor_receiver_collections_total{resource_type="Microsoft.Test/type1",status="success"} 1`+"\n")
+	assert.Contains(t, text, `azure_monitor_receiver_collected_metrics_total{resource_type="Microsoft.Test/type1"} 2`+"\n")
+}
+
+func TestPrometheusInstrumentation_Throttled(t *testing.T) {
+	instrumentation := NewPrometheusInstrumentation()
+	metricsClient := &failingMetricsClient{err: &azcore.ResponseError{StatusCode: http.StatusTooManyRequests, ErrorCode: "TooManyRequests"}}
+	ammr := newTestReceiver(WithInstrumentation(instrumentation), withTestMetricsClient(metricsClient))
+
+	_, _, err := ammr.CollectResourceTargetMetricsWithContext(context.Background(), ammr.Targets.ResourceTargets[0])
+	require.Error(t, err)
+
+	text := getInstrumentationText(t, instrumentation)
+	assert.Contains(t, text, `azure_monitor_receiver_api_calls_total{api="metrics",resource_type="Microsoft.Test/type1",status="throttled"} 1`+"\n")
+	assert.Contains(t, text, `azure_monitor_receiver_collections_total{resource_type="Microsoft.Test/type1",status="error"} 1`+"\n")
+}
+
+func TestPrometheusInstrumentation_Initialization(t *testing.T) {
+	instrumentation := NewPrometheusInstrumentation()
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID,
+		NewTargets([]*ResourceTarget{}, []*ResourceGroupTarget{}, []*Resource{NewResource(testResourceType1, []string{}, []string{})}),
+		setMockAzureClients(),
+		WithInstrumentation(instrumentation))
+	require.NoError(t, err)
+
+	require.NoError(t, ammr.InitializeTargetsWithContext(context.Background()))
+
+	text := getInstrumentationText(t, instrumentation)
+	assert.Contains(t, text, `azure_monitor_receiver_api_calls_total{api="resources",resource_type="",status="success"} 1`+"\n")
+	assert.Contains(t, text, `azure_monitor_receiver_api_calls_total{api="metric_definitions",resource_type="Microsoft.Test/type1",status="success"}`)
+	assert.Contains(t, text, `azure_monitor_receiver_initializations_total{status="success"} 1`+"\n")
+	assert.Contains(t, text, "azure_monitor_receiver_resource_targets "+formatInstrumentationValue(float64(len(ammr.Targets.ResourceTargets)))+"\n")
+}
+
+func TestPrometheusInstrumentation_Metrics(t *testing.T) {
+	instrumentation := NewPrometheusInstrumentation(1)
+	instrumentation.now = func() time.Time {
+		return time.Date(2022, 2, 22, 23, 0, 0, 0, time.UTC)
+	}
+
+	instrumentation.ObserveAPICall(&APICall{API: APIMetrics, ResourceType: testResourceType1, Duration: 2 * time.Second})
+	instrumentation.ObserveTargetsInitialization(&TargetsInitialization{ResourceTargetsNum: 3})
+
+	timeSeries, err := createRemoteWriteTimeSeries(instrumentation.Metrics())
+	require.NoError(t, err)
+
+	values := make(map[string]float64)
+	for _, series := range timeSeries {
+		name := ""
+		le := ""
+		for _, label := range series.labels {
+			if label.name == metricLabelName {
+				name = label.value
+			} else if label.name == "le" {
+				le = label.value
+			}
+		}
+
+		values[name+le] = series.samples[0].value
+		assert.Equal(t, int64(1645570800000), series.samples[0].timestamp)
+	}
+
+	assert.Equal(t, 1.0, values["azure_monitor_receiver_api_calls_total"])
+	assert.Equal(t, 0.0, values["azure_monitor_receiver_api_call_duration_seconds_bucket1"])
+	assert.Equal(t, 1.0, values["azure_monitor_receiver_api_call_duration_seconds_bucket+Inf"])
+	assert.Equal(t, 2.0, values["azure_monitor_receiver_api_call_duration_seconds_sum"])
+	assert.Equal(t, 1.0, values["azure_monitor_receiver_api_call_duration_seconds_count"])
+	assert.Equal(t, 3.0, values["azure_monitor_receiver_resource_targets"])
+	assert.Equal(t, 1.0, values["azure_monitor_receiver_initializations_total"])
+}
+
+func TestGetResourceIDResourceType(t *testing.T) {
+	resourceTypes := map[string]string{
+		testFullResourceGroup1ResourceType1Resource1:                                             testResourceType1,
+		"/subscriptions/s/resourceGroups/rg/providers/Microsoft.Sql/servers/server/databases/db": "Microsoft.Sql/servers/databases",
+		"/subscriptions/s/resourceGroups/rg":                                                     "",
+	}
+
+	for resourceID, resourceType := range resourceTypes {
+		assert.Equal(t, resourceType, getResourceIDResourceType(resourceID), resourceID)
+	}
+}
@@ -0,0 +1,44 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler is a slog handler that discards all records, used when no logger is set.
+type discardHandler struct{}
+
+var discardLogger = slog.New(discardHandler{})
+
+// WithLogger lets you log what the receiver does. Debug records describe the resources filters sent to Azure,
+// the resource targets created, the splits performed and the metrics that were skipped and why.
+// Any slog.Handler can be used, so the records can be sent to other logging libraries.
+func WithLogger(logger *slog.Logger) ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.logger = logger
+	}
+}
+
+func (ammr *AzureMonitorMetricsReceiver) getLogger() *slog.Logger {
+	if ammr.logger == nil {
+		return discardLogger
+	}
+
+	return ammr.logger
+}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool {
+	return false
+}
+
+func (discardHandler) Handle(context.Context, slog.Record) error {
+	return nil
+}
+
+func (dh discardHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return dh
+}
+
+func (dh discardHandler) WithGroup(string) slog.Handler {
+	return dh
+}
@@ -0,0 +1,59 @@
+package azuremonitormetricsreceiver
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(output *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(output, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+func TestWithLogger_Initialization(t *testing.T) {
+	output := &bytes.Buffer{}
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID,
+		NewTargets([]*ResourceTarget{}, []*ResourceGroupTarget{}, []*Resource{NewResource(testResourceType1, []string{}, []string{})}),
+		setMockAzureClients(),
+		WithLogger(newTestLogger(output)))
+	require.NoError(t, err)
+
+	require.NoError(t, ammr.InitializeTargetsWithContext(context.Background()))
+
+	logs := output.String()
+	assert.Contains(t, logs, `msg="listing resources of subscription"`)
+	assert.Contains(t, logs, `filter="resourceType eq 'Microsoft.Test/type1'"`)
+	assert.Contains(t, logs, `msg="created resource target" resource_id=`+testFullResourceGroup1ResourceType1Resource1)
+	assert.Contains(t, logs, `msg="listing metric definitions"`)
+	assert.Contains(t, logs, `msg="set resource target metrics from metric definitions"`)
+	assert.Contains(t, logs, `msg="set resource target default aggregations"`)
+}
+
+func TestWithLogger_SkippedMetrics(t *testing.T) {
+	output := &bytes.Buffer{}
+	ammr := &AzureMonitorMetricsReceiver{
+		AzureClients:        setMockAzureClients(),
+		subscriptionID:      testSubscriptionID,
+		unsettledBucketsNum: 10,
+		logger:              newTestLogger(output),
+	}
+
+	target := NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1, testMetric2}, []string{})
+	metrics, _, err := ammr.CollectResourceTargetMetricsWithContext(context.Background(), target)
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+
+	logs := output.String()
+	assert.Contains(t, logs, `msg="listing metrics" resource_id=`+testFullResourceGroup1ResourceType1Resource1)
+	assert.Contains(t, logs, `msg="metric was skipped"`)
+	assert.Contains(t, logs, `reason="metric has no new or changed settled values"`)
+}
+
+func TestGetLogger_Discard(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{}
+	assert.False(t, ammr.getLogger().Enabled(context.Background(), slog.LevelError))
+}
@@ -0,0 +1,179 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/tracing"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/managementgroups/armmanagementgroups"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+const (
+	// DefaultManagementGroupsRefreshInterval is the default time between refreshes of the resource targets
+	// of management group targets.
+	DefaultManagementGroupsRefreshInterval = time.Hour
+
+	managementGroupSubscriptionType = "/subscriptions"
+)
+
+// WithManagementGroupsRefreshInterval lets you set the time between refreshes of the resource targets of management
+// group targets, which discovers new subscriptions and resources. See RefreshTargetsWithContext.
+func WithManagementGroupsRefreshInterval(refreshInterval time.Duration) ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.refreshInterval = refreshInterval
+	}
+}
+
+// CreateResourceTargetsFromManagementGroupTargetsWithContext creates resource targets from management group targets.
+func (ammr *AzureMonitorMetricsReceiver) CreateResourceTargetsFromManagementGroupTargetsWithContext(ctx context.Context) error {
+	for _, target := range ammr.Targets.managementGroupTargets {
+		if err := ammr.createResourceTargetFromManagementGroupTarget(ctx, target); err != nil {
+			return fmt.Errorf("error creating resource targets from management group target %s: %v", target.managementGroup, err)
+		}
+	}
+
+	return nil
+}
+
+func (ammr *AzureMonitorMetricsReceiver) createResourceTargetFromManagementGroupTarget(ctx context.Context, target *ManagementGroupTarget) error {
+	subscriptionIDs, err := ammr.listManagementGroupSubscriptions(ctx, target.managementGroup)
+	if err != nil {
+		return err
+	}
+
+	if len(subscriptionIDs) == 0 {
+		return fmt.Errorf("could not find subscriptions under management group %s", target.managementGroup)
+	}
+
+	var resources []*armresources.GenericResourceExpanded
+	if ammr.useResourceGraph {
+		resources, err = ammr.listResourceGraphResources(ctx, createResourceGraphQuery("", target.resources), subscriptionIDs)
+	} else {
+		resources, err = ammr.listSubscriptionsResources(ctx, subscriptionIDs, target.resources)
+	}
+	if err != nil {
+		return err
+	}
+
+	resourceTargetsCreatedNum, err := ammr.createResourceTargetFromTargetResources(ctx, resources, target.resources)
+	if err != nil {
+		return fmt.Errorf("error creating resource target from management group target resources: %v", err)
+	}
+
+	ammr.getLogger().DebugContext(ctx, "created resource targets from management group target",
+		"management_group", target.managementGroup, "subscriptions", len(subscriptionIDs), "resource_targets", resourceTargetsCreatedNum)
+	return nil
+}
+
+// listManagementGroupSubscriptions returns the IDs of the subscriptions under the management group and all its
+// descendant management groups.
+func (ammr *AzureMonitorMetricsReceiver) listManagementGroupSubscriptions(ctx context.Context, managementGroup string) ([]string, error) {
+	if ammr.AzureClients.ManagementGroupsClient == nil {
+		return nil, fmt.Errorf("management groups client is missing")
+	}
+
+	ctx, cancel := ammr.withRequestTimeout(ctx)
+	defer cancel()
+
+	ammr.getLogger().DebugContext(ctx, "listing subscriptions of management group", "management_group", managementGroup)
+
+	ctx, span := ammr.startSpan(ctx, spanManagementGroupDescendants,
+		tracing.Attribute{Key: attributeManagementGroup, Value: managementGroup})
+
+	start := ammr.getCurrentTime()
+	responses, err := ammr.AzureClients.ManagementGroupsClient.GetDescendants(ctx, managementGroup, nil)
+	ammr.observeAPICall(APIManagementGroups, "", start, err)
+
+	subscriptionIDs := make([]string, 0)
+	if err == nil {
+		for _, response := range responses {
+			for _, descendant := range response.Value {
+				subscriptionID, err := getManagementGroupsClientSubscriptionID(descendant)
+				if err != nil {
+					endSpan(span, err)
+					return nil, err
+				}
+
+				if subscriptionID != nil {
+					subscriptionIDs = append(subscriptionIDs, *subscriptionID)
+				}
+			}
+		}
+
+		span.SetAttributes(tracing.Attribute{Key: attributePagesNum, Value: len(responses)},
+			tracing.Attribute{Key: attributeSubscriptionsNum, Value: len(subscriptionIDs)})
+	}
+	endSpan(span, err)
+
+	return subscriptionIDs, err
+}
+
+// listSubscriptionsResources lists the resources of the resource types in every subscription.
+func (ammr *AzureMonitorMetricsReceiver) listSubscriptionsResources(ctx context.Context, subscriptionIDs []string, targetResources []*Resource) ([]*armresources.GenericResourceExpanded, error) {
+	if ammr.AzureClients.NewResourcesClient == nil {
+		return nil, fmt.Errorf("resources client of subscription is missing")
+	}
+
+	resources := make([]*armresources.GenericResourceExpanded, 0)
+	for _, subscriptionID := range subscriptionIDs {
+		resourcesClient, err := ammr.AzureClients.NewResourcesClient(subscriptionID)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptionResources, err := ammr.listSubscriptionResources(ctx, resourcesClient, subscriptionID, targetResources)
+		if err != nil {
+			return nil, fmt.Errorf("error listing resources of subscription %s: %v", subscriptionID, err)
+		}
+
+		resources = append(resources, subscriptionResources...)
+	}
+
+	return resources, nil
+}
+
+func (amgc *azureManagementGroupsClient) GetDescendants(
+	ctx context.Context,
+	groupID string,
+	options *armmanagementgroups.ClientGetDescendantsOptions,
+) ([]*armmanagementgroups.ClientGetDescendantsResponse, error) {
+	responses := make([]*armmanagementgroups.ClientGetDescendantsResponse, 0)
+	pager := amgc.client.NewGetDescendantsPager(groupID, options)
+
+	for pager.More() {
+		pageCtx, span := startPageSpan(ctx, spanManagementGroupDescendantsPage, len(responses)+1)
+		response, err := pager.NextPage(pageCtx)
+		endSpan(span, err)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, &response)
+	}
+
+	return responses, nil
+}
+
+// getManagementGroupsClientSubscriptionID returns the subscription ID of a subscription descendant, or nil if the
+// descendant is a management group.
+func getManagementGroupsClientSubscriptionID(descendant *armmanagementgroups.DescendantInfo) (*string, error) {
+	if descendant == nil {
+		return nil, fmt.Errorf("management groups client response is bad formatted: descendant is missing")
+	}
+
+	if descendant.Type == nil {
+		return nil, fmt.Errorf("management groups client response is bad formatted: descendant Type is missing")
+	}
+
+	if !strings.EqualFold(*descendant.Type, managementGroupSubscriptionType) {
+		return nil, nil
+	}
+
+	if descendant.Name == nil {
+		return nil, fmt.Errorf("management groups client response is bad formatted: descendant Name is missing")
+	}
+
+	return descendant.Name, nil
+}
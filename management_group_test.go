@@ -0,0 +1,220 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testManagementGroup = "managementGroup"
+
+func newTestManagementGroupTargets() *Targets {
+	targets := NewTargets(nil, nil, nil)
+	targets.AddManagementGroupTargets(NewManagementGroupTarget(testManagementGroup,
+		[]*Resource{NewResource(testResourceType1, []string{testMetric1}, []string{})}))
+	return targets
+}
+
+func TestCreateResourceTargetsFromManagementGroupTargets(t *testing.T) {
+	managementGroupsClient := &mockManagementGroupsClient{subscriptionIDs: []string{testSubscriptionID, testOtherSubscriptionID}}
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, newTestManagementGroupTargets(),
+		setMockAzureClientsWithManagementGroups(managementGroupsClient))
+	require.NoError(t, err)
+
+	err = ammr.CreateResourceTargetsFromManagementGroupTargetsWithContext(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, ammr.Targets.ResourceTargets, 4)
+	assert.Equal(t, testFullResourceGroup1ResourceType1Resource1, ammr.Targets.ResourceTargets[0].ResourceID)
+	assert.Equal(t, testFullResourceGroup2ResourceType1Resource3, ammr.Targets.ResourceTargets[1].ResourceID)
+	assert.Equal(t, strings.Replace(testFullResourceGroup1ResourceType1Resource1, testSubscriptionID, testOtherSubscriptionID, 1),
+		ammr.Targets.ResourceTargets[2].ResourceID)
+	assert.Equal(t, strings.Replace(testFullResourceGroup2ResourceType1Resource3, testSubscriptionID, testOtherSubscriptionID, 1),
+		ammr.Targets.ResourceTargets[3].ResourceID)
+	assert.Equal(t, []string{testMetric1}, ammr.Targets.ResourceTargets[3].Metrics)
+}
+
+func TestCreateResourceTargetsFromManagementGroupTargets_ResourceGraphDiscovery(t *testing.T) {
+	managementGroupsClient := &mockManagementGroupsClient{subscriptionIDs: []string{testSubscriptionID, testOtherSubscriptionID}}
+	resourceGraphClient := &mockResourceGraphClient{}
+	azureClients := setMockAzureClientsWithManagementGroups(managementGroupsClient)
+	azureClients.ResourceGraphClient = resourceGraphClient
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, newTestManagementGroupTargets(), azureClients, WithResourceGraphDiscovery())
+	require.NoError(t, err)
+
+	err = ammr.CreateResourceTargetsFromManagementGroupTargetsWithContext(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, ammr.Targets.ResourceTargets, 2)
+
+	requests := resourceGraphClient.getRequests()
+	require.NotEmpty(t, requests)
+	require.Len(t, requests[0].Subscriptions, 2)
+	assert.Equal(t, testSubscriptionID, *requests[0].Subscriptions[0])
+	assert.Equal(t, testOtherSubscriptionID, *requests[0].Subscriptions[1])
+}
+
+func TestCreateResourceTargetsFromManagementGroupTargets_NoSubscriptions(t *testing.T) {
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, newTestManagementGroupTargets(),
+		setMockAzureClientsWithManagementGroups(&mockManagementGroupsClient{}))
+	require.NoError(t, err)
+
+	err = ammr.CreateResourceTargetsFromManagementGroupTargetsWithContext(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "could not find subscriptions under management group "+testManagementGroup)
+}
+
+func TestCreateResourceTargetsFromManagementGroupTargets_NoManagementGroupsClient(t *testing.T) {
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, newTestManagementGroupTargets(), setMockAzureClients())
+	require.NoError(t, err)
+
+	err = ammr.CreateResourceTargetsFromManagementGroupTargetsWithContext(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "management groups client is missing")
+}
+
+func TestRefreshTargets_ManagementGroupTargets(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	managementGroupsClient := &mockManagementGroupsClient{subscriptionIDs: []string{testSubscriptionID}}
+	targets := newTestManagementGroupTargets()
+	targets.ResourceTargets = []*ResourceTarget{NewResourceTarget(testResourceGroup1ResourceType2Resource2, []string{}, []string{})}
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, targets, setMockAzureClientsWithManagementGroups(managementGroupsClient),
+		WithManagementGroupsRefreshInterval(10*time.Minute))
+	require.NoError(t, err)
+	ammr.now = func() time.Time { return now }
+
+	require.NoError(t, ammr.InitializeTargetsWithContext(context.Background()))
+	resourceTargetsNum := len(ammr.Targets.ResourceTargets)
+
+	managementGroupsClient.addSubscription(testOtherSubscriptionID)
+
+	// The management group target is not refreshed before the refresh interval passes.
+	now = now.Add(5 * time.Minute)
+	require.NoError(t, ammr.RefreshTargetsWithContext(context.Background()))
+	assert.Len(t, ammr.Targets.ResourceTargets, resourceTargetsNum)
+
+	now = now.Add(5 * time.Minute)
+	require.NoError(t, ammr.RefreshTargetsWithContext(context.Background()))
+	assert.Greater(t, len(ammr.Targets.ResourceTargets), resourceTargetsNum)
+
+	otherSubscriptionResourceTargetsNum := 0
+	for _, target := range ammr.Targets.ResourceTargets {
+		if strings.Contains(target.ResourceID, testOtherSubscriptionID) {
+			otherSubscriptionResourceTargetsNum++
+		}
+	}
+
+	assert.Equal(t, len(ammr.Targets.ResourceTargets)-resourceTargetsNum, otherSubscriptionResourceTargetsNum)
+
+	// The resource target is kept.
+	assert.Equal(t, testFullResourceGroup1ResourceType2Resource2, ammr.Targets.ResourceTargets[0].ResourceID)
+}
+
+func TestRefreshTargets_PrunesMetricDefinitionsCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	managementGroupsClient := &mockManagementGroupsClient{subscriptionIDs: []string{testSubscriptionID, testOtherSubscriptionID}}
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, newTestManagementGroupTargets(),
+		setMockAzureClientsWithManagementGroups(managementGroupsClient), WithManagementGroupsRefreshInterval(10*time.Minute))
+	require.NoError(t, err)
+	ammr.now = func() time.Time { return now }
+
+	require.NoError(t, ammr.InitializeTargetsWithContext(context.Background()))
+
+	isOtherSubscriptionCached := func() bool {
+		for _, resourceID := range getMetricDefinitionsCacheResourceIDs(ammr.metricDefinitionsCache) {
+			if strings.Contains(resourceID, testOtherSubscriptionID) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	require.True(t, isOtherSubscriptionCached())
+
+	managementGroupsClient.removeSubscription(testOtherSubscriptionID)
+	now = now.Add(10 * time.Minute)
+	require.NoError(t, ammr.RefreshTargetsWithContext(context.Background()))
+
+	assert.False(t, isOtherSubscriptionCached())
+	assert.NotEmpty(t, getMetricDefinitionsCacheResourceIDs(ammr.metricDefinitionsCache))
+}
+
+func TestRefreshTargets_NoManagementGroupTargets(t *testing.T) {
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID,
+		NewTargets(nil, nil, []*Resource{NewResource(testResourceType1, []string{}, []string{})}), setMockAzureClients())
+	require.NoError(t, err)
+	require.NoError(t, ammr.InitializeTargetsWithContext(context.Background()))
+
+	resourceTargets := ammr.Targets.ResourceTargets
+	ammr.now = func() time.Time { return time.Now().Add(24 * time.Hour) }
+
+	require.NoError(t, ammr.RefreshTargetsWithContext(context.Background()))
+	assert.Equal(t, resourceTargets, ammr.Targets.ResourceTargets)
+}
+
+func TestCheckConfigValidation_ManagementGroupTargetWithNoManagementGroup(t *testing.T) {
+	targets := NewTargets(nil, nil, nil)
+	targets.AddManagementGroupTargets(NewManagementGroupTarget("", []*Resource{NewResource(testResourceType1, []string{}, []string{})}))
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets:        targets,
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	err := ammr.checkValidation()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "management group target #1 management group is empty or missing")
+}
+
+func TestCheckConfigValidation_ManagementGroupTargetWithNoResources(t *testing.T) {
+	targets := NewTargets(nil, nil, nil)
+	targets.AddManagementGroupTargets(NewManagementGroupTarget(testManagementGroup, []*Resource{}))
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets:        targets,
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	err := ammr.checkValidation()
+	require.Error(t, err)
+}
+
+func TestConfigCreateTargets_ManagementGroupTargets(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+management_group_targets:
+  - management_group: `+testManagementGroup+`
+    resources:
+      - resource_type: `+testResourceType1+`
+collection:
+  management_groups_refresh_interval: 30m
+`), ConfigFormatYAML)
+	require.NoError(t, err)
+
+	targets := config.CreateTargets()
+	require.Len(t, targets.managementGroupTargets, 1)
+	assert.Equal(t, testManagementGroup, targets.managementGroupTargets[0].managementGroup)
+	require.Len(t, targets.managementGroupTargets[0].resources, 1)
+	assert.Equal(t, testResourceType1, targets.managementGroupTargets[0].resources[0].resourceType)
+
+	ammr := &AzureMonitorMetricsReceiver{}
+	for _, receiverOption := range config.createReceiverOptions() {
+		receiverOption(ammr)
+	}
+
+	assert.Equal(t, 30*time.Minute, ammr.refreshInterval)
+}
+
+func TestConfigValidate_BadManagementGroupsRefreshInterval(t *testing.T) {
+	config := &Config{Collection: CollectionConfig{ManagementGroupsRefreshInterval: "-1m"}}
+
+	_, err := config.GetManagementGroupsRefreshInterval()
+	require.Error(t, err)
+}
@@ -0,0 +1,87 @@
+package azuremonitormetricsreceiver
+
+import (
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+)
+
+const (
+	// MetricUnitSeconds is seconds base unit name.
+	MetricUnitSeconds = "seconds"
+	// MetricUnitBytes is bytes base unit name.
+	MetricUnitBytes = "bytes"
+	// MetricUnitByteSeconds is byte seconds base unit name.
+	MetricUnitByteSeconds = "byte_seconds"
+	// MetricUnitBytesPerSecond is bytes per second base unit name.
+	MetricUnitBytesPerSecond = "bytes_per_second"
+	// MetricUnitCores is cores base unit name.
+	MetricUnitCores = "cores"
+	// MetricUnitPerSecond is per second base unit name.
+	MetricUnitPerSecond = "per_second"
+	// MetricUnitRatio is ratio base unit name.
+	MetricUnitRatio = "ratio"
+)
+
+type metricUnitNormalization struct {
+	baseUnit string
+	scale    float64
+}
+
+// metricUnitNormalizations maps every Azure Monitor metric unit to its base unit, following Prometheus conventions:
+//
+//	BitsPerSecond  -> bytes_per_second (value / 8)
+//	ByteSeconds    -> byte_seconds
+//	Bytes          -> bytes
+//	BytesPerSecond -> bytes_per_second
+//	Cores          -> cores
+//	Count          -> no unit
+//	CountPerSecond -> per_second
+//	MilliCores     -> cores (value / 1000)
+//	MilliSeconds   -> seconds (value / 1000)
+//	NanoCores      -> cores (value / 1000000000)
+//	Percent        -> ratio (value / 100)
+//	Seconds        -> seconds
+//	Unspecified    -> no unit
+var metricUnitNormalizations = map[armmonitor.MetricUnit]metricUnitNormalization{
+	armmonitor.MetricUnitBitsPerSecond:  {baseUnit: MetricUnitBytesPerSecond, scale: 1.0 / 8},
+	armmonitor.MetricUnitByteSeconds:    {baseUnit: MetricUnitByteSeconds, scale: 1},
+	armmonitor.MetricUnitBytes:          {baseUnit: MetricUnitBytes, scale: 1},
+	armmonitor.MetricUnitBytesPerSecond: {baseUnit: MetricUnitBytesPerSecond, scale: 1},
+	armmonitor.MetricUnitCores:          {baseUnit: MetricUnitCores, scale: 1},
+	armmonitor.MetricUnitCount:          {baseUnit: "", scale: 1},
+	armmonitor.MetricUnitCountPerSecond: {baseUnit: MetricUnitPerSecond, scale: 1},
+	armmonitor.MetricUnitMilliCores:     {baseUnit: MetricUnitCores, scale: 1e-3},
+	armmonitor.MetricUnitMilliSeconds:   {baseUnit: MetricUnitSeconds, scale: 1e-3},
+	armmonitor.MetricUnitNanoCores:      {baseUnit: MetricUnitCores, scale: 1e-9},
+	armmonitor.MetricUnitPercent:        {baseUnit: MetricUnitRatio, scale: 1e-2},
+	armmonitor.MetricUnitSeconds:        {baseUnit: MetricUnitSeconds, scale: 1},
+	armmonitor.MetricUnitUnspecified:    {baseUnit: "", scale: 1},
+}
+
+// normalizedMetricFields are the metric fields that hold values in the metric unit.
+// The count field holds the number of samples, so it is never scaled.
+var normalizedMetricFields = []string{MetricFieldTotal, MetricFieldAverage, MetricFieldMinimum, MetricFieldMaximum}
+
+// normalizeMetricUnit converts the metric values to the metric base unit, appends the base unit suffix to the
+// metric name and sets the unit tag to the base unit. Metrics with unknown units or without a base unit are not changed.
+func normalizeMetricUnit(metric *Metric) {
+	normalization, found := metricUnitNormalizations[armmonitor.MetricUnit(metric.Tags[MetricTagUnit])]
+	if !found || normalization.baseUnit == "" {
+		return
+	}
+
+	if normalization.scale != 1 {
+		for _, field := range normalizedMetricFields {
+			if value, ok := metric.Fields[field].(float64); ok {
+				metric.Fields[field] = value * normalization.scale
+			}
+		}
+	}
+
+	if !strings.HasSuffix(metric.Name, "_"+normalization.baseUnit) {
+		metric.Name += "_" + normalization.baseUnit
+	}
+
+	metric.Tags[MetricTagUnit] = normalization.baseUnit
+}
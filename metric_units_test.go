@@ -0,0 +1,102 @@
+package azuremonitormetricsreceiver
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricUnitNormalizations_AllMetricUnits(t *testing.T) {
+	for _, unit := range armmonitor.PossibleMetricUnitValues() {
+		_, found := metricUnitNormalizations[unit]
+		assert.True(t, found, "metric unit %s has no normalization", unit)
+	}
+}
+
+func TestNormalizeMetricUnit_MilliSeconds(t *testing.T) {
+	metric := &Metric{
+		Name: "azure_monitor_microsoft_test_type1_latency",
+		Fields: map[string]interface{}{
+			MetricFieldTimeStamp: "2022-02-22T22:59:00Z",
+			MetricFieldAverage:   1500.0,
+			MetricFieldMaximum:   3000.0,
+			MetricFieldCount:     4.0,
+		},
+		Tags: map[string]string{MetricTagUnit: string(armmonitor.MetricUnitMilliSeconds)},
+	}
+
+	normalizeMetricUnit(metric)
+
+	assert.Equal(t, "azure_monitor_microsoft_test_type1_latency_seconds", metric.Name)
+	assert.Equal(t, 1.5, metric.Fields[MetricFieldAverage])
+	assert.Equal(t, 3.0, metric.Fields[MetricFieldMaximum])
+	assert.Equal(t, 4.0, metric.Fields[MetricFieldCount])
+	assert.Equal(t, "2022-02-22T22:59:00Z", metric.Fields[MetricFieldTimeStamp])
+	assert.Equal(t, MetricUnitSeconds, metric.Tags[MetricTagUnit])
+}
+
+func TestNormalizeMetricUnit_BitsPerSecond(t *testing.T) {
+	metric := &Metric{
+		Name:   "azure_monitor_microsoft_test_type1_network_in",
+		Fields: map[string]interface{}{MetricFieldTotal: 800.0},
+		Tags:   map[string]string{MetricTagUnit: string(armmonitor.MetricUnitBitsPerSecond)},
+	}
+
+	normalizeMetricUnit(metric)
+
+	assert.Equal(t, "azure_monitor_microsoft_test_type1_network_in_bytes_per_second", metric.Name)
+	assert.Equal(t, 100.0, metric.Fields[MetricFieldTotal])
+	assert.Equal(t, MetricUnitBytesPerSecond, metric.Tags[MetricTagUnit])
+}
+
+func TestNormalizeMetricUnit_NameWithUnitSuffix(t *testing.T) {
+	metric := &Metric{
+		Name:   "azure_monitor_microsoft_test_type1_used_bytes",
+		Fields: map[string]interface{}{MetricFieldTotal: 10.0},
+		Tags:   map[string]string{MetricTagUnit: string(armmonitor.MetricUnitBytes)},
+	}
+
+	normalizeMetricUnit(metric)
+
+	assert.Equal(t, "azure_monitor_microsoft_test_type1_used_bytes", metric.Name)
+	assert.Equal(t, 10.0, metric.Fields[MetricFieldTotal])
+}
+
+func TestNormalizeMetricUnit_Count(t *testing.T) {
+	metric := &Metric{
+		Name:   "azure_monitor_microsoft_test_type1_requests",
+		Fields: map[string]interface{}{MetricFieldTotal: 10.0},
+		Tags:   map[string]string{MetricTagUnit: string(armmonitor.MetricUnitCount)},
+	}
+
+	normalizeMetricUnit(metric)
+
+	assert.Equal(t, "azure_monitor_microsoft_test_type1_requests", metric.Name)
+	assert.Equal(t, 10.0, metric.Fields[MetricFieldTotal])
+	assert.Equal(t, string(armmonitor.MetricUnitCount), metric.Tags[MetricTagUnit])
+}
+
+func TestCollectResourceTargetMetrics_WithMetricUnitsNormalization(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource7, []string{testMetric4}, []string{string(armmonitor.AggregationTypeEnumAverage)}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:         setMockAzureClients(),
+		subscriptionID:       testSubscriptionID,
+		normalizeMetricUnits: true,
+	}
+
+	metrics, _, err := ammr.CollectResourceTargetMetrics(ammr.Targets.ResourceTargets[0])
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+
+	assert.Equal(t, "azure_monitor_microsoft_test_type1_percentage_cpu_ratio", metrics[0].Name)
+	assert.Equal(t, 0.025, metrics[0].Fields[MetricFieldAverage])
+	assert.Equal(t, MetricUnitRatio, metrics[0].Tags[MetricTagUnit])
+}
@@ -0,0 +1,339 @@
+package azuremonitormetricsreceiver
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	instrumentationMetricAPICalls               = "azure_monitor_receiver_api_calls_total"
+	instrumentationMetricAPICallDuration        = "azure_monitor_receiver_api_call_duration_seconds"
+	instrumentationMetricCollections            = "azure_monitor_receiver_collections_total"
+	instrumentationMetricCollectedMetrics       = "azure_monitor_receiver_collected_metrics_total"
+	instrumentationMetricNotCollectedMetrics    = "azure_monitor_receiver_not_collected_metrics_total"
+	instrumentationMetricInitializations        = "azure_monitor_receiver_initializations_total"
+	instrumentationMetricResourceTargets        = "azure_monitor_receiver_resource_targets"
+	instrumentationMetricInitializationDuration = "azure_monitor_receiver_initialization_duration_seconds"
+
+	instrumentationStatusSuccess   = "success"
+	instrumentationStatusThrottled = "throttled"
+	instrumentationStatusError     = "error"
+
+	instrumentationKindCounter   = "counter"
+	instrumentationKindGauge     = "gauge"
+	instrumentationKindHistogram = "histogram"
+)
+
+// DefaultInstrumentationDurationBuckets are the default upper bounds in seconds of the API call duration histogram.
+var DefaultInstrumentationDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// PrometheusInstrumentation is an Instrumentation that keeps the receiver internal metrics in memory.
+// It serves them in the Prometheus text exposition format as an http.Handler, and returns them as metrics
+// that can be written to any sink, such as RemoteWriteSender, with the same names:
+//
+//	azure_monitor_receiver_api_calls_total{api,resource_type,status}
+//	azure_monitor_receiver_api_call_duration_seconds{api} (histogram)
+//	azure_monitor_receiver_collections_total{resource_type,status}
+//	azure_monitor_receiver_collected_metrics_total{resource_type}
+//	azure_monitor_receiver_not_collected_metrics_total{resource_type}
+//	azure_monitor_receiver_initializations_total{status}
+//	azure_monitor_receiver_initialization_duration_seconds
+//	azure_monitor_receiver_resource_targets
+//
+// The status is success, throttled (API calls only) or error.
+type PrometheusInstrumentation struct {
+	mutex           sync.Mutex
+	families        map[string]*instrumentationFamily
+	durationBuckets []float64
+	now             func() time.Time
+}
+
+type instrumentationFamily struct {
+	name   string
+	help   string
+	kind   string
+	series map[string]*instrumentationSeries
+}
+
+type instrumentationSeries struct {
+	labels       []instrumentationLabel
+	value        float64
+	bucketCounts []uint64
+	count        uint64
+}
+
+type instrumentationLabel struct {
+	name  string
+	value string
+}
+
+// NewPrometheusInstrumentation lets you create a new Prometheus instrumentation.
+// If durationBuckets is empty, DefaultInstrumentationDurationBuckets is used.
+func NewPrometheusInstrumentation(durationBuckets ...float64) *PrometheusInstrumentation {
+	if len(durationBuckets) == 0 {
+		durationBuckets = DefaultInstrumentationDurationBuckets
+	}
+
+	durationBuckets = append([]float64{}, durationBuckets...)
+	sort.Float64s(durationBuckets)
+
+	pi := &PrometheusInstrumentation{
+		families:        make(map[string]*instrumentationFamily),
+		durationBuckets: durationBuckets,
+		now:             time.Now,
+	}
+
+	pi.addFamily(instrumentationMetricAPICalls, "Azure API calls.", instrumentationKindCounter)
+	pi.addFamily(instrumentationMetricAPICallDuration, "Azure API calls duration in seconds.", instrumentationKindHistogram)
+	pi.addFamily(instrumentationMetricCollections, "Resource target collections.", instrumentationKindCounter)
+	pi.addFamily(instrumentationMetricCollectedMetrics, "Collected metrics.", instrumentationKindCounter)
+	pi.addFamily(instrumentationMetricNotCollectedMetrics, "Metrics that were not collected.", instrumentationKindCounter)
+	pi.addFamily(instrumentationMetricInitializations, "Targets initializations and reloads.", instrumentationKindCounter)
+	pi.addFamily(instrumentationMetricInitializationDuration, "Last targets initialization duration in seconds.", instrumentationKindGauge)
+	pi.addFamily(instrumentationMetricResourceTargets, "Resource targets after the last successful initialization.", instrumentationKindGauge)
+	return pi
+}
+
+// ObserveAPICall counts the API call and observes its duration.
+func (pi *PrometheusInstrumentation) ObserveAPICall(call *APICall) {
+	status := instrumentationStatusSuccess
+	if call.IsThrottled() {
+		status = instrumentationStatusThrottled
+	} else if call.Err != nil {
+		status = instrumentationStatusError
+	}
+
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+
+	pi.add(instrumentationMetricAPICalls, 1, "api", call.API, "resource_type", call.ResourceType, "status", status)
+	pi.observe(instrumentationMetricAPICallDuration, call.Duration.Seconds(), "api", call.API)
+}
+
+// ObserveResourceTargetCollection counts the collection and its collected and not collected metrics.
+func (pi *PrometheusInstrumentation) ObserveResourceTargetCollection(collection *ResourceTargetCollection) {
+	status := instrumentationStatusSuccess
+	if collection.Err != nil {
+		status = instrumentationStatusError
+	}
+
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+
+	pi.add(instrumentationMetricCollections, 1, "resource_type", collection.ResourceType, "status", status)
+	pi.add(instrumentationMetricCollectedMetrics, float64(collection.MetricsNum), "resource_type", collection.ResourceType)
+	pi.add(instrumentationMetricNotCollectedMetrics, float64(collection.NotCollectedMetricsNum), "resource_type", collection.ResourceType)
+}
+
+// ObserveTargetsInitialization counts the initialization and sets the resource targets number.
+func (pi *PrometheusInstrumentation) ObserveTargetsInitialization(initialization *TargetsInitialization) {
+	status := instrumentationStatusSuccess
+	if initialization.Err != nil {
+		status = instrumentationStatusError
+	}
+
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+
+	pi.add(instrumentationMetricInitializations, 1, "status", status)
+	pi.set(instrumentationMetricInitializationDuration, initialization.Duration.Seconds())
+
+	if initialization.Err == nil {
+		pi.set(instrumentationMetricResourceTargets, float64(initialization.ResourceTargetsNum))
+	}
+}
+
+// ServeHTTP writes the internal metrics in the Prometheus text exposition format.
+func (pi *PrometheusInstrumentation) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	bufferedWriter := bufio.NewWriter(writer)
+	pi.writeText(bufferedWriter)
+	_ = bufferedWriter.Flush()
+}
+
+// Metrics returns the internal metrics, time stamped now. Every metric field is written by RemoteWriteSender as
+// a time series named <metric name>_<field>, which is the same name as in the Prometheus text exposition format.
+func (pi *PrometheusInstrumentation) Metrics() []*Metric {
+	timeStamp := pi.now().UTC().Format(time.RFC3339)
+	metrics := make([]*Metric, 0)
+
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+
+	for _, family := range pi.getSortedFamilies() {
+		for _, series := range family.getSortedSeries() {
+			if family.kind != instrumentationKindHistogram {
+				index := strings.LastIndex(family.name, "_")
+				metrics = append(metrics, createInstrumentationMetric(family.name[:index], family.name[index+1:], series.value, timeStamp, series.labels))
+				continue
+			}
+
+			for bucketIndex, bucket := range pi.durationBuckets {
+				labels := append(append([]instrumentationLabel{}, series.labels...), instrumentationLabel{name: "le", value: formatInstrumentationValue(bucket)})
+				metrics = append(metrics, createInstrumentationMetric(family.name, "bucket", float64(series.bucketCounts[bucketIndex]), timeStamp, labels))
+			}
+
+			labels := append(append([]instrumentationLabel{}, series.labels...), instrumentationLabel{name: "le", value: "+Inf"})
+			metrics = append(metrics,
+				createInstrumentationMetric(family.name, "bucket", float64(series.count), timeStamp, labels),
+				createInstrumentationMetric(family.name, "sum", series.value, timeStamp, series.labels),
+				createInstrumentationMetric(family.name, "count", float64(series.count), timeStamp, series.labels))
+		}
+	}
+
+	return metrics
+}
+
+func (pi *PrometheusInstrumentation) addFamily(name string, help string, kind string) {
+	pi.families[name] = &instrumentationFamily{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		series: make(map[string]*instrumentationSeries),
+	}
+}
+
+// getSeries returns the series of the family with the given label names and values, creating it if needed.
+func (pi *PrometheusInstrumentation) getSeries(name string, labelNamesValues []string) *instrumentationSeries {
+	family := pi.families[name]
+
+	labels := make([]instrumentationLabel, 0, len(labelNamesValues)/2)
+	for index := 0; index+1 < len(labelNamesValues); index += 2 {
+		labels = append(labels, instrumentationLabel{name: labelNamesValues[index], value: labelNamesValues[index+1]})
+	}
+
+	key := formatInstrumentationLabels(labels)
+	series, found := family.series[key]
+	if !found {
+		series = &instrumentationSeries{labels: labels}
+		if family.kind == instrumentationKindHistogram {
+			series.bucketCounts = make([]uint64, len(pi.durationBuckets))
+		}
+
+		family.series[key] = series
+	}
+
+	return series
+}
+
+func (pi *PrometheusInstrumentation) add(name string, value float64, labelNamesValues ...string) {
+	pi.getSeries(name, labelNamesValues).value += value
+}
+
+func (pi *PrometheusInstrumentation) set(name string, value float64, labelNamesValues ...string) {
+	pi.getSeries(name, labelNamesValues).value = value
+}
+
+func (pi *PrometheusInstrumentation) observe(name string, value float64, labelNamesValues ...string) {
+	series := pi.getSeries(name, labelNamesValues)
+	series.value += value
+	series.count++
+
+	for index, bucket := range pi.durationBuckets {
+		if value <= bucket {
+			series.bucketCounts[index]++
+		}
+	}
+}
+
+func (pi *PrometheusInstrumentation) writeText(writer *bufio.Writer) {
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+
+	for _, family := range pi.getSortedFamilies() {
+		fmt.Fprintf(writer, "# HELP %s %s\n", family.name, family.help)
+		fmt.Fprintf(writer, "# TYPE %s %s\n", family.name, family.kind)
+
+		for _, series := range family.getSortedSeries() {
+			if family.kind != instrumentationKindHistogram {
+				fmt.Fprintf(writer, "%s%s %s\n", family.name, formatInstrumentationLabels(series.labels), formatInstrumentationValue(series.value))
+				continue
+			}
+
+			for index, bucket := range pi.durationBuckets {
+				labels := append(append([]instrumentationLabel{}, series.labels...), instrumentationLabel{name: "le", value: formatInstrumentationValue(bucket)})
+				fmt.Fprintf(writer, "%s_bucket%s %d\n", family.name, formatInstrumentationLabels(labels), series.bucketCounts[index])
+			}
+
+			labels := append(append([]instrumentationLabel{}, series.labels...), instrumentationLabel{name: "le", value: "+Inf"})
+			fmt.Fprintf(writer, "%s_bucket%s %d\n", family.name, formatInstrumentationLabels(labels), series.count)
+			fmt.Fprintf(writer, "%s_sum%s %s\n", family.name, formatInstrumentationLabels(series.labels), formatInstrumentationValue(series.value))
+			fmt.Fprintf(writer, "%s_count%s %d\n", family.name, formatInstrumentationLabels(series.labels), series.count)
+		}
+	}
+}
+
+func (pi *PrometheusInstrumentation) getSortedFamilies() []*instrumentationFamily {
+	families := make([]*instrumentationFamily, 0, len(pi.families))
+	for _, family := range pi.families {
+		families = append(families, family)
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	return families
+}
+
+func (family *instrumentationFamily) getSortedSeries() []*instrumentationSeries {
+	keys := make([]string, 0, len(family.series))
+	for key := range family.series {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	series := make([]*instrumentationSeries, 0, len(keys))
+	for _, key := range keys {
+		series = append(series, family.series[key])
+	}
+
+	return series
+}
+
+func createInstrumentationMetric(name string, fieldName string, value float64, timeStamp string, labels []instrumentationLabel) *Metric {
+	tags := make(map[string]string, len(labels))
+	for _, label := range labels {
+		tags[label.name] = label.value
+	}
+
+	return &Metric{
+		Name: name,
+		Fields: map[string]interface{}{
+			MetricFieldTimeStamp: timeStamp,
+			fieldName:            value,
+		},
+		Tags: tags,
+	}
+}
+
+func formatInstrumentationLabels(labels []instrumentationLabel) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	formattedLabels := make([]string, 0, len(labels))
+	for _, label := range labels {
+		formattedLabels = append(formattedLabels, fmt.Sprintf(`%s="%s"`, label.name, replacer.Replace(label.value)))
+	}
+
+	return "{" + strings.Join(formattedLabels, ",") + "}"
+}
+
+func formatInstrumentationValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
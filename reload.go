@@ -0,0 +1,390 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// targetsPlan is the resource targets that were created from a single configured target.
+type targetsPlan struct {
+	key             string
+	targets         *Targets
+	resourceTargets []*ResourceTarget
+	// expiresAt is the time the resource targets should be refreshed at. It is zero if they never expire.
+	expiresAt time.Time
+}
+
+type targetsPlanKey struct {
+	Kind            string        `json:"kind"`
+	ResourceGroup   string        `json:"resource_group,omitempty"`
+	ResourceID      string        `json:"resource_id,omitempty"`
+	ResourceType    string        `json:"resource_type,omitempty"`
+	Query           string        `json:"query,omitempty"`
+	Subscriptions   []string      `json:"subscriptions,omitempty"`
+	ManagementGroup string        `json:"management_group,omitempty"`
+	Metrics         []string      `json:"metrics"`
+	Aggregations    []string      `json:"aggregations"`
+	Timeout         time.Duration `json:"timeout,omitempty"`
+	// ExpandChildResources is set for resource types only.
+	ExpandChildResources bool `json:"expand_child_resources,omitempty"`
+}
+
+// TargetsDiff describes the difference between the current targets and the reloaded targets.
+type TargetsDiff struct {
+	AddedTargetsNum     int
+	RemovedTargetsNum   int
+	UnchangedTargetsNum int
+}
+
+// InitializeTargets creates resource targets from all resource group, subscription, resource graph and management
+// group targets, sets and validates their metrics and aggregations, and splits them by min time grain and max metrics
+// per request.
+// The resource targets created from each configured target are recorded, so ReloadTargets only initializes
+// the targets that changed.
+//
+// Deprecated: Use InitializeTargetsWithContext instead.
+func (ammr *AzureMonitorMetricsReceiver) InitializeTargets() error {
+	return ammr.InitializeTargetsWithContext(ammr.getContext())
+}
+
+// InitializeTargetsWithContext creates resource targets from all resource group, subscription, resource graph and
+// management group targets, sets and validates their metrics and aggregations, and splits them by min time grain and
+// max metrics per request.
+// The resource targets created from each configured target are recorded, so ReloadTargetsWithContext only
+// initializes the targets that changed.
+func (ammr *AzureMonitorMetricsReceiver) InitializeTargetsWithContext(ctx context.Context) (err error) {
+	start := ammr.getCurrentTime()
+	defer func() {
+		ammr.targetsMutex.RLock()
+		defer ammr.targetsMutex.RUnlock()
+
+		ammr.observeTargetsInitialization(start, err)
+	}()
+
+	plans, _, err := ammr.createTargetsPlans(ctx, ammr.Targets, nil)
+	if err != nil {
+		return err
+	}
+
+	ammr.targetsMutex.Lock()
+	defer ammr.targetsMutex.Unlock()
+
+	ammr.Targets.ResourceTargets = getTargetsPlansResourceTargets(plans)
+	ammr.targetsPlans = plans
+
+	if len(ammr.Targets.ResourceTargets) == 0 {
+		ammr.getLogger().WarnContext(ctx, "targets initialization created no resource targets")
+	}
+
+	return nil
+}
+
+// ReloadTargets replaces the receiver targets at runtime. Only new or changed targets are initialized,
+// using the cached metric definitions, and the resource targets of unchanged targets are reused.
+// The new targets are swapped in between collection cycles.
+//
+// Deprecated: Use ReloadTargetsWithContext instead.
+func (ammr *AzureMonitorMetricsReceiver) ReloadTargets(targets *Targets) (*TargetsDiff, error) {
+	return ammr.ReloadTargetsWithContext(ammr.getContext(), targets)
+}
+
+// ReloadTargetsWithContext replaces the receiver targets at runtime. Only new or changed targets are initialized,
+// using the cached metric definitions, and the resource targets of unchanged targets are reused.
+// The new targets are swapped in between collection cycles.
+func (ammr *AzureMonitorMetricsReceiver) ReloadTargetsWithContext(ctx context.Context, targets *Targets) (diff *TargetsDiff, err error) {
+	start := ammr.getCurrentTime()
+	defer func() {
+		ammr.targetsMutex.RLock()
+		defer ammr.targetsMutex.RUnlock()
+
+		ammr.observeTargetsInitialization(start, err)
+	}()
+
+	newAmmr := &AzureMonitorMetricsReceiver{
+		Targets:            cloneTargets(targets),
+		subscriptionID:     ammr.subscriptionID,
+		preferredTimeGrain: ammr.preferredTimeGrain,
+		requestTimeout:     ammr.requestTimeout,
+		refreshInterval:    ammr.refreshInterval,
+	}
+
+	if err := newAmmr.checkValidation(); err != nil {
+		return nil, fmt.Errorf("got validation error: %v", err)
+	}
+
+	newAmmr.resolveResourceTargetsResourceID()
+
+	ammr.targetsMutex.RLock()
+	currentPlans := make(map[string]*targetsPlan)
+	for _, plan := range ammr.targetsPlans {
+		currentPlans[plan.key] = plan
+	}
+	ammr.targetsMutex.RUnlock()
+
+	// createTargetsPlans removes the reused plans from currentPlans, so only the removed plans are left.
+	plans, unchangedTargetsNum, err := ammr.createTargetsPlans(ctx, newAmmr.Targets, currentPlans)
+	if err != nil {
+		return nil, err
+	}
+
+	ammr.targetsMutex.Lock()
+	defer ammr.targetsMutex.Unlock()
+
+	ammr.Targets = NewTargets(getTargetsPlansResourceTargets(plans), newAmmr.Targets.resourceGroupTargets, newAmmr.Targets.subscriptionTargets)
+	ammr.Targets.AddResourceGraphTargets(newAmmr.Targets.resourceGraphTargets...)
+	ammr.Targets.AddManagementGroupTargets(newAmmr.Targets.managementGroupTargets...)
+	ammr.targetsPlans = plans
+	ammr.pruneMetricDefinitionsCache()
+
+	return &TargetsDiff{
+		AddedTargetsNum:     len(plans) - unchangedTargetsNum,
+		RemovedTargetsNum:   len(currentPlans),
+		UnchangedTargetsNum: unchangedTargetsNum,
+	}, nil
+}
+
+func (ammr *AzureMonitorMetricsReceiver) createTargetsPlans(ctx context.Context, targets *Targets, currentPlans map[string]*targetsPlan) ([]*targetsPlan, int, error) {
+	plans := make([]*targetsPlan, 0)
+	unchangedTargetsNum := 0
+
+	addPlan := func(key targetsPlanKey, planTargets *Targets) error {
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+
+		if currentPlan, found := currentPlans[string(encodedKey)]; found {
+			plans = append(plans, currentPlan)
+			delete(currentPlans, string(encodedKey))
+			unchangedTargetsNum++
+			return nil
+		}
+
+		plan, err := ammr.initializeTargetsPlan(ctx, string(encodedKey), planTargets)
+		if err != nil {
+			return err
+		}
+
+		plans = append(plans, plan)
+		return nil
+	}
+
+	for _, target := range targets.ResourceTargets {
+		key := targetsPlanKey{Kind: "resource", ResourceID: target.ResourceID, Metrics: copyStrings(target.Metrics), Aggregations: copyStrings(target.Aggregations), Timeout: target.Timeout}
+		planTargets := NewTargets([]*ResourceTarget{cloneResourceTarget(target)}, nil, nil)
+
+		if err := addPlan(key, planTargets); err != nil {
+			return nil, 0, fmt.Errorf("error initializing resource target %s: %v", target.ResourceID, err)
+		}
+	}
+
+	for _, target := range targets.resourceGroupTargets {
+		for _, resource := range target.resources {
+			key := targetsPlanKey{Kind: "resource_group", ResourceGroup: target.resourceGroup, ResourceType: resource.resourceType, Metrics: copyStrings(resource.metrics), Aggregations: copyStrings(resource.aggregations), Timeout: resource.timeout, ExpandChildResources: resource.expandChildResources}
+			planTargets := NewTargets(nil, []*ResourceGroupTarget{NewResourceGroupTarget(target.resourceGroup, []*Resource{resource})}, nil)
+
+			if err := addPlan(key, planTargets); err != nil {
+				return nil, 0, fmt.Errorf("error initializing resource group target %s resource type %s: %v", target.resourceGroup, resource.resourceType, err)
+			}
+		}
+	}
+
+	for _, resource := range targets.subscriptionTargets {
+		key := targetsPlanKey{Kind: "subscription", ResourceType: resource.resourceType, Metrics: copyStrings(resource.metrics), Aggregations: copyStrings(resource.aggregations), Timeout: resource.timeout, ExpandChildResources: resource.expandChildResources}
+		planTargets := NewTargets(nil, nil, []*Resource{resource})
+
+		if err := addPlan(key, planTargets); err != nil {
+			return nil, 0, fmt.Errorf("error initializing subscription target resource type %s: %v", resource.resourceType, err)
+		}
+	}
+
+	for index, target := range targets.resourceGraphTargets {
+		key := targetsPlanKey{Kind: "resource_graph", Query: target.query, Subscriptions: copyStrings(target.subscriptions), Metrics: copyStrings(target.metrics), Aggregations: copyStrings(target.aggregations), Timeout: target.timeout}
+		planTargets := NewTargets(nil, nil, nil)
+		planTargets.AddResourceGraphTargets(target)
+
+		if err := addPlan(key, planTargets); err != nil {
+			return nil, 0, fmt.Errorf("error initializing resource graph target #%d: %v", index+1, err)
+		}
+	}
+
+	for _, target := range targets.managementGroupTargets {
+		for _, resource := range target.resources {
+			key := targetsPlanKey{Kind: "management_group", ManagementGroup: target.managementGroup, ResourceType: resource.resourceType, Metrics: copyStrings(resource.metrics), Aggregations: copyStrings(resource.aggregations), Timeout: resource.timeout, ExpandChildResources: resource.expandChildResources}
+			planTargets := NewTargets(nil, nil, nil)
+			planTargets.AddManagementGroupTargets(NewManagementGroupTarget(target.managementGroup, []*Resource{resource}))
+
+			if err := addPlan(key, planTargets); err != nil {
+				return nil, 0, fmt.Errorf("error initializing management group target %s resource type %s: %v", target.managementGroup, resource.resourceType, err)
+			}
+		}
+	}
+
+	return plans, unchangedTargetsNum, nil
+}
+
+// initializeTargetsPlan initializes the resource targets of the plan targets. The resource targets of management
+// group targets expire after the management groups refresh interval.
+func (ammr *AzureMonitorMetricsReceiver) initializeTargetsPlan(ctx context.Context, key string, planTargets *Targets) (*targetsPlan, error) {
+	plan := &targetsPlan{key: key, targets: cloneTargets(planTargets)}
+
+	planAmmr := &AzureMonitorMetricsReceiver{
+		Targets:                planTargets,
+		AzureClients:           ammr.AzureClients,
+		subscriptionID:         ammr.subscriptionID,
+		metricDefinitionsCache: ammr.metricDefinitionsCache,
+		preferredTimeGrain:     ammr.preferredTimeGrain,
+		requestTimeout:         ammr.requestTimeout,
+		instrumentation:        ammr.instrumentation,
+		logger:                 ammr.logger,
+		tracer:                 ammr.tracer,
+		useResourceGraph:       ammr.useResourceGraph,
+		now:                    ammr.now,
+	}
+
+	if err := planAmmr.initializeResourceTargets(ctx); err != nil {
+		return nil, err
+	}
+
+	plan.resourceTargets = planAmmr.Targets.ResourceTargets
+	if len(planTargets.managementGroupTargets) > 0 {
+		plan.expiresAt = ammr.getCurrentTime().Add(ammr.getRefreshInterval())
+	}
+
+	return plan, nil
+}
+
+// RefreshTargetsWithContext refreshes the resource targets of the management group targets whose refresh interval
+// passed, so the resources of new subscriptions under the management groups are collected, and the resources of
+// removed subscriptions are not. The other targets are not changed. Scheduler.Run calls it before every collection,
+// so call it periodically only if you collect metrics yourself.
+// If refreshing a target fails, its resource targets are kept, and it is refreshed again on the next call.
+func (ammr *AzureMonitorMetricsReceiver) RefreshTargetsWithContext(ctx context.Context) (err error) {
+	start := ammr.getCurrentTime()
+
+	ammr.targetsMutex.RLock()
+	expiredPlans := make([]*targetsPlan, 0)
+	for _, plan := range ammr.targetsPlans {
+		if plan.isExpired(start) {
+			expiredPlans = append(expiredPlans, plan)
+		}
+	}
+	ammr.targetsMutex.RUnlock()
+
+	if len(expiredPlans) == 0 {
+		return nil
+	}
+
+	defer func() {
+		ammr.targetsMutex.RLock()
+		defer ammr.targetsMutex.RUnlock()
+
+		ammr.observeTargetsInitialization(start, err)
+	}()
+
+	refreshedPlans := make(map[*targetsPlan]*targetsPlan)
+	for _, plan := range expiredPlans {
+		refreshedPlan, planErr := ammr.initializeTargetsPlan(ctx, plan.key, cloneTargets(plan.targets))
+		if planErr != nil {
+			if err == nil {
+				err = fmt.Errorf("error refreshing targets: %v", planErr)
+			}
+
+			continue
+		}
+
+		refreshedPlans[plan] = refreshedPlan
+	}
+
+	ammr.targetsMutex.Lock()
+	defer ammr.targetsMutex.Unlock()
+
+	// The plans may have been replaced by a reload while they were refreshed.
+	for index, plan := range ammr.targetsPlans {
+		if refreshedPlan, found := refreshedPlans[plan]; found {
+			ammr.targetsPlans[index] = refreshedPlan
+		}
+	}
+
+	ammr.Targets.ResourceTargets = getTargetsPlansResourceTargets(ammr.targetsPlans)
+	ammr.pruneMetricDefinitionsCache()
+	ammr.getLogger().DebugContext(ctx, "refreshed targets", "targets", len(refreshedPlans))
+	return err
+}
+
+func (ammr *AzureMonitorMetricsReceiver) getRefreshInterval() time.Duration {
+	if ammr.refreshInterval == 0 {
+		return DefaultManagementGroupsRefreshInterval
+	}
+
+	return ammr.refreshInterval
+}
+
+func (plan *targetsPlan) isExpired(now time.Time) bool {
+	return !plan.expiresAt.IsZero() && !now.Before(plan.expiresAt)
+}
+
+func (ammr *AzureMonitorMetricsReceiver) initializeResourceTargets(ctx context.Context) error {
+	if err := ammr.CreateResourceTargetsFromResourceGroupTargetsWithContext(ctx); err != nil {
+		return err
+	}
+
+	if err := ammr.CreateResourceTargetsFromSubscriptionTargetsWithContext(ctx); err != nil {
+		return err
+	}
+
+	if err := ammr.CreateResourceTargetsFromResourceGraphTargetsWithContext(ctx); err != nil {
+		return err
+	}
+
+	if err := ammr.CreateResourceTargetsFromManagementGroupTargetsWithContext(ctx); err != nil {
+		return err
+	}
+
+	if err := ammr.CheckResourceTargetsMetricsValidationWithContext(ctx); err != nil {
+		return err
+	}
+
+	if err := ammr.SetResourceTargetsMetricsWithContext(ctx); err != nil {
+		return err
+	}
+
+	if err := ammr.SplitResourceTargetsMetricsByMinTimeGrainWithContext(ctx); err != nil {
+		return err
+	}
+
+	ammr.SplitResourceTargetsWithMoreThanMaxMetrics()
+	ammr.SetResourceTargetsAggregations()
+	return nil
+}
+
+func getTargetsPlansResourceTargets(plans []*targetsPlan) []*ResourceTarget {
+	resourceTargets := make([]*ResourceTarget, 0)
+	for _, plan := range plans {
+		resourceTargets = append(resourceTargets, plan.resourceTargets...)
+	}
+
+	return resourceTargets
+}
+
+func cloneTargets(targets *Targets) *Targets {
+	resourceTargets := make([]*ResourceTarget, 0, len(targets.ResourceTargets))
+	for _, target := range targets.ResourceTargets {
+		resourceTargets = append(resourceTargets, cloneResourceTarget(target))
+	}
+
+	newTargets := NewTargets(resourceTargets, targets.resourceGroupTargets, targets.subscriptionTargets)
+	newTargets.AddResourceGraphTargets(targets.resourceGraphTargets...)
+	newTargets.AddManagementGroupTargets(targets.managementGroupTargets...)
+	return newTargets
+}
+
+func cloneResourceTarget(target *ResourceTarget) *ResourceTarget {
+	newTarget := NewResourceTarget(target.ResourceID, copyStrings(target.Metrics), copyStrings(target.Aggregations))
+	newTarget.TimeGrain = target.TimeGrain
+	newTarget.Retention = target.Retention
+	newTarget.Timeout = target.Timeout
+	return newTarget
+}
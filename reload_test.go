@@ -0,0 +1,152 @@
+package azuremonitormetricsreceiver
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitializeTargets_Success(t *testing.T) {
+	azureClients := setMockAzureClients()
+	definitionsClient := newCountingMetricDefinitionsClient()
+	azureClients.MetricDefinitionsClient = definitionsClient
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID,
+		NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testResourceGroup1ResourceType2Resource2, []string{testMetric1}, []string{string(armmonitor.AggregationTypeEnumTotal)}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{
+				NewResource(testResourceType1, []string{}, []string{}),
+			},
+		),
+		azureClients)
+	require.NoError(t, err)
+
+	err = ammr.InitializeTargets()
+	require.NoError(t, err)
+
+	// resource2 metric1, resource1 split to PT1M and PT5M metrics, resource3 metrics.
+	assert.Len(t, ammr.Targets.ResourceTargets, 4)
+	assert.Len(t, ammr.targetsPlans, 2)
+	assert.Equal(t, 1, definitionsClient.getResourceCalls(testFullResourceGroup1ResourceType1Resource1))
+
+	for _, target := range ammr.Targets.ResourceTargets {
+		assert.NotEmpty(t, target.Metrics)
+		assert.NotEmpty(t, target.Aggregations)
+	}
+}
+
+func TestReloadTargets_OnlyChangedTargetsInitialized(t *testing.T) {
+	azureClients := setMockAzureClients()
+	definitionsClient := newCountingMetricDefinitionsClient()
+	azureClients.MetricDefinitionsClient = definitionsClient
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID,
+		NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testResourceGroup1ResourceType2Resource2, []string{testMetric1}, []string{string(armmonitor.AggregationTypeEnumTotal)}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{
+				NewResource(testResourceType2, []string{testMetric1}, []string{}),
+			},
+		),
+		azureClients)
+	require.NoError(t, err)
+	require.NoError(t, ammr.InitializeTargets())
+
+	unchangedTarget := ammr.Targets.ResourceTargets[0]
+
+	diff, err := ammr.ReloadTargets(NewTargets(
+		[]*ResourceTarget{
+			NewResourceTarget(testResourceGroup1ResourceType2Resource2, []string{testMetric1}, []string{string(armmonitor.AggregationTypeEnumTotal)}),
+		},
+		[]*ResourceGroupTarget{
+			NewResourceGroupTarget(testResourceGroup2, []*Resource{
+				NewResource(testResourceType1, []string{testMetric1}, []string{}),
+			}),
+		},
+		[]*Resource{},
+	))
+	require.NoError(t, err)
+
+	assert.Equal(t, &TargetsDiff{AddedTargetsNum: 1, RemovedTargetsNum: 1, UnchangedTargetsNum: 1}, diff)
+	require.Len(t, ammr.Targets.ResourceTargets, 2)
+	assert.Same(t, unchangedTarget, ammr.Targets.ResourceTargets[0])
+	assert.Equal(t, testFullResourceGroup2ResourceType1Resource3, ammr.Targets.ResourceTargets[1].ResourceID)
+	assert.Equal(t, 1, definitionsClient.getResourceCalls(testFullResourceGroup1ResourceType2Resource2))
+	assert.Equal(t, 1, definitionsClient.getResourceCalls(testFullResourceGroup2ResourceType1Resource3))
+}
+
+func TestReloadTargets_PrunesMetricDefinitionsCache(t *testing.T) {
+	azureClients := setMockAzureClients()
+	definitionsClient := newCountingMetricDefinitionsClient()
+	azureClients.MetricDefinitionsClient = definitionsClient
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID,
+		NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testResourceGroup1ResourceType1Resource1, []string{testMetric1}, []string{}),
+				NewResourceTarget(testResourceGroup1ResourceType2Resource2, []string{testMetric1}, []string{}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		azureClients)
+	require.NoError(t, err)
+	require.NoError(t, ammr.InitializeTargets())
+	assert.Equal(t, []string{testFullResourceGroup1ResourceType1Resource1, testFullResourceGroup1ResourceType2Resource2},
+		getMetricDefinitionsCacheResourceIDs(ammr.metricDefinitionsCache))
+
+	_, err = ammr.ReloadTargets(NewTargets(
+		[]*ResourceTarget{
+			NewResourceTarget(testResourceGroup1ResourceType2Resource2, []string{testMetric1}, []string{}),
+		},
+		[]*ResourceGroupTarget{},
+		[]*Resource{},
+	))
+	require.NoError(t, err)
+	assert.Equal(t, []string{testFullResourceGroup1ResourceType2Resource2}, getMetricDefinitionsCacheResourceIDs(ammr.metricDefinitionsCache))
+
+	// The metric definitions of a resource that is collected again are listed again.
+	_, err = ammr.ReloadTargets(NewTargets(
+		[]*ResourceTarget{
+			NewResourceTarget(testResourceGroup1ResourceType1Resource1, []string{testMetric1}, []string{}),
+			NewResourceTarget(testResourceGroup1ResourceType2Resource2, []string{testMetric1}, []string{}),
+		},
+		[]*ResourceGroupTarget{},
+		[]*Resource{},
+	))
+	require.NoError(t, err)
+	assert.Equal(t, 2, definitionsClient.getResourceCalls(testFullResourceGroup1ResourceType1Resource1))
+	assert.Equal(t, 1, definitionsClient.getResourceCalls(testFullResourceGroup1ResourceType2Resource2))
+}
+
+func TestReloadTargets_InvalidTargets(t *testing.T) {
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID,
+		NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testResourceGroup1ResourceType2Resource2, []string{testMetric1}, []string{}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		setMockAzureClients())
+	require.NoError(t, err)
+	require.NoError(t, ammr.InitializeTargets())
+
+	_, err = ammr.ReloadTargets(NewTargets([]*ResourceTarget{}, []*ResourceGroupTarget{}, []*Resource{}))
+	require.Error(t, err)
+
+	_, err = ammr.ReloadTargets(NewTargets([]*ResourceTarget{}, []*ResourceGroupTarget{}, []*Resource{
+		NewResource(testResourceType3, []string{}, []string{}),
+	}))
+	require.Error(t, err)
+
+	require.Len(t, ammr.Targets.ResourceTargets, 1)
+	assert.Equal(t, testFullResourceGroup1ResourceType2Resource2, ammr.Targets.ResourceTargets[0].ResourceID)
+}
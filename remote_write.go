@@ -0,0 +1,298 @@
+package azuremonitormetricsreceiver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// DefaultRemoteWriteTimeout is the default timeout of a remote write request.
+	DefaultRemoteWriteTimeout = 30 * time.Second
+	// DefaultRemoteWriteMaxRetries is the default max retries of a failed remote write request.
+	DefaultRemoteWriteMaxRetries = 3
+	// DefaultRemoteWriteRetryBackoff is the default backoff before the first retry, doubled on every retry.
+	DefaultRemoteWriteRetryBackoff = time.Second
+
+	remoteWriteUserAgent      = "azure-monitor-metrics-receiver"
+	remoteWriteVersion        = "0.1.0"
+	remoteWriteMaxErrorLength = 512
+
+	metricLabelName = "__name__"
+)
+
+// RemoteWriteSender is a sink that sends metrics to a Prometheus remote write endpoint, such as Logz.io metrics listener.
+type RemoteWriteSender struct {
+	url          string
+	token        string
+	headers      map[string]string
+	httpClient   *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// RemoteWriteSenderOptions lets you set optional remote write sender parameters.
+type RemoteWriteSenderOptions func(*RemoteWriteSender)
+
+type remoteWriteSample struct {
+	value     float64
+	timestamp int64
+}
+
+type remoteWriteLabel struct {
+	name  string
+	value string
+}
+
+type remoteWriteTimeSeries struct {
+	labels  []remoteWriteLabel
+	samples []remoteWriteSample
+}
+
+// NewRemoteWriteSender lets you create a new remote write sender.
+func NewRemoteWriteSender(url string, remoteWriteSenderOptions ...RemoteWriteSenderOptions) (*RemoteWriteSender, error) {
+	if url == "" {
+		return nil, fmt.Errorf("remote write URL is empty or missing")
+	}
+
+	remoteWriteSender := &RemoteWriteSender{
+		url:          url,
+		headers:      make(map[string]string),
+		httpClient:   &http.Client{Timeout: DefaultRemoteWriteTimeout},
+		maxRetries:   DefaultRemoteWriteMaxRetries,
+		retryBackoff: DefaultRemoteWriteRetryBackoff,
+	}
+
+	for _, remoteWriteSenderOption := range remoteWriteSenderOptions {
+		remoteWriteSenderOption(remoteWriteSender)
+	}
+
+	return remoteWriteSender, nil
+}
+
+// WithRemoteWriteToken lets you set the token that is sent as a bearer token in the Authorization header.
+func WithRemoteWriteToken(token string) RemoteWriteSenderOptions {
+	return func(remoteWriteSender *RemoteWriteSender) {
+		remoteWriteSender.token = token
+	}
+}
+
+// WithRemoteWriteHeader lets you add a header to every remote write request.
+func WithRemoteWriteHeader(name string, value string) RemoteWriteSenderOptions {
+	return func(remoteWriteSender *RemoteWriteSender) {
+		remoteWriteSender.headers[name] = value
+	}
+}
+
+// WithRemoteWriteHTTPClient lets you set the HTTP client that sends the remote write requests.
+func WithRemoteWriteHTTPClient(httpClient *http.Client) RemoteWriteSenderOptions {
+	return func(remoteWriteSender *RemoteWriteSender) {
+		if httpClient != nil {
+			remoteWriteSender.httpClient = httpClient
+		}
+	}
+}
+
+// WithRemoteWriteRetries lets you set the max retries of a failed request and the backoff before the first retry.
+func WithRemoteWriteRetries(maxRetries int, retryBackoff time.Duration) RemoteWriteSenderOptions {
+	return func(remoteWriteSender *RemoteWriteSender) {
+		remoteWriteSender.maxRetries = maxRetries
+		remoteWriteSender.retryBackoff = retryBackoff
+	}
+}
+
+// Write converts the metrics to Prometheus time series and sends them to the remote write endpoint.
+// Failed requests are retried on network errors, 429 and 5xx responses.
+func (rws *RemoteWriteSender) Write(ctx context.Context, metrics []*Metric) error {
+	timeSeries, err := createRemoteWriteTimeSeries(metrics)
+	if err != nil {
+		return fmt.Errorf("error converting metrics to time series: %v", err)
+	}
+
+	if len(timeSeries) == 0 {
+		return nil
+	}
+
+	body := snappy.Encode(nil, encodeRemoteWriteRequest(timeSeries))
+	backoff := rws.retryBackoff
+
+	for attempt := 0; ; attempt++ {
+		isRetryable, err := rws.send(ctx, body)
+		if err == nil {
+			return nil
+		}
+
+		if !isRetryable || attempt >= rws.maxRetries {
+			return fmt.Errorf("error sending remote write request: %v", err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("error sending remote write request: %v: %w", err, ctx.Err())
+		}
+
+		backoff *= 2
+	}
+}
+
+func (rws *RemoteWriteSender) send(ctx context.Context, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, rws.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("User-Agent", remoteWriteUserAgent)
+	request.Header.Set("X-Prometheus-Remote-Write-Version", remoteWriteVersion)
+
+	if rws.token != "" {
+		request.Header.Set("Authorization", "Bearer "+rws.token)
+	}
+
+	for name, value := range rws.headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := rws.httpClient.Do(request)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, response.Body)
+		return false, nil
+	}
+
+	responseBody, _ := io.ReadAll(io.LimitReader(response.Body, remoteWriteMaxErrorLength))
+	isRetryable := response.StatusCode == http.StatusTooManyRequests || response.StatusCode/100 == 5
+	return isRetryable, fmt.Errorf("got status code %d: %s", response.StatusCode, strings.TrimSpace(string(responseBody)))
+}
+
+// createRemoteWriteTimeSeries creates a time series for every metric value field. The time series name is
+// the metric name with the field name as suffix, and its labels are the metric tags.
+func createRemoteWriteTimeSeries(metrics []*Metric) ([]*remoteWriteTimeSeries, error) {
+	timeSeries := make([]*remoteWriteTimeSeries, 0)
+
+	for _, metric := range metrics {
+		timeStamp, err := getMetricTimeStamp(metric)
+		if err != nil {
+			return nil, err
+		}
+
+		fieldNames := make([]string, 0, len(metric.Fields))
+		for fieldName := range metric.Fields {
+			if fieldName != MetricFieldTimeStamp {
+				fieldNames = append(fieldNames, fieldName)
+			}
+		}
+
+		sort.Strings(fieldNames)
+
+		for _, fieldName := range fieldNames {
+			value, ok := metric.Fields[fieldName].(float64)
+			if !ok {
+				return nil, fmt.Errorf("metric %s field %s is not a number", metric.Name, fieldName)
+			}
+
+			timeSeries = append(timeSeries, &remoteWriteTimeSeries{
+				labels:  createRemoteWriteLabels(metric.Name+"_"+fieldName, metric.Tags),
+				samples: []remoteWriteSample{{value: value, timestamp: timeStamp.UnixMilli()}},
+			})
+		}
+	}
+
+	return timeSeries, nil
+}
+
+func getMetricTimeStamp(metric *Metric) (*time.Time, error) {
+	timeStampField, ok := metric.Fields[MetricFieldTimeStamp].(string)
+	if !ok {
+		return nil, fmt.Errorf("metric %s field %s is missing", metric.Name, MetricFieldTimeStamp)
+	}
+
+	timeStamp, err := time.Parse(time.RFC3339, timeStampField)
+	if err != nil {
+		return nil, fmt.Errorf("metric %s field %s is bad formatted: %v", metric.Name, MetricFieldTimeStamp, err)
+	}
+
+	return &timeStamp, nil
+}
+
+func createRemoteWriteLabels(name string, tags map[string]string) []remoteWriteLabel {
+	labels := make([]remoteWriteLabel, 0, len(tags)+1)
+	labels = append(labels, remoteWriteLabel{name: metricLabelName, value: sanitizeRemoteWriteName(name)})
+
+	for tagName, tagValue := range tags {
+		// Prometheus treats empty labels as missing labels, and remote write receivers may reject them.
+		if tagValue == "" {
+			continue
+		}
+
+		labels = append(labels, remoteWriteLabel{name: sanitizeRemoteWriteName(tagName), value: tagValue})
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+
+	return labels
+}
+
+func sanitizeRemoteWriteName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == ':' {
+			return r
+		}
+
+		return '_'
+	}, name)
+}
+
+// encodeRemoteWriteRequest encodes the time series as a Prometheus remote write WriteRequest protobuf message.
+func encodeRemoteWriteRequest(timeSeries []*remoteWriteTimeSeries) []byte {
+	var request []byte
+
+	for _, series := range timeSeries {
+		var encodedSeries []byte
+
+		for _, label := range series.labels {
+			var encodedLabel []byte
+			encodedLabel = protowire.AppendTag(encodedLabel, 1, protowire.BytesType)
+			encodedLabel = protowire.AppendString(encodedLabel, label.name)
+			encodedLabel = protowire.AppendTag(encodedLabel, 2, protowire.BytesType)
+			encodedLabel = protowire.AppendString(encodedLabel, label.value)
+
+			encodedSeries = protowire.AppendTag(encodedSeries, 1, protowire.BytesType)
+			encodedSeries = protowire.AppendBytes(encodedSeries, encodedLabel)
+		}
+
+		for _, sample := range series.samples {
+			var encodedSample []byte
+			encodedSample = protowire.AppendTag(encodedSample, 1, protowire.Fixed64Type)
+			encodedSample = protowire.AppendFixed64(encodedSample, math.Float64bits(sample.value))
+			encodedSample = protowire.AppendTag(encodedSample, 2, protowire.VarintType)
+			encodedSample = protowire.AppendVarint(encodedSample, uint64(sample.timestamp))
+
+			encodedSeries = protowire.AppendTag(encodedSeries, 2, protowire.BytesType)
+			encodedSeries = protowire.AppendBytes(encodedSeries, encodedSample)
+		}
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, encodedSeries)
+	}
+
+	return request
+}
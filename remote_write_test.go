@@ -0,0 +1,182 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func decodeRemoteWriteRequest(t *testing.T, request []byte) []*remoteWriteTimeSeries {
+	timeSeries := make([]*remoteWriteTimeSeries, 0)
+
+	for len(request) > 0 {
+		_, _, tagLength := protowire.ConsumeTag(request)
+		encodedSeries, length := protowire.ConsumeBytes(request[tagLength:])
+		require.GreaterOrEqual(t, length, 0)
+		request = request[tagLength+length:]
+
+		series := &remoteWriteTimeSeries{}
+
+		for len(encodedSeries) > 0 {
+			number, _, tagLength := protowire.ConsumeTag(encodedSeries)
+			message, length := protowire.ConsumeBytes(encodedSeries[tagLength:])
+			require.GreaterOrEqual(t, length, 0)
+			encodedSeries = encodedSeries[tagLength+length:]
+
+			if number == 1 {
+				_, _, nameTagLength := protowire.ConsumeTag(message)
+				name, nameLength := protowire.ConsumeString(message[nameTagLength:])
+				message = message[nameTagLength+nameLength:]
+				_, _, valueTagLength := protowire.ConsumeTag(message)
+				value, _ := protowire.ConsumeString(message[valueTagLength:])
+				series.labels = append(series.labels, remoteWriteLabel{name: name, value: value})
+				continue
+			}
+
+			_, _, valueTagLength := protowire.ConsumeTag(message)
+			value, valueLength := protowire.ConsumeFixed64(message[valueTagLength:])
+			message = message[valueTagLength+valueLength:]
+			_, _, timestampTagLength := protowire.ConsumeTag(message)
+			timestamp, _ := protowire.ConsumeVarint(message[timestampTagLength:])
+			series.samples = append(series.samples, remoteWriteSample{value: math.Float64frombits(value), timestamp: int64(timestamp)})
+		}
+
+		timeSeries = append(timeSeries, series)
+	}
+
+	return timeSeries
+}
+
+func createTestRemoteWriteMetric() *Metric {
+	return &Metric{
+		Name: "azure_monitor_microsoft_test_type1_metric1",
+		Fields: map[string]interface{}{
+			MetricFieldTimeStamp: "2022-02-22T22:59:00Z",
+			MetricFieldTotal:     5.0,
+			MetricFieldMaximum:   2.5,
+		},
+		Tags: map[string]string{
+			MetricTagResourceName: testResource1Name,
+			MetricTagUnit:         "Count",
+		},
+	}
+}
+
+func TestRemoteWriteSender_Write(t *testing.T) {
+	var timeSeries []*remoteWriteTimeSeries
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "Bearer token", request.Header.Get("Authorization"))
+		assert.Equal(t, "snappy", request.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", request.Header.Get("Content-Type"))
+		assert.Equal(t, "value", request.Header.Get("X-Custom"))
+
+		body, err := io.ReadAll(request.Body)
+		require.NoError(t, err)
+
+		decodedBody, err := snappy.Decode(nil, body)
+		require.NoError(t, err)
+
+		timeSeries = decodeRemoteWriteRequest(t, decodedBody)
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	remoteWriteSender, err := NewRemoteWriteSender(server.URL, WithRemoteWriteToken("token"), WithRemoteWriteHeader("X-Custom", "value"))
+	require.NoError(t, err)
+
+	err = remoteWriteSender.Write(context.Background(), []*Metric{createTestRemoteWriteMetric()})
+	require.NoError(t, err)
+
+	timeStamp := time.Date(2022, 2, 22, 22, 59, 0, 0, time.UTC).UnixMilli()
+	assert.Equal(t, []*remoteWriteTimeSeries{
+		{
+			labels: []remoteWriteLabel{
+				{name: metricLabelName, value: "azure_monitor_microsoft_test_type1_metric1_maximum"},
+				{name: MetricTagResourceName, value: testResource1Name},
+				{name: MetricTagUnit, value: "Count"},
+			},
+			samples: []remoteWriteSample{{value: 2.5, timestamp: timeStamp}},
+		},
+		{
+			labels: []remoteWriteLabel{
+				{name: metricLabelName, value: "azure_monitor_microsoft_test_type1_metric1_total"},
+				{name: MetricTagResourceName, value: testResource1Name},
+				{name: MetricTagUnit, value: "Count"},
+			},
+			samples: []remoteWriteSample{{value: 5.0, timestamp: timeStamp}},
+		},
+	}, timeSeries)
+}
+
+func TestCreateRemoteWriteLabels_EmptyTag(t *testing.T) {
+	labels := createRemoteWriteLabels(testMetric1, map[string]string{
+		MetricTagResourceGroup: "",
+		MetricTagResourceName:  testResource1Name,
+	})
+
+	require.Len(t, labels, 2)
+	assert.Equal(t, MetricTagResourceName, labels[1].name)
+}
+
+func TestRemoteWriteSender_RetryOnServerError(t *testing.T) {
+	var requestsNum int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(&requestsNum, 1) < 3 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	remoteWriteSender, err := NewRemoteWriteSender(server.URL, WithRemoteWriteRetries(3, time.Millisecond))
+	require.NoError(t, err)
+
+	err = remoteWriteSender.Write(context.Background(), []*Metric{createTestRemoteWriteMetric()})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requestsNum))
+}
+
+func TestRemoteWriteSender_NoRetryOnClientError(t *testing.T) {
+	var requestsNum int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&requestsNum, 1)
+		http.Error(writer, "bad token", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	remoteWriteSender, err := NewRemoteWriteSender(server.URL, WithRemoteWriteRetries(3, time.Millisecond))
+	require.NoError(t, err)
+
+	err = remoteWriteSender.Write(context.Background(), []*Metric{createTestRemoteWriteMetric()})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "bad token")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requestsNum))
+}
+
+func TestRemoteWriteSender_MissingTimeStamp(t *testing.T) {
+	remoteWriteSender, err := NewRemoteWriteSender("http://localhost")
+	require.NoError(t, err)
+
+	err = remoteWriteSender.Write(context.Background(), []*Metric{{Name: testMetric1, Fields: map[string]interface{}{MetricFieldTotal: 1.0}}})
+	require.Error(t, err)
+}
+
+func TestNewRemoteWriteSender_NoURL(t *testing.T) {
+	_, err := NewRemoteWriteSender("")
+	require.Error(t, err)
+}
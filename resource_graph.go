@@ -0,0 +1,186 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/tracing"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+const (
+	// resourceGraphPageSize is the max rows per Azure Resource Graph API page.
+	resourceGraphPageSize = 1000
+)
+
+// WithResourceGraphDiscovery lets you discover the resources of resource group and subscription targets with
+// Azure Resource Graph queries instead of listing the resources with Azure Resource Manager API.
+func WithResourceGraphDiscovery() ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.useResourceGraph = true
+	}
+}
+
+// CreateResourceTargetsFromResourceGraphTargetsWithContext creates resource targets from resource graph targets.
+func (ammr *AzureMonitorMetricsReceiver) CreateResourceTargetsFromResourceGraphTargetsWithContext(ctx context.Context) error {
+	for index, target := range ammr.Targets.resourceGraphTargets {
+		if err := ammr.createResourceTargetFromResourceGraphTarget(ctx, target); err != nil {
+			return fmt.Errorf("error creating resource targets from resource graph target #%d: %v", index+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (ammr *AzureMonitorMetricsReceiver) createResourceTargetFromResourceGraphTarget(ctx context.Context, target *ResourceGraphTarget) error {
+	subscriptionIDs := target.subscriptions
+	if len(subscriptionIDs) == 0 {
+		subscriptionIDs = []string{ammr.subscriptionID}
+	}
+
+	resources, err := ammr.listResourceGraphResources(ctx, target.query, subscriptionIDs)
+	if err != nil {
+		return err
+	}
+
+	if len(resources) == 0 {
+		return fmt.Errorf("could not find resources with query %s", target.query)
+	}
+
+	for _, resource := range resources {
+		newTarget := NewResourceTarget(*resource.ID, target.metrics, target.aggregations)
+		newTarget.Timeout = target.timeout
+		ammr.Targets.ResourceTargets = append(ammr.Targets.ResourceTargets, newTarget)
+		ammr.getLogger().DebugContext(ctx, "created resource target", "resource_id", *resource.ID)
+	}
+
+	ammr.getLogger().DebugContext(ctx, "created resource targets from resource graph target",
+		"query", target.query, "resource_targets", len(resources))
+	return nil
+}
+
+// listResourceGraphResources runs an Azure Resource Graph query on the subscriptions and returns the resources of
+// the query rows. Every row must have an id column. The type column is optional.
+func (ammr *AzureMonitorMetricsReceiver) listResourceGraphResources(ctx context.Context, query string, subscriptionIDs []string) ([]*armresources.GenericResourceExpanded, error) {
+	if ammr.AzureClients.ResourceGraphClient == nil {
+		return nil, fmt.Errorf("resource graph client is missing")
+	}
+
+	ctx, cancel := ammr.withRequestTimeout(ctx)
+	defer cancel()
+
+	ammr.getLogger().DebugContext(ctx, "querying resource graph", "subscription_ids", subscriptionIDs, "query", query)
+
+	ctx, span := ammr.startSpan(ctx, spanResourceGraphResources,
+		tracing.Attribute{Key: attributeSubscriptionID, Value: strings.Join(subscriptionIDs, ",")},
+		tracing.Attribute{Key: attributeQuery, Value: query})
+
+	resources, pagesNum, err := ammr.queryResourceGraph(ctx, query, subscriptionIDs)
+	if err == nil {
+		setResourcesListSpanAttributes(span, pagesNum, len(resources))
+	}
+	endSpan(span, err)
+
+	return resources, err
+}
+
+func (ammr *AzureMonitorMetricsReceiver) queryResourceGraph(ctx context.Context, query string, subscriptionIDs []string) ([]*armresources.GenericResourceExpanded, int, error) {
+	resources := make([]*armresources.GenericResourceExpanded, 0)
+	request := armresourcegraph.QueryRequest{
+		Query:         to.Ptr(query),
+		Subscriptions: to.SliceOfPtrs(subscriptionIDs...),
+		Options: &armresourcegraph.QueryRequestOptions{
+			ResultFormat: to.Ptr(armresourcegraph.ResultFormatObjectArray),
+			Top:          to.Ptr[int32](resourceGraphPageSize),
+		},
+	}
+
+	pagesNum := 0
+	for {
+		pagesNum++
+		pageCtx, span := startPageSpan(ctx, spanResourceGraphResourcesPage, pagesNum)
+
+		start := ammr.getCurrentTime()
+		response, err := ammr.AzureClients.ResourceGraphClient.Resources(pageCtx, request, nil)
+		ammr.observeAPICall(APIResourceGraph, "", start, err)
+		if err != nil {
+			endSpan(span, err)
+			return nil, pagesNum, err
+		}
+
+		pageResources, err := getResourceGraphResponseResources(&response)
+		if err == nil {
+			span.SetAttributes(tracing.Attribute{Key: attributeResourcesNum, Value: len(pageResources)})
+		}
+		endSpan(span, err)
+		if err != nil {
+			return nil, pagesNum, err
+		}
+
+		resources = append(resources, pageResources...)
+
+		if response.SkipToken == nil || *response.SkipToken == "" {
+			return resources, pagesNum, nil
+		}
+
+		options := *request.Options
+		options.SkipToken = response.SkipToken
+		request.Options = &options
+	}
+}
+
+// createResourceGraphQuery creates an Azure Resource Graph query of the resources of the resource types,
+// under the resource group if it is not empty.
+func createResourceGraphQuery(resourceGroup string, resources []*Resource) string {
+	resourceTypes := make([]string, 0, len(resources))
+	for _, resourceType := range getResourcesListedTypes(resources) {
+		resourceTypes = append(resourceTypes, quoteResourceGraphString(resourceType))
+	}
+
+	query := "Resources | where type in~ (" + strings.Join(resourceTypes, ", ") + ")"
+	if resourceGroup != "" {
+		query += " and resourceGroup =~ " + quoteResourceGraphString(resourceGroup)
+	}
+
+	return query + " | project id, type"
+}
+
+func quoteResourceGraphString(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+func getResourceGraphResponseResources(response *armresourcegraph.ClientResourcesResponse) ([]*armresources.GenericResourceExpanded, error) {
+	if response.Data == nil {
+		return []*armresources.GenericResourceExpanded{}, nil
+	}
+
+	rows, ok := response.Data.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("resource graph response is bad formatted: data is not an object array")
+	}
+
+	resources := make([]*armresources.GenericResourceExpanded, 0, len(rows))
+	for _, row := range rows {
+		columns, ok := row.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("resource graph response is bad formatted: row is not an object")
+		}
+
+		resourceID, ok := columns["id"].(string)
+		if !ok || resourceID == "" {
+			return nil, fmt.Errorf("resource graph response is bad formatted: row id is missing")
+		}
+
+		resource := &armresources.GenericResourceExpanded{ID: to.Ptr(resourceID)}
+		if resourceType, ok := columns["type"].(string); ok {
+			resource.Type = to.Ptr(resourceType)
+		}
+
+		resources = append(resources, resource)
+	}
+
+	return resources, nil
+}
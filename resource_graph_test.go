@@ -0,0 +1,212 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateResourceTargetsFromResourceGraphTargets(t *testing.T) {
+	resourceGraphClient := &mockResourceGraphClient{}
+	targets := NewTargets(nil, nil, nil)
+	resourceGraphTarget := NewResourceGraphTarget("Resources | project id", []string{testMetric1}, []string{"Total"})
+	targets.AddResourceGraphTargets(resourceGraphTarget)
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, targets, setMockAzureClientsWithResourceGraph(resourceGraphClient))
+	require.NoError(t, err)
+
+	err = ammr.CreateResourceTargetsFromResourceGraphTargetsWithContext(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, ammr.Targets.ResourceTargets, 3)
+	assert.Equal(t, testFullResourceGroup1ResourceType1Resource1, ammr.Targets.ResourceTargets[0].ResourceID)
+	assert.Equal(t, testFullResourceGroup1ResourceType2Resource2, ammr.Targets.ResourceTargets[1].ResourceID)
+	assert.Equal(t, testFullResourceGroup2ResourceType1Resource3, ammr.Targets.ResourceTargets[2].ResourceID)
+	assert.Equal(t, []string{testMetric1}, ammr.Targets.ResourceTargets[2].Metrics)
+	assert.Equal(t, []string{"Total"}, ammr.Targets.ResourceTargets[2].Aggregations)
+
+	// The rows are returned in three pages.
+	requests := resourceGraphClient.getRequests()
+	require.Len(t, requests, 3)
+	assert.Nil(t, requests[0].Options.SkipToken)
+	assert.Equal(t, "2", *requests[2].Options.SkipToken)
+	assert.Equal(t, "Resources | project id", *requests[0].Query)
+	assert.Equal(t, armresourcegraph.ResultFormatObjectArray, *requests[0].Options.ResultFormat)
+	require.Len(t, requests[0].Subscriptions, 1)
+	assert.Equal(t, testSubscriptionID, *requests[0].Subscriptions[0])
+}
+
+func TestCreateResourceTargetsFromResourceGraphTargets_Subscriptions(t *testing.T) {
+	resourceGraphClient := &mockResourceGraphClient{}
+	targets := NewTargets(nil, nil, nil)
+	resourceGraphTarget := NewResourceGraphTarget("Resources | project id", []string{}, []string{})
+	resourceGraphTarget.SetSubscriptions([]string{testSubscriptionID, testOtherSubscriptionID})
+	targets.AddResourceGraphTargets(resourceGraphTarget)
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, targets, setMockAzureClientsWithResourceGraph(resourceGraphClient))
+	require.NoError(t, err)
+
+	err = ammr.CreateResourceTargetsFromResourceGraphTargetsWithContext(context.Background())
+	require.NoError(t, err)
+
+	requests := resourceGraphClient.getRequests()
+	require.Len(t, requests[0].Subscriptions, 2)
+	assert.Equal(t, testOtherSubscriptionID, *requests[0].Subscriptions[1])
+}
+
+func TestCreateResourceTargetsFromResourceGraphTargets_NoResourceGraphClient(t *testing.T) {
+	targets := NewTargets(nil, nil, nil)
+	targets.AddResourceGraphTargets(NewResourceGraphTarget("Resources | project id", []string{}, []string{}))
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, targets, setMockAzureClients())
+	require.NoError(t, err)
+
+	err = ammr.CreateResourceTargetsFromResourceGraphTargetsWithContext(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "resource graph client is missing")
+}
+
+func TestInitializeTargets_ResourceGraphDiscovery(t *testing.T) {
+	resourceGraphClient := &mockResourceGraphClient{}
+	targets := NewTargets(nil, nil, []*Resource{NewResource(testResourceType1, []string{}, []string{})})
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, targets, setMockAzureClientsWithResourceGraph(resourceGraphClient),
+		WithResourceGraphDiscovery())
+	require.NoError(t, err)
+
+	err = ammr.CreateResourceTargetsFromSubscriptionTargetsWithContext(context.Background())
+	require.NoError(t, err)
+
+	// The resource types are matched case-insensitively.
+	require.Len(t, ammr.Targets.ResourceTargets, 2)
+	assert.Equal(t, testFullResourceGroup1ResourceType1Resource1, ammr.Targets.ResourceTargets[0].ResourceID)
+	assert.Equal(t, testFullResourceGroup2ResourceType1Resource3, ammr.Targets.ResourceTargets[1].ResourceID)
+
+	requests := resourceGraphClient.getRequests()
+	require.NotEmpty(t, requests)
+	assert.Equal(t, "Resources | where type in~ ('Microsoft.Test/type1') | project id, type", *requests[0].Query)
+}
+
+func TestInitializeTargets_ResourceGraphDiscoveryResourceGroup(t *testing.T) {
+	resourceGraphClient := &mockResourceGraphClient{}
+	targets := NewTargets(nil, []*ResourceGroupTarget{
+		NewResourceGroupTarget(testResourceGroup1, []*Resource{NewResource(testResourceType2, []string{}, []string{})}),
+	}, nil)
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, targets, setMockAzureClientsWithResourceGraph(resourceGraphClient),
+		WithResourceGraphDiscovery())
+	require.NoError(t, err)
+
+	err = ammr.CreateResourceTargetsFromResourceGroupTargetsWithContext(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, ammr.Targets.ResourceTargets, 1)
+	assert.Equal(t, testFullResourceGroup1ResourceType2Resource2, ammr.Targets.ResourceTargets[0].ResourceID)
+
+	requests := resourceGraphClient.getRequests()
+	require.NotEmpty(t, requests)
+	assert.Equal(t, "Resources | where type in~ ('Microsoft.Test/type2') and resourceGroup =~ 'resourceGroup1' | project id, type",
+		*requests[0].Query)
+}
+
+func TestReloadTargets_ResourceGraphTargets(t *testing.T) {
+	resourceGraphClient := &mockResourceGraphClient{}
+	newTargets := func() *Targets {
+		targets := NewTargets(nil, nil, nil)
+		targets.AddResourceGraphTargets(NewResourceGraphTarget("Resources | project id", []string{}, []string{}))
+		return targets
+	}
+
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID, newTargets(), setMockAzureClientsWithResourceGraph(resourceGraphClient))
+	require.NoError(t, err)
+	require.NoError(t, ammr.InitializeTargetsWithContext(context.Background()))
+	requestsNum := len(resourceGraphClient.getRequests())
+
+	diff, err := ammr.ReloadTargetsWithContext(context.Background(), newTargets())
+	require.NoError(t, err)
+	assert.Equal(t, &TargetsDiff{UnchangedTargetsNum: 1}, diff)
+	assert.Len(t, resourceGraphClient.getRequests(), requestsNum)
+	assert.Len(t, ammr.Targets.resourceGraphTargets, 1)
+}
+
+func TestCheckConfigValidation_ResourceGraphTargetWithNoQuery(t *testing.T) {
+	targets := NewTargets(nil, nil, nil)
+	targets.AddResourceGraphTargets(NewResourceGraphTarget(" ", []string{}, []string{}))
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets:        targets,
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	err := ammr.checkValidation()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "resource graph target #1 query is empty or missing")
+}
+
+func TestCheckConfigValidation_ResourceGraphTargetWithInvalidAggregation(t *testing.T) {
+	targets := NewTargets(nil, nil, nil)
+	targets.AddResourceGraphTargets(NewResourceGraphTarget("Resources | project id", []string{}, []string{testInvalidAggregation}))
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets:        targets,
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	err := ammr.checkValidation()
+	require.Error(t, err)
+}
+
+func TestCreateResourceGraphQuery_Quoting(t *testing.T) {
+	query := createResourceGraphQuery(`it's\rg`, []*Resource{
+		NewResource(testResourceType1, []string{}, []string{}),
+		NewResource(testResourceType2, []string{}, []string{}),
+	})
+
+	assert.Equal(t, `Resources | where type in~ ('Microsoft.Test/type1', 'Microsoft.Test/type2') and resourceGroup =~ 'it\'s\\rg' | project id, type`, query)
+}
+
+func TestGetResourceGraphResponseResources_BadFormatted(t *testing.T) {
+	for name, data := range map[string]interface{}{
+		"not an object array": map[string]interface{}{"id": testFullResourceGroup1ResourceType1Resource1},
+		"row not an object":   []interface{}{"row"},
+		"row without id":      []interface{}{map[string]interface{}{"type": testResourceType1}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			response := &armresourcegraph.ClientResourcesResponse{QueryResponse: armresourcegraph.QueryResponse{Data: data}}
+
+			_, err := getResourceGraphResponseResources(response)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "resource graph response is bad formatted")
+		})
+	}
+}
+
+func TestConfigCreateTargets_ResourceGraphTargets(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+resource_graph_targets:
+  - query: Resources | where tags.env == 'prod' | project id
+    subscription_ids: [`+testOtherSubscriptionID+`]
+    metrics: [`+testMetric1+`]
+    timeout: 30s
+collection:
+  resource_graph_discovery: true
+`), ConfigFormatYAML)
+	require.NoError(t, err)
+
+	targets := config.CreateTargets()
+	require.Len(t, targets.resourceGraphTargets, 1)
+	assert.Equal(t, "Resources | where tags.env == 'prod' | project id", targets.resourceGraphTargets[0].query)
+	assert.Equal(t, []string{testOtherSubscriptionID}, targets.resourceGraphTargets[0].subscriptions)
+	assert.Equal(t, []string{testMetric1}, targets.resourceGraphTargets[0].metrics)
+	assert.Equal(t, "30s", targets.resourceGraphTargets[0].timeout.String())
+
+	ammr := &AzureMonitorMetricsReceiver{}
+	for _, receiverOption := range config.createReceiverOptions() {
+		receiverOption(ammr)
+	}
+
+	assert.True(t, ammr.useResourceGraph)
+}
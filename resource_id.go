@@ -0,0 +1,255 @@
+package azuremonitormetricsreceiver
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	resourceIDSubscriptionsKey  = "subscriptions"
+	resourceIDResourceGroupsKey = "resourceGroups"
+	resourceIDProvidersKey      = "providers"
+)
+
+// ResourceID is a parsed Azure Resource Manager resource ID, such as
+// /subscriptions/xxx/resourceGroups/rg/providers/Microsoft.Sql/servers/server/databases/database.
+//
+// A relative resource ID starts with resourceGroups/... or providers/... and has no subscription ID.
+// An extension resource ID has nested providers, such as .../providers/Microsoft.Compute/virtualMachines/vm/providers/
+// Microsoft.Insights/diagnosticSettings/setting. Its provider, resource types and resource names are of the last
+// provider, and Scope returns the resource it extends.
+type ResourceID struct {
+	subscriptionID string
+	resourceGroup  string
+	provider       string
+	resourceTypes  []string
+	resourceNames  []string
+	scope          *ResourceID
+}
+
+// ParseResourceID parses a full or relative resource ID. The keys (subscriptions, resourceGroups and providers) are
+// case-insensitive, and the values keep their case.
+func ParseResourceID(resourceID string) (*ResourceID, error) {
+	trimmedResourceID := strings.Trim(strings.TrimSpace(resourceID), "/")
+	if trimmedResourceID == "" {
+		return nil, fmt.Errorf("resource ID is empty")
+	}
+
+	segments := strings.Split(trimmedResourceID, "/")
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("resource ID %s is bad formatted: empty segment", resourceID)
+		}
+	}
+
+	id := &ResourceID{}
+	index := 0
+
+	if strings.EqualFold(segments[index], resourceIDSubscriptionsKey) {
+		if index+1 >= len(segments) {
+			return nil, fmt.Errorf("resource ID %s is bad formatted: subscription ID is missing", resourceID)
+		}
+
+		id.subscriptionID = segments[index+1]
+		index += 2
+	}
+
+	if index < len(segments) && strings.EqualFold(segments[index], resourceIDResourceGroupsKey) {
+		if index+1 >= len(segments) {
+			return nil, fmt.Errorf("resource ID %s is bad formatted: resource group is missing", resourceID)
+		}
+
+		id.resourceGroup = segments[index+1]
+		index += 2
+	}
+
+	for index < len(segments) {
+		if !strings.EqualFold(segments[index], resourceIDProvidersKey) {
+			return nil, fmt.Errorf("resource ID %s is bad formatted: unexpected segment %s", resourceID, segments[index])
+		}
+
+		if index+1 >= len(segments) {
+			return nil, fmt.Errorf("resource ID %s is bad formatted: provider is missing", resourceID)
+		}
+
+		providerID := &ResourceID{
+			subscriptionID: id.subscriptionID,
+			resourceGroup:  id.resourceGroup,
+			provider:       segments[index+1],
+		}
+		index += 2
+
+		for index < len(segments) && !strings.EqualFold(segments[index], resourceIDProvidersKey) {
+			if index+1 >= len(segments) {
+				return nil, fmt.Errorf("resource ID %s is bad formatted: resource type %s name is missing", resourceID, segments[index])
+			}
+
+			providerID.resourceTypes = append(providerID.resourceTypes, segments[index])
+			providerID.resourceNames = append(providerID.resourceNames, segments[index+1])
+			index += 2
+		}
+
+		if len(providerID.resourceTypes) == 0 {
+			return nil, fmt.Errorf("resource ID %s is bad formatted: provider %s resource type is missing", resourceID, providerID.provider)
+		}
+
+		if id.provider != "" {
+			providerID.scope = id
+		}
+
+		id = providerID
+	}
+
+	return id, nil
+}
+
+// SubscriptionID returns the subscription ID, or an empty string if the resource ID is relative.
+func (id *ResourceID) SubscriptionID() string {
+	return id.subscriptionID
+}
+
+// ResourceGroup returns the resource group name, or an empty string if the resource is not under a resource group.
+func (id *ResourceID) ResourceGroup() string {
+	return id.resourceGroup
+}
+
+// Provider returns the resource provider namespace, such as Microsoft.Sql, or an empty string if the resource ID
+// is a subscription or a resource group.
+func (id *ResourceID) Provider() string {
+	return id.provider
+}
+
+// ResourceTypes returns the resource types without the provider, parent resources first, such as servers and databases.
+func (id *ResourceID) ResourceTypes() []string {
+	return copyStrings(id.resourceTypes)
+}
+
+// ResourceNames returns the resource names, parent resources first, such as the server name and the database name.
+func (id *ResourceID) ResourceNames() []string {
+	return copyStrings(id.resourceNames)
+}
+
+// ResourceType returns the full resource type, such as Microsoft.Sql/servers/databases, or an empty string if the
+// resource ID has no provider.
+func (id *ResourceID) ResourceType() string {
+	if id.provider == "" {
+		return ""
+	}
+
+	return id.provider + "/" + strings.Join(id.resourceTypes, "/")
+}
+
+// Name returns the resource name, or an empty string if the resource ID has no provider.
+func (id *ResourceID) Name() string {
+	if len(id.resourceNames) == 0 {
+		return ""
+	}
+
+	return id.resourceNames[len(id.resourceNames)-1]
+}
+
+// ParentNames returns the names of the parent resources of a child resource, such as the server name of a database,
+// or nil if the resource is not a child resource.
+func (id *ResourceID) ParentNames() []string {
+	if len(id.resourceNames) < 2 {
+		return nil
+	}
+
+	return copyStrings(id.resourceNames[:len(id.resourceNames)-1])
+}
+
+// relativeName returns the resource name relative to its top level resource, such as server for a server
+// and server/databases/database for a database of the server.
+func (id *ResourceID) relativeName() string {
+	if len(id.resourceNames) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	builder.WriteString(id.resourceNames[0])
+	for index := 1; index < len(id.resourceNames); index++ {
+		builder.WriteString("/" + id.resourceTypes[index] + "/" + id.resourceNames[index])
+	}
+
+	return builder.String()
+}
+
+// Scope returns the resource that an extension resource extends, or nil if the resource is not an extension resource.
+func (id *ResourceID) Scope() *ResourceID {
+	return id.scope
+}
+
+// IsRelative returns whether the resource ID has no subscription ID.
+func (id *ResourceID) IsRelative() bool {
+	return id.subscriptionID == ""
+}
+
+// String formats the resource ID. Relative resource IDs are formatted without a leading slash.
+func (id *ResourceID) String() string {
+	var builder strings.Builder
+
+	if id.scope != nil {
+		builder.WriteString(id.scope.String())
+	} else {
+		if id.subscriptionID != "" {
+			builder.WriteString("/" + resourceIDSubscriptionsKey + "/" + id.subscriptionID)
+		}
+
+		if id.resourceGroup != "" {
+			builder.WriteString("/" + resourceIDResourceGroupsKey + "/" + id.resourceGroup)
+		}
+	}
+
+	if id.provider != "" {
+		builder.WriteString("/" + resourceIDProvidersKey + "/" + id.provider)
+		for index, resourceType := range id.resourceTypes {
+			builder.WriteString("/" + resourceType + "/" + id.resourceNames[index])
+		}
+	}
+
+	if id.IsRelative() {
+		return strings.TrimPrefix(builder.String(), "/")
+	}
+
+	return builder.String()
+}
+
+// withSubscriptionID returns a copy of a relative resource ID under the subscription.
+// Full resource IDs are returned as is.
+func (id *ResourceID) withSubscriptionID(subscriptionID string) *ResourceID {
+	if !id.IsRelative() {
+		return id
+	}
+
+	newID := *id
+	newID.subscriptionID = subscriptionID
+	if id.scope != nil {
+		newID.scope = id.scope.withSubscriptionID(subscriptionID)
+	}
+
+	return &newID
+}
+
+// resolveResourceTargetsResourceID makes the resource IDs of the resource targets full resource IDs under the receiver
+// subscription. The resource IDs must be valid.
+func (ammr *AzureMonitorMetricsReceiver) resolveResourceTargetsResourceID() {
+	for _, target := range ammr.Targets.ResourceTargets {
+		resourceID, err := ParseResourceID(target.ResourceID)
+		if err != nil {
+			continue
+		}
+
+		target.ResourceID = resourceID.withSubscriptionID(ammr.subscriptionID).String()
+	}
+}
+
+// getResourceIDResourceType returns the resource type of a resource ID, such as Microsoft.Compute/virtualMachines
+// or Microsoft.Sql/servers/databases, or an empty string if the resource ID has no provider or is bad formatted.
+func getResourceIDResourceType(resourceID string) string {
+	id, err := ParseResourceID(resourceID)
+	if err != nil {
+		return ""
+	}
+
+	return id.ResourceType()
+}
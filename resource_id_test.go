@@ -0,0 +1,173 @@
+package azuremonitormetricsreceiver
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResourceID_FullResourceID(t *testing.T) {
+	resourceID, err := ParseResourceID(testFullResourceGroup1ResourceType1Resource1)
+	require.NoError(t, err)
+
+	assert.Equal(t, testSubscriptionID, resourceID.SubscriptionID())
+	assert.Equal(t, testResourceGroup1, resourceID.ResourceGroup())
+	assert.Equal(t, "Microsoft.Test", resourceID.Provider())
+	assert.Equal(t, []string{"type1"}, resourceID.ResourceTypes())
+	assert.Equal(t, []string{testResource1Name}, resourceID.ResourceNames())
+	assert.Equal(t, testResourceType1, resourceID.ResourceType())
+	assert.Equal(t, testResource1Name, resourceID.Name())
+	assert.Nil(t, resourceID.ParentNames())
+	assert.Nil(t, resourceID.Scope())
+	assert.False(t, resourceID.IsRelative())
+	assert.Equal(t, testFullResourceGroup1ResourceType1Resource1, resourceID.String())
+}
+
+func TestParseResourceID_RelativeResourceID(t *testing.T) {
+	resourceID, err := ParseResourceID(testResourceGroup1ResourceType1Resource1)
+	require.NoError(t, err)
+
+	assert.True(t, resourceID.IsRelative())
+	assert.Equal(t, testResourceGroup1, resourceID.ResourceGroup())
+	assert.Equal(t, testResourceGroup1ResourceType1Resource1, resourceID.String())
+	assert.Equal(t, testFullResourceGroup1ResourceType1Resource1, resourceID.withSubscriptionID(testSubscriptionID).String())
+}
+
+func TestParseResourceID_MixedCase(t *testing.T) {
+	resourceID, err := ParseResourceID("/SUBSCRIPTIONS/" + testSubscriptionID + "/resourcegroups/RG/Providers/Microsoft.Sql/servers/Server/")
+	require.NoError(t, err)
+
+	assert.Equal(t, testSubscriptionID, resourceID.SubscriptionID())
+	assert.Equal(t, "RG", resourceID.ResourceGroup())
+	assert.Equal(t, "Server", resourceID.Name())
+	assert.Equal(t, "/subscriptions/"+testSubscriptionID+"/resourceGroups/RG/providers/Microsoft.Sql/servers/Server", resourceID.String())
+}
+
+func TestParseResourceID_ChildResource(t *testing.T) {
+	resourceID, err := ParseResourceID(testSQLServerID + "/databases/database")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"servers", "databases"}, resourceID.ResourceTypes())
+	assert.Equal(t, "Microsoft.Sql/servers/databases", resourceID.ResourceType())
+	assert.Equal(t, "database", resourceID.Name())
+	assert.Equal(t, []string{"server"}, resourceID.ParentNames())
+}
+
+func TestParseResourceID_NestedProviders(t *testing.T) {
+	extensionResourceID := testSQLServerID + "/providers/Microsoft.Insights/diagnosticSettings/setting"
+	resourceID, err := ParseResourceID(extensionResourceID)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Microsoft.Insights/diagnosticSettings", resourceID.ResourceType())
+	assert.Equal(t, "setting", resourceID.Name())
+	assert.Equal(t, testResourceGroup1, resourceID.ResourceGroup())
+	require.NotNil(t, resourceID.Scope())
+	assert.Equal(t, testSQLServerID, resourceID.Scope().String())
+	assert.Equal(t, extensionResourceID, resourceID.String())
+}
+
+func TestParseResourceID_SubscriptionResource(t *testing.T) {
+	resourceID, err := ParseResourceID("/subscriptions/" + testSubscriptionID + "/providers/Microsoft.Test/type1/" + testResource1Name)
+	require.NoError(t, err)
+
+	assert.Equal(t, "", resourceID.ResourceGroup())
+	assert.Equal(t, testResourceType1, resourceID.ResourceType())
+}
+
+func TestParseResourceID_ResourceGroup(t *testing.T) {
+	resourceID, err := ParseResourceID("/subscriptions/" + testSubscriptionID + "/resourceGroups/" + testResourceGroup1)
+	require.NoError(t, err)
+
+	assert.Equal(t, "", resourceID.Provider())
+	assert.Equal(t, "", resourceID.ResourceType())
+	assert.Equal(t, "", resourceID.Name())
+}
+
+func TestParseResourceID_BadFormatted(t *testing.T) {
+	for name, resourceID := range map[string]string{
+		"empty":                  " ",
+		"empty segment":          "/subscriptions//resourceGroups/rg",
+		"no subscription ID":     "/subscriptions",
+		"no resource group":      "/subscriptions/s/resourceGroups",
+		"unexpected segment":     "/subscriptions/s/resourceGroups/rg/Microsoft.Test/type1/resource1",
+		"no provider":            "/subscriptions/s/resourceGroups/rg/providers",
+		"no resource type":       "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Test",
+		"no resource name":       "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Test/type1",
+		"no child resource name": "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Test/type1/resource1/child",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseResourceID(resourceID)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestCheckConfigValidation_ResourceTargetWithBadFormattedResourceID(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets:        NewTargets([]*ResourceTarget{NewResourceTarget("resourceGroups/rg/Microsoft.Test/type1", []string{}, []string{})}, nil, nil),
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	err := ammr.checkValidation()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "resource target #1 resource ID is bad formatted")
+}
+
+func TestCheckConfigValidation_ResourceTargetWithResourceGroupResourceID(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets:        NewTargets([]*ResourceTarget{NewResourceTarget("resourceGroups/rg", []string{}, []string{})}, nil, nil),
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	err := ammr.checkValidation()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "resource target #1 resource ID resourceGroups/rg is not a resource")
+}
+
+func TestGetMetricTags_BadFormattedMetricID(t *testing.T) {
+	for name, metricID := range map[string]string{
+		"no metrics provider": testFullResourceGroup1ResourceType1Resource1,
+		"relative":            testResourceGroup1ResourceType1Resource1 + "/providers/Microsoft.Insights/metrics/" + testMetric1,
+		"no resource":         "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Insights/metrics/" + testMetric1,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := getMetricResourceID(&armmonitor.Metric{ID: to.Ptr(metricID)})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "metric ID is bad formatted")
+		})
+	}
+}
+
+func TestGetMetricTags_SubscriptionResource(t *testing.T) {
+	metric := &armmonitor.Metric{
+		ID:   to.Ptr("/subscriptions/" + testSubscriptionID + "/providers/Microsoft.Test/type1/" + testResource1Name + "/providers/Microsoft.Insights/metrics/" + testMetric1),
+		Unit: to.Ptr(armmonitor.UnitCount),
+	}
+	response := &armmonitor.MetricsClientListResponse{Response: armmonitor.Response{
+		Namespace:      to.Ptr(testResourceType1),
+		Resourceregion: to.Ptr(testResourceRegion),
+	}}
+
+	metricTags, err := getMetricTags(metric, response)
+	require.NoError(t, err)
+
+	// The resource group tag is set for every resource, so all the metrics have the same tags.
+	resourceGroup, found := metricTags[MetricTagResourceGroup]
+	assert.True(t, found)
+	assert.Equal(t, "", resourceGroup)
+	assert.Equal(t, testResource1Name, metricTags[MetricTagResourceName])
+}
+
+func TestGetMetricTags_MetricNameWithSlash(t *testing.T) {
+	resourceID, err := getMetricResourceID(&armmonitor.Metric{
+		ID: to.Ptr(testFullResourceGroup1ResourceType1Resource1 + "/providers/Microsoft.Insights/metrics/Disk Read Bytes/sec"),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, testFullResourceGroup1ResourceType1Resource1, resourceID.String())
+}
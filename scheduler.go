@@ -0,0 +1,266 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Scheduler collects the receiver resource targets metrics into a sink, polling each group of resource targets
+// at its metrics min time grain, so hourly metrics are not polled every minute.
+type Scheduler struct {
+	receiver       *AzureMonitorMetricsReceiver
+	sink           Sink
+	ingestionDelay time.Duration
+	errorHandler   func(error)
+	now            func() time.Time
+	after          func(time.Duration) <-chan time.Time
+}
+
+// SchedulerOptions lets you set optional scheduler parameters.
+type SchedulerOptions func(*Scheduler)
+
+// NewScheduler lets you create a new scheduler that collects the receiver metrics into the sink.
+func NewScheduler(receiver *AzureMonitorMetricsReceiver, sink Sink, schedulerOptions ...SchedulerOptions) *Scheduler {
+	scheduler := &Scheduler{
+		receiver:     receiver,
+		sink:         sink,
+		errorHandler: func(error) {},
+		now:          time.Now,
+		after:        time.After,
+	}
+
+	for _, schedulerOption := range schedulerOptions {
+		schedulerOption(scheduler)
+	}
+
+	return scheduler
+}
+
+// WithSchedulerIngestionDelay lets you delay each collection after its time grain boundary,
+// giving Azure Monitor time to ingest the latest bucket.
+func WithSchedulerIngestionDelay(ingestionDelay time.Duration) SchedulerOptions {
+	return func(scheduler *Scheduler) {
+		scheduler.ingestionDelay = ingestionDelay
+	}
+}
+
+// WithSchedulerErrorHandler lets you handle collection errors. The scheduler keeps running after errors.
+func WithSchedulerErrorHandler(errorHandler func(error)) SchedulerOptions {
+	return func(scheduler *Scheduler) {
+		if errorHandler != nil {
+			scheduler.errorHandler = errorHandler
+		}
+	}
+}
+
+// Run collects metrics until the context is done. Every time grain group is collected once when Run starts,
+// and then on every time grain boundary plus the ingestion delay. The expired resource targets of management group
+// targets are refreshed before every collection.
+// If the resource targets cannot be grouped by time grain, or there are no resource targets, the error is handled
+// by the error handler and the scheduler tries again on the next boundary of the smallest time grain.
+func (s *Scheduler) Run(ctx context.Context) error {
+	nextRunTimes := make(map[time.Duration]time.Time)
+
+	for {
+		if err := s.receiver.RefreshTargetsWithContext(ctx); err != nil {
+			s.errorHandler(err)
+		}
+
+		timeGrainsTargets, err := s.receiver.groupResourceTargetsByTimeGrain(ctx)
+		if err != nil {
+			err = fmt.Errorf("error grouping resource targets by time grain: %v", err)
+		} else if len(timeGrainsTargets) == 0 {
+			err = fmt.Errorf("no resource targets to collect metrics from")
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			s.errorHandler(err)
+
+			if !s.wait(ctx, s.getNextRunTime(getMinTimeGrain(nextRunTimes), s.now())) {
+				return nil
+			}
+
+			continue
+		}
+
+		now := s.now()
+		timeGrains := make([]time.Duration, 0, len(timeGrainsTargets))
+		for timeGrain := range timeGrainsTargets {
+			timeGrains = append(timeGrains, timeGrain)
+			if _, found := nextRunTimes[timeGrain]; !found {
+				nextRunTimes[timeGrain] = now
+			}
+		}
+
+		for timeGrain := range nextRunTimes {
+			if _, found := timeGrainsTargets[timeGrain]; !found {
+				delete(nextRunTimes, timeGrain)
+			}
+		}
+
+		sort.Slice(timeGrains, func(i, j int) bool {
+			return timeGrains[i] < timeGrains[j]
+		})
+
+		nextRunTime := nextRunTimes[timeGrains[0]]
+		for _, timeGrain := range timeGrains {
+			if nextRunTimes[timeGrain].Before(nextRunTime) {
+				nextRunTime = nextRunTimes[timeGrain]
+			}
+		}
+
+		if !s.wait(ctx, nextRunTime) {
+			return nil
+		}
+
+		now = s.now()
+		for _, timeGrain := range timeGrains {
+			if nextRunTimes[timeGrain].After(now) {
+				continue
+			}
+
+			s.collect(ctx, timeGrainsTargets[timeGrain])
+			nextRunTimes[timeGrain] = s.getNextRunTime(timeGrain, now)
+		}
+	}
+}
+
+func (s *Scheduler) collect(ctx context.Context, targets []*ResourceTarget) {
+	notCollectedMetrics := make([]string, 0)
+
+	for _, target := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if err := s.receiver.collectResourceTargetMetricsToSink(ctx, target, s.sink, &notCollectedMetrics); err != nil {
+			s.errorHandler(err)
+		}
+	}
+
+	if s.receiver.isCheckpointEnabled() {
+		// The watermarks of the collected resource targets are saved even if the context is done.
+		if err := s.receiver.SaveCheckpointWithContext(context.WithoutCancel(ctx)); err != nil {
+			s.errorHandler(err)
+		}
+	}
+}
+
+// wait waits until the run time. It returns false if the context is done.
+func (s *Scheduler) wait(ctx context.Context, runTime time.Time) bool {
+	if wait := runTime.Sub(s.now()); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-s.after(wait):
+		}
+	}
+
+	return ctx.Err() == nil
+}
+
+// getMinTimeGrain returns the smallest time grain of the next run times, or the smallest Azure Monitor time grain
+// if there are no next run times yet.
+func getMinTimeGrain(nextRunTimes map[time.Duration]time.Time) time.Duration {
+	minTimeGrain := time.Duration(0)
+	for timeGrain := range nextRunTimes {
+		if minTimeGrain == 0 || timeGrain < minTimeGrain {
+			minTimeGrain = timeGrain
+		}
+	}
+
+	if minTimeGrain == 0 {
+		return time.Minute
+	}
+
+	return minTimeGrain
+}
+
+// getNextRunTime returns the first time grain boundary plus ingestion delay that is after now.
+func (s *Scheduler) getNextRunTime(timeGrain time.Duration, now time.Time) time.Time {
+	nextRunTime := now.Add(-s.ingestionDelay).Truncate(timeGrain).Add(s.ingestionDelay)
+	for !nextRunTime.After(now) {
+		nextRunTime = nextRunTime.Add(timeGrain)
+	}
+
+	return nextRunTime
+}
+
+func (ammr *AzureMonitorMetricsReceiver) groupResourceTargetsByTimeGrain(ctx context.Context) (map[time.Duration][]*ResourceTarget, error) {
+	ammr.targetsMutex.RLock()
+	targets := append([]*ResourceTarget{}, ammr.Targets.ResourceTargets...)
+	ammr.targetsMutex.RUnlock()
+
+	timeGrainsTargets := make(map[time.Duration][]*ResourceTarget)
+
+	for _, target := range targets {
+		timeGrain, err := ammr.getResourceTargetTimeGrain(ctx, target)
+		if err != nil {
+			return nil, fmt.Errorf("error getting resource target %s time grain: %v", target.ResourceID, err)
+		}
+
+		timeGrainsTargets[timeGrain] = append(timeGrainsTargets[timeGrain], target)
+	}
+
+	return timeGrainsTargets, nil
+}
+
+// getResourceTargetTimeGrain returns the resource target time grain, or the smallest min time grain of
+// the resource target metrics if the resource target has no time grain.
+func (ammr *AzureMonitorMetricsReceiver) getResourceTargetTimeGrain(ctx context.Context, target *ResourceTarget) (time.Duration, error) {
+	if target.TimeGrain != "" {
+		return parseISO8601Duration(target.TimeGrain)
+	}
+
+	response, err := ammr.getMetricDefinitionsResponse(ctx, target)
+	if err != nil {
+		return 0, err
+	}
+
+	var targetTimeGrain time.Duration
+
+	for _, metricDefinition := range response.Value {
+		metricNameValue, err := getMetricDefinitionsClientMetricNameValue(metricDefinition)
+		if err != nil {
+			return 0, err
+		}
+
+		if !isMetricInResourceTargetMetrics(*metricNameValue, target.Metrics) {
+			continue
+		}
+
+		availability, err := getMetricDefinitionsMetricAvailability(metricDefinition, ammr.preferredTimeGrain)
+		if err != nil {
+			return 0, err
+		}
+
+		if targetTimeGrain == 0 || availability.timeGrainDuration < targetTimeGrain {
+			targetTimeGrain = availability.timeGrainDuration
+		}
+	}
+
+	if targetTimeGrain == 0 {
+		return 0, fmt.Errorf("resource target metrics have no metric definitions")
+	}
+
+	return targetTimeGrain, nil
+}
+
+func isMetricInResourceTargetMetrics(metric string, targetMetrics []string) bool {
+	changedCommaMetric := strings.Replace(metric, ",", "%2", -1)
+
+	for _, targetMetric := range targetMetrics {
+		if targetMetric == metric || targetMetric == changedCommaMetric {
+			return true
+		}
+	}
+
+	return false
+}
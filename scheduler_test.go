@@ -0,0 +1,186 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	mutex   sync.Mutex
+	current time.Time
+	end     time.Time
+	cancel  context.CancelFunc
+}
+
+func (fc *fakeClock) now() time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.current
+}
+
+func (fc *fakeClock) after(duration time.Duration) <-chan time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.current = fc.current.Add(duration)
+	if fc.current.After(fc.end) {
+		fc.cancel()
+	}
+
+	channel := make(chan time.Time, 1)
+	channel <- fc.current
+	return channel
+}
+
+func TestSchedulerRun_CollectsEveryTimeGrain(t *testing.T) {
+	azureClients := setMockAzureClients()
+	metricsClient := newCountingMetricsClient()
+	azureClients.MetricsClient = metricsClient
+
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1, testMetric2}, []string{string(armmonitor.AggregationTypeEnumTotal)}),
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric3}, []string{string(armmonitor.AggregationTypeEnumTotal)}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:   azureClients,
+		subscriptionID: testSubscriptionID,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := &fakeClock{
+		current: time.Date(2022, 2, 22, 22, 0, 30, 0, time.UTC),
+		end:     time.Date(2022, 2, 22, 22, 10, 20, 0, time.UTC),
+		cancel:  cancel,
+	}
+
+	sink := &mockSink{}
+	scheduler := NewScheduler(ammr, sink, WithSchedulerIngestionDelay(10*time.Second))
+	scheduler.now = clock.now
+	scheduler.after = clock.after
+
+	err := scheduler.Run(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, 11, metricsClient.getMetricsCalls(testMetric1+","+testMetric2))
+	assert.Equal(t, 3, metricsClient.getMetricsCalls(testMetric3))
+	assert.Len(t, sink.getBatches(), 14)
+}
+
+func TestSchedulerRun_RetriesAfterErrors(t *testing.T) {
+	azureClients := setMockAzureClients()
+	metricDefinitionsClient := newCountingMetricDefinitionsClient()
+	metricDefinitionsClient.failingCallsNum = 1
+	azureClients.MetricDefinitionsClient = metricDefinitionsClient
+	metricsClient := newCountingMetricsClient()
+	azureClients.MetricsClient = metricsClient
+
+	for name, targets := range map[string][]*ResourceTarget{
+		"metric definitions error": {
+			NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1, testMetric2}, []string{string(armmonitor.AggregationTypeEnumTotal)}),
+		},
+		"no resource targets": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ammr := &AzureMonitorMetricsReceiver{
+				Targets:        NewTargets(targets, []*ResourceGroupTarget{}, []*Resource{}),
+				AzureClients:   azureClients,
+				subscriptionID: testSubscriptionID,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			clock := &fakeClock{
+				current: time.Date(2022, 2, 22, 22, 0, 30, 0, time.UTC),
+				end:     time.Date(2022, 2, 22, 22, 3, 20, 0, time.UTC),
+				cancel:  cancel,
+			}
+
+			errs := make([]error, 0)
+			scheduler := NewScheduler(ammr, &mockSink{}, WithSchedulerErrorHandler(func(err error) {
+				errs = append(errs, err)
+			}))
+			scheduler.now = clock.now
+			scheduler.after = clock.after
+
+			// The scheduler keeps running after errors, and returns only when the context is done.
+			err := scheduler.Run(ctx)
+			require.NoError(t, err)
+			require.NotEmpty(t, errs)
+
+			if len(targets) == 0 {
+				assert.Len(t, errs, 4)
+				assert.Contains(t, errs[0].Error(), "no resource targets to collect metrics from")
+				return
+			}
+
+			// The first try fails, and the metrics are collected on the next minutes.
+			require.Len(t, errs, 1)
+			assert.Contains(t, errs[0].Error(), "too many requests")
+			assert.Equal(t, 3, metricsClient.getMetricsCalls(testMetric1+","+testMetric2))
+		})
+	}
+}
+
+func TestSchedulerGetNextRunTime(t *testing.T) {
+	scheduler := NewScheduler(&AzureMonitorMetricsReceiver{}, &mockSink{}, WithSchedulerIngestionDelay(2*time.Minute))
+	now := time.Date(2022, 2, 22, 22, 1, 30, 0, time.UTC)
+
+	assert.Equal(t, time.Date(2022, 2, 22, 22, 2, 0, 0, time.UTC), scheduler.getNextRunTime(time.Minute, now))
+	assert.Equal(t, time.Date(2022, 2, 22, 22, 2, 0, 0, time.UTC), scheduler.getNextRunTime(5*time.Minute, now))
+	assert.Equal(t, time.Date(2022, 2, 22, 22, 2, 0, 0, time.UTC), scheduler.getNextRunTime(time.Hour, now))
+	assert.Equal(t, time.Date(2022, 2, 22, 23, 2, 0, 0, time.UTC), scheduler.getNextRunTime(time.Hour, now.Add(time.Minute)))
+}
+
+func TestGroupResourceTargetsByTimeGrain_Success(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1, testMetric2}, []string{}),
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric3}, []string{}),
+				NewResourceTarget(testFullResourceGroup1ResourceType2Resource2, []string{testMetric1}, []string{}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	timeGrainsTargets, err := ammr.groupResourceTargetsByTimeGrain(context.Background())
+	require.NoError(t, err)
+
+	assert.Len(t, timeGrainsTargets, 2)
+	assert.Len(t, timeGrainsTargets[time.Minute], 2)
+	assert.Len(t, timeGrainsTargets[5*time.Minute], 1)
+}
+
+func TestGroupResourceTargetsByTimeGrain_UnknownMetric(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testInvalidMetric}, []string{}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	_, err := ammr.groupResourceTargetsByTimeGrain(context.Background())
+	require.Error(t, err)
+}
@@ -0,0 +1,415 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultSinkBatchSize is the default max metrics per batch written to the sink.
+	DefaultSinkBatchSize = 1000
+	// DefaultSinkFlushInterval is the default max time metrics wait in a batch before it is written to the sink.
+	DefaultSinkFlushInterval = 10 * time.Second
+	// DefaultSinkQueueSize is the default max metrics waiting to be batched.
+	DefaultSinkQueueSize = 10000
+)
+
+// BackpressurePolicy describes what happens when metrics are written to a full batching sink queue.
+type BackpressurePolicy int
+
+const (
+	// BackpressurePolicyBlock blocks the writer until there is room in the queue or the context is done.
+	BackpressurePolicyBlock BackpressurePolicy = iota
+	// BackpressurePolicyDrop drops the metrics that do not fit in the queue.
+	BackpressurePolicyDrop
+)
+
+// Sink is a destination that collected metrics are streamed into.
+type Sink interface {
+	Write(context.Context, []*Metric) error
+}
+
+// FlushingSink is a sink that writes metrics asynchronously, and can also write metrics and wait until they are
+// written. If a checkpoint store or a correction window is set, the receiver writes to flushing sinks with
+// WriteAndFlush, so the watermarks advance only after the metrics are written.
+// Sinks that are not flushing sinks must return from Write only after the metrics are written.
+type FlushingSink interface {
+	Sink
+	WriteAndFlush(context.Context, []*Metric) error
+}
+
+// BatchingSink is a sink that queues metrics and writes them to another sink in batches.
+type BatchingSink struct {
+	sink               Sink
+	batchSize          int
+	flushInterval      time.Duration
+	queueSize          int
+	backpressurePolicy BackpressurePolicy
+	errorHandler       func(error)
+
+	queue        chan *batchingSinkItem
+	droppedNum   uint64
+	mutex        sync.Mutex
+	isClosed     bool
+	writers      sync.WaitGroup
+	closeChannel chan struct{}
+	doneChannel  chan struct{}
+	// ctx is the context of the writes to the sink. It is canceled when closing the batching sink times out.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// batchingSinkItem is a queued metric, or a flush request if the metric is nil.
+type batchingSinkItem struct {
+	metric *Metric
+	// delivery is set for the metrics and the flush request of WriteAndFlush.
+	delivery *batchingSinkDelivery
+}
+
+// batchingSinkDelivery is the result of writing the metrics of WriteAndFlush to the sink.
+type batchingSinkDelivery struct {
+	// err is the first error writing the metrics. It can be read once done is closed.
+	err  error
+	done chan struct{}
+}
+
+// BatchingSinkOptions lets you set optional batching sink parameters.
+type BatchingSinkOptions func(*BatchingSink)
+
+// NewBatchingSink lets you create a new batching sink that writes to the given sink.
+func NewBatchingSink(sink Sink, batchingSinkOptions ...BatchingSinkOptions) *BatchingSink {
+	batchingSink := &BatchingSink{
+		sink:               sink,
+		batchSize:          DefaultSinkBatchSize,
+		flushInterval:      DefaultSinkFlushInterval,
+		queueSize:          DefaultSinkQueueSize,
+		backpressurePolicy: BackpressurePolicyBlock,
+		errorHandler:       func(error) {},
+		closeChannel:       make(chan struct{}),
+		doneChannel:        make(chan struct{}),
+	}
+	batchingSink.ctx, batchingSink.cancel = context.WithCancel(context.Background())
+
+	for _, batchingSinkOption := range batchingSinkOptions {
+		batchingSinkOption(batchingSink)
+	}
+
+	batchingSink.queue = make(chan *batchingSinkItem, batchingSink.queueSize)
+	go batchingSink.run()
+
+	return batchingSink
+}
+
+// WithSinkBatchSize lets you set the max metrics per batch.
+func WithSinkBatchSize(batchSize int) BatchingSinkOptions {
+	return func(batchingSink *BatchingSink) {
+		if batchSize > 0 {
+			batchingSink.batchSize = batchSize
+		}
+	}
+}
+
+// WithSinkFlushInterval lets you set the max time metrics wait in a batch before it is written.
+func WithSinkFlushInterval(flushInterval time.Duration) BatchingSinkOptions {
+	return func(batchingSink *BatchingSink) {
+		if flushInterval > 0 {
+			batchingSink.flushInterval = flushInterval
+		}
+	}
+}
+
+// WithSinkQueueSize lets you set the max metrics waiting to be batched.
+func WithSinkQueueSize(queueSize int) BatchingSinkOptions {
+	return func(batchingSink *BatchingSink) {
+		if queueSize > 0 {
+			batchingSink.queueSize = queueSize
+		}
+	}
+}
+
+// WithSinkBackpressurePolicy lets you set what happens when the queue is full.
+func WithSinkBackpressurePolicy(backpressurePolicy BackpressurePolicy) BatchingSinkOptions {
+	return func(batchingSink *BatchingSink) {
+		batchingSink.backpressurePolicy = backpressurePolicy
+	}
+}
+
+// WithSinkErrorHandler lets you handle errors of batches that could not be written.
+func WithSinkErrorHandler(errorHandler func(error)) BatchingSinkOptions {
+	return func(batchingSink *BatchingSink) {
+		if errorHandler != nil {
+			batchingSink.errorHandler = errorHandler
+		}
+	}
+}
+
+// Write queues metrics to be written in batches.
+// With the block backpressure policy, it waits for room in the queue until the context is done or the batching sink
+// is closed.
+func (bs *BatchingSink) Write(ctx context.Context, metrics []*Metric) error {
+	return bs.queueMetrics(ctx, metrics, nil)
+}
+
+// WriteAndFlush queues metrics, writes the current batch with them and waits until they are written to the sink.
+// It returns an error if any of the metrics was dropped or could not be written, even with the drop backpressure
+// policy.
+func (bs *BatchingSink) WriteAndFlush(ctx context.Context, metrics []*Metric) error {
+	delivery := &batchingSinkDelivery{done: make(chan struct{})}
+	if err := bs.queueMetrics(ctx, metrics, delivery); err != nil {
+		return err
+	}
+
+	select {
+	case <-delivery.done:
+		return delivery.err
+	case <-ctx.Done():
+		return fmt.Errorf("error waiting for metrics to be written: %w", ctx.Err())
+	}
+}
+
+// queueMetrics queues the metrics, followed by a flush request if the delivery is set.
+func (bs *BatchingSink) queueMetrics(ctx context.Context, metrics []*Metric, delivery *batchingSinkDelivery) error {
+	bs.mutex.Lock()
+	if bs.isClosed {
+		bs.mutex.Unlock()
+		return fmt.Errorf("batching sink is closed")
+	}
+
+	bs.writers.Add(1)
+	bs.mutex.Unlock()
+	defer bs.writers.Done()
+
+	for index, metric := range metrics {
+		item := &batchingSinkItem{metric: metric, delivery: delivery}
+
+		if bs.backpressurePolicy == BackpressurePolicyDrop {
+			select {
+			case bs.queue <- item:
+			default:
+				if delivery != nil {
+					atomic.AddUint64(&bs.droppedNum, uint64(len(metrics)-index))
+					return fmt.Errorf("batching sink queue is full: %d metrics were dropped", len(metrics)-index)
+				}
+
+				atomic.AddUint64(&bs.droppedNum, 1)
+			}
+
+			continue
+		}
+
+		if err := bs.queueItem(ctx, item); err != nil {
+			atomic.AddUint64(&bs.droppedNum, uint64(len(metrics)-index))
+			return err
+		}
+	}
+
+	if delivery != nil {
+		return bs.queueItem(ctx, &batchingSinkItem{delivery: delivery})
+	}
+
+	return nil
+}
+
+// queueItem waits for room in the queue until the context is done or the batching sink is closed.
+func (bs *BatchingSink) queueItem(ctx context.Context, item *batchingSinkItem) error {
+	select {
+	case bs.queue <- item:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error queueing metrics: %w", ctx.Err())
+	case <-bs.closeChannel:
+		return fmt.Errorf("batching sink is closed")
+	}
+}
+
+// Close writes all queued metrics and stops the batching sink.
+// It waits until the queued metrics are written, use CloseWithContext to limit the wait.
+func (bs *BatchingSink) Close() {
+	_ = bs.CloseWithContext(context.Background())
+}
+
+// CloseWithContext writes all queued metrics and stops the batching sink. Writes that wait for room in the queue
+// fail. If the context is done before the queued metrics are written, the write to the sink is canceled, the rest
+// of the queued metrics are dropped and the context error is returned.
+func (bs *BatchingSink) CloseWithContext(ctx context.Context) error {
+	bs.mutex.Lock()
+	if !bs.isClosed {
+		bs.isClosed = true
+		close(bs.closeChannel)
+		bs.mutex.Unlock()
+
+		// No writer sends to the queue once the writers are done, so the queue can be closed.
+		bs.writers.Wait()
+		close(bs.queue)
+	} else {
+		bs.mutex.Unlock()
+	}
+
+	select {
+	case <-bs.doneChannel:
+		return nil
+	case <-ctx.Done():
+		bs.cancel()
+		return fmt.Errorf("error closing batching sink: %w", ctx.Err())
+	}
+}
+
+// DroppedMetricsNum returns the number of metrics that were dropped because the queue was full.
+func (bs *BatchingSink) DroppedMetricsNum() uint64 {
+	return atomic.LoadUint64(&bs.droppedNum)
+}
+
+func (bs *BatchingSink) run() {
+	defer close(bs.doneChannel)
+	defer bs.cancel()
+
+	ticker := time.NewTicker(bs.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*batchingSinkItem, 0, bs.batchSize)
+
+	for {
+		select {
+		case item, ok := <-bs.queue:
+			if !ok {
+				bs.flush(batch)
+				return
+			}
+
+			if item.metric == nil {
+				// The metrics of the flush request were queued before it, so they are in this batch or were written.
+				bs.flush(batch)
+				batch = make([]*batchingSinkItem, 0, bs.batchSize)
+				close(item.delivery.done)
+				continue
+			}
+
+			batch = append(batch, item)
+			if len(batch) >= bs.batchSize {
+				bs.flush(batch)
+				batch = make([]*batchingSinkItem, 0, bs.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				bs.flush(batch)
+				batch = make([]*batchingSinkItem, 0, bs.batchSize)
+			}
+		}
+	}
+}
+
+func (bs *BatchingSink) flush(batch []*batchingSinkItem) {
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := bs.ctx.Err(); err != nil {
+		atomic.AddUint64(&bs.droppedNum, uint64(len(batch)))
+		setBatchingSinkDeliveriesError(batch, fmt.Errorf("batching sink is closed: %w", err))
+		return
+	}
+
+	metrics := make([]*Metric, 0, len(batch))
+	for _, item := range batch {
+		metrics = append(metrics, item.metric)
+	}
+
+	if err := bs.sink.Write(bs.ctx, metrics); err != nil {
+		err = fmt.Errorf("error writing batch of %d metrics: %w", len(metrics), err)
+		setBatchingSinkDeliveriesError(batch, err)
+		bs.errorHandler(err)
+	}
+}
+
+func setBatchingSinkDeliveriesError(batch []*batchingSinkItem, err error) {
+	for _, item := range batch {
+		if item.delivery != nil && item.delivery.err == nil {
+			item.delivery.err = err
+		}
+	}
+}
+
+// writeMetricsToSink writes the metrics to the sink. If the collection update of the metrics is committed after the
+// write, and the sink is a flushing sink, it waits until the metrics are written.
+func (ammr *AzureMonitorMetricsReceiver) writeMetricsToSink(ctx context.Context, sink Sink, metrics []*Metric) error {
+	if flushingSink, ok := sink.(FlushingSink); ok && (ammr.isCheckpointEnabled() || ammr.correctionWindow > 0) {
+		return flushingSink.WriteAndFlush(ctx, metrics)
+	}
+
+	return sink.Write(ctx, metrics)
+}
+
+// CollectResourceTargetsMetricsToSink collects metrics of all resource targets and writes them to the sink
+// as each resource target completes. It returns the metrics that were not collected.
+// If a checkpoint store is set, the watermarks advance only after the metrics are written, and are saved
+// to the checkpoint store at the end. Flushing sinks, such as BatchingSink, are flushed to confirm the metrics
+// are written, see FlushingSink.
+//
+// Deprecated: Use CollectResourceTargetsMetricsToSinkWithContext instead.
+func (ammr *AzureMonitorMetricsReceiver) CollectResourceTargetsMetricsToSink(sink Sink) ([]string, error) {
+	return ammr.CollectResourceTargetsMetricsToSinkWithContext(ammr.getContext(), sink)
+}
+
+// CollectResourceTargetsMetricsToSinkWithContext collects metrics of all resource targets and writes them to the sink
+// as each resource target completes. It returns the metrics that were not collected.
+// It stops when the context is done, and every Azure Monitor API call is limited by its resource target timeout.
+// If a checkpoint store is set, the watermarks advance only after the metrics are written, and are saved
+// to the checkpoint store at the end. Flushing sinks, such as BatchingSink, are flushed to confirm the metrics
+// are written, see FlushingSink.
+func (ammr *AzureMonitorMetricsReceiver) CollectResourceTargetsMetricsToSinkWithContext(ctx context.Context, sink Sink) ([]string, error) {
+	ammr.targetsMutex.RLock()
+	defer ammr.targetsMutex.RUnlock()
+
+	notCollectedMetrics, err := ammr.collectResourceTargetsMetricsToSink(ctx, ammr.Targets.ResourceTargets, sink)
+
+	if ammr.isCheckpointEnabled() {
+		if saveErr := ammr.SaveCheckpointWithContext(ctx); saveErr != nil && err == nil {
+			return nil, saveErr
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return notCollectedMetrics, nil
+}
+
+func (ammr *AzureMonitorMetricsReceiver) collectResourceTargetsMetricsToSink(ctx context.Context, targets []*ResourceTarget, sink Sink) ([]string, error) {
+	notCollectedMetrics := make([]string, 0)
+
+	for _, target := range targets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := ammr.collectResourceTargetMetricsToSink(ctx, target, sink, &notCollectedMetrics); err != nil {
+			return nil, err
+		}
+	}
+
+	return notCollectedMetrics, nil
+}
+
+func (ammr *AzureMonitorMetricsReceiver) collectResourceTargetMetricsToSink(ctx context.Context, target *ResourceTarget, sink Sink, notCollectedMetrics *[]string) error {
+	metrics, targetNotCollectedMetrics, update, err := ammr.collectResourceTargetMetrics(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	*notCollectedMetrics = append(*notCollectedMetrics, targetNotCollectedMetrics...)
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	if err = ammr.writeMetricsToSink(ctx, sink, metrics); err != nil {
+		return fmt.Errorf("error writing resource target %s metrics to sink: %v", target.ResourceID, err)
+	}
+
+	ammr.commitCollectionUpdate(update)
+	return nil
+}
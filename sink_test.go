@@ -0,0 +1,203 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestMetrics(metricsNum int) []*Metric {
+	metrics := make([]*Metric, 0, metricsNum)
+
+	for index := 0; index < metricsNum; index++ {
+		metrics = append(metrics, &Metric{Name: testMetric1})
+	}
+
+	return metrics
+}
+
+func TestBatchingSink_BatchSize(t *testing.T) {
+	sink := &mockSink{}
+	batchingSink := NewBatchingSink(sink, WithSinkBatchSize(2), WithSinkFlushInterval(time.Hour))
+
+	require.NoError(t, batchingSink.Write(context.Background(), createTestMetrics(5)))
+	batchingSink.Close()
+
+	batches := sink.getBatches()
+	require.Len(t, batches, 3)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 2)
+	assert.Len(t, batches[2], 1)
+}
+
+func TestBatchingSink_FlushInterval(t *testing.T) {
+	sink := &mockSink{}
+	batchingSink := NewBatchingSink(sink, WithSinkBatchSize(100), WithSinkFlushInterval(10*time.Millisecond))
+	defer batchingSink.Close()
+
+	require.NoError(t, batchingSink.Write(context.Background(), createTestMetrics(3)))
+
+	assert.Eventually(t, func() bool {
+		batches := sink.getBatches()
+		return len(batches) == 1 && len(batches[0]) == 3
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestBatchingSink_DropPolicy(t *testing.T) {
+	sink := &mockSink{block: make(chan struct{})}
+	batchingSink := NewBatchingSink(sink,
+		WithSinkBatchSize(1),
+		WithSinkQueueSize(2),
+		WithSinkFlushInterval(time.Hour),
+		WithSinkBackpressurePolicy(BackpressurePolicyDrop))
+
+	require.NoError(t, batchingSink.Write(context.Background(), createTestMetrics(10)))
+	assert.Greater(t, batchingSink.DroppedMetricsNum(), uint64(0))
+
+	close(sink.block)
+	batchingSink.Close()
+
+	metricsNum := 0
+	for _, batch := range sink.getBatches() {
+		metricsNum += len(batch)
+	}
+
+	assert.Equal(t, uint64(10), uint64(metricsNum)+batchingSink.DroppedMetricsNum())
+}
+
+func TestBatchingSink_BlockPolicyContextDone(t *testing.T) {
+	sink := &mockSink{block: make(chan struct{})}
+	batchingSink := NewBatchingSink(sink, WithSinkBatchSize(1), WithSinkQueueSize(1), WithSinkFlushInterval(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := batchingSink.Write(ctx, createTestMetrics(10))
+	require.Error(t, err)
+
+	close(sink.block)
+	batchingSink.Close()
+}
+
+func TestBatchingSink_WriteAfterClose(t *testing.T) {
+	batchingSink := NewBatchingSink(&mockSink{})
+	batchingSink.Close()
+
+	err := batchingSink.Write(context.Background(), createTestMetrics(1))
+	require.Error(t, err)
+}
+
+func TestBatchingSink_CloseWhileWriteBlocked(t *testing.T) {
+	sink := &mockSink{blockUntilDone: true}
+	batchingSink := NewBatchingSink(sink, WithSinkBatchSize(1), WithSinkQueueSize(1), WithSinkFlushInterval(time.Hour))
+
+	writeErrChannel := make(chan error, 1)
+	go func() {
+		writeErrChannel <- batchingSink.Write(context.Background(), createTestMetrics(10))
+	}()
+
+	// The write blocks, since the sink blocks the batch and the queue is full.
+	assert.Never(t, func() bool { return len(writeErrChannel) > 0 }, 50*time.Millisecond, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := batchingSink.CloseWithContext(ctx)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	select {
+	case err = <-writeErrChannel:
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "batching sink is closed")
+	case <-time.After(time.Second):
+		t.Fatal("write is still blocked after close")
+	}
+
+	// The write to the sink is canceled, so the batching sink stops.
+	done := make(chan struct{})
+	go func() {
+		batchingSink.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("batching sink did not stop after close timed out")
+	}
+}
+
+func TestBatchingSink_WriteAndFlush(t *testing.T) {
+	sink := &mockSink{}
+	batchingSink := NewBatchingSink(sink, WithSinkBatchSize(100), WithSinkFlushInterval(time.Hour))
+	defer batchingSink.Close()
+
+	require.NoError(t, batchingSink.Write(context.Background(), createTestMetrics(2)))
+	require.NoError(t, batchingSink.WriteAndFlush(context.Background(), createTestMetrics(3)))
+
+	// The metrics are written when WriteAndFlush returns, with the metrics queued before them.
+	batches := sink.getBatches()
+	require.Len(t, batches, 1)
+	assert.Len(t, batches[0], 5)
+}
+
+func TestBatchingSink_WriteAndFlushSinkError(t *testing.T) {
+	var handledErr error
+	batchingSink := NewBatchingSink(&mockSink{err: fmt.Errorf("sink error")}, WithSinkFlushInterval(time.Hour),
+		WithSinkErrorHandler(func(err error) { handledErr = err }))
+	defer batchingSink.Close()
+
+	err := batchingSink.WriteAndFlush(context.Background(), createTestMetrics(3))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sink error")
+	assert.Equal(t, err, handledErr)
+}
+
+func TestBatchingSink_WriteAndFlushDropPolicy(t *testing.T) {
+	sink := &mockSink{block: make(chan struct{})}
+	batchingSink := NewBatchingSink(sink,
+		WithSinkBatchSize(1),
+		WithSinkQueueSize(1),
+		WithSinkFlushInterval(time.Hour),
+		WithSinkBackpressurePolicy(BackpressurePolicyDrop))
+
+	err := batchingSink.WriteAndFlush(context.Background(), createTestMetrics(10))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "metrics were dropped")
+
+	close(sink.block)
+	batchingSink.Close()
+}
+
+func TestCollectResourceTargetsMetricsToSink_Success(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1, testMetric2}, []string{string(armmonitor.AggregationTypeEnumTotal), string(armmonitor.AggregationTypeEnumMaximum)}),
+				NewResourceTarget(testFullResourceGroup2ResourceType2Resource4, []string{testMetric1}, []string{string(armmonitor.AggregationTypeEnumTotal)}),
+				NewResourceTarget(testFullResourceGroup1ResourceType2Resource2, []string{testMetric1}, []string{string(armmonitor.AggregationTypeEnumTotal)}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	sink := &mockSink{}
+	notCollectedMetrics, err := ammr.CollectResourceTargetsMetricsToSink(sink)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{testFullResourceGroup2ResourceType2Resource4 + "/providers/Microsoft.Insights/metrics/metric1"}, notCollectedMetrics)
+
+	batches := sink.getBatches()
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 1)
+}
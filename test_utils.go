@@ -2,9 +2,17 @@ package azuremonitormetricsreceiver
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/managementgroups/armmanagementgroups"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 )
 
@@ -14,6 +22,79 @@ type mockAzureMetricDefinitionsClient struct{}
 
 type mockAzureMetricsClient struct{}
 
+type countingMetricDefinitionsClient struct {
+	mutex         sync.Mutex
+	client        MetricDefinitionsClient
+	resourceCalls map[string]int
+	// failingCallsNum is the number of first calls that fail, like throttled calls.
+	failingCallsNum int
+}
+
+type countingMetricsClient struct {
+	mutex            sync.Mutex
+	client           MetricsClient
+	metricsCalls     map[string]int
+	metricsIntervals map[string]string
+	metricsTimespans map[string]string
+}
+
+// revisingMetricsClient revises the total of the given metric value index of every metric after the first call.
+type revisingMetricsClient struct {
+	mutex            sync.Mutex
+	client           MetricsClient
+	calls            int
+	metricValueIndex int
+	revisedTotal     float64
+}
+
+// hangingMetricsClient never responds. It returns the context error when the context is done.
+type hangingMetricsClient struct {
+	mutex     sync.Mutex
+	calls     int
+	deadlines []time.Time
+}
+
+// failingMetricsClient returns the given error on every call.
+type failingMetricsClient struct {
+	err error
+}
+
+type mockResourceGraphClient struct {
+	mutex    sync.Mutex
+	requests []armresourcegraph.QueryRequest
+}
+
+type mockManagementGroupsClient struct {
+	mutex           sync.Mutex
+	subscriptionIDs []string
+}
+
+type mockSubscriptionResourcesClient struct {
+	subscriptionID string
+}
+
+type mockAnyResourceMetricDefinitionsClient struct{}
+
+type mockStaticMetricDefinitionsClient struct {
+	metricDefinitions map[string][]*armmonitor.MetricDefinition
+	errs              map[string]error
+}
+
+type mockSink struct {
+	mutex   sync.Mutex
+	batches [][]*Metric
+	block   chan struct{}
+	err     error
+	// blockUntilDone blocks every write until its context is done.
+	blockUntilDone bool
+}
+
+type mockCheckpointStore struct {
+	mutex      sync.Mutex
+	watermarks map[string]time.Time
+	saveCalls  int
+}
+
 const (
 	testSubscriptionID = "subscriptionID"
 	testClientID       = "clientID"
@@ -33,6 +114,7 @@ const (
 	testResource4Name = "resource4"
 	testResource5Name = "resource5"
 	testResource6Name = "resource6"
+	testResource7Name = "resource7"
 
 	testResourceGroup1ResourceType1Resource1     = "resourceGroups/" + testResourceGroup1 + "/providers/" + testResourceType1 + "/" + testResource1Name
 	testResourceGroup1ResourceType2Resource2     = "resourceGroups/" + testResourceGroup1 + "/providers/" + testResourceType2 + "/" + testResource2Name
@@ -40,18 +122,22 @@ const (
 	testResourceGroup2ResourceType2Resource4     = "resourceGroups/" + testResourceGroup2 + "/providers/" + testResourceType2 + "/" + testResource4Name
 	testResourceGroup2ResourceType2Resource5     = "resourceGroups/" + testResourceGroup2 + "/providers/" + testResourceType2 + "/" + testResource5Name
 	testResourceGroup2ResourceType2Resource6     = "resourceGroups/" + testResourceGroup2 + "/providers/" + testResourceType2 + "/" + testResource6Name
+	testResourceGroup1ResourceType1Resource7     = "resourceGroups/" + testResourceGroup1 + "/providers/" + testResourceType1 + "/" + testResource7Name
 	testFullResourceGroup1ResourceType1Resource1 = "/subscriptions/" + testSubscriptionID + "/" + testResourceGroup1ResourceType1Resource1
 	testFullResourceGroup1ResourceType2Resource2 = "/subscriptions/" + testSubscriptionID + "/" + testResourceGroup1ResourceType2Resource2
 	testFullResourceGroup2ResourceType1Resource3 = "/subscriptions/" + testSubscriptionID + "/" + testResourceGroup2ResourceType1Resource3
 	testFullResourceGroup2ResourceType2Resource4 = "/subscriptions/" + testSubscriptionID + "/" + testResourceGroup2ResourceType2Resource4
 	testFullResourceGroup2ResourceType2Resource5 = "/subscriptions/" + testSubscriptionID + "/" + testResourceGroup2ResourceType2Resource5
 	testFullResourceGroup2ResourceType2Resource6 = "/subscriptions/" + testSubscriptionID + "/" + testResourceGroup2ResourceType2Resource6
+	testFullResourceGroup1ResourceType1Resource7 = "/subscriptions/" + testSubscriptionID + "/" + testResourceGroup1ResourceType1Resource7
 
 	testMetric1             = "metric1"
 	testMetric2             = "metric2"
 	testMetric3             = "metric3"
 	testMetric3WithComma    = ",metric3,"
 	testMetric3ChangedComma = "%2metric3%2"
+	testMetric4             = "CpuPercentage"
+	testMetric4DisplayName  = "Percentage CPU"
 	testInvalidMetric       = "invalid"
 	testInvalidAggregation  = "Invalid"
 
@@ -67,6 +153,275 @@ func setMockAzureClients() *AzureClients {
 	}
 }
 
+func setMockAzureClientsWithResourceGraph(resourceGraphClient ResourceGraphClient) *AzureClients {
+	azureClients := setMockAzureClients()
+	azureClients.ResourceGraphClient = resourceGraphClient
+	return azureClients
+}
+
+func setMockAzureClientsWithManagementGroups(managementGroupsClient ManagementGroupsClient) *AzureClients {
+	azureClients := setMockAzureClients()
+	azureClients.MetricDefinitionsClient = &mockAnyResourceMetricDefinitionsClient{}
+	azureClients.ManagementGroupsClient = managementGroupsClient
+	azureClients.NewResourcesClient = func(subscriptionID string) (ResourcesClient, error) {
+		return &mockSubscriptionResourcesClient{subscriptionID: subscriptionID}, nil
+	}
+
+	return azureClients
+}
+
+// newTestReceiver creates a receiver of a resource target of testMetric1 and testMetric2 with the mock Azure clients.
+// The receiver options are applied in order, after the receiver is created.
+func newTestReceiver(receiverOptions ...ReceiverOptions) *AzureMonitorMetricsReceiver {
+	ammr := &AzureMonitorMetricsReceiver{
+		Targets: NewTargets(
+			[]*ResourceTarget{
+				NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1, testMetric2}, []string{}),
+			},
+			[]*ResourceGroupTarget{},
+			[]*Resource{},
+		),
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+
+	for _, receiverOption := range receiverOptions {
+		receiverOption(ammr)
+	}
+
+	return ammr
+}
+
+func withTestResourceTargets(resourceTargets ...*ResourceTarget) ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.Targets.ResourceTargets = resourceTargets
+	}
+}
+
+func withTestMetricsClient(metricsClient MetricsClient) ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.AzureClients.MetricsClient = metricsClient
+	}
+}
+
+func withTestNow(now time.Time) ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.now = func() time.Time {
+			return now
+		}
+	}
+}
+
+func newCountingMetricDefinitionsClient() *countingMetricDefinitionsClient {
+	return &countingMetricDefinitionsClient{
+		client:        &mockAzureMetricDefinitionsClient{},
+		resourceCalls: make(map[string]int),
+	}
+}
+
+func (cmdc *countingMetricDefinitionsClient) List(
+	ctx context.Context,
+	resourceID string,
+	options *armmonitor.MetricDefinitionsClientListOptions) (armmonitor.MetricDefinitionsClientListResponse, error) {
+	cmdc.mutex.Lock()
+	cmdc.resourceCalls[resourceID]++
+	isFailing := cmdc.failingCallsNum > 0
+	if isFailing {
+		cmdc.failingCallsNum--
+	}
+	cmdc.mutex.Unlock()
+
+	if isFailing {
+		return armmonitor.MetricDefinitionsClientListResponse{}, fmt.Errorf("too many requests")
+	}
+
+	return cmdc.client.List(ctx, resourceID, options)
+}
+
+func (cmdc *countingMetricDefinitionsClient) getResourceCalls(resourceID string) int {
+	cmdc.mutex.Lock()
+	defer cmdc.mutex.Unlock()
+
+	return cmdc.resourceCalls[resourceID]
+}
+
+func newCountingMetricsClient() *countingMetricsClient {
+	return &countingMetricsClient{
+		client:           &mockAzureMetricsClient{},
+		metricsCalls:     make(map[string]int),
+		metricsIntervals: make(map[string]string),
+		metricsTimespans: make(map[string]string),
+	}
+}
+
+func (cmc *countingMetricsClient) List(
+	ctx context.Context,
+	resourceID string,
+	options *armmonitor.MetricsClientListOptions) (armmonitor.MetricsClientListResponse, error) {
+	cmc.mutex.Lock()
+	if options != nil && options.Metricnames != nil {
+		cmc.metricsCalls[*options.Metricnames]++
+
+		if options.Interval != nil {
+			cmc.metricsIntervals[*options.Metricnames] = *options.Interval
+		}
+
+		if options.Timespan != nil {
+			cmc.metricsTimespans[*options.Metricnames] = *options.Timespan
+		}
+	}
+	cmc.mutex.Unlock()
+
+	return cmc.client.List(ctx, resourceID, options)
+}
+
+func (cmc *countingMetricsClient) getMetricsCalls(metricNames string) int {
+	cmc.mutex.Lock()
+	defer cmc.mutex.Unlock()
+
+	return cmc.metricsCalls[metricNames]
+}
+
+func (cmc *countingMetricsClient) getMetricsInterval(metricNames string) string {
+	cmc.mutex.Lock()
+	defer cmc.mutex.Unlock()
+
+	return cmc.metricsIntervals[metricNames]
+}
+
+func (cmc *countingMetricsClient) getMetricsTimespan(metricNames string) string {
+	cmc.mutex.Lock()
+	defer cmc.mutex.Unlock()
+
+	return cmc.metricsTimespans[metricNames]
+}
+
+func newRevisingMetricsClient(metricValueIndex int, revisedTotal float64) *revisingMetricsClient {
+	return &revisingMetricsClient{
+		client:           &mockAzureMetricsClient{},
+		metricValueIndex: metricValueIndex,
+		revisedTotal:     revisedTotal,
+	}
+}
+
+func (rmc *revisingMetricsClient) List(
+	ctx context.Context,
+	resourceID string,
+	options *armmonitor.MetricsClientListOptions) (armmonitor.MetricsClientListResponse, error) {
+	rmc.mutex.Lock()
+	rmc.calls++
+	isRevised := rmc.calls > 1
+	rmc.mutex.Unlock()
+
+	response, err := rmc.client.List(ctx, resourceID, options)
+	if err != nil || !isRevised {
+		return response, err
+	}
+
+	for _, metric := range response.Value {
+		revisedTotal := rmc.revisedTotal
+		metric.Timeseries[0].Data[rmc.metricValueIndex].Total = &revisedTotal
+	}
+
+	return response, nil
+}
+
+func (hmc *hangingMetricsClient) List(
+	ctx context.Context,
+	_ string,
+	_ *armmonitor.MetricsClientListOptions) (armmonitor.MetricsClientListResponse, error) {
+	hmc.mutex.Lock()
+	hmc.calls++
+	if deadline, found := ctx.Deadline(); found {
+		hmc.deadlines = append(hmc.deadlines, deadline)
+	}
+	hmc.mutex.Unlock()
+
+	<-ctx.Done()
+	return armmonitor.MetricsClientListResponse{}, ctx.Err()
+}
+
+func (hmc *hangingMetricsClient) getCalls() int {
+	hmc.mutex.Lock()
+	defer hmc.mutex.Unlock()
+
+	return hmc.calls
+}
+
+func (hmc *hangingMetricsClient) getDeadlines() []time.Time {
+	hmc.mutex.Lock()
+	defer hmc.mutex.Unlock()
+
+	return append([]time.Time{}, hmc.deadlines...)
+}
+
+func (fmc *failingMetricsClient) List(
+	_ context.Context,
+	_ string,
+	_ *armmonitor.MetricsClientListOptions) (armmonitor.MetricsClientListResponse, error) {
+	return armmonitor.MetricsClientListResponse{}, fmc.err
+}
+
+func (ms *mockSink) Write(ctx context.Context, metrics []*Metric) error {
+	if ms.block != nil {
+		<-ms.block
+	}
+
+	if ms.blockUntilDone {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if ms.err != nil {
+		return ms.err
+	}
+
+	ms.batches = append(ms.batches, metrics)
+	return nil
+}
+
+func (ms *mockSink) getBatches() [][]*Metric {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	return append([][]*Metric{}, ms.batches...)
+}
+
+func newMockCheckpointStore(watermarks map[string]time.Time) *mockCheckpointStore {
+	return &mockCheckpointStore{
+		watermarks: copyWatermarks(watermarks),
+	}
+}
+
+func (mcs *mockCheckpointStore) Load(_ context.Context) (map[string]time.Time, error) {
+	mcs.mutex.Lock()
+	defer mcs.mutex.Unlock()
+
+	return copyWatermarks(mcs.watermarks), nil
+}
+
+func (mcs *mockCheckpointStore) Save(_ context.Context, watermarks map[string]time.Time) error {
+	mcs.mutex.Lock()
+	defer mcs.mutex.Unlock()
+
+	mcs.saveCalls++
+	for key, watermark := range watermarks {
+		mcs.watermarks[key] = watermark
+	}
+
+	return nil
+}
+
+func (mcs *mockCheckpointStore) getWatermark(key string) time.Time {
+	mcs.mutex.Lock()
+	defer mcs.mutex.Unlock()
+
+	return mcs.watermarks[key]
+}
+
 func (marc *mockAzureResourcesClient) List(_ context.Context, _ *armresources.ClientListOptions) ([]*armresources.ClientListResponse, error) {
 	responses := make([]*armresources.ClientListResponse, 0)
 	resourceIDS := make([]string, 0)
@@ -151,6 +506,162 @@ func (marc *mockAzureResourcesClient) ListByResourceGroup(
 	return nil, nil
 }
 
+// Resources returns a page with a single row of every resource, and a skip token of the next page.
+// Resource Graph returns the resource types in lower case.
+func (mrgc *mockResourceGraphClient) Resources(
+	_ context.Context,
+	query armresourcegraph.QueryRequest,
+	_ *armresourcegraph.ClientResourcesOptions) (armresourcegraph.ClientResourcesResponse, error) {
+	mrgc.mutex.Lock()
+	mrgc.requests = append(mrgc.requests, query)
+	mrgc.mutex.Unlock()
+
+	rows := []interface{}{
+		map[string]interface{}{"id": testFullResourceGroup1ResourceType1Resource1, "type": strings.ToLower(testResourceType1)},
+		map[string]interface{}{"id": testFullResourceGroup1ResourceType2Resource2, "type": strings.ToLower(testResourceType2)},
+		map[string]interface{}{"id": testFullResourceGroup2ResourceType1Resource3, "type": strings.ToLower(testResourceType1)},
+	}
+
+	page := 0
+	if query.Options != nil && query.Options.SkipToken != nil {
+		page, _ = strconv.Atoi(*query.Options.SkipToken)
+	}
+
+	response := armresourcegraph.ClientResourcesResponse{
+		QueryResponse: armresourcegraph.QueryResponse{Data: []interface{}{rows[page]}},
+	}
+
+	if page+1 < len(rows) {
+		skipToken := strconv.Itoa(page + 1)
+		response.SkipToken = &skipToken
+	}
+
+	return response, nil
+}
+
+func (mrgc *mockResourceGraphClient) getRequests() []armresourcegraph.QueryRequest {
+	mrgc.mutex.Lock()
+	defer mrgc.mutex.Unlock()
+
+	return append([]armresourcegraph.QueryRequest{}, mrgc.requests...)
+}
+
+// GetDescendants returns a child management group and the subscriptions, in two pages.
+func (mmgc *mockManagementGroupsClient) GetDescendants(
+	_ context.Context,
+	groupID string,
+	_ *armmanagementgroups.ClientGetDescendantsOptions) ([]*armmanagementgroups.ClientGetDescendantsResponse, error) {
+	mmgc.mutex.Lock()
+	defer mmgc.mutex.Unlock()
+
+	managementGroupType := "Microsoft.Management/managementGroups"
+	childManagementGroup := groupID + "-child"
+	responses := []*armmanagementgroups.ClientGetDescendantsResponse{{
+		DescendantListResult: armmanagementgroups.DescendantListResult{
+			Value: []*armmanagementgroups.DescendantInfo{{Name: &childManagementGroup, Type: &managementGroupType}},
+		},
+	}}
+
+	subscriptionsResponse := &armmanagementgroups.ClientGetDescendantsResponse{}
+	for index := range mmgc.subscriptionIDs {
+		subscriptionType := "/subscriptions"
+		subscriptionsResponse.Value = append(subscriptionsResponse.Value,
+			&armmanagementgroups.DescendantInfo{Name: &mmgc.subscriptionIDs[index], Type: &subscriptionType})
+	}
+
+	return append(responses, subscriptionsResponse), nil
+}
+
+func (mmgc *mockManagementGroupsClient) addSubscription(subscriptionID string) {
+	mmgc.mutex.Lock()
+	defer mmgc.mutex.Unlock()
+
+	mmgc.subscriptionIDs = append(mmgc.subscriptionIDs, subscriptionID)
+}
+
+func (mmgc *mockManagementGroupsClient) removeSubscription(subscriptionID string) {
+	mmgc.mutex.Lock()
+	defer mmgc.mutex.Unlock()
+
+	subscriptionIDs := make([]string, 0, len(mmgc.subscriptionIDs))
+	for _, id := range mmgc.subscriptionIDs {
+		if id != subscriptionID {
+			subscriptionIDs = append(subscriptionIDs, id)
+		}
+	}
+
+	mmgc.subscriptionIDs = subscriptionIDs
+}
+
+func getMetricDefinitionsCacheResourceIDs(cache *metricDefinitionsCache) []string {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	resourceIDs := make([]string, 0, len(cache.responses))
+	for resourceID := range cache.responses {
+		resourceIDs = append(resourceIDs, resourceID)
+	}
+
+	sort.Strings(resourceIDs)
+	return resourceIDs
+}
+
+// List returns the mock subscription resources, in the subscription.
+func (msrc *mockSubscriptionResourcesClient) List(ctx context.Context, options *armresources.ClientListOptions) ([]*armresources.ClientListResponse, error) {
+	responses, err := (&mockAzureResourcesClient{}).List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, response := range responses {
+		for _, resource := range response.Value {
+			resourceID := strings.Replace(*resource.ID, testSubscriptionID, msrc.subscriptionID, 1)
+			resource.ID = &resourceID
+		}
+	}
+
+	return responses, nil
+}
+
+func (msrc *mockSubscriptionResourcesClient) ListByResourceGroup(
+	ctx context.Context,
+	resourceGroup string,
+	options *armresources.ClientListByResourceGroupOptions) ([]*armresources.ClientListByResourceGroupResponse, error) {
+	return (&mockAzureResourcesClient{}).ListByResourceGroup(ctx, resourceGroup, options)
+}
+
+// List returns the metric definitions of resource1 for every resource.
+func (mamdc *mockAnyResourceMetricDefinitionsClient) List(
+	ctx context.Context,
+	_ string,
+	options *armmonitor.MetricDefinitionsClientListOptions) (armmonitor.MetricDefinitionsClientListResponse, error) {
+	return (&mockAzureMetricDefinitionsClient{}).List(ctx, testFullResourceGroup1ResourceType1Resource1, options)
+}
+
+// List returns the metric definitions or the error of the resource.
+func (msmdc *mockStaticMetricDefinitionsClient) List(
+	_ context.Context,
+	resourceID string,
+	_ *armmonitor.MetricDefinitionsClientListOptions) (armmonitor.MetricDefinitionsClientListResponse, error) {
+	if err, found := msmdc.errs[resourceID]; found {
+		return armmonitor.MetricDefinitionsClientListResponse{}, err
+	}
+
+	return armmonitor.MetricDefinitionsClientListResponse{
+		MetricDefinitionCollection: armmonitor.MetricDefinitionCollection{Value: msmdc.metricDefinitions[resourceID]},
+	}, nil
+}
+
+func newTestMetricDefinition(metricName string, timeGrains ...string) *armmonitor.MetricDefinition {
+	metricDefinition := &armmonitor.MetricDefinition{Name: &armmonitor.LocalizableString{Value: to.Ptr(metricName)}}
+	for _, timeGrain := range timeGrains {
+		metricDefinition.MetricAvailabilities = append(metricDefinition.MetricAvailabilities,
+			&armmonitor.MetricAvailability{TimeGrain: to.Ptr(timeGrain)})
+	}
+
+	return metricDefinition
+}
+
 func (mamdc *mockAzureMetricDefinitionsClient) List(
 	_ context.Context,
 	resourceID string,
@@ -293,6 +804,32 @@ func (mamdc *mockAzureMetricDefinitionsClient) List(
 		}, nil
 	}
 
+	if resourceID == testFullResourceGroup1ResourceType1Resource7 {
+		namespace := testResourceType1
+		metricName := testMetric4
+		metricDisplayName := testMetric4DisplayName
+
+		return armmonitor.MetricDefinitionsClientListResponse{
+			MetricDefinitionCollection: armmonitor.MetricDefinitionCollection{
+				Value: []*armmonitor.MetricDefinition{
+					{
+						ID:        &resourceID,
+						Namespace: &namespace,
+						Name: &armmonitor.LocalizableString{
+							Value:          &metricName,
+							LocalizedValue: &metricDisplayName,
+						},
+						MetricAvailabilities: []*armmonitor.MetricAvailability{
+							{
+								TimeGrain: &timeGrains[0],
+							},
+						},
+					},
+				},
+			},
+		}, nil
+	}
+
 	return armmonitor.MetricDefinitionsClientListResponse{}, nil
 }
 
@@ -610,5 +1147,40 @@ func (mamc *mockAzureMetricsClient) List(
 		}, nil
 	}
 
+	if resourceID == testFullResourceGroup1ResourceType1Resource7 {
+		metricID := testFullResourceGroup1ResourceType1Resource7 + "/providers/Microsoft.Insights/metrics/" + testMetric4
+		metricName := testMetric4
+		metricDisplayName := testMetric4DisplayName
+		metricUnit := armmonitor.UnitPercent
+
+		return armmonitor.MetricsClientListResponse{
+			Response: armmonitor.Response{
+				Namespace:      &namespaces[0],
+				Resourceregion: &resourceRegion,
+				Value: []*armmonitor.Metric{
+					{
+						ID: &metricID,
+						Name: &armmonitor.LocalizableString{
+							Value:          &metricName,
+							LocalizedValue: &metricDisplayName,
+						},
+						Unit: &metricUnit,
+						Timeseries: []*armmonitor.TimeSeriesElement{
+							{
+								Data: []*armmonitor.MetricValue{
+									{
+										TimeStamp: &timeStamps[4],
+										Average:   &aggregationValues[2],
+									},
+								},
+							},
+						},
+						ErrorCode: &metricErrorCode,
+					},
+				},
+			},
+		}, nil
+	}
+
 	return armmonitor.MetricsClientListResponse{}, nil
 }
@@ -0,0 +1,42 @@
+package azuremonitormetricsreceiver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var iso8601DurationRegexp = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseISO8601Duration parses ISO 8601 durations of days, hours, minutes and seconds, such as PT1M, PT1H or P1D,
+// which is the format of Azure Monitor time grains and retentions.
+func parseISO8601Duration(duration string) (time.Duration, error) {
+	matches := iso8601DurationRegexp.FindStringSubmatch(duration)
+	if matches == nil || duration == "P" || duration == "PT" {
+		return 0, fmt.Errorf("duration %s is not a valid ISO 8601 duration", duration)
+	}
+
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var parsedDuration time.Duration
+
+	for index, unit := range units {
+		if matches[index+1] == "" {
+			continue
+		}
+
+		value, err := strconv.Atoi(matches[index+1])
+		if err != nil {
+			return 0, fmt.Errorf("duration %s is not a valid ISO 8601 duration: %v", duration, err)
+		}
+
+		parsedDuration += time.Duration(value) * unit
+	}
+
+	return parsedDuration, nil
+}
+
+// formatTimespan formats a time range as an ISO 8601 interval, which is the format of Azure Monitor timespans.
+func formatTimespan(start time.Time, end time.Time) string {
+	return start.UTC().Format(time.RFC3339) + "/" + end.UTC().Format(time.RFC3339)
+}
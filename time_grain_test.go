@@ -0,0 +1,35 @@
+package azuremonitormetricsreceiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseISO8601Duration_Success(t *testing.T) {
+	durations := map[string]time.Duration{
+		"PT1M":    time.Minute,
+		"PT5M":    5 * time.Minute,
+		"PT1H":    time.Hour,
+		"PT12H":   12 * time.Hour,
+		"P1D":     24 * time.Hour,
+		"P93D":    93 * 24 * time.Hour,
+		"PT30S":   30 * time.Second,
+		"P1DT12H": 36 * time.Hour,
+	}
+
+	for duration, expectedDuration := range durations {
+		parsedDuration, err := parseISO8601Duration(duration)
+		require.NoError(t, err, duration)
+		assert.Equal(t, expectedDuration, parsedDuration, duration)
+	}
+}
+
+func TestParseISO8601Duration_Invalid(t *testing.T) {
+	for _, duration := range []string{"", "P", "PT", "1M", "PT1X", "P1M", "PT-1M"} {
+		_, err := parseISO8601Duration(duration)
+		require.Error(t, err, duration)
+	}
+}
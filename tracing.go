@@ -0,0 +1,124 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/tracing"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+)
+
+const (
+	tracerName    = "github.com/logzio/azure-monitor-metrics-receiver"
+	tracerVersion = ""
+
+	spanMetricsList           = "AzureMonitor.Metrics.List"
+	spanMetricDefinitionsList = "AzureMonitor.MetricDefinitions.List"
+	spanResourcesList         = "AzureResources.List"
+	spanResourcesListPage     = "AzureResources.ListPage"
+
+	spanResourceGraphResources     = "AzureResourceGraph.Resources"
+	spanResourceGraphResourcesPage = "AzureResourceGraph.ResourcesPage"
+
+	spanManagementGroupDescendants     = "AzureManagementGroups.GetDescendants"
+	spanManagementGroupDescendantsPage = "AzureManagementGroups.GetDescendantsPage"
+
+	attributeResourceID           = "azure.resource_id"
+	attributeResourceType         = "azure.resource_type"
+	attributeResourceGroup        = "azure.resource_group"
+	attributeManagementGroup      = "azure.management_group"
+	attributeSubscriptionID       = "azure.subscription_id"
+	attributeFilter               = "azure.filter"
+	attributeQuery                = "azure.query"
+	attributeMetricsNum           = "azure.metrics.count"
+	attributeResponseMetricsNum   = "azure.response.metrics.count"
+	attributeMetricDefinitionsNum = "azure.response.metric_definitions.count"
+	attributeTimespan             = "azure.timespan"
+	attributePageNumber           = "azure.page.number"
+	attributePagesNum             = "azure.response.pages.count"
+	attributeResourcesNum         = "azure.response.resources.count"
+	attributeSubscriptionsNum     = "azure.response.subscriptions.count"
+	attributeHTTPStatusCode       = "http.response.status_code"
+	attributeAzureErrorCode       = "azure.error_code"
+)
+
+type tracerContextKey struct{}
+
+// WithTracingProvider lets you trace the receiver Azure API calls. A span is started around every metrics and
+// metric definitions list call, every resources list call and every resources page, annotated with the resource ID,
+// the metrics count, the page number and the call status.
+// Use azotel.NewTracingProvider to export the spans with OpenTelemetry.
+func WithTracingProvider(provider tracing.Provider) ReceiverOptions {
+	return func(ammr *AzureMonitorMetricsReceiver) {
+		ammr.tracer = provider.NewTracer(tracerName, tracerVersion)
+	}
+}
+
+// startSpan starts a client span. The returned context carries the tracer, so the Azure clients created by
+// CreateAzureClientsWithCreds can start a child span for every page.
+// A receiver without a tracing provider starts no-op spans.
+func (ammr *AzureMonitorMetricsReceiver) startSpan(ctx context.Context, name string, attributes ...tracing.Attribute) (context.Context, tracing.Span) {
+	if !ammr.tracer.Enabled() {
+		return ctx, tracing.Span{}
+	}
+
+	ctx, span := ammr.tracer.Start(ctx, name, &tracing.SpanOptions{Kind: tracing.SpanKindClient, Attributes: attributes})
+	return context.WithValue(ctx, tracerContextKey{}, ammr.tracer), span
+}
+
+// startPageSpan starts a client span for a page, using the tracer the context carries.
+func startPageSpan(ctx context.Context, name string, pageNumber int) (context.Context, tracing.Span) {
+	tracer, ok := ctx.Value(tracerContextKey{}).(tracing.Tracer)
+	if !ok || !tracer.Enabled() {
+		return ctx, tracing.Span{}
+	}
+
+	return tracer.Start(ctx, name, &tracing.SpanOptions{
+		Kind:       tracing.SpanKindClient,
+		Attributes: []tracing.Attribute{{Key: attributePageNumber, Value: pageNumber}},
+	})
+}
+
+// endSpan sets the span status from the error, and ends the span.
+func endSpan(span tracing.Span, err error) {
+	defer span.End()
+
+	if err == nil {
+		span.SetStatus(tracing.SpanStatusOK, "")
+		return
+	}
+
+	var responseError *azcore.ResponseError
+	if errors.As(err, &responseError) {
+		span.SetAttributes(
+			tracing.Attribute{Key: attributeHTTPStatusCode, Value: responseError.StatusCode},
+			tracing.Attribute{Key: attributeAzureErrorCode, Value: responseError.ErrorCode},
+		)
+	}
+
+	span.SetStatus(tracing.SpanStatusError, err.Error())
+}
+
+func (ammr *AzureMonitorMetricsReceiver) startMetricsListSpan(ctx context.Context, target *ResourceTarget, timespan string) (context.Context, tracing.Span) {
+	return ammr.startSpan(ctx, spanMetricsList,
+		tracing.Attribute{Key: attributeResourceID, Value: target.ResourceID},
+		tracing.Attribute{Key: attributeResourceType, Value: getResourceIDResourceType(target.ResourceID)},
+		tracing.Attribute{Key: attributeMetricsNum, Value: len(target.Metrics)},
+		tracing.Attribute{Key: attributeTimespan, Value: timespan})
+}
+
+func endMetricsListSpan(span tracing.Span, response *armmonitor.MetricsClientListResponse, err error) {
+	if err == nil {
+		span.SetAttributes(tracing.Attribute{Key: attributeResponseMetricsNum, Value: len(response.Value)})
+	}
+
+	endSpan(span, err)
+}
+
+func setResourcesListSpanAttributes(span tracing.Span, pagesNum int, resourcesNum int) {
+	span.SetAttributes(
+		tracing.Attribute{Key: attributePagesNum, Value: pagesNum},
+		tracing.Attribute{Key: attributeResourcesNum, Value: resourcesNum},
+	)
+}
@@ -0,0 +1,212 @@
+package azuremonitormetricsreceiver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSpan struct {
+	name       string
+	kind       tracing.SpanKind
+	attributes map[string]any
+	status     tracing.SpanStatus
+	ended      bool
+}
+
+type testTracingProvider struct {
+	mutex sync.Mutex
+	spans []*testSpan
+}
+
+func (ttp *testTracingProvider) provider() tracing.Provider {
+	return tracing.NewProvider(func(_, _ string) tracing.Tracer {
+		return tracing.NewTracer(func(ctx context.Context, spanName string, options *tracing.SpanOptions) (context.Context, tracing.Span) {
+			span := &testSpan{name: spanName, kind: options.Kind, attributes: make(map[string]any)}
+			setAttributes := func(attributes ...tracing.Attribute) {
+				ttp.mutex.Lock()
+				defer ttp.mutex.Unlock()
+
+				for _, attribute := range attributes {
+					span.attributes[attribute.Key] = attribute.Value
+				}
+			}
+
+			setAttributes(options.Attributes...)
+
+			ttp.mutex.Lock()
+			ttp.spans = append(ttp.spans, span)
+			ttp.mutex.Unlock()
+
+			return ctx, tracing.NewSpan(tracing.SpanImpl{
+				End: func() {
+					ttp.mutex.Lock()
+					defer ttp.mutex.Unlock()
+
+					span.ended = true
+				},
+				SetAttributes: setAttributes,
+				SetStatus: func(status tracing.SpanStatus, _ string) {
+					ttp.mutex.Lock()
+					defer ttp.mutex.Unlock()
+
+					span.status = status
+				},
+			})
+		}, nil)
+	}, nil)
+}
+
+func (ttp *testTracingProvider) getSpans(name string) []*testSpan {
+	ttp.mutex.Lock()
+	defer ttp.mutex.Unlock()
+
+	spans := make([]*testSpan, 0)
+	for _, span := range ttp.spans {
+		if span.name == name {
+			spans = append(spans, span)
+		}
+	}
+
+	return spans
+}
+
+func TestWithTracingProvider_Initialization(t *testing.T) {
+	tracingProvider := &testTracingProvider{}
+	ammr, err := NewAzureMonitorMetricsReceiver(testSubscriptionID,
+		NewTargets([]*ResourceTarget{}, []*ResourceGroupTarget{}, []*Resource{NewResource(testResourceType1, []string{}, []string{})}),
+		setMockAzureClients(),
+		WithTracingProvider(tracingProvider.provider()))
+	require.NoError(t, err)
+
+	require.NoError(t, ammr.InitializeTargetsWithContext(context.Background()))
+
+	resourcesSpans := tracingProvider.getSpans(spanResourcesList)
+	require.Len(t, resourcesSpans, 1)
+	assert.Equal(t, tracing.SpanKindClient, resourcesSpans[0].kind)
+	assert.Equal(t, tracing.SpanStatusOK, resourcesSpans[0].status)
+	assert.True(t, resourcesSpans[0].ended)
+	assert.Equal(t, testSubscriptionID, resourcesSpans[0].attributes[attributeSubscriptionID])
+	assert.Equal(t, "resourceType eq 'Microsoft.Test/type1'", resourcesSpans[0].attributes[attributeFilter])
+	assert.Equal(t, 1, resourcesSpans[0].attributes[attributePagesNum])
+	assert.NotZero(t, resourcesSpans[0].attributes[attributeResourcesNum])
+
+	metricDefinitionsSpans := tracingProvider.getSpans(spanMetricDefinitionsList)
+	require.NotEmpty(t, metricDefinitionsSpans)
+	assert.Equal(t, testFullResourceGroup1ResourceType1Resource1, metricDefinitionsSpans[0].attributes[attributeResourceID])
+	assert.Equal(t, testResourceType1, metricDefinitionsSpans[0].attributes[attributeResourceType])
+	assert.NotZero(t, metricDefinitionsSpans[0].attributes[attributeMetricDefinitionsNum])
+	assert.Equal(t, tracing.SpanStatusOK, metricDefinitionsSpans[0].status)
+}
+
+func TestWithTracingProvider_MetricsList(t *testing.T) {
+	tracingProvider := &testTracingProvider{}
+	ammr := &AzureMonitorMetricsReceiver{
+		AzureClients:   setMockAzureClients(),
+		subscriptionID: testSubscriptionID,
+	}
+	WithTracingProvider(tracingProvider.provider())(ammr)
+
+	target := NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1, testMetric2}, []string{})
+	_, _, err := ammr.CollectResourceTargetMetricsWithContext(context.Background(), target)
+	require.NoError(t, err)
+
+	spans := tracingProvider.getSpans(spanMetricsList)
+	require.Len(t, spans, 1)
+	assert.Equal(t, testFullResourceGroup1ResourceType1Resource1, spans[0].attributes[attributeResourceID])
+	assert.Equal(t, 2, spans[0].attributes[attributeMetricsNum])
+	assert.Equal(t, 2, spans[0].attributes[attributeResponseMetricsNum])
+	assert.Equal(t, tracing.SpanStatusOK, spans[0].status)
+	assert.True(t, spans[0].ended)
+}
+
+func TestWithTracingProvider_MetricsListError(t *testing.T) {
+	tracingProvider := &testTracingProvider{}
+	azureClients := setMockAzureClients()
+	azureClients.MetricsClient = &failingMetricsClient{err: &azcore.ResponseError{StatusCode: http.StatusTooManyRequests, ErrorCode: "TooManyRequests"}}
+	ammr := &AzureMonitorMetricsReceiver{
+		AzureClients:   azureClients,
+		subscriptionID: testSubscriptionID,
+	}
+	WithTracingProvider(tracingProvider.provider())(ammr)
+
+	target := NewResourceTarget(testFullResourceGroup1ResourceType1Resource1, []string{testMetric1}, []string{})
+	_, _, err := ammr.CollectResourceTargetMetricsWithContext(context.Background(), target)
+	require.Error(t, err)
+
+	spans := tracingProvider.getSpans(spanMetricsList)
+	require.Len(t, spans, 1)
+	assert.Equal(t, tracing.SpanStatusError, spans[0].status)
+	assert.Equal(t, http.StatusTooManyRequests, spans[0].attributes[attributeHTTPStatusCode])
+	assert.Equal(t, "TooManyRequests", spans[0].attributes[attributeAzureErrorCode])
+	assert.NotContains(t, spans[0].attributes, attributeResponseMetricsNum)
+	assert.True(t, spans[0].ended)
+}
+
+func TestWithTracingProvider_ResourcesPages(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		if request.URL.Query().Get("page") == "" {
+			_, _ = fmt.Fprintf(writer, `{"value": [{"id": %q, "type": %q}], "nextLink": "%s%s?page=2&api-version=2021-04-01"}`,
+				testFullResourceGroup1ResourceType1Resource1, testResourceType1, server.URL, request.URL.Path)
+			return
+		}
+
+		_, _ = writer.Write([]byte(`{"value": [{"id": "a", "type": "b"}, {"id": "c", "type": "d"}]}`))
+	}))
+	defer server.Close()
+
+	azureCloud, err := NewCustomAzureCloud("https://login.example.com/", server.URL, "https://management.example.com")
+	require.NoError(t, err)
+
+	azureClients, err := CreateAzureClientsWithCreds(testSubscriptionID, &testTokenCredential{},
+		WithAzureClientOptions(&azcore.ClientOptions{Transport: server.Client()}),
+		WithAzureCloud(azureCloud))
+	require.NoError(t, err)
+
+	tracingProvider := &testTracingProvider{}
+	ammr := &AzureMonitorMetricsReceiver{
+		AzureClients:   azureClients,
+		subscriptionID: testSubscriptionID,
+	}
+	WithTracingProvider(tracingProvider.provider())(ammr)
+
+	ctx, span := ammr.startSpan(context.Background(), spanResourcesList)
+	responses, err := azureClients.ResourcesClient.List(ctx, nil)
+	endSpan(span, err)
+	require.NoError(t, err)
+	require.Len(t, responses, 2)
+
+	pageSpans := tracingProvider.getSpans(spanResourcesListPage)
+	require.Len(t, pageSpans, 2)
+	assert.Equal(t, 1, pageSpans[0].attributes[attributePageNumber])
+	assert.Equal(t, 1, pageSpans[0].attributes[attributeResourcesNum])
+	assert.Equal(t, 2, pageSpans[1].attributes[attributePageNumber])
+	assert.Equal(t, 2, pageSpans[1].attributes[attributeResourcesNum])
+
+	for _, pageSpan := range pageSpans {
+		assert.Equal(t, tracing.SpanStatusOK, pageSpan.status)
+		assert.True(t, pageSpan.ended)
+	}
+}
+
+func TestStartSpan_NoTracingProvider(t *testing.T) {
+	ammr := &AzureMonitorMetricsReceiver{}
+
+	ctx, span := ammr.startSpan(context.Background(), spanMetricsList)
+	endSpan(span, nil)
+
+	assert.Nil(t, ctx.Value(tracerContextKey{}))
+
+	_, pageSpan := startPageSpan(ctx, spanResourcesListPage, 1)
+	endSpan(pageSpan, nil)
+}